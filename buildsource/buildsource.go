@@ -0,0 +1,289 @@
+// Package buildsource reads the files to push, diff or sign from a folder,
+// a zip file, a single file, or any other archive butler knows how to read.
+package buildsource
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/itchio/boar"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/tarextractor"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/pools"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// Format is an archive format wharf can't read directly
+type Format string
+
+const (
+	FormatTar      Format = "tar"
+	FormatTarGz    Format = "tar.gz"
+	FormatTarBz2   Format = "tar.bz2"
+	FormatTarXz    Format = "tar.xz"
+	FormatTarZst   Format = "tar.zst"
+	FormatSevenZip Format = "7-zip"
+)
+
+var formatSuffixes = []struct {
+	suffix string
+	format Format
+}{
+	{".tar", FormatTar},
+	{".tar.gz", FormatTarGz},
+	{".tgz", FormatTarGz},
+	{".tar.bz2", FormatTarBz2},
+	{".tbz2", FormatTarBz2},
+	{".tar.xz", FormatTarXz},
+	{".txz", FormatTarXz},
+	{".tar.zst", FormatTarZst},
+	{".tzst", FormatTarZst},
+	{".7z", FormatSevenZip},
+	{".rar", FormatSevenZip},
+}
+
+// DetectFormat returns the format of an archive, judging by its name.
+// It returns "" for zip files and anything that isn't an archive, which
+// wharf handles on its own.
+func DetectFormat(name string) Format {
+	lowerName := strings.ToLower(name)
+	for _, fs := range formatSuffixes {
+		if strings.HasSuffix(lowerName, fs.suffix) {
+			return fs.format
+		}
+	}
+	return ""
+}
+
+// streamable returns true if files can be read in order from the
+// archive, without extracting it first
+func (f Format) streamable() bool {
+	return f != FormatSevenZip
+}
+
+// Params control how a source is opened
+type Params struct {
+	WalkOpts *tlc.WalkOpts
+	Consumer *state.Consumer
+
+	// NoStreaming extracts archives to a temporary folder even when
+	// they could be streamed, for callers that need random access.
+	NoStreaming bool
+}
+
+// A Source is a container along with a way to read its files
+type Source struct {
+	// Path is the folder, archive or file that was opened
+	Path string
+	// Format is the archive format, empty for folders, zip files and single files
+	Format Format
+	// Streamed is set when files are read straight from the archive,
+	// rather than from a temporary extraction folder
+	Streamed bool
+	// LocalPath is something pools.New can read from: Path itself, or the
+	// folder the archive was extracted to. It's empty when streaming.
+	LocalPath string
+
+	Container  *tlc.Container
+	Exclusions []*filtering.Exclusion
+
+	consumer *state.Consumer
+	tempDir  string
+}
+
+// Open walks a source, honoring ignore files. Archives are streamed if
+// their format and layout allow it, and extracted to a temporary folder
+// otherwise. Close must be called once done with the source.
+func Open(sourcePath string, params *Params) (*Source, error) {
+	opts := params.WalkOpts
+	if opts == nil {
+		opts = &tlc.WalkOpts{}
+	}
+	consumer := params.Consumer
+	if consumer == nil {
+		consumer = &state.Consumer{}
+	}
+
+	s := &Source{
+		Path:      sourcePath,
+		LocalPath: sourcePath,
+		consumer:  consumer,
+	}
+
+	if sourcePath != tlc.NullPath {
+		format, err := detectFile(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		s.Format = format
+	}
+
+	if s.Format == "" {
+		container, exclusions, err := filtering.WalkAnyWithExclusions(sourcePath, opts)
+		if err != nil {
+			return nil, err
+		}
+		s.Container = container
+		s.Exclusions = exclusions
+		return s, nil
+	}
+
+	if s.Format.streamable() && !params.NoStreaming && !opts.Dereference {
+		container, exclusions, err := walkStream(sourcePath, s.Format, opts, consumer)
+		if err == nil {
+			consumer.Debugf("Streaming files from %s archive", s.Format)
+			s.Streamed = true
+			s.LocalPath = ""
+			s.Container = container
+			s.Exclusions = exclusions
+			return s, nil
+		}
+
+		if nse, ok := errors.Cause(err).(*notStreamableError); ok {
+			consumer.Infof("Can't stream files from %s archive (%s)", s.Format, nse.reason)
+		} else {
+			return nil, err
+		}
+	}
+
+	err := s.extract()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	container, exclusions, err := filtering.WalkAnyWithExclusions(s.LocalPath, opts)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.Container = container
+	s.Exclusions = exclusions
+	return s, nil
+}
+
+func detectFile(sourcePath string) (Format, error) {
+	file, err := eos.Open(sourcePath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer file.Close()
+
+	stats, err := file.Stat()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if stats.IsDir() {
+		return "", nil
+	}
+	return DetectFormat(stats.Name()), nil
+}
+
+// extract unpacks the archive to a temporary folder
+func (s *Source) extract() error {
+	tempDir, err := ioutil.TempDir("", "butler-source")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.tempDir = tempDir
+	s.LocalPath = tempDir
+
+	file, err := eos.Open(s.Path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	var ex savior.Extractor
+	if s.Format == FormatSevenZip {
+		info, err := boar.Probe(&boar.ProbeParams{
+			File:     file,
+			Consumer: s.consumer,
+		})
+		if err != nil {
+			return errors.Wrap(err, "probing archive")
+		}
+		if info == nil {
+			return errors.Errorf("%s: not a supported archive", s.Path)
+		}
+
+		ex, err = info.GetExtractor(file, s.consumer)
+		if err != nil {
+			return errors.Wrap(err, "opening archive")
+		}
+	} else {
+		source, err := openStream(file, s.Format, s.consumer)
+		if err != nil {
+			return err
+		}
+		ex = tarextractor.New(source)
+	}
+
+	s.consumer.Opf("Extracting %s archive to a temporary folder...", s.Format)
+	ex.SetConsumer(s.consumer)
+	_, err = ex.Resume(nil, &savior.FolderSink{
+		Directory: tempDir,
+		Consumer:  s.consumer,
+	})
+	if err != nil {
+		return errors.Wrap(err, "extracting archive")
+	}
+	return nil
+}
+
+// NewPool returns a pool to read the files of the source's container.
+// Pools for streamed archives only support reading files in order.
+func (s *Source) NewPool() (wsync.Pool, error) {
+	if s.Streamed {
+		return &streamPool{
+			path:      s.Path,
+			format:    s.Format,
+			container: s.Container,
+			consumer:  s.consumer,
+		}, nil
+	}
+	return pools.New(s.Container, s.LocalPath)
+}
+
+// Seekable returns true if files of the source can be read from any
+// offset cheaply, which is the case when they're in a folder on disk
+func (s *Source) Seekable() bool {
+	if s.Streamed || s.LocalPath == "" {
+		return false
+	}
+	stats, err := os.Stat(s.LocalPath)
+	return err == nil && stats.IsDir()
+}
+
+// Describe returns a short description of how files are read, for logging
+func (s *Source) Describe() string {
+	switch {
+	case s.Format == "":
+		return s.Path
+	case s.Streamed:
+		return s.Path + " (streamed " + string(s.Format) + ")"
+	default:
+		return s.Path + " (extracted " + string(s.Format) + ")"
+	}
+}
+
+// Close removes the temporary extraction folder, if any
+func (s *Source) Close() error {
+	if s.tempDir == "" {
+		return nil
+	}
+
+	err := os.RemoveAll(s.tempDir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	s.tempDir = ""
+	return nil
+}
@@ -0,0 +1,164 @@
+package buildsource_test
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/buildsource"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type tarEntry struct {
+	name     string
+	contents string
+	linkname string
+}
+
+func makeTarGz(t *testing.T, dir string, entries []tarEntry) string {
+	archivePath := filepath.Join(dir, "build.tar.gz")
+	f, err := os.Create(archivePath)
+	wtest.Must(t, err)
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Mode:     0644,
+			Size:     int64(len(e.contents)),
+			Typeflag: tar.TypeReg,
+		}
+		switch {
+		case e.linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.linkname
+			hdr.Mode = 0777
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag = tar.TypeDir
+			hdr.Mode = 0755
+		}
+		wtest.Must(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.contents))
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, tw.Close())
+	wtest.Must(t, gw.Close())
+	return archivePath
+}
+
+func readAll(t *testing.T, s *buildsource.Source) map[string]string {
+	pool, err := s.NewPool()
+	wtest.Must(t, err)
+	defer pool.Close()
+
+	res := make(map[string]string)
+	for i, f := range s.Container.Files {
+		r, err := pool.GetReader(int64(i))
+		wtest.Must(t, err)
+		buf, err := ioutil.ReadAll(r)
+		wtest.Must(t, err)
+		res[f.Path] = string(buf)
+	}
+	return res
+}
+
+func TestStreamedArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildsource")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	archivePath := makeTarGz(t, dir, []tarEntry{
+		{name: "./.butlerignore", contents: "*.log\n"},
+		{name: "./data/debug.log", contents: "oops"},
+		{name: "./data/level1.dat", contents: "level 1"},
+		{name: "./data/level2.dat", contents: "level 2"},
+		{name: "./game", contents: "#!/bin/sh"},
+		{name: "./game-data/", contents: ""},
+		{name: "./game-data/.DS_Store", contents: "junk"},
+		{name: "./launch", linkname: "game"},
+	})
+
+	s, err := buildsource.Open(archivePath, &buildsource.Params{
+		WalkOpts: &tlc.WalkOpts{Filter: filtering.FilterPaths},
+	})
+	wtest.Must(t, err)
+	defer s.Close()
+
+	assert.True(t, s.Streamed)
+	assert.EqualValues(t, buildsource.FormatTarGz, s.Format)
+
+	var paths []string
+	for _, f := range s.Container.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.EqualValues(t, []string{"data/level1.dat", "data/level2.dat", "game"}, paths)
+	assert.EqualValues(t, 2, len(s.Container.Dirs))
+	assert.EqualValues(t, "launch", s.Container.Symlinks[0].Path)
+	assert.EqualValues(t, len("level 1")*2+len("#!/bin/sh"), s.Container.Size)
+
+	var excluded []string
+	for _, e := range s.Exclusions {
+		excluded = append(excluded, e.Path)
+	}
+	assert.EqualValues(t, []string{".butlerignore", "data/debug.log"}, excluded)
+
+	contents := readAll(t, s)
+	assert.EqualValues(t, "level 2", contents["data/level2.dat"])
+	assert.EqualValues(t, "#!/bin/sh", contents["game"])
+
+	// reading again starts over
+	assert.EqualValues(t, contents, readAll(t, s))
+
+	// the same files, extracted and walked
+	extracted, err := buildsource.Open(archivePath, &buildsource.Params{
+		WalkOpts:    &tlc.WalkOpts{Filter: filtering.FilterPaths},
+		NoStreaming: true,
+	})
+	wtest.Must(t, err)
+	defer extracted.Close()
+
+	assert.False(t, extracted.Streamed)
+	assert.NoError(t, s.Container.EnsureEqual(extracted.Container))
+	assert.EqualValues(t, contents, readAll(t, extracted))
+}
+
+func TestUnsortedArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildsource")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	archivePath := makeTarGz(t, dir, []tarEntry{
+		{name: "b.dat", contents: "b"},
+		{name: "a.dat", contents: "a"},
+	})
+
+	s, err := buildsource.Open(archivePath, &buildsource.Params{})
+	wtest.Must(t, err)
+	defer s.Close()
+
+	assert.False(t, s.Streamed, "files need to be in walk order to be streamed")
+	assert.NotEmpty(t, s.LocalPath)
+	assert.EqualValues(t, "a.dat", s.Container.Files[0].Path)
+	assert.EqualValues(t, map[string]string{"a.dat": "a", "b.dat": "b"}, readAll(t, s))
+
+	localPath := s.LocalPath
+	wtest.Must(t, s.Close())
+	_, err = os.Stat(localPath)
+	assert.True(t, os.IsNotExist(err), "temporary folder is removed on close")
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.EqualValues(t, buildsource.FormatTarZst, buildsource.DetectFormat("Game-1.2.TAR.ZST"))
+	assert.EqualValues(t, buildsource.FormatTarGz, buildsource.DetectFormat("game.tgz"))
+	assert.EqualValues(t, buildsource.FormatSevenZip, buildsource.DetectFormat("game.7z"))
+	assert.EqualValues(t, "", buildsource.DetectFormat("game.zip"))
+	assert.EqualValues(t, "", buildsource.DetectFormat("setup.exe"))
+}
@@ -0,0 +1,397 @@
+package buildsource
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itchio/boar/szextractor/xzsource"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/savior"
+	"github.com/itchio/savior/bzip2source"
+	"github.com/itchio/savior/gzipsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/zstdsource"
+	"github.com/pkg/errors"
+)
+
+// maxIgnoreFileSize is how much of an ignore file we'll read from an archive
+const maxIgnoreFileSize = 1024 * 1024
+
+// notStreamableError is returned when an archive has to be extracted
+// before its files can be read
+type notStreamableError struct {
+	reason string
+}
+
+func (e *notStreamableError) Error() string {
+	return fmt.Sprintf("archive can't be streamed: %s", e.reason)
+}
+
+// openStream returns the decompressed tar stream of an archive. Resume
+// must be called before reading from it.
+func openStream(file eos.File, format Format, consumer *state.Consumer) (savior.Source, error) {
+	source := seeksource.FromFile(file)
+
+	switch format {
+	case FormatTar:
+		return source, nil
+	case FormatTarGz:
+		return gzipsource.New(source), nil
+	case FormatTarBz2:
+		return bzip2source.New(source), nil
+	case FormatTarZst:
+		return zstdsource.New(source), nil
+	case FormatTarXz:
+		xs, err := xzsource.New(file, consumer)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening xz stream")
+		}
+		return xs, nil
+	}
+	return nil, errors.Errorf("can't stream %s archives", format)
+}
+
+func openTar(file eos.File, format Format, consumer *state.Consumer) (*tar.Reader, error) {
+	source, err := openStream(file, format, consumer)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening archive")
+	}
+
+	// hide any Seek method, tar would use it to skip entries
+	return tar.NewReader(struct{ io.Reader }{source}), nil
+}
+
+// entryPath returns the slash-separated path of a tar entry, relative to the
+// root of the container, or "" for the root itself.
+func entryPath(name string) (string, error) {
+	if strings.HasPrefix(name, "/") {
+		return "", errors.Errorf("archive entry %s has an absolute path", name)
+	}
+
+	p := path.Clean(name)
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", errors.Errorf("archive entry %s points outside of the archive", name)
+	}
+	if p == "." {
+		return "", nil
+	}
+	return p, nil
+}
+
+// walkLess orders paths the same way tlc.WalkDir visits them: folders
+// first, then their contents, siblings in lexical order.
+func walkLess(a, b string) bool {
+	ac := strings.Split(a, "/")
+	bc := strings.Split(b, "/")
+	for i := 0; i < len(ac) && i < len(bc); i++ {
+		if ac[i] != bc[i] {
+			return ac[i] < bc[i]
+		}
+	}
+	return len(ac) < len(bc)
+}
+
+// entryInfo is an os.FileInfo for filters, named like WalkDir would name it
+type entryInfo struct {
+	name    string
+	mode    os.FileMode
+	size    int64
+	modTime time.Time
+}
+
+var _ os.FileInfo = (*entryInfo)(nil)
+
+func (ei *entryInfo) Name() string       { return ei.name }
+func (ei *entryInfo) Size() int64        { return ei.size }
+func (ei *entryInfo) Mode() os.FileMode  { return ei.mode }
+func (ei *entryInfo) ModTime() time.Time { return ei.modTime }
+func (ei *entryInfo) IsDir() bool        { return ei.mode.IsDir() }
+func (ei *entryInfo) Sys() interface{}   { return nil }
+
+// walkStream lists the contents of a tar archive without extracting it.
+// The resulting container is the same as if the archive had been extracted
+// and walked, or a *notStreamableError is returned.
+func walkStream(archivePath string, format Format, opts *tlc.WalkOpts, consumer *state.Consumer) (*tlc.Container, []*filtering.Exclusion, error) {
+	file, err := eos.Open(archivePath)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	defer file.Close()
+
+	tr, err := openTar(file, format, consumer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := opts.Filter
+	if filter == nil {
+		filter = tlc.DefaultFilter
+	}
+
+	dirs := make(map[string]*tlc.Dir)
+	filteredDirs := make(map[string]bool)
+	seen := make(map[string]bool)
+	ignoreFiles := make(map[string][]byte)
+	var files []*tlc.File
+	var symlinks []*tlc.Symlink
+
+	// addDirs records the parent folders of p, even if the archive doesn't
+	// list them, and returns false if one of them is filtered out.
+	var addDirs func(p string) bool
+	addDirs = func(p string) bool {
+		dir := path.Dir(p)
+		if dir == "." {
+			return true
+		}
+		if filteredDirs[dir] {
+			return false
+		}
+		if _, ok := dirs[dir]; ok {
+			return true
+		}
+
+		if !addDirs(dir) {
+			filteredDirs[dir] = true
+			return false
+		}
+
+		mode := os.ModeDir | 0755 | tlc.ModeMask
+		if !filter(&entryInfo{name: path.Base(dir), mode: mode}) {
+			filteredDirs[dir] = true
+			return false
+		}
+		dirs[dir] = &tlc.Dir{Path: dir, Mode: uint32(mode)}
+		return true
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading archive")
+		}
+
+		p, err := entryPath(hdr.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir, tar.TypeSymlink:
+			// supported
+		case tar.TypeLink:
+			return nil, nil, &notStreamableError{"it contains hard links"}
+		default:
+			// devices, fifos, etc. are skipped when walking folders too
+			continue
+		}
+
+		if seen[p] {
+			return nil, nil, &notStreamableError{fmt.Sprintf("%s is listed several times", p)}
+		}
+		seen[p] = true
+
+		if !addDirs(p) {
+			continue
+		}
+
+		// don't end up with files we (the patcher) can't modify
+		mode := hdr.FileInfo().Mode() | tlc.ModeMask
+		info := &entryInfo{
+			name:    path.Base(p),
+			mode:    mode,
+			size:    hdr.Size,
+			modTime: hdr.ModTime,
+		}
+		if !filter(info) {
+			if mode.IsDir() {
+				filteredDirs[p] = true
+			}
+			continue
+		}
+
+		switch {
+		case mode.IsDir():
+			if d, ok := dirs[p]; ok {
+				// was implied by an earlier entry
+				d.Mode = uint32(mode)
+			} else {
+				dirs[p] = &tlc.Dir{Path: p, Mode: uint32(mode)}
+			}
+		case mode&os.ModeSymlink > 0:
+			symlinks = append(symlinks, &tlc.Symlink{Path: p, Mode: uint32(mode), Dest: hdr.Linkname})
+		default:
+			files = append(files, &tlc.File{Path: p, Mode: uint32(mode), Size: hdr.Size})
+
+			if path.Base(p) == filtering.IgnoreFileName {
+				contents, err := ioutil.ReadAll(io.LimitReader(tr, maxIgnoreFileSize))
+				if err != nil {
+					return nil, nil, errors.Wrap(err, "reading archive")
+				}
+				ignoreFiles[p] = contents
+			}
+		}
+	}
+
+	// files are read in the order they're listed in the container, so it has to
+	// match both the archive order and the order in which folders are walked.
+	for i := 1; i < len(files); i++ {
+		if !walkLess(files[i-1].Path, files[i].Path) {
+			return nil, nil, &notStreamableError{"its files aren't sorted by path"}
+		}
+	}
+
+	container := &tlc.Container{
+		Files:    files,
+		Symlinks: symlinks,
+	}
+	for _, d := range dirs {
+		container.Dirs = append(container.Dirs, d)
+	}
+	sort.Slice(container.Dirs, func(i, j int) bool {
+		return walkLess(container.Dirs[i].Path, container.Dirs[j].Path)
+	})
+	sort.Slice(container.Symlinks, func(i, j int) bool {
+		return walkLess(container.Symlinks[i].Path, container.Symlinks[j].Path)
+	})
+
+	var ignoreFilePaths []string
+	for p := range ignoreFiles {
+		ignoreFilePaths = append(ignoreFilePaths, p)
+	}
+	// rules from parent folders come first, so nested ignore files win
+	sort.Slice(ignoreFilePaths, func(i, j int) bool {
+		di := strings.Count(ignoreFilePaths[i], "/")
+		dj := strings.Count(ignoreFilePaths[j], "/")
+		if di != dj {
+			return di < dj
+		}
+		return ignoreFilePaths[i] < ignoreFilePaths[j]
+	})
+
+	var rules []*filtering.IgnoreRule
+	for _, p := range ignoreFilePaths {
+		fileRules, err := filtering.ParseIgnoreFile(p, path.Dir(p), bytes.NewReader(ignoreFiles[p]))
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reading ignore files")
+		}
+		rules = append(rules, fileRules...)
+	}
+
+	// also computes offsets and the total size
+	container, exclusions := filtering.NewIgnoreRules(rules).Apply(container, "")
+	return container, exclusions, nil
+}
+
+// streamPool reads files from a tar archive, in order. Reading a file that
+// comes before the last one read starts over from the beginning of the archive.
+type streamPool struct {
+	path      string
+	format    Format
+	container *tlc.Container
+	consumer  *state.Consumer
+
+	file eos.File
+	tr   *tar.Reader
+	// next is the index of the first file that can be read without starting over
+	next int64
+}
+
+func (sp *streamPool) GetSize(fileIndex int64) int64 {
+	return sp.container.Files[fileIndex].Size
+}
+
+func (sp *streamPool) GetReader(fileIndex int64) (io.Reader, error) {
+	if sp.tr == nil || fileIndex < sp.next {
+		err := sp.rewind()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	target := sp.container.Files[fileIndex].Path
+	for {
+		hdr, err := sp.tr.Next()
+		if err == io.EOF {
+			return nil, errors.Errorf("%s: not found in archive %s", target, sp.path)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "reading archive")
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		p, err := entryPath(hdr.Name)
+		if err != nil {
+			return nil, err
+		}
+		if p == target {
+			sp.next = fileIndex + 1
+			return struct{ io.Reader }{sp.tr}, nil
+		}
+	}
+}
+
+func (sp *streamPool) GetReadSeeker(fileIndex int64) (io.ReadSeeker, error) {
+	return nil, errors.Errorf("%s: files of streamed archives can only be read in order", sp.path)
+}
+
+func (sp *streamPool) rewind() error {
+	err := sp.Close()
+	if err != nil {
+		return err
+	}
+
+	file, err := eos.Open(sp.path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	tr, err := openTar(file, sp.format, sp.consumer)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	sp.file = file
+	sp.tr = tr
+	sp.next = 0
+	return nil
+}
+
+func (sp *streamPool) Close() error {
+	sp.tr = nil
+	if sp.file == nil {
+		return nil
+	}
+
+	err := sp.file.Close()
+	sp.file = nil
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
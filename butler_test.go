@@ -150,7 +150,7 @@ func TestAllTheThings(t *testing.T) {
 		t.Logf("Signing %s\n", filepath)
 
 		sigPath := path.Join(workingDir, "signature.pwr.sig")
-		mist(t, sign.Do(filepath, sigPath, compression, false))
+		mist(t, sign.Do(&sign.Params{Dir: filepath, Signature: sigPath, Compression: compression}))
 
 		sigReader, err := eos.Open(sigPath)
 		mist(t, err)
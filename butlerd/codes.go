@@ -26,6 +26,8 @@ var codeMessages = map[Code]string{
 	CodeDatabaseBusy: "The database is busy",
 
 	CodeCantRemoveLocationBecauseOfActiveDownloads: "An install location could not be removed because it has active downloads",
+
+	CodeBuildSealMismatch: "This build isn't sealed with the key pinned for this game.",
 }
 
 func (code Code) RpcErrorMessage() string {
@@ -9,7 +9,6 @@ import (
 	"github.com/itchio/butler/cmd/verify"
 
 	"github.com/itchio/butler/cmd/sign"
-	"github.com/itchio/wharf/pwr"
 
 	"github.com/itchio/butler/butlerd"
 	"github.com/itchio/butler/butlerd/messages"
@@ -122,9 +121,9 @@ func Test_InstallUpdateNonWharf(t *testing.T) {
 	must(os.MkdirAll(tmpDir, 0755))
 
 	sigPath := filepath.Join(tmpDir, "expected-sig.pws")
-	err = sign.Do(referenceFolder, sigPath, pwr.CompressionSettings{}, false)
+	err = sign.Do(&sign.Params{Dir: referenceFolder, Signature: sigPath})
 	must(err)
 
-	err = verify.Do(sigPath, actualFolder, "", "")
+	err = verify.Do(&verify.Params{SignaturePath: sigPath, Dir: actualFolder})
 	assert.NoError(err)
 }
@@ -2261,6 +2261,126 @@ func (r *InstallLocationsScanConfirmImportType) Call(rc *butlerd.RequestContext,
 
 var InstallLocationsScanConfirmImport *InstallLocationsScanConfirmImportType
 
+// Install.Keys.Pin (Request)
+
+type InstallKeysPinType struct {}
+
+var _ RequestMessage = (*InstallKeysPinType)(nil)
+
+func (r *InstallKeysPinType) Method() string {
+  return "Install.Keys.Pin"
+}
+
+func (r *InstallKeysPinType) Register(router router, f func(*butlerd.RequestContext, butlerd.InstallKeysPinParams) (*butlerd.InstallKeysPinResult, error)) {
+  router.Register("Install.Keys.Pin", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.InstallKeysPinParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Install.Keys.Pin")
+    }
+    return res, nil
+  })
+}
+
+func (r *InstallKeysPinType) TestCall(rc *butlerd.RequestContext, params butlerd.InstallKeysPinParams) (*butlerd.InstallKeysPinResult, error) {
+  var result butlerd.InstallKeysPinResult
+  err := rc.Call("Install.Keys.Pin", params, &result)
+  return &result, err
+}
+
+var InstallKeysPin *InstallKeysPinType
+
+// Install.Keys.Unpin (Request)
+
+type InstallKeysUnpinType struct {}
+
+var _ RequestMessage = (*InstallKeysUnpinType)(nil)
+
+func (r *InstallKeysUnpinType) Method() string {
+  return "Install.Keys.Unpin"
+}
+
+func (r *InstallKeysUnpinType) Register(router router, f func(*butlerd.RequestContext, butlerd.InstallKeysUnpinParams) (*butlerd.InstallKeysUnpinResult, error)) {
+  router.Register("Install.Keys.Unpin", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.InstallKeysUnpinParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Install.Keys.Unpin")
+    }
+    return res, nil
+  })
+}
+
+func (r *InstallKeysUnpinType) TestCall(rc *butlerd.RequestContext, params butlerd.InstallKeysUnpinParams) (*butlerd.InstallKeysUnpinResult, error) {
+  var result butlerd.InstallKeysUnpinResult
+  err := rc.Call("Install.Keys.Unpin", params, &result)
+  return &result, err
+}
+
+var InstallKeysUnpin *InstallKeysUnpinType
+
+// Install.Keys.Get (Request)
+
+type InstallKeysGetType struct {}
+
+var _ RequestMessage = (*InstallKeysGetType)(nil)
+
+func (r *InstallKeysGetType) Method() string {
+  return "Install.Keys.Get"
+}
+
+func (r *InstallKeysGetType) Register(router router, f func(*butlerd.RequestContext, butlerd.InstallKeysGetParams) (*butlerd.InstallKeysGetResult, error)) {
+  router.Register("Install.Keys.Get", func (rc *butlerd.RequestContext) (interface{}, error) {
+    var params butlerd.InstallKeysGetParams
+    err := json.Unmarshal(*rc.Params, &params)
+    if err != nil {
+    	return nil, &butlerd.RpcError{Code: jsonrpc2.CodeParseError, Message: err.Error()}
+    }
+    err = params.Validate()
+    if err != nil {
+    	return nil, err
+    }
+    res, err := f(rc, params)
+    if err != nil {
+    	return nil, err
+    }
+    if res == nil {
+    	return nil, errors.New("internal error: nil result for Install.Keys.Get")
+    }
+    return res, nil
+  })
+}
+
+func (r *InstallKeysGetType) TestCall(rc *butlerd.RequestContext, params butlerd.InstallKeysGetParams) (*butlerd.InstallKeysGetResult, error) {
+  var result butlerd.InstallKeysGetResult
+  err := rc.Call("Install.Keys.Get", params, &result)
+  return &result, err
+}
+
+var InstallKeysGet *InstallKeysGetType
+
 
 //==============================
 // Downloads
@@ -3428,6 +3548,9 @@ func EnsureAllRequests(router *butlerd.Router) {
   if _, ok := router.Handlers["Install.Locations.Remove"]; !ok { panic("missing request handler for (Install.Locations.Remove)") }
   if _, ok := router.Handlers["Install.Locations.GetByID"]; !ok { panic("missing request handler for (Install.Locations.GetByID)") }
   if _, ok := router.Handlers["Install.Locations.Scan"]; !ok { panic("missing request handler for (Install.Locations.Scan)") }
+  if _, ok := router.Handlers["Install.Keys.Pin"]; !ok { panic("missing request handler for (Install.Keys.Pin)") }
+  if _, ok := router.Handlers["Install.Keys.Unpin"]; !ok { panic("missing request handler for (Install.Keys.Unpin)") }
+  if _, ok := router.Handlers["Install.Keys.Get"]; !ok { panic("missing request handler for (Install.Keys.Get)") }
   if _, ok := router.Handlers["Downloads.Queue"]; !ok { panic("missing request handler for (Downloads.Queue)") }
   if _, ok := router.Handlers["Downloads.Prioritize"]; !ok { panic("missing request handler for (Downloads.Prioritize)") }
   if _, ok := router.Handlers["Downloads.List"]; !ok { panic("missing request handler for (Downloads.List)") }
@@ -1662,6 +1662,99 @@ type InstallLocationsScanResult struct {
 	NumImportedItems int64 `json:"numImportedItems"`
 }
 
+// Pins a public key for a game: before installing any of its builds,
+// butlerd checks that the build's signature was sealed with that key
+// (see `butler keygen` and `butler push --sign-key`).
+//
+// @name Install.Keys.Pin
+// @category Install
+// @caller client
+type InstallKeysPinParams struct {
+	// Game to pin the key for, replaces any key pinned before
+	GameID int64 `json:"gameId"`
+
+	// Public key, of the form `ed25519:<base64>`, as found
+	// in the `.pub` file written by `butler keygen`
+	PublicKey string `json:"publicKey"`
+
+	// What to do with builds that aren't sealed with the key,
+	// defaults to `refuse`
+	// @optional
+	Policy SealPolicy `json:"policy"`
+}
+
+func (p InstallKeysPinParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.GameID, validation.Required),
+		validation.Field(&p.PublicKey, validation.Required),
+		validation.Field(&p.Policy, validation.In(SealPolicyRefuse, SealPolicyWarn)),
+	)
+}
+
+type InstallKeysPinResult struct {
+	PinnedKey *PinnedKey `json:"pinnedKey"`
+}
+
+// Removes the key pinned for a game, if any
+//
+// @name Install.Keys.Unpin
+// @category Install
+// @caller client
+type InstallKeysUnpinParams struct {
+	GameID int64 `json:"gameId"`
+}
+
+func (p InstallKeysUnpinParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.GameID, validation.Required),
+	)
+}
+
+type InstallKeysUnpinResult struct {
+}
+
+// Retrieves the key pinned for a game, if any
+//
+// @name Install.Keys.Get
+// @category Install
+// @caller client
+type InstallKeysGetParams struct {
+	GameID int64 `json:"gameId"`
+}
+
+func (p InstallKeysGetParams) Validate() error {
+	return validation.ValidateStruct(&p,
+		validation.Field(&p.GameID, validation.Required),
+	)
+}
+
+type InstallKeysGetResult struct {
+	// Not set if no key is pinned for this game
+	// @optional
+	PinnedKey *PinnedKey `json:"pinnedKey"`
+}
+
+// A public key builds of a game must be sealed with
+type PinnedKey struct {
+	GameID int64 `json:"gameId"`
+	// Of the form `ed25519:<base64>`
+	PublicKey string `json:"publicKey"`
+	// Short identifier for the key, as shown by `butler keygen`
+	Fingerprint string     `json:"fingerprint"`
+	Policy      SealPolicy `json:"policy"`
+}
+
+// What to do when installing a build that isn't sealed
+// with the key pinned for its game
+type SealPolicy string
+
+const (
+	// Fail the install with @@CodeBuildSealMismatch
+	SealPolicyRefuse SealPolicy = "refuse"
+	// Log a warning and install anyway
+	SealPolicyWarn SealPolicy = "warn"
+)
+
 //----------------------------------------------------------------------
 // Downloads
 //----------------------------------------------------------------------
@@ -2450,6 +2543,9 @@ const (
 
 	// An install location could not be removed because it has active downloads
 	CodeCantRemoveLocationBecauseOfActiveDownloads Code = 18000
+
+	// A build isn't sealed with the key pinned for its game
+	CodeBuildSealMismatch Code = 19000
 )
 
 //==================================
@@ -18,8 +18,11 @@ import (
 )
 
 var args = struct {
-	patch *string
-	old   *string
+	patch  *string
+	old    *string
+	more   *[]string
+	chain  *bool
+	verify *bool
 
 	dir       *string
 	inplace   *bool
@@ -34,6 +37,7 @@ func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("apply", "(Advanced) Use a patch to patch a directory to a new version")
 	args.patch = cmd.Arg("patch", "Patch file (.pwr), previously generated with the `diff` command.").Required().String()
 	args.old = cmd.Arg("old", "Directory, archive, or empty directory (/dev/null) to patch").Required().String()
+	args.more = cmd.Arg("more", "With --chain: more patches, followed by the directory to patch").Strings()
 
 	args.dir = cmd.Flag("dir", "Directory to create newer files in, instead of working in-place").Short('d').String()
 	args.inplace = cmd.Flag("inplace", "Apply patch directly to old directory. Required for safety").Bool()
@@ -42,10 +46,37 @@ func Register(ctx *mansion.Context) {
 	args.wounds = cmd.Flag("wounds", "When given, write wounds to this path instead of failing (exclusive with --heal)").String()
 	args.heal = cmd.Flag("heal", "When given, heal using specified source instead of failing (exclusive with --wounds)").String()
 	args.stage = cmd.Flag("stage", "When given, use that folder for intermediary files when doing in-place ptching").String()
+	args.chain = cmd.Flag("chain", "Apply several patches back to back: butler apply --chain p1.pwr p2.pwr old/ --dir new/").Bool()
+	args.verify = cmd.Flag("verify", "With --chain, verify each step against the signature next to its patch (patch.pwr.sig)").Bool()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
+	if *args.chain {
+		if *args.wounds != "" || *args.heal != "" {
+			ctx.Must(errors.New("--wounds and --heal can't be used with --chain"))
+		}
+		if *args.dryrun {
+			ctx.Must(errors.New("--dryrun can't be used with --chain"))
+		}
+
+		paths := append([]string{*args.patch, *args.old}, *args.more...)
+		ctx.Must(DoChain(&ChainParams{
+			Patches:       paths[:len(paths)-1],
+			Target:        paths[len(paths)-1],
+			Output:        *args.dir,
+			InPlace:       *args.inplace,
+			Verify:        *args.verify,
+			SignaturePath: *args.signature,
+			StagePath:     *args.stage,
+		}))
+		return
+	}
+
+	if len(*args.more) > 0 {
+		ctx.Must(errors.New("apply takes a single patch, use --chain to apply several"))
+	}
+
 	ctx.Must(Do(&Params{
 		Patch:  *args.patch,
 		Target: *args.old,
@@ -0,0 +1,307 @@
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// ChainParams control how a sequence of patches is applied
+type ChainParams struct {
+	// Patches are applied in order
+	Patches []string
+	// Target is the folder the first patch applies to
+	Target string
+
+	// Output is where to write the final build. If empty or the same as
+	// Target, patches are applied in place, which requires InPlace.
+	Output  string
+	InPlace bool
+
+	// Verify checks the build after each step against the signature
+	// that sits next to its patch (patch.pwr.sig)
+	Verify bool
+	// SignaturePath is a signature to check the final build against, optional
+	SignaturePath string
+
+	// StagePath holds checkpoints and files that are being patched,
+	// defaults to the output folder with .chain-staging appended
+	StagePath string
+}
+
+// chainStateName is where progress of a chain is saved in the stage folder
+const chainStateName = "chain.json"
+
+// chainState remembers which steps of a chain are done, so an interrupted
+// chain resumes where it left off
+type chainState struct {
+	Patches []string `json:"patches"`
+	Done    int      `json:"done"`
+}
+
+// A ChainStepError is returned when one of the steps of a chain fails
+type ChainStepError struct {
+	Step  int
+	Total int
+	Patch string
+	Err   error
+}
+
+func (cse *ChainStepError) Error() string {
+	return fmt.Sprintf("step %d/%d (%s): %s", cse.Step, cse.Total, cse.Patch, cse.Err.Error())
+}
+
+// Cause returns the error the step failed with, see errors.Cause
+func (cse *ChainStepError) Cause() error {
+	return cse.Err
+}
+
+// DoChain applies patches back to back, with a single staging folder,
+// saving progress between and during steps.
+func DoChain(params *ChainParams) error {
+	if len(params.Patches) == 0 {
+		return errors.New("apply: need at least one patch")
+	}
+
+	target := filepath.Clean(params.Target)
+	output := target
+	if params.Output != "" {
+		output = filepath.Clean(params.Output)
+	}
+	inPlace := output == target
+	if inPlace && !params.InPlace {
+		comm.Dief("Refusing to destructively patch %s without --inplace", output)
+	}
+
+	stagePath := params.StagePath
+	if stagePath == "" {
+		stagePath = output + ".chain-staging"
+	}
+
+	var sigPaths []string
+	if params.Verify {
+		for _, patch := range params.Patches {
+			sigPath := patch + ".sig"
+			_, err := os.Stat(sigPath)
+			if err != nil {
+				return errors.Wrapf(err, "looking for signature of %s", patch)
+			}
+			sigPaths = append(sigPaths, sigPath)
+		}
+	}
+	var finalSig *pwr.SignatureInfo
+	if params.SignaturePath != "" {
+		var err error
+		finalSig, err = readSignature(params.SignaturePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	state, err := readChainState(stagePath)
+	if err != nil {
+		return err
+	}
+	if state != nil {
+		if !samePatches(state.Patches, params.Patches) {
+			return errors.Errorf("%s holds progress for a different chain of patches, remove it or use --stage", stagePath)
+		}
+		comm.Opf("Resuming chain after step %d/%d", state.Done, len(params.Patches))
+	} else {
+		if !inPlace {
+			empty, err := isEmptyDir(output)
+			if err != nil {
+				return err
+			}
+			if !empty {
+				return errors.Errorf("%s is not empty, refusing to apply a chain of patches to it", output)
+			}
+		}
+		state = &chainState{Patches: params.Patches}
+	}
+
+	err = os.MkdirAll(stagePath, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// saved right away, so that resuming an interrupted first step
+	// doesn't find a non-empty output folder
+	err = writeChainState(stagePath, state)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	consumer := comm.NewStateConsumer()
+	var container *tlc.Container
+
+	for i := state.Done; i < len(params.Patches); i++ {
+		patch := params.Patches[i]
+		stepErr := func(err error) error {
+			return &ChainStepError{Step: i + 1, Total: len(params.Patches), Patch: eos.Redact(patch), Err: err}
+		}
+
+		comm.Opf("Step %d/%d: applying %s", i+1, len(params.Patches), eos.Redact(patch))
+
+		stepParams := &operate.PatchInPlaceParams{
+			Consumer:       consumer,
+			PatchURL:       patch,
+			Folder:         output,
+			StageFolder:    filepath.Join(stagePath, "files"),
+			CheckpointPath: filepath.Join(stagePath, "checkpoint.bwl"),
+			OnProgress:     comm.Progress,
+		}
+		if i == 0 && !inPlace {
+			// the first step writes a fresh copy, the old build stays untouched
+			stepParams.OldFolder = target
+		}
+
+		comm.StartProgress()
+		container, err = operate.PatchInPlace(stepParams)
+		comm.EndProgress()
+		if err != nil {
+			return stepErr(err)
+		}
+
+		if params.Verify {
+			sig, err := readSignature(sigPaths[i])
+			if err != nil {
+				return stepErr(err)
+			}
+			err = validate(output, sig)
+			if err != nil {
+				return stepErr(err)
+			}
+			comm.Logf("Verified against %s", sigPaths[i])
+		}
+
+		state.Done = i + 1
+		err = writeChainState(stagePath, state)
+		if err != nil {
+			return stepErr(err)
+		}
+	}
+
+	if finalSig != nil {
+		err = validate(output, finalSig)
+		if err != nil {
+			return errors.WithMessage(err, "verifying final build")
+		}
+		comm.Logf("Verified against %s", params.SignaturePath)
+	}
+
+	err = os.RemoveAll(stagePath)
+	if err != nil {
+		comm.Warnf("Could not remove staging folder: %s", err.Error())
+	}
+
+	if container != nil {
+		comm.Statf("Applied %d patches, %s (%s) in %s", len(params.Patches),
+			progress.FormatBytes(container.Size), container.Stats(), progress.FormatDuration(time.Since(startTime)))
+	} else {
+		comm.Statf("All %d patches were already applied", len(params.Patches))
+	}
+	return nil
+}
+
+func readSignature(sigPath string) (*pwr.SignatureInfo, error) {
+	sigReader, err := eos.Open(sigPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening signature")
+	}
+	defer sigReader.Close()
+
+	sigSource := seeksource.FromFile(sigReader)
+	_, err = sigSource.Resume(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating source for signature")
+	}
+
+	sig, err := pwr.ReadSignature(context.Background(), sigSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding signature")
+	}
+	return sig, nil
+}
+
+// validate checks a folder against a signature
+func validate(dir string, sig *pwr.SignatureInfo) error {
+	vc := &pwr.ValidatorContext{
+		Consumer: comm.NewStateConsumer(),
+		FailFast: true,
+	}
+
+	err := vc.Validate(context.Background(), dir, sig)
+	if err != nil {
+		return errors.Wrap(err, "while validating")
+	}
+	return nil
+}
+
+func readChainState(stagePath string) (*chainState, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(stagePath, chainStateName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	state := &chainState{}
+	err = json.Unmarshal(buf, state)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading chain progress")
+	}
+	return state, nil
+}
+
+func writeChainState(stagePath string, state *chainState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	statePath := filepath.Join(stagePath, chainStateName)
+	err = ioutil.WriteFile(statePath+".tmp", buf, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(statePath+".tmp", statePath))
+}
+
+func samePatches(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func isEmptyDir(dir string) (bool, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, errors.WithStack(err)
+	}
+	return len(entries) == 0, nil
+}
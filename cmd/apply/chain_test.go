@@ -0,0 +1,155 @@
+package apply_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/apply"
+	"github.com/itchio/butler/cmd/diff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+var compression = pwr.CompressionSettings{
+	Algorithm: pwr.CompressionAlgorithm_ZSTD,
+	Quality:   1,
+}
+
+func writeBuild(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, []byte(contents), 0644))
+	}
+}
+
+func copyFile(t *testing.T, src string, dst string) {
+	buf, err := ioutil.ReadFile(src)
+	wtest.Must(t, err)
+	wtest.Must(t, ioutil.WriteFile(dst, buf, 0644))
+}
+
+func assertSameBuild(t *testing.T, expectedDir string, actualDir string) {
+	filepath.Walk(expectedDir, func(path string, info os.FileInfo, err error) error {
+		wtest.Must(t, err)
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(expectedDir, path)
+		wtest.Must(t, err)
+
+		expected, err := ioutil.ReadFile(path)
+		wtest.Must(t, err)
+		actual, err := ioutil.ReadFile(filepath.Join(actualDir, rel))
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(expected, actual), "%s should match", rel)
+		return nil
+	})
+}
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chain")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	builds := []map[string]string{
+		{"a.txt": "first", "b/c.txt": "unchanged", "gone.txt": "removed in v2"},
+		{"a.txt": "second", "b/c.txt": "unchanged", "new.txt": "added in v2"},
+		{"a.txt": "third", "b/c.txt": "unchanged", "b/new.txt": "moved in v3"},
+	}
+	var buildDirs []string
+	for i, files := range builds {
+		buildDir := filepath.Join(dir, fmt.Sprintf("v%d", i+1))
+		writeBuild(t, buildDir, files)
+		buildDirs = append(buildDirs, buildDir)
+	}
+
+	var patches []string
+	for i := 1; i < len(buildDirs); i++ {
+		patch := filepath.Join(dir, fmt.Sprintf("p%d.pwr", i))
+		wtest.Must(t, diff.Do(&diff.Params{
+			Target:      buildDirs[i-1],
+			Source:      buildDirs[i],
+			Patch:       patch,
+			Compression: compression,
+		}))
+		patches = append(patches, patch)
+	}
+
+	out := filepath.Join(dir, "out")
+	wtest.Must(t, apply.DoChain(&apply.ChainParams{
+		Patches: patches,
+		Target:  buildDirs[0],
+		Output:  out,
+		Verify:  true,
+	}))
+	assertSameBuild(t, buildDirs[2], out)
+
+	_, err = os.Stat(out + ".chain-staging")
+	assert.True(t, os.IsNotExist(err), "staging folder should be removed")
+
+	// the old build is left alone
+	buf, err := ioutil.ReadFile(filepath.Join(buildDirs[0], "a.txt"))
+	wtest.Must(t, err)
+	assert.EqualValues(t, "first", string(buf))
+
+	// output must be empty
+	err = apply.DoChain(&apply.ChainParams{
+		Patches: patches,
+		Target:  buildDirs[0],
+		Output:  out,
+	})
+	assert.Error(t, err)
+
+	// a step that doesn't give the expected build is reported
+	badPatch := filepath.Join(dir, "bad.pwr")
+	copyFile(t, patches[1], badPatch)
+	copyFile(t, patches[0]+".sig", badPatch+".sig")
+	wrongOut := filepath.Join(dir, "wrong")
+	err = apply.DoChain(&apply.ChainParams{
+		Patches: []string{patches[0], badPatch},
+		Target:  buildDirs[0],
+		Output:  wrongOut,
+		Verify:  true,
+	})
+	assert.Error(t, err)
+	stepErr, ok := err.(*apply.ChainStepError)
+	assert.True(t, ok, "should be a step error: %+v", err)
+	if ok {
+		assert.EqualValues(t, 2, stepErr.Step)
+		assert.EqualValues(t, 2, stepErr.Total)
+	}
+
+	// in place, after an interrupted run
+	inPlace := filepath.Join(dir, "inplace")
+	writeBuild(t, inPlace, builds[0])
+	stage := filepath.Join(dir, "stage")
+	wtest.Must(t, apply.DoChain(&apply.ChainParams{
+		Patches:   patches[:1],
+		Target:    inPlace,
+		InPlace:   true,
+		StagePath: stage,
+	}))
+	assertSameBuild(t, buildDirs[1], inPlace)
+
+	wtest.Must(t, os.MkdirAll(stage, 0755))
+	state, err := json.Marshal(map[string]interface{}{"patches": patches, "done": 1})
+	wtest.Must(t, err)
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(stage, "chain.json"), state, 0644))
+	wtest.Must(t, apply.DoChain(&apply.ChainParams{
+		Patches:   patches,
+		Target:    inPlace,
+		InPlace:   true,
+		StagePath: stage,
+	}))
+	assertSameBuild(t, buildDirs[2], inPlace)
+}
@@ -0,0 +1,268 @@
+package auditzip
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	upstreamzip "archive/zip"
+
+	itchiozip "github.com/itchio/arkive/zip"
+	"github.com/itchio/boar"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// auditor checks entries one by one, and remembers which
+// ones should go in a repaired archive
+type auditor struct {
+	foundErrors []string
+	numEntries  int
+
+	// cleaned path => index of the first entry with that path
+	paths map[string]int
+	// lower-case path or parent folder => first spelling seen
+	folded map[string]string
+
+	// lower-case path => spelling used in the repaired archive
+	spellings map[string]string
+	// path in the repaired archive => entry that ends up there
+	planned map[string]*plannedEntry
+}
+
+type plannedEntry struct {
+	index int
+	kind  savior.EntryKind
+}
+
+func newAuditor() *auditor {
+	return &auditor{
+		paths:     make(map[string]int),
+		folded:    make(map[string]string),
+		spellings: make(map[string]string),
+		planned:   make(map[string]*plannedEntry),
+	}
+}
+
+func (a *auditor) markError(path string, message string, args ...interface{}) {
+	formatted := fmt.Sprintf(message, args...)
+	fullMessage := fmt.Sprintf("(%s): %s", path, formatted)
+	a.foundErrors = append(a.foundErrors, fullMessage)
+}
+
+func (a *auditor) check(entry *Entry, rc io.Reader) error {
+	a.numEntries++
+
+	name := entry.Name
+	p := boar.CleanFileName(name)
+
+	if entry.NonUTF8 {
+		for _, r := range name {
+			if r > 127 {
+				a.markError(p, "Entry has non-ASCII characters but isn't encoded as utf-8")
+				break
+			}
+		}
+	} else if !utf8.ValidString(name) {
+		a.markError(p, "Entry name isn't valid utf-8")
+	}
+
+	if isAbsolute(name) {
+		a.markError(p, "Entry has an absolute path")
+	}
+	if climbs(name) {
+		a.markError(p, "Entry has '..' in its path, it could end up outside the destination folder")
+	}
+
+	if previousIndex, ok := a.paths[p]; ok {
+		a.markError(p, "Duplicate path at indices (%d) and (%d)", entry.Index, previousIndex)
+	} else {
+		a.paths[p] = entry.Index
+		a.checkCase(p)
+	}
+
+	if entry.Unsupported != "" {
+		a.markError(p, "%s", entry.Unsupported)
+		return nil
+	}
+
+	var linkBuf bytes.Buffer
+	var w io.Writer = ioutil.Discard
+	if entry.Kind == savior.EntryKindSymlink && entry.Linkname == "" {
+		w = &linkBuf
+	}
+
+	actualSize, err := io.Copy(w, rc)
+	if err != nil {
+		cause := errors.Cause(err)
+		if cause == itchiozip.ErrChecksum || cause == upstreamzip.ErrChecksum {
+			a.markError(p, "CRC mismatch, contents are corrupted")
+		} else {
+			a.markError(p, "%s", err.Error())
+		}
+		return nil
+	}
+
+	if entry.Kind == savior.EntryKindFile && actualSize != entry.UncompressedSize {
+		a.markError(p, "Dictionary says (%s) is %s (%d bytes), but it's actually %s (%d bytes)",
+			p,
+			progress.FormatBytes(entry.UncompressedSize),
+			entry.UncompressedSize,
+			progress.FormatBytes(actualSize),
+			actualSize,
+		)
+	}
+
+	if entry.Kind == savior.EntryKindSymlink {
+		linkname := entry.Linkname
+		if linkname == "" {
+			linkname = linkBuf.String()
+		}
+		if escapes(p, linkname) {
+			a.markError(p, "Symlink to (%s) points outside of the archive", linkname)
+			return nil
+		}
+	}
+
+	a.plan(entry)
+	return nil
+}
+
+// checkCase looks for paths and parent folders that only differ by case
+// from ones seen earlier, like "Data/a.txt" and "data/b.txt"
+func (a *auditor) checkCase(p string) {
+	prefix := ""
+	for _, part := range strings.Split(p, "/") {
+		if prefix != "" {
+			prefix += "/"
+		}
+		prefix += part
+
+		lower := strings.ToLower(prefix)
+		other, ok := a.folded[lower]
+		if !ok {
+			a.folded[lower] = prefix
+			continue
+		}
+		if other != prefix {
+			a.markError(p, "Path only differs by case from (%s), they'll collide on Windows and macOS", other)
+			return
+		}
+	}
+}
+
+// plan records where an entry that checks out goes in a repaired archive.
+// When several entries end up at the same path, the last one wins, like
+// it would when extracting.
+func (a *auditor) plan(entry *Entry) {
+	name := fixName(entry.Name, entry.NonUTF8)
+	if name == "" {
+		return
+	}
+	name = a.respell(name)
+
+	if previous, ok := a.planned[name]; ok {
+		if entry.Kind == savior.EntryKindDir && previous.kind != savior.EntryKindDir {
+			// folder entries are optional in zip files anyway
+			return
+		}
+	}
+	a.planned[name] = &plannedEntry{
+		index: entry.Index,
+		kind:  entry.Kind,
+	}
+}
+
+// respell makes paths that only differ by case use the first spelling seen,
+// for each of their components.
+func (a *auditor) respell(name string) string {
+	res := ""
+	for _, part := range strings.Split(name, "/") {
+		candidate := part
+		if res != "" {
+			candidate = res + "/" + part
+		}
+
+		key := strings.ToLower(candidate)
+		if spelled, ok := a.spellings[key]; ok {
+			res = spelled
+		} else {
+			a.spellings[key] = candidate
+			res = candidate
+		}
+	}
+	return res
+}
+
+// fixName turns an entry name into a relative, slash-separated, utf-8 path
+// that stays inside the destination folder. It returns an empty string
+// if nothing's left.
+func fixName(name string, nonUTF8 bool) string {
+	if !utf8.ValidString(name) {
+		if nonUTF8 {
+			// the zip spec says names not flagged as utf-8 are in code page 437
+			decoded, err := charmap.CodePage437.NewDecoder().String(name)
+			if err == nil {
+				name = decoded
+			}
+		}
+		name = strings.ToValidUTF8(name, "_")
+	}
+
+	name = strings.Replace(name, `\`, "/", -1)
+	if hasDriveLetter(name) {
+		name = name[2:]
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".":
+			// skip
+		case "..":
+			if len(parts) > 0 {
+				parts = parts[:len(parts)-1]
+			}
+		default:
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+func isAbsolute(name string) bool {
+	return strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || hasDriveLetter(name)
+}
+
+func hasDriveLetter(name string) bool {
+	if len(name) < 2 || name[1] != ':' {
+		return false
+	}
+	c := name[0]
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func climbs(name string) bool {
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// escapes returns true if a symlink at slash path p, pointing to linkname,
+// would point outside of the folder it's extracted in
+func escapes(p string, linkname string) bool {
+	if isAbsolute(linkname) {
+		return true
+	}
+	target := path.Join(path.Dir(p), strings.Replace(linkname, `\`, "/", -1))
+	return target == ".." || strings.HasPrefix(target, "../")
+}
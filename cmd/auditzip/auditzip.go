@@ -8,6 +8,8 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	upstreamzip "archive/zip"
 
@@ -17,6 +19,7 @@ import (
 	"github.com/itchio/boar"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/mansion"
+	"github.com/itchio/savior"
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/eos/option"
 	"github.com/itchio/wharf/state"
@@ -26,6 +29,7 @@ import (
 var args = struct {
 	file     *string
 	upstream *bool
+	repair   *string
 }{}
 
 var doArgs = struct {
@@ -34,9 +38,10 @@ var doArgs = struct {
 }{}
 
 func Register(ctx *mansion.Context) {
-	cmd := ctx.App.Command("auditzip", "Audit a zip file for common errors")
-	args.file = cmd.Arg("file", ".zip file to audit").Required().String()
+	cmd := ctx.App.Command("auditzip", "Audit an archive (.zip, .tar, .tar.gz, .tar.bz2, .tar.xz, .7z) for common errors")
+	args.file = cmd.Arg("file", "Archive to audit").Required().String()
 	args.upstream = cmd.Flag("upstream", "Use upstream zip implementation (archive/zip)").Bool()
+	args.repair = cmd.Flag("repair", "Write a clean .zip file with everything that could be salvaged to this path").String()
 	ctx.Register(cmd, do)
 
 	doCmd := ctx.App.Command("mkprotozip", "Make a zip with all supported entry types")
@@ -198,11 +203,37 @@ func DoMk(consumer *state.Consumer, file string, rfc bool) error {
 
 func do(ctx *mansion.Context) {
 	consumer := comm.NewStateConsumer()
-	ctx.Must(Do(consumer, *args.file, *args.upstream))
+	ctx.Must(Do(&Params{
+		File:       *args.file,
+		Upstream:   *args.upstream,
+		RepairPath: *args.repair,
+		Consumer:   consumer,
+	}))
 }
 
-func Do(consumer *state.Consumer, file string, upstream bool) error {
-	f, err := eos.Open(file, option.WithConsumer(consumer))
+// Params configures Do
+type Params struct {
+	// File is the archive to audit: .zip, .tar, .tar.gz, .tar.bz2, .tar.xz or .7z
+	File string
+	// Upstream reads zip files with archive/zip instead of itchio/arkive
+	Upstream bool
+	// RepairPath, if set, is where a clean .zip file is written with
+	// all the entries that could be salvaged
+	RepairPath string
+
+	Consumer *state.Consumer
+}
+
+// Do reads every entry of an archive and reports anything that could
+// trip up extractors. When repairing, it only returns an error if the
+// repaired archive doesn't check out either.
+func Do(params *Params) error {
+	consumer := params.Consumer
+	if consumer == nil {
+		consumer = &state.Consumer{}
+	}
+
+	f, err := eos.Open(params.File, option.WithConsumer(consumer))
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -215,104 +246,106 @@ func Do(consumer *state.Consumer, file string, upstream bool) error {
 
 	consumer.Opf("Auditing (%s)...", stats.Name())
 
-	var impl ZipImpl
-	if upstream {
-		consumer.Opf("Using upstream zip implementation")
-		impl = &upstreamImpl{}
-	} else {
-		consumer.Opf("Using itchio/arkive zip implementation")
-		impl = &itchioImpl{}
-	}
-
-	var foundErrors []string
-
-	markError := func(path string, message string, args ...interface{}) {
-		formatted := fmt.Sprintf(message, args...)
-		fullMessage := fmt.Sprintf("(%s): %s", path, formatted)
-		foundErrors = append(foundErrors, fullMessage)
+	impl, err := getImpl(consumer, f, stats.Name(), params.Upstream)
+	if err != nil {
+		return err
 	}
 
-	paths := make(map[string]int)
+	a := newAuditor()
 	started := false
 
-	err = impl.EachEntry(consumer, f, stats.Size(), func(index int, name string, nonutf8 bool, uncompressedSize int64, rc io.ReadCloser, numEntries int) error {
+	err = impl.EachEntry(consumer, f, stats.Size(), func(entry *Entry, rc io.Reader, numEntries int) error {
 		if !started {
 			comm.StartProgress()
 			started = true
 		}
-		path := boar.CleanFileName(name)
-
-		if nonutf8 {
-			for _, r := range name {
-				if r > 127 {
-					markError(path, "Entry has non-ASCII characters but isn't encoded as utf-8")
-					break
-				}
-			}
-		}
-
-		comm.Progress(float64(index) / float64(numEntries))
-		comm.ProgressLabel(path)
-
-		if previousIndex, ok := paths[path]; ok {
-			consumer.Warnf("Duplicate path (%s) at indices (%d) and (%d)", path, index, previousIndex)
+		if numEntries > 0 {
+			comm.Progress(float64(entry.Index) / float64(numEntries))
 		}
-		paths[path] = index
+		comm.ProgressLabel(boar.CleanFileName(entry.Name))
 
-		actualSize, err := io.Copy(ioutil.Discard, rc)
-		if err != nil {
-			markError(path, err.Error())
-			return nil
-		}
-
-		if actualSize != uncompressedSize {
-			err := fmt.Errorf("Dictionary says (%s) is %s (%d bytes), but it's actually %s (%d bytes)",
-				path,
-				progress.FormatBytes(uncompressedSize),
-				uncompressedSize,
-				progress.FormatBytes(actualSize),
-				actualSize,
-			)
-			return errors.WithStack(err)
-		}
-		return nil
+		return a.check(entry, rc)
 	})
 	comm.EndProgress()
 	if err != nil {
-		return errors.WithStack(err)
+		if a.numEntries == 0 {
+			return errors.WithStack(err)
+		}
+		// the archive is damaged after some point, what came
+		// before can still be salvaged
+		a.markError(stats.Name(), "Couldn't read past entry %d: %s", a.numEntries, err.Error())
 	}
 
-	if len(foundErrors) > 0 {
+	if len(a.foundErrors) > 0 {
 		consumer.Infof("================================================")
-		consumer.Statf("Found %d errors:", len(foundErrors))
-		for _, fullMessage := range foundErrors {
+		consumer.Statf("Found %d errors:", len(a.foundErrors))
+		for _, fullMessage := range a.foundErrors {
 			consumer.Logf(" ✖ %s", fullMessage)
 		}
 		consumer.Infof("================================================")
-		return fmt.Errorf("Found %d errors in zip file", len(foundErrors))
+	} else {
+		consumer.Statf("Everything checks out!")
 	}
 
-	consumer.Statf("Everything checks out!")
+	if params.RepairPath != "" {
+		err = a.repair(consumer, impl, f, stats, params.RepairPath)
+		if err != nil {
+			return err
+		}
+
+		return Do(&Params{
+			File:     params.RepairPath,
+			Consumer: consumer,
+		})
+	}
 
+	if len(a.foundErrors) > 0 {
+		return fmt.Errorf("Found %d errors in archive", len(a.foundErrors))
+	}
 	return nil
 }
 
-// zip implementation types
+// archive implementation types
+
+// An Entry is what all archive formats have in common
+type Entry struct {
+	// Index is the position of the entry in the archive
+	Index int
+	// Name is the path of the entry, as stored in the archive
+	Name string
+	// NonUTF8 is set for zip entries whose name isn't flagged as utf-8
+	NonUTF8 bool
+
+	Kind     savior.EntryKind
+	Mode     os.FileMode
+	Modified time.Time
+
+	// UncompressedSize is the size the archive says the entry has
+	UncompressedSize int64
+	// Linkname is the target of symlinks, for formats that store it
+	// in metadata rather than in contents
+	Linkname string
+	// Unsupported is set for entries butler doesn't extract, like hard links
+	Unsupported string
+}
 
-type EachEntryFunc func(index int, name string, nonutf8 bool, uncompressedSize int64, rc io.ReadCloser, numEntries int) error
+// EachEntryFunc is called with the contents of each entry. numEntries is 0
+// if the format doesn't know in advance, in which case the implementation
+// reports progress.
+type EachEntryFunc func(entry *Entry, rc io.Reader, numEntries int) error
 
-type ZipImpl interface {
-	EachEntry(consumer *state.Consumer, r io.ReaderAt, size int64, cb EachEntryFunc) error
+type ArchiveImpl interface {
+	EachEntry(consumer *state.Consumer, f eos.File, size int64, cb EachEntryFunc) error
 }
 
 // itchio zip impl
 
 type itchioImpl struct{}
 
-var _ ZipImpl = (*itchioImpl)(nil)
+var _ ArchiveImpl = (*itchioImpl)(nil)
 
-func (a *itchioImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size int64, cb EachEntryFunc) error {
-	zr, err := itchiozip.NewReader(r, size)
+func (a *itchioImpl) EachEntry(consumer *state.Consumer, f eos.File, size int64, cb EachEntryFunc) error {
+	zr, err := itchiozip.NewReader(f, size)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -345,7 +378,7 @@ func (a *itchioImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size int
 			}
 		}
 
-		err = cb(index, entry.Name, entry.NonUTF8, int64(entry.UncompressedSize64), rc, numEntries)
+		err = cb(zipEntry(index, entry.Name, entry.NonUTF8, entry.Mode(), entry.Modified, entry.UncompressedSize64), rc, numEntries)
 		rc.Close()
 		if err != nil {
 			return errors.WithStack(err)
@@ -359,10 +392,10 @@ func (a *itchioImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size int
 
 type upstreamImpl struct{}
 
-var _ ZipImpl = (*upstreamImpl)(nil)
+var _ ArchiveImpl = (*upstreamImpl)(nil)
 
-func (a *upstreamImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size int64, cb EachEntryFunc) error {
-	zr, err := upstreamzip.NewReader(r, size)
+func (a *upstreamImpl) EachEntry(consumer *state.Consumer, f eos.File, size int64, cb EachEntryFunc) error {
+	zr, err := upstreamzip.NewReader(f, size)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -388,7 +421,7 @@ func (a *upstreamImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size i
 			return errors.WithStack(err)
 		}
 
-		err = cb(index, entry.Name, entry.NonUTF8, int64(entry.UncompressedSize64), rc, numEntries)
+		err = cb(zipEntry(index, entry.Name, entry.NonUTF8, entry.Mode(), entry.Modified, entry.UncompressedSize64), rc, numEntries)
 		rc.Close()
 		if err != nil {
 			return errors.WithStack(err)
@@ -398,6 +431,26 @@ func (a *upstreamImpl) EachEntry(consumer *state.Consumer, r io.ReaderAt, size i
 	return nil
 }
 
+func zipEntry(index int, name string, nonutf8 bool, mode os.FileMode, modified time.Time, uncompressedSize uint64) *Entry {
+	entry := &Entry{
+		Index:            index,
+		Name:             name,
+		NonUTF8:          nonutf8,
+		Kind:             savior.EntryKindFile,
+		Mode:             mode,
+		Modified:         modified,
+		UncompressedSize: int64(uncompressedSize),
+	}
+	switch {
+	case mode.IsDir() || strings.HasSuffix(name, "/"):
+		entry.Kind = savior.EntryKindDir
+	case mode&os.ModeSymlink != 0:
+		// zip symlinks store their target as contents
+		entry.Kind = savior.EntryKindSymlink
+	}
+	return entry
+}
+
 // utils
 
 func printExtras(consumer *state.Consumer, size int64, compressedSize int64, uncompressedSize int64, comment string) {
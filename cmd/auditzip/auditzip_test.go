@@ -1,11 +1,20 @@
 package auditzip_test
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/itchio/butler/cmd/auditzip"
 	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
 
 	_ "github.com/itchio/boar/lzmasupport"
 )
@@ -18,9 +27,157 @@ func TestProtoZip(t *testing.T) {
 	}
 
 	upstream := true
-	wtest.Must(t, auditzip.Do(consumer, "./testdata/proto.zip", upstream))
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: "./testdata/proto.zip", Upstream: upstream, Consumer: consumer}))
 
 	upstream = false
-	wtest.Must(t, auditzip.Do(consumer, "./testdata/proto.zip", upstream))
-	wtest.Must(t, auditzip.Do(consumer, "./testdata/proto-with-lzma.zip", upstream))
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: "./testdata/proto.zip", Upstream: upstream, Consumer: consumer}))
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: "./testdata/proto-with-lzma.zip", Upstream: upstream, Consumer: consumer}))
+}
+
+func TestAuditAndRepairZip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditzip")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(fh *zip.FileHeader, contents string) {
+		w, err := zw.CreateHeader(fh)
+		wtest.Must(t, err)
+		_, err = w.Write([]byte(contents))
+		wtest.Must(t, err)
+	}
+	symlink := func(name string, dest string) {
+		fh := &zip.FileHeader{Name: name}
+		fh.SetMode(os.ModeSymlink | 0777)
+		add(fh, dest)
+	}
+	add(&zip.FileHeader{Name: "readme.txt", Method: zip.Deflate}, "hello")
+	add(&zip.FileHeader{Name: "../evil.txt"}, "evil")
+	add(&zip.FileHeader{Name: "/etc/passwd"}, "root")
+	add(&zip.FileHeader{Name: "Data/a.txt"}, "a")
+	add(&zip.FileHeader{Name: "data/b.txt"}, "b")
+	add(&zip.FileHeader{Name: "readme.txt", Method: zip.Deflate}, "hello again")
+	symlink("link", "../../outside")
+	symlink("ok-link", "readme.txt")
+	add(&zip.FileHeader{Name: "corrupt.bin"}, "corrupt me please")
+	add(&zip.FileHeader{Name: "caf\x82.txt", NonUTF8: true}, "coffee")
+	wtest.Must(t, zw.Close())
+
+	zipBytes := buf.Bytes()
+	i := bytes.Index(zipBytes, []byte("corrupt me"))
+	zipBytes[i] = 'C'
+
+	broken := filepath.Join(dir, "broken.zip")
+	wtest.Must(t, ioutil.WriteFile(broken, zipBytes, 0644))
+
+	for _, upstream := range []bool{false, true} {
+		err = auditzip.Do(&auditzip.Params{File: broken, Upstream: upstream, Consumer: testConsumer(t)})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "Found 7 errors")
+	}
+
+	repaired := filepath.Join(dir, "repaired.zip")
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: broken, RepairPath: repaired, Consumer: testConsumer(t)}))
+
+	contents := readZip(t, repaired)
+	assert.EqualValues(t, map[string]string{
+		"readme.txt": "hello again",
+		"evil.txt":   "evil",
+		"etc/passwd": "root",
+		"Data/a.txt": "a",
+		"Data/b.txt": "b",
+		"ok-link":    "readme.txt",
+		"café.txt":   "coffee",
+	}, contents)
+}
+
+func TestAuditAndRepairTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "auditzip")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	add := func(hdr *tar.Header, contents string) {
+		hdr.Size = int64(len(contents))
+		wtest.Must(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(contents))
+		wtest.Must(t, err)
+	}
+	add(&tar.Header{Name: "game/", Typeflag: tar.TypeDir, Mode: 0755}, "")
+	add(&tar.Header{Name: "game/run.sh", Typeflag: tar.TypeReg, Mode: 0755}, "#!/bin/sh")
+	add(&tar.Header{Name: "game/RUN.sh", Typeflag: tar.TypeReg, Mode: 0644}, "#!/bin/bash")
+	add(&tar.Header{Name: "game/lib", Typeflag: tar.TypeLink, Linkname: "game/run.sh"}, "")
+	add(&tar.Header{Name: "game/up", Typeflag: tar.TypeSymlink, Linkname: "../../x"}, "")
+	add(&tar.Header{Name: "game/ok", Typeflag: tar.TypeSymlink, Linkname: "run.sh"}, "")
+	wtest.Must(t, tw.Close())
+	wtest.Must(t, gw.Close())
+
+	broken := filepath.Join(dir, "broken.tar.gz")
+	wtest.Must(t, ioutil.WriteFile(broken, buf.Bytes(), 0644))
+
+	err = auditzip.Do(&auditzip.Params{File: broken, Consumer: testConsumer(t)})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "Found 3 errors")
+
+	repaired := filepath.Join(dir, "repaired.zip")
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: broken, RepairPath: repaired, Consumer: testConsumer(t)}))
+
+	contents := readZip(t, repaired)
+	assert.EqualValues(t, map[string]string{
+		"game/":       "",
+		"game/run.sh": "#!/bin/bash",
+		"game/ok":     "run.sh",
+	}, contents)
+
+	// truncated archives get salvaged up to the damage
+	buf.Reset()
+	gw = gzip.NewWriter(&buf)
+	tw = tar.NewWriter(gw)
+	rng := rand.New(rand.NewSource(1))
+	for _, name := range []string{"first.bin", "second.bin"} {
+		data := make([]byte, 64*1024)
+		rng.Read(data)
+		add(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644}, string(data))
+	}
+	wtest.Must(t, tw.Close())
+	wtest.Must(t, gw.Close())
+
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	wtest.Must(t, ioutil.WriteFile(truncated, buf.Bytes()[:100*1024], 0644))
+
+	err = auditzip.Do(&auditzip.Params{File: truncated, Consumer: testConsumer(t)})
+	assert.Error(t, err)
+
+	wtest.Must(t, auditzip.Do(&auditzip.Params{File: truncated, RepairPath: repaired, Consumer: testConsumer(t)}))
+	contents = readZip(t, repaired)
+	assert.Len(t, contents, 1)
+	assert.Len(t, contents["first.bin"], 64*1024)
+}
+
+func testConsumer(t *testing.T) *state.Consumer {
+	return &state.Consumer{
+		OnMessage: func(level string, message string) {
+			t.Logf("%s %s", level, message)
+		},
+	}
+}
+
+func readZip(t *testing.T, file string) map[string]string {
+	zr, err := zip.OpenReader(file)
+	wtest.Must(t, err)
+	defer zr.Close()
+
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		wtest.Must(t, err)
+		buf, err := ioutil.ReadAll(rc)
+		rc.Close()
+		wtest.Must(t, err)
+		contents[f.Name] = string(buf)
+	}
+	return contents
 }
@@ -0,0 +1,317 @@
+package auditzip
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/itchio/boar/szextractor"
+	"github.com/itchio/boar/szextractor/xzsource"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/counter"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+var (
+	gzipMagic     = []byte{0x1f, 0x8b}
+	bzip2Magic    = []byte("BZh")
+	xzMagic       = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	sevenZipMagic = []byte{'7', 'z', 0xbc, 0xaf, 0x27, 0x1c}
+)
+
+// getImpl picks an implementation from the first bytes of the file,
+// anything we don't recognize is read as a zip file, since those
+// can have anything in front of them (self-extracting archives, etc.)
+func getImpl(consumer *state.Consumer, f eos.File, name string, upstream bool) (ArchiveImpl, error) {
+	header := make([]byte, 512)
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return nil, errors.WithStack(err)
+	}
+	header = header[:n]
+
+	var impl ArchiveImpl
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		impl = &tarImpl{compression: "gzip"}
+	case bytes.HasPrefix(header, bzip2Magic):
+		impl = &tarImpl{compression: "bzip2"}
+	case bytes.HasPrefix(header, xzMagic):
+		impl = &tarImpl{compression: "xz"}
+	case bytes.HasPrefix(header, sevenZipMagic):
+		consumer.Opf("Using 7-zip")
+		return &sevenZipImpl{}, nil
+	case len(header) >= 262 && string(header[257:262]) == "ustar",
+		strings.HasSuffix(strings.ToLower(name), ".tar"):
+		impl = &tarImpl{}
+	case upstream:
+		consumer.Opf("Using upstream zip implementation")
+		return &upstreamImpl{}, nil
+	default:
+		consumer.Opf("Using itchio/arkive zip implementation")
+		return &itchioImpl{}, nil
+	}
+
+	consumer.Opf("Reading as %s", impl)
+	return impl, nil
+}
+
+// tar impl
+
+type tarImpl struct {
+	// compression is one of "", "gzip", "bzip2", "xz"
+	compression string
+}
+
+var _ ArchiveImpl = (*tarImpl)(nil)
+
+func (ti *tarImpl) String() string {
+	switch ti.compression {
+	case "gzip":
+		return "tar.gz"
+	case "bzip2":
+		return "tar.bz2"
+	case "xz":
+		return "tar.xz"
+	default:
+		return "tar"
+	}
+}
+
+func (ti *tarImpl) EachEntry(consumer *state.Consumer, f eos.File, size int64, cb EachEntryFunc) error {
+	cr := counter.NewReaderCallback(func(count int64) {
+		if size > 0 {
+			comm.Progress(float64(count) / float64(size))
+		}
+	}, io.NewSectionReader(f, 0, size))
+
+	// the standard library checks the CRCs of gzip and bzip2 streams,
+	// xz goes through 7-zip, which does its own checking
+	var r io.Reader
+	switch ti.compression {
+	case "gzip":
+		gr, err := gzip.NewReader(cr)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer gr.Close()
+		r = gr
+	case "bzip2":
+		r = bzip2.NewReader(cr)
+	case "xz":
+		xs, err := xzsource.New(f, consumer)
+		if err != nil {
+			return errors.Wrap(err, "opening xz stream")
+		}
+		_, err = xs.Resume(nil)
+		if err != nil {
+			return errors.Wrap(err, "opening xz stream")
+		}
+		r = xs
+	default:
+		r = cr
+	}
+
+	tr := tar.NewReader(r)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return errors.WithStack(err)
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			// not an entry, just defaults for the following ones
+			index--
+			continue
+		}
+
+		entry := &Entry{
+			Index:            index,
+			Name:             hdr.Name,
+			Kind:             savior.EntryKindFile,
+			Mode:             os.FileMode(hdr.Mode).Perm(),
+			Modified:         hdr.ModTime,
+			UncompressedSize: hdr.Size,
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			entry.Kind = savior.EntryKindDir
+		case tar.TypeSymlink:
+			entry.Kind = savior.EntryKindSymlink
+			entry.Linkname = hdr.Linkname
+		case tar.TypeLink:
+			entry.Unsupported = fmt.Sprintf("Entry is a hard link to (%s), which butler doesn't extract", hdr.Linkname)
+		default:
+			if !hdr.FileInfo().Mode().IsRegular() {
+				entry.Unsupported = fmt.Sprintf("Entry has type (%c), which butler doesn't extract", hdr.Typeflag)
+			}
+		}
+
+		err = cb(entry, tr, 0)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	// make sure we get to the end of the compressed stream, that's
+	// where the checksums are
+	_, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return errors.Wrap(err, "reading past the end of the tar archive")
+	}
+
+	return nil
+}
+
+// 7-zip impl
+
+type sevenZipImpl struct{}
+
+var _ ArchiveImpl = (*sevenZipImpl)(nil)
+
+func (si *sevenZipImpl) EachEntry(consumer *state.Consumer, f eos.File, size int64, cb EachEntryFunc) error {
+	ex, err := szextractor.New(f, consumer)
+	if err != nil {
+		return errors.Wrap(err, "opening with 7-zip")
+	}
+
+	numEntries := 0
+	if el, ok := ex.(interface{ Entries() []*savior.Entry }); ok {
+		numEntries = len(el.Entries())
+	}
+
+	sink := &callbackSink{
+		cb:         cb,
+		numEntries: numEntries,
+	}
+	defer sink.Close()
+
+	_, err = ex.Resume(nil, sink)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return sink.Close()
+}
+
+// callbackSink passes everything an extractor extracts to an EachEntryFunc
+type callbackSink struct {
+	cb         EachEntryFunc
+	numEntries int
+
+	index  int
+	writer *callbackWriter
+}
+
+var _ savior.Sink = (*callbackSink)(nil)
+
+func (cs *callbackSink) entry(e *savior.Entry) *Entry {
+	entry := &Entry{
+		Index:            cs.index,
+		Name:             e.CanonicalPath,
+		Kind:             e.Kind,
+		Mode:             e.Mode,
+		UncompressedSize: e.UncompressedSize,
+		Linkname:         e.Linkname,
+	}
+	cs.index++
+	return entry
+}
+
+func (cs *callbackSink) Mkdir(e *savior.Entry) error {
+	err := cs.closeWriter()
+	if err != nil {
+		return err
+	}
+	return cs.cb(cs.entry(e), bytes.NewReader(nil), cs.numEntries)
+}
+
+func (cs *callbackSink) Symlink(e *savior.Entry, linkname string) error {
+	err := cs.closeWriter()
+	if err != nil {
+		return err
+	}
+	entry := cs.entry(e)
+	entry.Linkname = linkname
+	return cs.cb(entry, bytes.NewReader(nil), cs.numEntries)
+}
+
+func (cs *callbackSink) GetWriter(e *savior.Entry) (savior.EntryWriter, error) {
+	err := cs.closeWriter()
+	if err != nil {
+		return nil, err
+	}
+
+	entry := cs.entry(e)
+	pr, pw := io.Pipe()
+	cw := &callbackWriter{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := cs.cb(entry, pr, cs.numEntries)
+		// let the extractor finish writing if we stopped reading early
+		io.Copy(ioutil.Discard, pr)
+		cw.done <- err
+	}()
+	cs.writer = cw
+	return cw, nil
+}
+
+func (cs *callbackSink) Preallocate(e *savior.Entry) error {
+	return nil
+}
+
+func (cs *callbackSink) Nuke() error {
+	return nil
+}
+
+func (cs *callbackSink) Close() error {
+	return cs.closeWriter()
+}
+
+func (cs *callbackSink) closeWriter() error {
+	if cs.writer == nil {
+		return nil
+	}
+	cw := cs.writer
+	cs.writer = nil
+	return cw.Close()
+}
+
+type callbackWriter struct {
+	pw     *io.PipeWriter
+	done   chan error
+	closed bool
+	err    error
+}
+
+var _ savior.EntryWriter = (*callbackWriter)(nil)
+
+func (cw *callbackWriter) Write(buf []byte) (int, error) {
+	return cw.pw.Write(buf)
+}
+
+func (cw *callbackWriter) Sync() error {
+	return nil
+}
+
+func (cw *callbackWriter) Close() error {
+	if !cw.closed {
+		cw.closed = true
+		cw.pw.Close()
+		cw.err = <-cw.done
+	}
+	return cw.err
+}
@@ -0,0 +1,127 @@
+package auditzip
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	itchiozip "github.com/itchio/arkive/zip"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+// repair reads the archive a second time and writes the entries planned
+// while auditing to a new .zip file, with clean names, the same
+// permissions mkzip uses, and only the store and deflate methods.
+func (a *auditor) repair(consumer *state.Consumer, impl ArchiveImpl, f eos.File, stats os.FileInfo, repairPath string) error {
+	names := make(map[int]string)
+	for name, pe := range a.planned {
+		names[pe.index] = name
+	}
+	numPlanned := len(names)
+
+	consumer.Opf("Writing repaired archive to (%s)...", repairPath)
+
+	out, err := os.Create(repairPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+
+	zw := itchiozip.NewWriter(out)
+
+	comm.StartProgress()
+	err = impl.EachEntry(&state.Consumer{}, f, stats.Size(), func(entry *Entry, rc io.Reader, numEntries int) error {
+		if numEntries > 0 {
+			comm.Progress(float64(entry.Index) / float64(numEntries))
+		}
+
+		name, ok := names[entry.Index]
+		if !ok {
+			return nil
+		}
+		delete(names, entry.Index)
+
+		err := writeEntry(zw, name, entry, rc, stats)
+		if err != nil {
+			return errors.Wrapf(err, "writing (%s)", name)
+		}
+		return nil
+	})
+	comm.EndProgress()
+	if err != nil && len(names) > 0 {
+		// it's fine to run into the damage we saw while auditing,
+		// as long as we got everything that came before
+		return errors.WithStack(err)
+	}
+
+	err = zw.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = out.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	consumer.Statf("Wrote %d entries", numPlanned)
+	if dropped := a.numEntries - numPlanned; dropped > 0 {
+		consumer.Warnf("Left out %d entries that were broken, unsafe or overwritten by later ones", dropped)
+	}
+	return nil
+}
+
+func writeEntry(zw *itchiozip.Writer, name string, entry *Entry, rc io.Reader, stats os.FileInfo) error {
+	fh := &itchiozip.FileHeader{
+		Name:   name,
+		Method: itchiozip.Store,
+	}
+	modified := entry.Modified
+	if modified.IsZero() {
+		modified = stats.ModTime()
+	}
+	fh.Modified = modified.UTC()
+
+	switch entry.Kind {
+	case savior.EntryKindDir:
+		fh.Name += "/"
+		fh.SetMode(os.ModeDir | 0755)
+		_, err := zw.CreateHeader(fh)
+		return errors.WithStack(err)
+	case savior.EntryKindSymlink:
+		linkname := entry.Linkname
+		if linkname == "" {
+			buf, err := ioutil.ReadAll(rc)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			linkname = string(buf)
+		}
+
+		fh.SetMode(os.ModeSymlink | 0777)
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = w.Write([]byte(linkname))
+		return errors.WithStack(err)
+	default:
+		mode := os.FileMode(0644)
+		if entry.Mode&0111 != 0 {
+			mode = 0755
+		}
+		fh.SetMode(mode)
+		fh.Method = itchiozip.Deflate
+
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		_, err = io.Copy(w, rc)
+		return errors.WithStack(err)
+	}
+}
@@ -0,0 +1,140 @@
+package builds
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/itchio/butler/cmd/status"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	target      *string
+	userVersion *string
+	limit       *int
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("builds", "List the builds of a channel, most recent first. See `butler fetch --build` to download one of them.")
+	args.target = cmd.Arg("target", "Which user/project:channel to list builds of, for example 'leafo/x-moon:win-64'").Required().String()
+	args.userVersion = cmd.Flag("userversion", "Only list builds with this user version").String()
+	args.limit = cmd.Flag("limit", "How many builds to list at most, 0 for all of them").Default("20").Int()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	go ctx.DoVersionCheck()
+
+	client, err := ctx.AuthenticateViaOauth()
+	ctx.Must(errors.Wrap(err, "authenticating"))
+
+	builds, err := List(client, *args.target)
+	ctx.Must(err)
+
+	if *args.userVersion != "" {
+		builds = FilterByUserVersion(builds, *args.userVersion)
+	}
+	if *args.limit > 0 && len(builds) > *args.limit {
+		builds = builds[:*args.limit]
+	}
+
+	comm.ResultOrPrint(builds, func() {
+		printBuilds(builds)
+	})
+}
+
+// List returns the builds of a channel, most recent first.
+// specStr is of the form project:channel
+func List(client *itchio.Client, specStr string) ([]*itchio.Build, error) {
+	spec, err := itchio.ParseSpec(specStr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing target '%s'", specStr)
+	}
+
+	err = spec.EnsureChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	chanInfo, err := client.GetChannel(spec.Target, spec.Channel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up channel %s", spec.Channel)
+	}
+
+	ch := chanInfo.Channel
+	if ch == nil || ch.Upload == nil {
+		return nil, errors.Errorf("channel %s not found for %s", spec.Channel, spec.Target)
+	}
+
+	res, err := client.ListUploadBuilds(itchio.ListUploadBuildsParams{
+		UploadID: ch.Upload.ID,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "listing builds of channel %s", spec.Channel)
+	}
+
+	builds := res.Builds
+	sort.SliceStable(builds, func(i, j int) bool {
+		return builds[i].ID > builds[j].ID
+	})
+	return builds, nil
+}
+
+// FilterByUserVersion returns the builds with the given user version,
+// in the same order.
+func FilterByUserVersion(builds []*itchio.Build, userVersion string) []*itchio.Build {
+	var res []*itchio.Build
+	for _, b := range builds {
+		if b.UserVersion == userVersion {
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// ArchiveSize returns the size of a build's archive, or 0 if it
+// doesn't have one yet.
+func ArchiveSize(build *itchio.Build) int64 {
+	f := itchio.FindBuildFileEx(itchio.BuildFileTypeArchive, itchio.BuildFileSubTypeDefault, build.Files)
+	if f == nil {
+		return 0
+	}
+	return f.Size
+}
+
+func printBuilds(builds []*itchio.Build) {
+	if len(builds) == 0 {
+		comm.Logf("No builds found")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Build", "Version", "User version", "Created", "Size"})
+
+	for _, b := range builds {
+		created := ""
+		if b.CreatedAt != nil {
+			created = b.CreatedAt.Local().Format("2006-01-02 15:04")
+		}
+
+		size := ""
+		if s := ArchiveSize(b); s > 0 {
+			size = progress.FormatBytes(s)
+		}
+
+		table.Append([]string{
+			status.BuildState(b),
+			fmt.Sprintf("%d", b.Version),
+			b.UserVersion,
+			created,
+			size,
+		})
+	}
+	table.Render()
+}
@@ -0,0 +1,36 @@
+package builds_test
+
+import (
+	"testing"
+
+	"github.com/itchio/butler/cmd/builds"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterByUserVersion(t *testing.T) {
+	all := []*itchio.Build{
+		{ID: 30, UserVersion: "1.2"},
+		{ID: 20, UserVersion: "1.1"},
+		{ID: 15, UserVersion: "1.2"},
+		{ID: 10},
+	}
+
+	matches := builds.FilterByUserVersion(all, "1.2")
+	assert.EqualValues(t, 2, len(matches))
+	assert.EqualValues(t, 30, matches[0].ID)
+	assert.EqualValues(t, 15, matches[1].ID)
+
+	assert.Empty(t, builds.FilterByUserVersion(all, "2.0"))
+}
+
+func TestArchiveSize(t *testing.T) {
+	b := &itchio.Build{
+		Files: []*itchio.BuildFile{
+			{Type: itchio.BuildFileTypeSignature, SubType: itchio.BuildFileSubTypeDefault, Size: 100},
+			{Type: itchio.BuildFileTypeArchive, SubType: itchio.BuildFileSubTypeDefault, State: itchio.BuildFileStateUploaded, Size: 4096},
+		},
+	}
+	assert.EqualValues(t, 4096, builds.ArchiveSize(b))
+	assert.EqualValues(t, 0, builds.ArchiveSize(&itchio.Build{}))
+}
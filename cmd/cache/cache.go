@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/alecthomas/units"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/sigcache"
+	"github.com/itchio/httpkit/progress"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+var pruneArgs = struct {
+	maxSize *string
+	all     *bool
+}{}
+
+func Register(ctx *mansion.Context) {
+	parentCmd := ctx.App.Command("cache", "Manage the local cache of build signatures")
+
+	{
+		cmd := parentCmd.Command("ls", "List cached build signatures, most recently used first")
+		ctx.Register(cmd, doList)
+	}
+
+	{
+		cmd := parentCmd.Command("prune", "Remove the least recently used build signatures")
+		pruneArgs.maxSize = cmd.Flag("max-size", "Size to shrink the cache to, for example '500MiB' (defaults to --signature-cache-size)").String()
+		pruneArgs.all = cmd.Flag("all", "Remove all cached signatures").Bool()
+		ctx.Register(cmd, doPrune)
+	}
+}
+
+func doList(ctx *mansion.Context) {
+	ctx.Must(List(ctx))
+}
+
+func List(ctx *mansion.Context) error {
+	sigCache := ctx.SignatureCache()
+	if !sigCache.Enabled() {
+		comm.Logf("The signature cache is disabled")
+	}
+
+	entries, err := sigCache.List()
+	if err != nil {
+		return errors.Wrap(err, "listing signature cache")
+	}
+
+	if len(entries) == 0 {
+		comm.Logf("No signatures cached in %s", sigCache.Dir)
+		return nil
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Build", "Size", "Last used", "Hash"})
+	for _, e := range entries {
+		table.Append([]string{
+			fmt.Sprintf("#%d", e.BuildID),
+			progress.FormatBytes(e.Size),
+			e.LastUsed.Format("2006-01-02 15:04"),
+			e.Hash[:12],
+		})
+	}
+	table.Render()
+
+	comm.Statf("%d signatures, %s total (limit is %s)", len(entries), progress.FormatBytes(sigcache.TotalSize(entries)), progress.FormatBytes(sigCache.MaxSize))
+	return nil
+}
+
+func doPrune(ctx *mansion.Context) {
+	sigCache := ctx.SignatureCache()
+	maxSize := sigCache.MaxSize
+
+	switch {
+	case *pruneArgs.all:
+		maxSize = 0
+	case *pruneArgs.maxSize != "":
+		size, err := units.ParseBase2Bytes(*pruneArgs.maxSize)
+		if err != nil {
+			ctx.Must(errors.Wrap(err, "parsing --max-size"))
+		}
+		maxSize = int64(size)
+	}
+
+	ctx.Must(Prune(ctx, maxSize))
+}
+
+func Prune(ctx *mansion.Context, maxSize int64) error {
+	sigCache := ctx.SignatureCache()
+
+	evicted, err := sigCache.Prune(maxSize)
+	if err != nil {
+		return errors.Wrap(err, "pruning signature cache")
+	}
+
+	for _, e := range evicted {
+		comm.Debugf("Removed signature for build #%d", e.BuildID)
+	}
+
+	remaining, err := sigCache.List()
+	if err != nil {
+		return errors.Wrap(err, "listing signature cache")
+	}
+
+	comm.Statf("Removed %d signatures, %s left in cache", len(evicted), progress.FormatBytes(sigcache.TotalSize(remaining)))
+	return nil
+}
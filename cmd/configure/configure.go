@@ -9,6 +9,7 @@ import (
 	"github.com/itchio/butler/mansion"
 	"github.com/itchio/dash"
 	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
 	"github.com/pkg/errors"
 )
 
@@ -37,6 +38,10 @@ type Params struct {
 	ArchFilter string
 	NoFilter   bool
 	Consumer   *state.Consumer
+	// Filter decides which files to look at, defaults to filtering.FilterPaths
+	Filter tlc.FilterFunc
+	// NoFixPermissions leaves the permissions of executables as they are
+	NoFixPermissions bool
 }
 
 func do(ctx *mansion.Context) {
@@ -60,11 +65,16 @@ func Do(params *Params) (*dash.Verdict, error) {
 
 	root := params.Path
 
+	filter := params.Filter
+	if filter == nil {
+		filter = filtering.FilterPaths
+	}
+
 	startTime := time.Now()
 
 	verdict, err := dash.Configure(root, &dash.ConfigureParams{
 		Consumer: consumer,
-		Filter:   filtering.FilterPaths,
+		Filter:   filter,
 	})
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -76,18 +86,20 @@ func Do(params *Params) (*dash.Verdict, error) {
 		}
 	}
 
-	fixedExecs, err := dash.FixPermissions(verdict, &dash.FixPermissionsParams{
-		Consumer: consumer,
-		DryRun:   false,
-	})
-	if err != nil {
-		return nil, errors.WithStack(err)
-	}
+	if !params.NoFixPermissions {
+		fixedExecs, err := dash.FixPermissions(verdict, &dash.FixPermissionsParams{
+			Consumer: consumer,
+			DryRun:   false,
+		})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
 
-	if len(fixedExecs) > 0 {
-		consumer.Statf("Fixed permissions of %d executables:", len(fixedExecs))
-		for _, fixedExec := range fixedExecs {
-			consumer.Logf("  - %s", fixedExec)
+		if len(fixedExecs) > 0 {
+			consumer.Statf("Fixed permissions of %d executables:", len(fixedExecs))
+			for _, fixedExec := range fixedExecs {
+				consumer.Logf("  - %s", fixedExec)
+			}
 		}
 	}
 
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/itchio/butler/buildsource"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
@@ -14,7 +15,6 @@ import (
 	"github.com/itchio/wharf/counter"
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/eos/option"
-	"github.com/itchio/wharf/pools"
 	"github.com/itchio/wharf/pools/nullpool"
 	"github.com/itchio/wharf/pwr"
 	"github.com/itchio/wharf/tlc"
@@ -31,9 +31,9 @@ var args = struct {
 }{}
 
 func Register(ctx *mansion.Context) {
-	cmd := ctx.App.Command("diff", "(Advanced) Compute the difference between two directories or .zip archives. Stores the patch in `patch.pwr`, and a signature in `patch.pwr.sig` for integrity checks and further diff.")
-	args.old = cmd.Arg("old", "Directory or .zip archive (slower) with older files, or signature file generated from old directory.").Required().String()
-	args.new = cmd.Arg("new", "Directory or .zip archive (slower) with newer files").Required().String()
+	cmd := ctx.App.Command("diff", "(Advanced) Compute the difference between two directories or archives. Stores the patch in `patch.pwr`, and a signature in `patch.pwr.sig` for integrity checks and further diff.")
+	args.old = cmd.Arg("old", "Directory or archive (slower) with older files, or signature file generated from old directory.").Required().String()
+	args.new = cmd.Arg("new", "Directory or archive (slower) with newer files").Required().String()
 	args.patch = cmd.Arg("patch", "Path to write the patch file (recommended extension is `.pwr`) The signature file will be written to the same path, with .sig added to the end.").Default("patch.pwr").String()
 	args.verify = cmd.Flag("verify", "Make sure generated patch applies cleanly by applying it (slower)").Bool()
 	ctx.Register(cmd, do)
@@ -109,18 +109,29 @@ func Do(params *Params) error {
 		return nil
 	}
 
+	var targetSource *buildsource.Source
 	err = readAsSignature()
 
 	if err != nil {
 		if errors.Cause(err) == wire.ErrFormat || errors.Cause(err) == io.EOF {
-			// must be a container then
-			targetSignature.Container, err = tlc.WalkAny(params.Target, &tlc.WalkOpts{Filter: filtering.FilterPaths})
-			// Container (dir, archive, etc.)
-			comm.Opf("Hashing %s", params.Target)
+			// must be a container then (dir, archive, etc.)
+			// applying the patch to verify it needs random access to the target
+			targetSource, err = buildsource.Open(params.Target, &buildsource.Params{
+				WalkOpts:    &tlc.WalkOpts{Filter: filtering.FilterPaths},
+				Consumer:    comm.NewStateConsumer(),
+				NoStreaming: params.Verify,
+			})
+			if err != nil {
+				return errors.Wrap(err, "walking target")
+			}
+			defer targetSource.Close()
+			targetSignature.Container = targetSource.Container
+
+			comm.Opf("Hashing %s", targetSource.Describe())
 
 			comm.StartProgress()
 			var targetPool wsync.Pool
-			targetPool, err = pools.New(targetSignature.Container, params.Target)
+			targetPool, err = targetSource.NewPool()
 			if err != nil {
 				return errors.Wrap(err, "opening target as directory")
 			}
@@ -143,14 +154,17 @@ func Do(params *Params) error {
 
 	startTime = time.Now()
 
-	var sourceContainer *tlc.Container
-	sourceContainer, err = tlc.WalkAny(params.Source, &tlc.WalkOpts{Filter: filtering.FilterPaths})
+	source, err := buildsource.Open(params.Source, &buildsource.Params{
+		WalkOpts: &tlc.WalkOpts{Filter: filtering.FilterPaths},
+		Consumer: comm.NewStateConsumer(),
+	})
 	if err != nil {
 		return errors.Wrap(err, "walking source as directory")
 	}
+	defer source.Close()
+	sourceContainer := source.Container
 
-	var sourcePool wsync.Pool
-	sourcePool, err = pools.New(sourceContainer, params.Source)
+	sourcePool, err := source.NewPool()
 	if err != nil {
 		return errors.Wrap(err, "walking source as directory")
 	}
@@ -182,7 +196,7 @@ func Do(params *Params) error {
 		Compression: &params.Compression,
 	}
 
-	comm.Opf("Diffing %s", params.Source)
+	comm.Opf("Diffing %s", source.Describe())
 	comm.StartProgress()
 	err = dctx.WritePatch(context.Background(), patchCounter, signatureCounter)
 	if err != nil {
@@ -209,6 +223,11 @@ func Do(params *Params) error {
 
 	if params.Verify {
 		comm.Opf("Applying patch to verify it...")
+		targetPath := params.Target
+		if targetSource != nil {
+			targetPath = targetSource.LocalPath
+		}
+
 		_, err := signatureWriter.Seek(0, io.SeekStart)
 		if err != nil {
 			return errors.Wrap(err, "seeking to beginning of fresh signature file")
@@ -232,7 +251,7 @@ func Do(params *Params) error {
 				Container: sourceContainer,
 				Signature: signature,
 			},
-			TargetPath:      params.Target,
+			TargetPath:      targetPath,
 			TargetContainer: targetSignature.Container,
 
 			SourceContainer: sourceContainer,
@@ -0,0 +1,296 @@
+package diffremote
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/itchio/butler/buildsource"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/containerdiff"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	dir      *string
+	target   *string
+	fixPerms *bool
+	autoWrap *bool
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("diff-remote", "Show what pushing a directory would change, compared to the latest build of a channel. Doesn't create a build.")
+	args.dir = cmd.Arg("dir", "Directory, zip file or archive to compare").Required().String()
+	args.target = cmd.Arg("target", "Which user/project:channel to compare against, for example 'leafo/x-moon:win-64'").Required().String()
+	args.fixPerms = cmd.Flag("fix-permissions", "Detect Mac & Linux executables and adjust their permissions, like push does").Default("true").Bool()
+	args.autoWrap = cmd.Flag("auto-wrap", "Wrap macOS app bundles in a folder, like push does").Default("true").Bool()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	go ctx.DoVersionCheck()
+
+	res, err := Do(ctx, &Params{
+		Dir:      *args.dir,
+		Target:   *args.target,
+		FixPerms: *args.fixPerms,
+		AutoWrap: *args.autoWrap,
+	})
+	ctx.Must(err)
+
+	comm.ResultOrPrint(res, func() {
+		printResult(res)
+	})
+}
+
+// Params control what to compare
+type Params struct {
+	// Dir is the folder, zip file or archive that would be pushed
+	Dir string
+	// Target is of the form project:channel
+	Target string
+
+	FixPerms bool
+	AutoWrap bool
+}
+
+// Result lists what pushing Dir to Target would change
+type Result struct {
+	// BuildID is the build that was compared against, 0 if the channel has none
+	BuildID int64 `json:"buildId"`
+
+	*containerdiff.Changes
+
+	// FreshBytes estimates how much new data a patch would contain,
+	// before compression.
+	FreshBytes int64 `json:"freshBytes"`
+}
+
+// Do compares a local folder to the latest build of a channel, using
+// the build's signature, without uploading anything.
+func Do(ctx *mansion.Context, params *Params) (*Result, error) {
+	spec, err := itchio.ParseSpec(params.Target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing target '%s'", params.Target)
+	}
+
+	err = spec.EnsureChannel()
+	if err != nil {
+		return nil, err
+	}
+
+	consumer := comm.NewStateConsumer()
+
+	dir := params.Dir
+	walkOpts := &tlc.WalkOpts{
+		Filter: filtering.FilterPaths,
+	}
+	if params.AutoWrap {
+		walkOpts.AutoWrap(&dir, consumer)
+	}
+
+	// validating needs random access to files
+	source, err := buildsource.Open(dir, &buildsource.Params{
+		WalkOpts:    walkOpts,
+		Consumer:    consumer,
+		NoStreaming: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "walking directory")
+	}
+	defer source.Close()
+
+	if params.FixPerms {
+		pool, err := source.NewPool()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		// closes the pool
+		err = source.Container.FixPermissions(pool)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return nil, errors.Wrap(err, "authenticating")
+	}
+
+	chanInfo, err := client.GetChannel(spec.Target, spec.Channel)
+	if err != nil {
+		// channels are created by their first push
+		if apiErr, ok := itchio.AsAPIError(err); !ok || apiErr.StatusCode != 404 {
+			return nil, errors.Wrapf(err, "looking up channel %s", spec.Channel)
+		}
+		chanInfo = nil
+	}
+
+	// files with a different size are modified as far as we're concerned,
+	// the others need to be checked against the signature
+	var candidates []*tlc.File
+	compareFile := func(oldFile *tlc.File, newFile *tlc.File, c *containerdiff.Change) bool {
+		if oldFile.Size != newFile.Size {
+			c.OldSize = oldFile.Size
+			c.ChangedBytes = newFile.Size
+			return true
+		}
+		candidates = append(candidates, oldFile)
+		return false
+	}
+
+	res := &Result{}
+	if chanInfo == nil || chanInfo.Channel == nil || chanInfo.Channel.Head == nil {
+		comm.Logf("Channel %s of %s has no builds yet, everything would be new", spec.Channel, spec.Target)
+		res.Changes = containerdiff.Compare(&tlc.Container{}, source.Container, compareFile)
+		res.FreshBytes = source.Container.Size
+		return res, nil
+	}
+	res.BuildID = chanInfo.Channel.Head.ID
+
+	comm.Opf("Comparing against build #%d...", res.BuildID)
+	sig, err := ctx.SignatureCache().Get(client, res.BuildID, consumer)
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting signature of build #%d", res.BuildID)
+	}
+
+	changes := containerdiff.Compare(sig.Container, source.Container, compareFile)
+	res.Changes = changes
+
+	for _, c := range changes.Added {
+		res.FreshBytes += c.Size
+	}
+	for _, c := range changes.Modified {
+		res.FreshBytes += c.ChangedBytes
+	}
+
+	if len(candidates) == 0 {
+		return res, nil
+	}
+
+	subSig := containerdiff.SubSignature(sig, candidates)
+	corrupted, err := validate(source.LocalPath, subSig)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range subSig.Container.Files {
+		changed := corrupted[f.Path]
+		if changed == 0 {
+			continue
+		}
+		if changed > f.Size {
+			changed = f.Size
+		}
+
+		changes.Modified = append(changes.Modified, &containerdiff.Change{
+			Path:         f.Path,
+			Kind:         "file",
+			Size:         f.Size,
+			OldSize:      f.Size,
+			ChangedBytes: changed,
+			Mode:         os.FileMode(f.Mode).Perm(),
+		})
+		res.FreshBytes += changed
+	}
+
+	return res, nil
+}
+
+// validate checks local files against a signature, and returns how many
+// bytes differ for each file that does.
+func validate(localPath string, sig *pwr.SignatureInfo) (map[string]int64, error) {
+	woundsFile, err := ioutil.TempFile("", "butler-diff-remote-*.pww")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	woundsPath := woundsFile.Name()
+	woundsFile.Close()
+	defer os.Remove(woundsPath)
+
+	vctx := &pwr.ValidatorContext{
+		WoundsPath: woundsPath,
+		Consumer:   comm.NewStateConsumer(),
+	}
+
+	comm.Opf("Checking %s of files against the signature...", progress.FormatBytes(sig.Container.Size))
+	comm.StartProgressWithTotalBytes(sig.Container.Size)
+	err = vctx.Validate(context.Background(), localPath, sig)
+	comm.EndProgress()
+	if err != nil {
+		return nil, errors.Wrap(err, "checking files")
+	}
+
+	return readWounds(woundsPath)
+}
+
+// readWounds sums up the size of file wounds in a wounds file, by path
+func readWounds(woundsPath string) (map[string]int64, error) {
+	reader, err := os.Open(woundsPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening wounds")
+	}
+	defer reader.Close()
+
+	source := seeksource.FromFile(reader)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading wounds")
+	}
+
+	rctx := wire.NewReadContext(source)
+	err = rctx.ExpectMagic(pwr.WoundsMagic)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading wounds magic")
+	}
+
+	wh := &pwr.WoundsHeader{}
+	err = rctx.ReadMessage(wh)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading wounds header")
+	}
+
+	container := &tlc.Container{}
+	err = rctx.ReadMessage(container)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading container from wounds file")
+	}
+
+	res := make(map[string]int64)
+	wound := &pwr.Wound{}
+	for {
+		wound.Reset()
+		err = rctx.ReadMessage(wound)
+		if err != nil {
+			if errors.Cause(err) == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "reading wound")
+		}
+
+		if wound.Kind != pwr.WoundKind_FILE {
+			continue
+		}
+		f := container.Files[wound.Index]
+		res[f.Path] += wound.End - wound.Start
+	}
+	return res, nil
+}
+
+func printResult(res *Result) {
+	if !containerdiff.Print(res.Changes) {
+		comm.Statf("No changes compared to build #%d", res.BuildID)
+		return
+	}
+	comm.Statf("Estimated patch size: %s of fresh data, before compression", progress.FormatBytes(res.FreshBytes))
+}
@@ -1,10 +1,13 @@
 package extract
 
 import (
+	"context"
+	"os"
 	"runtime"
 	"time"
 
 	"github.com/itchio/butler/installer"
+	"github.com/itchio/butler/installer/archive/intervalsaveconsumer"
 	"github.com/itchio/butler/installer/dmg/dmgextract"
 
 	"github.com/itchio/boar"
@@ -22,14 +25,24 @@ import (
 )
 
 var args = struct {
-	file *string
-	dir  *string
+	file            *string
+	dir             *string
+	includes        *[]string
+	excludes        *[]string
+	list            *bool
+	resumeFile      *string
+	stripComponents *int
 }{}
 
 func Register(ctx *mansion.Context) {
-	cmd := ctx.App.Command("extract", "Extract any archive file supported by butler or 7-zip").Hidden()
+	cmd := ctx.App.Command("extract", "Extract any archive file supported by butler or 7-zip")
 	args.file = cmd.Arg("file", "Path of the archive to extract").Required().String()
 	args.dir = cmd.Flag("dir", "An optional directory to which to extract files (defaults to CWD)").Default(".").Short('d').String()
+	args.includes = cmd.Flag("include", "Only extract entries matching this pattern (same syntax as .butlerignore). May be given several times").Strings()
+	args.excludes = cmd.Flag("exclude", "Don't extract entries matching this pattern (same syntax as .butlerignore). May be given several times").Strings()
+	args.list = cmd.Flag("list", "List the entries that would be extracted instead of extracting them").Short('l').Bool()
+	args.resumeFile = cmd.Flag("resume-file", "When given, write current progress to this file, resume from last location if it exists.").Short('f').String()
+	args.stripComponents = cmd.Flag("strip-components", "Remove this many leading folders from the path of entries, skipping entries that aren't deep enough").Default("0").Int()
 	ctx.Register(cmd, do)
 
 	fetch7zLibsCmd := ctx.App.Command("fetch-7z-libs", "Fetch 7-zip dependencies").Hidden()
@@ -41,10 +54,17 @@ func doFetch7zLibs(ctx *mansion.Context) {
 }
 
 func do(ctx *mansion.Context) {
+	selection, err := NewSelection(*args.includes, *args.excludes, *args.stripComponents)
+	ctx.Must(err)
+
 	ctx.Must(Do(ctx, &ExtractParams{
 		File: *args.file,
 		Dir:  *args.dir,
 
+		Selection:  selection,
+		List:       *args.list,
+		ResumeFile: *args.resumeFile,
+
 		Consumer: comm.NewStateConsumer(),
 	}))
 }
@@ -53,6 +73,19 @@ type ExtractParams struct {
 	File string
 	Dir  string
 
+	// Selection picks which entries are extracted, and where.
+	// Everything is extracted as-is if it's nil.
+	Selection *Selection
+	// List prints the entries that would be extracted, without extracting them
+	List bool
+	// ResumeFile is where progress is saved, extraction resumes
+	// from there if it exists
+	ResumeFile string
+
+	// Context stops the extraction when it's done, after
+	// saving progress to ResumeFile. Defaults to context.Background()
+	Context context.Context
+
 	Consumer *state.Consumer
 }
 
@@ -77,7 +110,11 @@ func Do(ctx *mansion.Context, params *ExtractParams) error {
 		return errors.Wrap(err, "stat'ing archive file")
 	}
 
-	consumer.Opf("Extracting %s to %s", stats.Name(), params.Dir)
+	if params.List {
+		consumer.Opf("Listing %s", stats.Name())
+	} else {
+		consumer.Opf("Extracting %s to %s", stats.Name(), params.Dir)
+	}
 
 	archiveInfo, err := boar.Probe(&boar.ProbeParams{
 		File:     file,
@@ -91,7 +128,15 @@ func Do(ctx *mansion.Context, params *ExtractParams) error {
 
 	startTime := time.Now()
 
+	if params.List {
+		return list(consumer, file, archiveInfo, params.Selection)
+	}
+
 	if archiveInfo.Strategy == boar.StrategyDmg {
+		if params.Selection != nil || params.ResumeFile != "" {
+			return errors.New("extract: disk images can't be extracted partially, or resumed")
+		}
+
 		consumer.Opf("Using dmgextract")
 		if runtime.GOOS != "darwin" {
 			consumer.Warnf("We're not on macOS, so unless you cross-compiled hdiutil, I'm betting this'll fail.")
@@ -128,18 +173,54 @@ func Do(ctx *mansion.Context, params *ExtractParams) error {
 
 		ex.SetConsumer(consumer)
 
-		sink := &savior.FolderSink{
+		var checkpoint *savior.ExtractorCheckpoint
+		if params.ResumeFile != "" {
+			saveCtx := params.Context
+			if saveCtx == nil {
+				saveCtx = context.Background()
+			}
+			sc := intervalsaveconsumer.New(params.ResumeFile, intervalsaveconsumer.DefaultInterval, consumer, saveCtx)
+			ex.SetSaveConsumer(sc)
+
+			checkpoint, err = sc.Load()
+			if err != nil {
+				consumer.Warnf("Could not load checkpoint: %s", err.Error())
+			}
+			if checkpoint != nil {
+				consumer.Opf("Resuming from checkpoint")
+				if ex.Features().ResumeSupport == savior.ResumeSupportNone {
+					consumer.Warnf("This format can't really be resumed, some work will be redone")
+				}
+			}
+		}
+
+		var sink savior.Sink = &savior.FolderSink{
 			Directory: params.Dir,
+			Consumer:  consumer,
+		}
+		if params.Selection != nil {
+			sink = &selectSink{
+				sink:      sink,
+				selection: params.Selection,
+			}
 		}
 
 		comm.StartProgress()
-		res, err := ex.Resume(nil, sink)
+		res, err := ex.Resume(checkpoint, sink)
 		comm.EndProgress()
+		sink.Close()
 
 		if err != nil {
 			return errors.Wrap(err, "extracting archive")
 		}
 		extractSize = res.Size()
+
+		if params.ResumeFile != "" {
+			err = os.Remove(params.ResumeFile)
+			if err != nil && !os.IsNotExist(err) {
+				consumer.Warnf("Could not remove resume file: %s", err.Error())
+			}
+		}
 	}
 
 	duration := time.Since(startTime)
@@ -0,0 +1,118 @@
+package extract_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/extract"
+	"github.com/itchio/butler/cmd/mkzip"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelection(t *testing.T) {
+	sel, err := extract.NewSelection([]string{"data/", "*.exe"}, []string{"*.log"}, 1)
+	wtest.Must(t, err)
+
+	path := func(p string, kind savior.EntryKind) string {
+		return sel.Path(&savior.Entry{CanonicalPath: p, Kind: kind})
+	}
+	var file savior.EntryKind = savior.EntryKindFile
+
+	assert.EqualValues(t, "game.exe", path("build/game.exe", file))
+	assert.EqualValues(t, "data/a.pak", path("build/data/a.pak", file))
+	assert.EqualValues(t, "data", path("build/data", savior.EntryKindDir))
+	assert.EqualValues(t, "", path("build/data/debug.log", file))
+	assert.EqualValues(t, "", path("build/readme.txt", file))
+	// not deep enough to survive --strip-components
+	assert.EqualValues(t, "", path("game.exe", file))
+
+	_, err = extract.NewSelection(nil, nil, -1)
+	assert.Error(t, err)
+
+	everything, err := extract.NewSelection(nil, nil, 0)
+	wtest.Must(t, err)
+	assert.Nil(t, everything)
+	assert.EqualValues(t, "build/readme.txt", everything.Path(&savior.Entry{CanonicalPath: "build/readme.txt"}))
+}
+
+func TestExtractResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extract")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	consumer := &state.Consumer{
+		OnMessage: func(level string, message string) {
+			t.Logf("[%s] %s", level, message)
+		},
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	src := filepath.Join(dir, "src")
+	files := map[string][]byte{
+		"big.dat":        make([]byte, 8*1024*1024),
+		"data/level.dat": make([]byte, 1024),
+		"docs/notes.txt": []byte("not needed"),
+	}
+	for name, contents := range files {
+		rng.Read(contents)
+		p := filepath.Join(src, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, contents, 0644))
+	}
+
+	archive := filepath.Join(dir, "src.zip")
+	wtest.Must(t, mkzip.Do(&mkzip.Params{
+		Out:      archive,
+		Dir:      src,
+		ModTime:  mkzip.DefaultModTime,
+		Consumer: consumer,
+	}))
+
+	sel, err := extract.NewSelection(nil, []string{"docs/"}, 1)
+	wtest.Must(t, err)
+
+	out := filepath.Join(dir, "out")
+	resumeFile := filepath.Join(dir, "extract.dat")
+	params := &extract.ExtractParams{
+		File:       archive,
+		Dir:        out,
+		Selection:  sel,
+		ResumeFile: resumeFile,
+		Consumer:   consumer,
+	}
+
+	// stops as soon as it can, after saving a checkpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	params.Context = ctx
+	err = extract.Do(nil, params)
+	assert.Error(t, err)
+	assert.EqualValues(t, savior.ErrStop, errors.Cause(err))
+	_, err = os.Stat(resumeFile)
+	wtest.Must(t, err)
+
+	params.Context = nil
+	wtest.Must(t, extract.Do(nil, params))
+
+	for name, contents := range files {
+		actual, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		if name == "docs/notes.txt" {
+			assert.True(t, os.IsNotExist(err), "%s should be excluded", name)
+			continue
+		}
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(contents, actual), "%s should be intact", name)
+	}
+
+	_, err = os.Stat(resumeFile)
+	assert.True(t, os.IsNotExist(err), "resume file should be removed once done")
+}
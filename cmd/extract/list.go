@@ -0,0 +1,105 @@
+package extract
+
+import (
+	"github.com/itchio/boar"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+// list prints the entries that would be extracted. Most formats have
+// a list of entries up front, the others have to be decompressed
+// to find out what's inside.
+func list(consumer *state.Consumer, file eos.File, archiveInfo *boar.Info, selection *Selection) error {
+	if archiveInfo.Strategy == boar.StrategyDmg {
+		return errors.New("extract: can't list disk images, use `butler ls` instead")
+	}
+
+	ex, err := archiveInfo.GetExtractor(file, consumer)
+	if err != nil {
+		return errors.Wrap(err, "getting extractor for archive")
+	}
+
+	var entries []*savior.Entry
+	if el, ok := ex.(boar.EntriesLister); ok {
+		entries = el.Entries()
+	}
+
+	if len(entries) == 0 {
+		consumer.Opf("Decompressing to find entries...")
+		ex.SetConsumer(consumer)
+		sink := &listSink{}
+		comm.StartProgress()
+		_, err = ex.Resume(nil, sink)
+		comm.EndProgress()
+		if err != nil {
+			return errors.Wrap(err, "reading archive")
+		}
+		entries = sink.entries
+	}
+
+	numListed := 0
+	for _, e := range entries {
+		p := selection.Path(e)
+		if p == "" {
+			continue
+		}
+		numListed++
+
+		result := &mansion.ArchiveEntryResult{
+			Path: p,
+			Kind: e.Kind.String(),
+			Mode: uint32(e.Mode.Perm()),
+			Size: e.UncompressedSize,
+		}
+		comm.ResultOrPrint(result, func() {
+			comm.Logf("%s %10s %s", e.Mode, progress.FormatBytes(e.UncompressedSize), p)
+		})
+	}
+	consumer.Statf("%d of %d entries would be extracted", numListed, len(entries))
+
+	return nil
+}
+
+// listSink remembers entries and throws away their contents
+type listSink struct {
+	entries []*savior.Entry
+}
+
+var _ savior.Sink = (*listSink)(nil)
+
+func (ls *listSink) add(entry *savior.Entry) {
+	e := *entry
+	ls.entries = append(ls.entries, &e)
+}
+
+func (ls *listSink) Mkdir(entry *savior.Entry) error {
+	ls.add(entry)
+	return nil
+}
+
+func (ls *listSink) Symlink(entry *savior.Entry, linkname string) error {
+	ls.add(entry)
+	return nil
+}
+
+func (ls *listSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	ls.add(entry)
+	return &skipWriter{entry: entry}, nil
+}
+
+func (ls *listSink) Preallocate(entry *savior.Entry) error {
+	return nil
+}
+
+func (ls *listSink) Nuke() error {
+	return nil
+}
+
+func (ls *listSink) Close() error {
+	return nil
+}
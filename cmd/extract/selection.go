@@ -0,0 +1,192 @@
+package extract
+
+import (
+	"strings"
+
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/savior"
+	"github.com/pkg/errors"
+)
+
+// A Selection decides which entries of an archive get extracted, and where.
+type Selection struct {
+	include         *filtering.IgnoreRules
+	exclude         *filtering.IgnoreRules
+	stripComponents int
+}
+
+// NewSelection parses --include and --exclude patterns, which use the same
+// syntax as .butlerignore files and are matched against paths in the archive.
+// If there are no include patterns, everything is included. It returns nil
+// if everything is extracted as-is.
+func NewSelection(includes []string, excludes []string, stripComponents int) (*Selection, error) {
+	if stripComponents < 0 {
+		return nil, errors.Errorf("--strip-components can't be negative (got %d)", stripComponents)
+	}
+	if len(includes) == 0 && len(excludes) == 0 && stripComponents == 0 {
+		return nil, nil
+	}
+
+	parse := func(source string, patterns []string) (*filtering.IgnoreRules, error) {
+		if len(patterns) == 0 {
+			return nil, nil
+		}
+		rules, err := filtering.ParseIgnoreFile(source, "", strings.NewReader(strings.Join(patterns, "\n")))
+		if err != nil {
+			return nil, err
+		}
+		return filtering.NewIgnoreRules(rules), nil
+	}
+
+	include, err := parse("--include", includes)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parse("--exclude", excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Selection{
+		include:         include,
+		exclude:         exclude,
+		stripComponents: stripComponents,
+	}, nil
+}
+
+// Path returns where entry should be extracted, relative to the destination
+// folder, or an empty string if it should be skipped.
+func (s *Selection) Path(entry *savior.Entry) string {
+	p := strings.Trim(entry.CanonicalPath, "/")
+	if p == "" || p == "." {
+		return ""
+	}
+
+	if s != nil {
+		isDir := entry.Kind == savior.EntryKindDir
+		if s.include != nil && s.include.Matching(p, isDir) == nil {
+			return ""
+		}
+		if s.exclude != nil && s.exclude.Matching(p, isDir) != nil {
+			return ""
+		}
+
+		if s.stripComponents > 0 {
+			parts := strings.Split(p, "/")
+			if len(parts) <= s.stripComponents {
+				return ""
+			}
+			p = strings.Join(parts[s.stripComponents:], "/")
+		}
+	}
+
+	return p
+}
+
+// selectSink passes the entries picked by a Selection to another sink,
+// under their new path, and skips the others.
+type selectSink struct {
+	sink      savior.Sink
+	selection *Selection
+}
+
+var _ savior.Sink = (*selectSink)(nil)
+
+// renamed returns a copy of entry with another path. The original is left
+// untouched, since extractors keep it in their checkpoints.
+func renamed(entry *savior.Entry, p string) *savior.Entry {
+	res := *entry
+	res.CanonicalPath = p
+	return &res
+}
+
+func (ss *selectSink) Mkdir(entry *savior.Entry) error {
+	p := ss.selection.Path(entry)
+	if p == "" {
+		return nil
+	}
+	return ss.sink.Mkdir(renamed(entry, p))
+}
+
+func (ss *selectSink) Symlink(entry *savior.Entry, linkname string) error {
+	p := ss.selection.Path(entry)
+	if p == "" {
+		return nil
+	}
+	return ss.sink.Symlink(renamed(entry, p), linkname)
+}
+
+func (ss *selectSink) Preallocate(entry *savior.Entry) error {
+	p := ss.selection.Path(entry)
+	if p == "" {
+		return nil
+	}
+	return ss.sink.Preallocate(renamed(entry, p))
+}
+
+func (ss *selectSink) GetWriter(entry *savior.Entry) (savior.EntryWriter, error) {
+	p := ss.selection.Path(entry)
+	if p == "" {
+		return &skipWriter{entry: entry}, nil
+	}
+
+	w, err := ss.sink.GetWriter(renamed(entry, p))
+	if err != nil {
+		return nil, err
+	}
+	return &selectWriter{
+		w:     w,
+		entry: entry,
+	}, nil
+}
+
+func (ss *selectSink) Nuke() error {
+	return ss.sink.Nuke()
+}
+
+func (ss *selectSink) Close() error {
+	return ss.sink.Close()
+}
+
+// selectWriter keeps the write offset of the original entry up to date,
+// so that checkpoints resume at the right place
+type selectWriter struct {
+	w     savior.EntryWriter
+	entry *savior.Entry
+}
+
+var _ savior.EntryWriter = (*selectWriter)(nil)
+
+func (sw *selectWriter) Write(buf []byte) (int, error) {
+	n, err := sw.w.Write(buf)
+	sw.entry.WriteOffset += int64(n)
+	return n, err
+}
+
+func (sw *selectWriter) Sync() error {
+	return sw.w.Sync()
+}
+
+func (sw *selectWriter) Close() error {
+	return sw.w.Close()
+}
+
+// skipWriter discards the contents of entries that aren't selected
+type skipWriter struct {
+	entry *savior.Entry
+}
+
+var _ savior.EntryWriter = (*skipWriter)(nil)
+
+func (sw *skipWriter) Write(buf []byte) (int, error) {
+	sw.entry.WriteOffset += int64(len(buf))
+	return len(buf), nil
+}
+
+func (sw *skipWriter) Sync() error {
+	return nil
+}
+
+func (sw *skipWriter) Close() error {
+	return nil
+}
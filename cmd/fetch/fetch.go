@@ -7,31 +7,64 @@ import (
 
 	"github.com/itchio/boar"
 
+	"github.com/itchio/butler/cmd/builds"
 	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/installer/bfs"
 	"github.com/itchio/butler/mansion"
 	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/wharf/tlc"
 	"github.com/pkg/errors"
 )
 
 var args = struct {
-	target *string
-	out    *string
+	target      *string
+	out         *string
+	buildID     *int64
+	userVersion *string
 }{}
 
 func Register(ctx *mansion.Context) {
-	cmd := ctx.App.Command("fetch", "Download and extract the latest build of a channel from itch.io")
+	cmd := ctx.App.Command("fetch", "Download and extract the latest build of a channel from itch.io, or an older one. See `butler builds`.")
 	ctx.Register(cmd, do)
 
 	args.target = cmd.Arg("target", "Which user/project:channel to fetch from, for example 'leafo/x-moon:win-64'. Targets are of the form project:channel where project is username/game or game_id.").Required().String()
 	args.out = cmd.Arg("out", "Directory to fetch and extract build to").Required().String()
+	args.buildID = cmd.Flag("build", "Fetch this build instead of the latest one").Int64()
+	args.userVersion = cmd.Flag("userversion", "Fetch the most recent build with this user version instead of the latest one").String()
 }
 
 func do(ctx *mansion.Context) {
-	ctx.Must(Do(ctx, *args.target, *args.out))
+	ctx.Must(Do(ctx, &Params{
+		Target:      *args.target,
+		Out:         *args.out,
+		BuildID:     *args.buildID,
+		UserVersion: *args.userVersion,
+	}))
 }
 
-func Do(ctx *mansion.Context, specStr string, outPath string) error {
+// Params control which build to fetch, and where to
+type Params struct {
+	// Target is of the form project:channel. The channel may be omitted if BuildID is set.
+	Target string
+	// Out is the folder to extract the build to. It must be empty, not exist,
+	// or contain a build fetched earlier from the same channel, which is then
+	// updated in place.
+	Out string
+
+	// BuildID is the build to fetch. If 0, the latest build of the channel is fetched.
+	BuildID int64
+	// UserVersion picks the most recent build of the channel with this user version
+	UserVersion string
+}
+
+func Do(ctx *mansion.Context, params *Params) error {
 	consumer := comm.NewStateConsumer()
+	specStr := params.Target
+	outPath := params.Out
+
+	if params.BuildID != 0 && params.UserVersion != "" {
+		return errors.New("--build and --userversion can't be used together")
+	}
 
 	err := os.MkdirAll(outPath, os.FileMode(0755))
 	if err != nil {
@@ -43,8 +76,16 @@ func Do(ctx *mansion.Context, specStr string, outPath string) error {
 		return errors.WithStack(err)
 	}
 
+	var receipt *bfs.Receipt
 	if len(outFiles) > 0 {
-		return fmt.Errorf("Destination directory %s exists and is not empty", outPath)
+		receipt, err = bfs.ReadReceipt(outPath)
+		if err != nil {
+			return err
+		}
+
+		if receipt == nil || receipt.Build == nil || receipt.InstallerName != fetchInstallerName {
+			return fmt.Errorf("Destination directory %s exists and is not empty", outPath)
+		}
 	}
 
 	spec, err := itchio.ParseSpec(specStr)
@@ -52,9 +93,11 @@ func Do(ctx *mansion.Context, specStr string, outPath string) error {
 		return err
 	}
 
-	err = spec.EnsureChannel()
-	if err != nil {
-		return err
+	if params.BuildID == 0 {
+		err = spec.EnsureChannel()
+		if err != nil {
+			return err
+		}
 	}
 
 	client, err := ctx.AuthenticateViaOauth()
@@ -62,34 +105,29 @@ func Do(ctx *mansion.Context, specStr string, outPath string) error {
 		return err
 	}
 
-	comm.Opf("Getting last build of channel %s", spec.Channel)
-
-	channelResponse, err := client.GetChannel(spec.Target, spec.Channel)
+	build, upload, err := findBuild(client, spec, params)
 	if err != nil {
 		return err
 	}
 
-	if channelResponse.Channel.Head == nil {
-		return fmt.Errorf("Channel %s doesn't have any builds yet", spec.Channel)
+	if receipt != nil {
+		if upload == nil {
+			return fmt.Errorf("Destination directory %s exists and is not empty (pass a channel along with --build to update it)", outPath)
+		}
+		if receipt.Upload == nil || receipt.Upload.ID != upload.ID {
+			return fmt.Errorf("Destination directory %s exists and is not empty (it was fetched from another channel)", outPath)
+		}
+		return update(ctx, client, outPath, receipt, build, upload)
 	}
 
-	buildID := channelResponse.Channel.Head.ID
-
-	buildFilesRes, err := client.ListBuildFiles(buildID)
+	url, err := archiveURL(client, build)
 	if err != nil {
+		if params.BuildID == 0 && params.UserVersion == "" {
+			return fmt.Errorf("Channel %s's latest build is still processing", spec.Channel)
+		}
 		return err
 	}
 
-	archiveFile := itchio.FindBuildFileEx(itchio.BuildFileTypeArchive, itchio.BuildFileSubTypeDefault, buildFilesRes.Files)
-	if archiveFile == nil {
-		return fmt.Errorf("Channel %s's latest build is still processing", spec.Channel)
-	}
-
-	url := client.MakeBuildFileDownloadURL(itchio.MakeBuildFileDownloadURLParams{
-		BuildID: buildID,
-		FileID:  archiveFile.ID,
-	})
-
 	comm.Opf("Extracting into %s", outPath)
 
 	comm.StartProgress()
@@ -104,5 +142,125 @@ func Do(ctx *mansion.Context, specStr string, outPath string) error {
 	}
 	comm.Statf("Extracted %s", extractRes.Stats())
 
+	container, err := tlc.WalkAny(outPath, &tlc.WalkOpts{})
+	if err != nil {
+		return errors.Wrap(err, "listing extracted files")
+	}
+
+	err = writeReceipt(outPath, upload, build, container)
+	if err != nil {
+		return err
+	}
+
+	sigCache := ctx.SignatureCache()
+	if sigCache.Enabled() {
+		// so that pushing from this directory later doesn't
+		// have to download the signature
+		_, err := sigCache.Get(client, build.ID, consumer)
+		if err != nil {
+			comm.Debugf("Could not cache signature: %s", err.Error())
+		}
+	}
+
 	return nil
 }
+
+// findBuild returns the build params ask for, and the upload of its channel.
+// The upload is nil if a build was asked for by ID, without a channel.
+func findBuild(client *itchio.Client, spec *itchio.Spec, params *Params) (*itchio.Build, *itchio.Upload, error) {
+	switch {
+	case params.BuildID != 0:
+		comm.Opf("Getting build #%d", params.BuildID)
+
+		buildRes, err := client.GetBuild(itchio.GetBuildParams{BuildID: params.BuildID})
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "looking up build #%d", params.BuildID)
+		}
+		logBuild(buildRes.Build)
+
+		var upload *itchio.Upload
+		if spec.Channel != "" {
+			upload, err = channelUpload(client, spec)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return buildRes.Build, upload, nil
+	case params.UserVersion != "":
+		comm.Opf("Looking for build %s of channel %s", params.UserVersion, spec.Channel)
+
+		channelBuilds, err := builds.List(client, params.Target)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		matches := builds.FilterByUserVersion(channelBuilds, params.UserVersion)
+		if len(matches) == 0 {
+			return nil, nil, fmt.Errorf("Channel %s doesn't have any recent build with user version %s", spec.Channel, params.UserVersion)
+		}
+		if len(matches) > 1 {
+			comm.Warnf("%d builds have user version %s, fetching the most recent one", len(matches), params.UserVersion)
+		}
+		logBuild(matches[0])
+
+		upload, err := channelUpload(client, spec)
+		if err != nil {
+			return nil, nil, err
+		}
+		return matches[0], upload, nil
+	default:
+		comm.Opf("Getting last build of channel %s", spec.Channel)
+
+		channelResponse, err := client.GetChannel(spec.Target, spec.Channel)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if channelResponse.Channel.Head == nil {
+			return nil, nil, fmt.Errorf("Channel %s doesn't have any builds yet", spec.Channel)
+		}
+		return channelResponse.Channel.Head, channelResponse.Channel.Upload, nil
+	}
+}
+
+// channelUpload returns the upload builds of a channel are pushed to
+func channelUpload(client *itchio.Client, spec *itchio.Spec) (*itchio.Upload, error) {
+	channelResponse, err := client.GetChannel(spec.Target, spec.Channel)
+	if err != nil {
+		return nil, errors.Wrapf(err, "looking up channel %s", spec.Channel)
+	}
+
+	if channelResponse.Channel == nil || channelResponse.Channel.Upload == nil {
+		return nil, errors.Errorf("channel %s not found for %s", spec.Channel, spec.Target)
+	}
+	return channelResponse.Channel.Upload, nil
+}
+
+// archiveURL returns where to download the archive of a build from
+func archiveURL(client *itchio.Client, build *itchio.Build) (string, error) {
+	buildFilesRes, err := client.ListBuildFiles(build.ID)
+	if err != nil {
+		return "", err
+	}
+
+	archiveFile := itchio.FindBuildFileEx(itchio.BuildFileTypeArchive, itchio.BuildFileSubTypeDefault, buildFilesRes.Files)
+	if archiveFile == nil {
+		return "", fmt.Errorf("Build #%d doesn't have an archive, it may still be processing or have failed", build.ID)
+	}
+
+	return client.MakeBuildFileDownloadURL(itchio.MakeBuildFileDownloadURLParams{
+		BuildID: build.ID,
+		FileID:  archiveFile.ID,
+	}), nil
+}
+
+func logBuild(build *itchio.Build) {
+	version := fmt.Sprintf("version %d", build.Version)
+	if build.UserVersion != "" {
+		version += fmt.Sprintf(" (%s)", build.UserVersion)
+	}
+	if build.CreatedAt != nil {
+		version += ", pushed " + build.CreatedAt.Local().Format("2006-01-02 15:04")
+	}
+	comm.Logf("Build #%d is %s", build.ID, version)
+}
@@ -0,0 +1,217 @@
+package fetch
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/installer/bfs"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// fetchInstallerName marks receipts written by fetch, folders installed
+// by anything else are never updated.
+const fetchInstallerName = "fetch"
+
+// stagingPath is where patches are staged and checkpointed, so
+// interrupted fetches can be resumed. Push ignores .itch folders.
+func stagingPath(outPath string) string {
+	return filepath.Join(outPath, ".itch", "fetch-staging")
+}
+
+// update brings a folder fetched earlier to the given build, by applying
+// patches if possible, and by healing it otherwise.
+func update(ctx *mansion.Context, client *itchio.Client, outPath string, receipt *bfs.Receipt, build *itchio.Build, upload *itchio.Upload) error {
+	current := receipt.Build
+
+	switch {
+	case current.ID == build.ID:
+		comm.Statf("%s already contains build #%d, nothing to fetch", outPath, build.ID)
+		return nil
+	case current.ID < build.ID:
+		comm.Opf("Upgrading %s from build #%d to #%d", outPath, current.ID, build.ID)
+
+		err := upgrade(client, outPath, receipt, build, upload)
+		if err == nil {
+			return os.RemoveAll(stagingPath(outPath))
+		}
+		comm.Warnf("Could not upgrade by patching: %s", err.Error())
+		comm.Logf("Falling back to healing...")
+	default:
+		comm.Opf("Downgrading %s from build #%d to #%d", outPath, current.ID, build.ID)
+	}
+
+	// the receipt may have been updated by patches applied so far
+	latestReceipt, err := bfs.ReadReceipt(outPath)
+	if err == nil && latestReceipt != nil {
+		receipt = latestReceipt
+	}
+
+	err = heal(ctx, client, outPath, receipt, build, upload)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(stagingPath(outPath))
+}
+
+// upgrade applies all patches between the build in the receipt and
+// the target build, updating the receipt after each of them.
+func upgrade(client *itchio.Client, outPath string, receipt *bfs.Receipt, build *itchio.Build, upload *itchio.Upload) error {
+	consumer := comm.NewStateConsumer()
+
+	upgradeRes, err := client.GetBuildUpgradePath(itchio.GetBuildUpgradePathParams{
+		CurrentBuildID: receipt.Build.ID,
+		TargetBuildID:  build.ID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "finding upgrade path")
+	}
+
+	// skip the current build, we already have it
+	upgradeBuilds := upgradeRes.UpgradePath.Builds
+	if len(upgradeBuilds) < 2 {
+		return errors.New("upgrade path is empty")
+	}
+	upgradeBuilds = upgradeBuilds[1:]
+
+	var totalSize int64
+	subTypes := make([]itchio.BuildFileSubType, len(upgradeBuilds))
+	for i, b := range upgradeBuilds {
+		f := operate.FindBuildFile(b.Files, itchio.BuildFileTypePatch, itchio.BuildFileSubTypeOptimized)
+		subTypes[i] = itchio.BuildFileSubTypeOptimized
+		if f == nil {
+			f = operate.FindBuildFile(b.Files, itchio.BuildFileTypePatch, itchio.BuildFileSubTypeDefault)
+			subTypes[i] = itchio.BuildFileSubTypeDefault
+		}
+		if f == nil {
+			return errors.Errorf("build #%d is missing a patch", b.ID)
+		}
+		totalSize += f.Size
+	}
+	comm.Logf("Applying %d patches, %s total", len(upgradeBuilds), progress.FormatBytes(totalSize))
+
+	staging := stagingPath(outPath)
+	err = os.MkdirAll(staging, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for i, b := range upgradeBuilds {
+		logBuild(b)
+
+		patchURL := client.MakeBuildDownloadURL(itchio.MakeBuildDownloadURLParams{
+			BuildID: b.ID,
+			Type:    itchio.BuildFileTypePatch,
+			SubType: subTypes[i],
+		})
+
+		comm.StartProgress()
+		container, err := operate.PatchInPlace(&operate.PatchInPlaceParams{
+			Ctx:            context.Background(),
+			Consumer:       consumer,
+			PatchURL:       patchURL,
+			Folder:         outPath,
+			StageFolder:    filepath.Join(staging, "patch-overlay"),
+			CheckpointPath: filepath.Join(staging, fmt.Sprintf("patch-%d-%s-checkpoint", b.ID, subTypes[i])),
+			OnProgress:     consumer.Progress,
+		})
+		comm.EndProgress()
+		if err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("while applying patch %d/%d (build #%d)", i+1, len(upgradeBuilds), b.ID))
+		}
+
+		// so an interrupted fetch picks up from here
+		err = writeReceipt(outPath, upload, b, container)
+		if err != nil {
+			return err
+		}
+	}
+
+	comm.Statf("Upgraded to build #%d", build.ID)
+	return nil
+}
+
+// heal checks every file against the signature of the target build,
+// downloads whatever is missing or different from its archive, and
+// removes files the target build doesn't have.
+func heal(ctx *mansion.Context, client *itchio.Client, outPath string, receipt *bfs.Receipt, build *itchio.Build, upload *itchio.Upload) error {
+	consumer := comm.NewStateConsumer()
+
+	url, err := archiveURL(client, build)
+	if err != nil {
+		return err
+	}
+
+	sig, err := ctx.SignatureCache().Get(client, build.ID, consumer)
+	if err != nil {
+		return errors.Wrapf(err, "getting signature of build #%d", build.ID)
+	}
+
+	vctx := &pwr.ValidatorContext{
+		Consumer:   consumer,
+		NumWorkers: 1,
+		HealPath:   "archive," + url,
+	}
+
+	comm.Opf("Healing %s...", progress.FormatBytes(sig.Container.Size))
+	comm.StartProgress()
+	err = vctx.Validate(context.Background(), outPath, sig)
+	comm.EndProgress()
+	if err != nil {
+		return errors.Wrap(err, "healing")
+	}
+
+	if vctx.WoundsConsumer.HasWounds() {
+		comm.Logf("%s were different or missing and were downloaded again",
+			progress.FormatBytes(vctx.WoundsConsumer.TotalCorrupted()))
+	}
+
+	err = bfs.BustGhosts(&bfs.BustGhostsParams{
+		Consumer: consumer,
+		Folder:   outPath,
+		NewFiles: containerFiles(sig.Container),
+		Receipt:  receipt,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = writeReceipt(outPath, upload, build, sig.Container)
+	if err != nil {
+		return err
+	}
+
+	comm.Statf("Healed to build #%d", build.ID)
+	return nil
+}
+
+// writeReceipt records which build a folder contains, and which upload it's
+// from, for the next fetch
+func writeReceipt(outPath string, upload *itchio.Upload, build *itchio.Build, container *tlc.Container) error {
+	receipt := &bfs.Receipt{
+		InstallerName: fetchInstallerName,
+		Upload:        upload,
+		Build:         build,
+		Files:         containerFiles(container),
+	}
+	return receipt.WriteReceipt(outPath)
+}
+
+func containerFiles(container *tlc.Container) []string {
+	var files []string
+	for _, f := range container.Files {
+		files = append(files, f.Path)
+	}
+	for _, s := range container.Symlinks {
+		files = append(files, s.Path)
+	}
+	return files
+}
@@ -0,0 +1,190 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itchio/boar"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/butler/installer"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/dash"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+// DoDeep runs a file through the same probes the app does when it installs
+// something: it picks an installer type, and finds launch targets by
+// extracting archives and configuring the result.
+func DoDeep(consumer *state.Consumer, inPath string) (*mansion.DeepFileResult, error) {
+	f, err := eos.Open(inPath, option.WithConsumer(consumer))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	stats, err := f.Stat()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if stats.IsDir() {
+		return nil, errors.Errorf("%s: is a directory", eos.Redact(inPath))
+	}
+
+	info, err := installer.GetInstallerInfo(consumer, f)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result := &mansion.DeepFileResult{
+		Path:          eos.Redact(inPath),
+		Size:          stats.Size(),
+		InstallerType: string(info.Type),
+		Reason:        info.Reason,
+		Candidates:    []*dash.Candidate{},
+	}
+
+	switch {
+	case info.Type == installer.InstallerTypeUnsupported:
+		result.Rejected = true
+		result.RejectReason = "Packaged in a way that isn't supported"
+	case installer.GetManager(string(info.Type)) == nil:
+		result.Rejected = true
+		result.RejectReason = fmt.Sprintf("No manager for installer (%s)", info.Type)
+	}
+	if result.Rejected {
+		return result, nil
+	}
+
+	switch info.Type {
+	case installer.InstallerTypeArchive:
+		ai := info.ArchiveInfo
+		result.Archive = &mansion.DeepArchiveResult{
+			Format:     ai.Format,
+			NumEntries: len(info.Entries),
+		}
+		if ai.StageTwoStrategy != boar.StageTwoStrategyNone {
+			result.Archive.StageTwo = ai.StageTwoStrategy.String()
+			result.Archive.PostExtract = ai.PostExtract
+		}
+
+		err = deepArchive(consumer, inPath, info, result)
+		if err != nil {
+			return nil, err
+		}
+	case installer.InstallerTypeDMG:
+		// mounting disk images only works on macOS, and takes a while
+		consumer.Warnf("Not looking inside disk image, try `butler extract` on macOS first")
+	default:
+		candidate := info.Candidate
+		if candidate == nil {
+			// the file extension registry was enough to pick an installer
+			candidate, err = dash.Sniff(f, filepath.Base(stats.Name()), stats.Size())
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+		if candidate != nil {
+			result.Candidates = append(result.Candidates, candidate)
+		}
+	}
+
+	return result, nil
+}
+
+// deepArchive extracts an archive to a temporary folder, then looks at what
+// the app would look at after installing it: nested installers, and launch targets
+func deepArchive(consumer *state.Consumer, inPath string, info *installer.InstallerInfo, result *mansion.DeepFileResult) error {
+	tmpDir, err := ioutil.TempDir("", "butler-file-deep")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	consumer.Opf("Extracting %s to look inside...", info.ArchiveInfo.Format)
+	res, err := boar.SimpleExtract(&boar.SimpleExtractParams{
+		ArchivePath:       inPath,
+		DestinationFolder: tmpDir,
+		Consumer:          consumer,
+	})
+	if err != nil {
+		return errors.Wrap(err, "extracting archive")
+	}
+
+	var files []string
+	for _, e := range res.Entries {
+		if e.Kind == savior.EntryKindFile {
+			files = append(files, e.CanonicalPath)
+		}
+	}
+
+	if len(files) == 1 {
+		single := filepath.Join(tmpDir, filepath.FromSlash(files[0]))
+		nested, err := func() (*installer.InstallerInfo, error) {
+			sf, err := os.Open(single)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			defer sf.Close()
+			return installer.GetInstallerInfo(consumer, sf)
+		}()
+		if err != nil {
+			consumer.Infof("Could not determine installer info for single file, skipping: %s", err.Error())
+		} else if installer.IsWindowsInstaller(nested.Type) {
+			result.NestedInstallerType = string(nested.Type)
+			if nested.Candidate != nil {
+				result.Candidates = append(result.Candidates, nested.Candidate)
+			}
+			return nil
+		}
+	}
+
+	verdict, err := dash.Configure(tmpDir, &dash.ConfigureParams{
+		Consumer: consumer,
+		Filter:   filtering.FilterPaths,
+	})
+	if err != nil {
+		return errors.Wrap(err, "configuring extracted archive")
+	}
+	result.Candidates = append(result.Candidates, verdict.Candidates...)
+	return nil
+}
+
+func printDeep(result *mansion.DeepFileResult) {
+	comm.Logf("%s: %s, installer type (%s)", result.Path, progress.FormatBytes(result.Size), result.InstallerType)
+	if result.Reason != "" {
+		comm.Logf("  because: %s", result.Reason)
+	}
+	if a := result.Archive; a != nil {
+		line := fmt.Sprintf("  archive: %s with %d entries", a.Format, a.NumEntries)
+		if a.StageTwo != "" {
+			line += fmt.Sprintf(", stage two: %s (%s)", a.StageTwo, strings.Join(a.PostExtract, ", "))
+		}
+		comm.Logf("%s", line)
+	}
+	if result.NestedInstallerType != "" {
+		comm.Logf("  contains a single %s installer, which would be run after extracting", result.NestedInstallerType)
+	}
+
+	if result.Rejected {
+		comm.Logf("  would be rejected: %s", result.RejectReason)
+		return
+	}
+
+	if len(result.Candidates) == 0 {
+		comm.Logf("  no launch targets found")
+		return
+	}
+	comm.Logf("  launch targets:")
+	for _, c := range result.Candidates {
+		comm.Logf("    %s", c.String())
+	}
+}
@@ -0,0 +1,83 @@
+package file_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/file"
+	"github.com/itchio/butler/cmd/mkzip"
+	"github.com/itchio/butler/installer"
+	"github.com/itchio/butler/installer/archive"
+	"github.com/itchio/butler/installer/naked"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	naked.Register()
+	archive.Register()
+}
+
+func TestDeep(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file-deep")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	consumer := &state.Consumer{
+		OnMessage: func(level string, message string) {
+			t.Logf("[%s] %s", level, message)
+		},
+	}
+
+	// the test binary is as native an executable as it gets
+	exe, err := os.Executable()
+	wtest.Must(t, err)
+	exeBytes, err := ioutil.ReadFile(exe)
+	wtest.Must(t, err)
+
+	src := filepath.Join(dir, "game")
+	wtest.Must(t, os.MkdirAll(src, 0755))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(src, "game"), exeBytes, 0755))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(src, "data.bin"), []byte("have fun"), 0644))
+
+	zipPath := filepath.Join(dir, "game.zip")
+	store, err := mkzip.ParseMethodRule("*=store")
+	wtest.Must(t, err)
+	wtest.Must(t, mkzip.Do(&mkzip.Params{
+		Out:      zipPath,
+		Dir:      src,
+		Methods:  []*mkzip.MethodRule{store},
+		ModTime:  mkzip.DefaultModTime,
+		Consumer: consumer,
+	}))
+
+	res, err := file.DoDeep(consumer, zipPath)
+	wtest.Must(t, err)
+	assert.EqualValues(t, installer.InstallerTypeArchive, res.InstallerType)
+	assert.NotEmpty(t, res.Reason)
+	assert.False(t, res.Rejected)
+	if assert.NotNil(t, res.Archive) {
+		assert.EqualValues(t, "zip", res.Archive.Format)
+		assert.EqualValues(t, 3, res.Archive.NumEntries)
+	}
+	if assert.Len(t, res.Candidates, 1) {
+		assert.EqualValues(t, "game/game", res.Candidates[0].Path)
+		assert.NotEmpty(t, res.Candidates[0].Arch)
+	}
+
+	res, err = file.DoDeep(consumer, filepath.Join(src, "game"))
+	wtest.Must(t, err)
+	assert.EqualValues(t, installer.InstallerTypeNaked, res.InstallerType)
+	assert.False(t, res.Rejected)
+	assert.Nil(t, res.Archive)
+	assert.Len(t, res.Candidates, 1)
+
+	res, err = file.DoDeep(consumer, filepath.Join(src, "data.bin"))
+	wtest.Must(t, err)
+	assert.EqualValues(t, installer.InstallerTypeUnknown, res.InstallerType)
+	assert.True(t, res.Rejected)
+	assert.Empty(t, res.Candidates)
+}
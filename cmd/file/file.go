@@ -8,6 +8,7 @@ import (
 	"github.com/itchio/arkive/zip"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/seal"
 	"github.com/itchio/httpkit/progress"
 	"github.com/itchio/savior/seeksource"
 	"github.com/itchio/spellbook"
@@ -22,15 +23,25 @@ import (
 
 var args = struct {
 	file *string
+	deep *bool
 }{}
 
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("file", "Prints the type of a given file, and some stats about it")
 	args.file = cmd.Arg("file", "A file you'd like to identify").Required().String()
+	args.deep = cmd.Flag("deep", "Probe the file the way the app does when installing it: installer type, archive contents, launch targets").Bool()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
+	if *args.deep {
+		result, err := DoDeep(comm.NewStateConsumer(), *args.file)
+		ctx.Must(err)
+		comm.ResultOrPrint(result, func() {
+			printDeep(result)
+		})
+		return
+	}
 	ctx.Must(Do(ctx, *args.file))
 }
 
@@ -146,6 +157,9 @@ func Do(ctx *mansion.Context, inPath string) error {
 			}
 
 			comm.Logf("%s: %s wharf signature file (%s) with %s", path, prettySize, sh.GetCompression().ToString(), container.Stats())
+			if s, err := seal.Read(reader, stats.Size()); err == nil {
+				comm.Logf("%s: sealed with key %s, use `butler verify --pubkey` to check it", path, seal.Fingerprint(s.PublicKey))
+			}
 			result = mansion.ContainerResult{
 				Type:             "wharf/signature",
 				NumFiles:         len(container.Files),
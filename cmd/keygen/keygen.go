@@ -0,0 +1,54 @@
+package keygen
+
+import (
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/seal"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	path *string
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("keygen", "Generate a key pair to seal builds with, see `butler sign --key` and `butler push --sign-key`")
+	args.path = cmd.Arg("path", "Where to write the private key, the public key is written next to it with a .pub extension").Required().String()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	ctx.Must(Do(*args.path))
+}
+
+// Result is what keygen prints in JSON mode
+type Result struct {
+	PublicKey   string `json:"publicKey"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Do writes a new key pair to path and path + ".pub"
+func Do(path string) error {
+	pub, priv, err := seal.GenerateKey()
+	if err != nil {
+		return err
+	}
+
+	err = seal.WriteKeyPair(path, priv)
+	if err != nil {
+		return errors.Wrap(err, "writing key pair")
+	}
+
+	comm.ResultOrPrint(&Result{
+		PublicKey:   seal.FormatPublicKey(pub),
+		Fingerprint: seal.Fingerprint(pub),
+	}, func() {
+		comm.Logf("Private key written to %s, keep it secret.", path)
+		comm.Logf("Public key written to %s.pub:", path)
+		comm.Logf("")
+		comm.Logf("  %s", seal.FormatPublicKey(pub))
+		comm.Logf("")
+		comm.Statf("Key fingerprint: %s", seal.Fingerprint(pub))
+	})
+	return nil
+}
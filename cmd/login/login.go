@@ -1,20 +1,60 @@
 package login
 
 import (
+	"io/ioutil"
+	"os"
+	"strings"
+
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/mansion"
 	"github.com/pkg/errors"
 )
 
+var args = struct {
+	device      *bool
+	apiKeyStdin *bool
+}{}
+
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("login", "Connect butler to your itch.io account and save credentials locally.")
+	args.device = cmd.Flag("device", "Log in by entering a code from another device, for machines without a browser").Bool()
+	args.apiKeyStdin = cmd.Flag("api-key-stdin", "Read an API key from standard input and save it, instead of logging in").Bool()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
+	ctx.DeviceLogin = *args.device
+	if *args.apiKeyStdin {
+		ctx.Must(SaveFromStdin(ctx))
+		return
+	}
 	ctx.Must(Do(ctx))
 }
 
+// SaveFromStdin reads an API key from standard input, checks it and
+// saves it, replacing any saved credentials.
+func SaveFromStdin(ctx *mansion.Context) error {
+	buf, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return errors.Wrap(err, "reading API key from stdin")
+	}
+
+	key := strings.TrimSpace(string(buf))
+	if key == "" {
+		return errors.New("no API key on stdin")
+	}
+	if strings.ContainsAny(key, " \t\r\n") {
+		return errors.New("expected a single API key on stdin")
+	}
+
+	err = ctx.SaveAPIKey(key)
+	if err != nil {
+		return err
+	}
+	comm.Result(map[string]string{"status": "success"})
+	return nil
+}
+
 func Do(ctx *mansion.Context) error {
 	if ctx.HasSavedCredentials() {
 		client, err := ctx.AuthenticateViaOauth()
@@ -28,7 +68,7 @@ func Do(ctx *mansion.Context) error {
 		}
 
 		comm.Logf("Your local credentials are valid!\n")
-		comm.Logf("If you want to log in as another account, use the `butler logout` command first, or specify a different credentials path with the `-i` flag.")
+		comm.Logf("If you want to log in as another account, use the `butler logout` command first, or save its credentials in a separate profile with `butler login --profile <name>`.")
 		comm.Result(map[string]string{"status": "success"})
 	} else {
 		// this does the full login flow + saves
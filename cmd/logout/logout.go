@@ -19,7 +19,7 @@ func do(ctx *mansion.Context) {
 }
 
 func Do(ctx *mansion.Context) error {
-	var identity = ctx.Identity
+	var identity = ctx.KeyPath()
 
 	_, err := os.Lstat(identity)
 	if err != nil {
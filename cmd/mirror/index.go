@@ -0,0 +1,154 @@
+package mirror
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	itchio "github.com/itchio/go-itchio"
+	"github.com/pkg/errors"
+)
+
+// IndexFileName is the name of the index, at the root of a mirror
+const IndexFileName = "index.json"
+
+// An Index lists everything that was mirrored from a project
+type Index struct {
+	Target    string                   `json:"target"`
+	UpdatedAt time.Time                `json:"updatedAt"`
+	Channels  map[string]*ChannelIndex `json:"channels"`
+}
+
+// A ChannelIndex lists the mirrored builds of a channel, oldest first
+type ChannelIndex struct {
+	Name     string        `json:"name"`
+	Tags     string        `json:"tags,omitempty"`
+	UploadID int64         `json:"uploadId"`
+	Builds   []*BuildEntry `json:"builds"`
+}
+
+// A BuildEntry describes a build whose files were all mirrored
+type BuildEntry struct {
+	ID            int64        `json:"id"`
+	ParentBuildID int64        `json:"parentBuildId"`
+	Version       int64        `json:"version"`
+	UserVersion   string       `json:"userVersion,omitempty"`
+	CreatedAt     *time.Time   `json:"createdAt,omitempty"`
+	Files         []*FileEntry `json:"files"`
+}
+
+// A FileEntry is a mirrored build file
+type FileEntry struct {
+	ID      int64                   `json:"id"`
+	Type    itchio.BuildFileType    `json:"type"`
+	SubType itchio.BuildFileSubType `json:"subType"`
+	Size    int64                   `json:"size"`
+	// Path is slash-separated, relative to the root of the mirror
+	Path string `json:"path"`
+}
+
+// ReadIndex reads the index of a mirror, or returns an empty index if
+// there isn't one yet.
+func ReadIndex(dir string) (*Index, error) {
+	index := &Index{
+		Channels: make(map[string]*ChannelIndex),
+	}
+
+	buf, err := ioutil.ReadFile(filepath.Join(dir, IndexFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return index, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	err = json.Unmarshal(buf, index)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing mirror index")
+	}
+	if index.Channels == nil {
+		index.Channels = make(map[string]*ChannelIndex)
+	}
+	return index, nil
+}
+
+// Write saves the index at the root of a mirror
+func (index *Index) Write(dir string) error {
+	buf, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write + rename, so we never leave a half-written index behind
+	indexPath := filepath.Join(dir, IndexFileName)
+	tmpPath := indexPath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buf, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpPath, indexPath))
+}
+
+// FindBuild returns the entry for a build, if it was mirrored
+func (ci *ChannelIndex) FindBuild(buildID int64) *BuildEntry {
+	for _, b := range ci.Builds {
+		if b.ID == buildID {
+			return b
+		}
+	}
+	return nil
+}
+
+// AddBuild records a build, keeping builds sorted
+func (ci *ChannelIndex) AddBuild(entry *BuildEntry) {
+	var builds []*BuildEntry
+	for _, b := range ci.Builds {
+		if b.ID != entry.ID {
+			builds = append(builds, b)
+		}
+	}
+	builds = append(builds, entry)
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].ID < builds[j].ID
+	})
+	ci.Builds = builds
+}
+
+// buildFilePath returns where a build file is stored in a mirror, relative
+// to its root: channel/buildID/type[-subtype].ext
+func buildFilePath(channel string, buildID int64, f *itchio.BuildFile) string {
+	name := string(f.Type)
+	if f.SubType != "" && f.SubType != itchio.BuildFileSubTypeDefault {
+		name += "-" + string(f.SubType)
+	}
+
+	switch f.Type {
+	case itchio.BuildFileTypeArchive:
+		name += ".zip"
+	case itchio.BuildFileTypePatch:
+		name += ".pwr"
+	case itchio.BuildFileTypeSignature:
+		name += ".pws"
+	case itchio.BuildFileTypeManifest:
+		name += ".pwm"
+	}
+
+	return path.Join(channel, strconv.FormatInt(buildID, 10), name)
+}
+
+// isComplete returns true if all files of a build entry are on disk,
+// with the right size
+func (be *BuildEntry) isComplete(dir string) bool {
+	for _, f := range be.Files {
+		stats, err := os.Stat(filepath.Join(dir, filepath.FromSlash(f.Path)))
+		if err != nil || stats.Size() != f.Size {
+			return false
+		}
+	}
+	return true
+}
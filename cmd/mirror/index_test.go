@@ -0,0 +1,68 @@
+package mirror
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildFilePath(t *testing.T) {
+	assert.EqualValues(t, "win-64/123/archive.zip", buildFilePath("win-64", 123, &itchio.BuildFile{
+		Type:    itchio.BuildFileTypeArchive,
+		SubType: itchio.BuildFileSubTypeDefault,
+	}))
+	assert.EqualValues(t, "win-64/123/patch-optimized.pwr", buildFilePath("win-64", 123, &itchio.BuildFile{
+		Type:    itchio.BuildFileTypePatch,
+		SubType: itchio.BuildFileSubTypeOptimized,
+	}))
+	assert.EqualValues(t, "win-64/123/signature.pws", buildFilePath("win-64", 123, &itchio.BuildFile{
+		Type:    itchio.BuildFileTypeSignature,
+		SubType: itchio.BuildFileSubTypeDefault,
+	}))
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mirror")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	index, err := ReadIndex(dir)
+	wtest.Must(t, err)
+	assert.Empty(t, index.Channels)
+
+	ci := &ChannelIndex{Name: "linux"}
+	index.Channels["linux"] = ci
+	ci.AddBuild(&BuildEntry{ID: 20})
+	ci.AddBuild(&BuildEntry{ID: 10})
+	ci.AddBuild(&BuildEntry{ID: 20, UserVersion: "1.1"})
+	assert.EqualValues(t, 2, len(ci.Builds))
+	assert.EqualValues(t, 10, ci.Builds[0].ID)
+	assert.EqualValues(t, "1.1", ci.FindBuild(20).UserVersion)
+	assert.Nil(t, ci.FindBuild(30))
+
+	wtest.Must(t, index.Write(dir))
+	index, err = ReadIndex(dir)
+	wtest.Must(t, err)
+	assert.EqualValues(t, 2, len(index.Channels["linux"].Builds))
+
+	entry := &BuildEntry{
+		ID: 10,
+		Files: []*FileEntry{
+			{Path: "linux/10/archive.zip", Size: 5},
+		},
+	}
+	assert.False(t, entry.isComplete(dir))
+
+	archivePath := filepath.Join(dir, "linux", "10", "archive.zip")
+	wtest.Must(t, os.MkdirAll(filepath.Dir(archivePath), 0755))
+	wtest.Must(t, ioutil.WriteFile(archivePath, []byte("hey"), 0644))
+	assert.False(t, entry.isComplete(dir), "partial files don't count")
+
+	wtest.Must(t, ioutil.WriteFile(archivePath, []byte("hello"), 0644))
+	assert.True(t, entry.isComplete(dir))
+}
@@ -0,0 +1,270 @@
+package mirror
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/itchio/butler/cmd/dl"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	target   *string
+	dir      *string
+	channels *[]string
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("mirror", "Download the builds of every channel of a project (archives, patches and signatures) to a folder. Running it again only downloads new builds.")
+	args.target = cmd.Arg("target", "Which user/project to mirror, for example 'leafo/x-moon'").Required().String()
+	args.dir = cmd.Arg("dir", "Folder to mirror builds to").Required().String()
+	args.channels = cmd.Flag("channel", "Only mirror this channel (can be specified multiple times)").Strings()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	go ctx.DoVersionCheck()
+
+	ctx.Must(Do(ctx, &Params{
+		Target:   *args.target,
+		Dir:      *args.dir,
+		Channels: *args.channels,
+	}))
+}
+
+// Params control what to mirror, and where to
+type Params struct {
+	// Target is user/game or a game ID
+	Target string
+	// Dir is where builds and the index are stored
+	Dir string
+	// Channels restricts mirroring to these channels, if not empty
+	Channels []string
+}
+
+type stats struct {
+	builds     int
+	skipped    int
+	files      int
+	downloaded int64
+}
+
+// Do downloads all build files of a project that aren't in the mirror yet,
+// updating the index after each build.
+func Do(ctx *mansion.Context, params *Params) error {
+	spec, err := itchio.ParseSpec(params.Target)
+	if err != nil {
+		return errors.Wrapf(err, "parsing target '%s'", params.Target)
+	}
+	if spec.Channel != "" {
+		return errors.Errorf("mirror works on whole projects, use --channel %s to only mirror that channel", spec.Channel)
+	}
+
+	err = os.MkdirAll(params.Dir, 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	index, err := ReadIndex(params.Dir)
+	if err != nil {
+		return err
+	}
+	if index.Target != "" && index.Target != spec.Target {
+		return errors.Errorf("%s is a mirror of %s, not %s", params.Dir, index.Target, spec.Target)
+	}
+	index.Target = spec.Target
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+
+	channelsRes, err := client.ListChannels(spec.Target)
+	if err != nil {
+		return errors.Wrap(err, "listing channels")
+	}
+
+	var names []string
+	for name := range channelsRes.Channels {
+		if len(params.Channels) > 0 && !contains(params.Channels, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range params.Channels {
+		if _, ok := channelsRes.Channels[name]; !ok {
+			return errors.Errorf("channel %s not found for %s", name, spec.Target)
+		}
+	}
+
+	startTime := time.Now()
+	st := &stats{}
+	for _, name := range names {
+		err := mirrorChannel(ctx, client, params.Dir, index, channelsRes.Channels[name], st)
+		if err != nil {
+			return errors.WithMessage(err, "mirroring channel "+name)
+		}
+	}
+
+	index.UpdatedAt = time.Now().UTC()
+	err = index.Write(params.Dir)
+	if err != nil {
+		return err
+	}
+
+	comm.Statf("Mirrored %d new builds (%d files, %s) in %s, %d were already there",
+		st.builds, st.files, progress.FormatBytes(st.downloaded),
+		progress.FormatDuration(time.Since(startTime)), st.skipped)
+	return nil
+}
+
+func mirrorChannel(ctx *mansion.Context, client *itchio.Client, dir string, index *Index, ch *itchio.Channel, st *stats) error {
+	if ch.Upload == nil {
+		return errors.New("channel has no upload")
+	}
+	if !isSafeName(ch.Name) {
+		return errors.Errorf("refusing to mirror channel with name %q", ch.Name)
+	}
+
+	ci := index.Channels[ch.Name]
+	if ci == nil {
+		ci = &ChannelIndex{Name: ch.Name}
+		index.Channels[ch.Name] = ci
+	}
+	ci.Tags = ch.Tags
+	ci.UploadID = ch.Upload.ID
+
+	buildsRes, err := client.ListUploadBuilds(itchio.ListUploadBuildsParams{
+		UploadID: ch.Upload.ID,
+	})
+	if err != nil {
+		return errors.Wrap(err, "listing builds")
+	}
+
+	builds := buildsRes.Builds
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].ID < builds[j].ID
+	})
+
+	comm.Opf("Channel %s: %d builds", ch.Name, len(builds))
+
+	for _, build := range builds {
+		if entry := ci.FindBuild(build.ID); entry != nil && entry.isComplete(dir) {
+			st.skipped++
+			continue
+		}
+
+		if build.State != itchio.BuildStateCompleted {
+			comm.Logf("Skipping build #%d, it's %s", build.ID, build.State)
+			continue
+		}
+
+		entry, err := mirrorBuild(ctx, client, dir, ch.Name, build, st)
+		if err != nil {
+			return errors.WithMessage(err, fmt.Sprintf("mirroring build #%d", build.ID))
+		}
+		ci.AddBuild(entry)
+		st.builds++
+
+		// so an interrupted mirror doesn't download this build again
+		err = index.Write(dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func mirrorBuild(ctx *mansion.Context, client *itchio.Client, dir string, channel string, build *itchio.Build, st *stats) (*BuildEntry, error) {
+	filesRes, err := client.ListBuildFiles(build.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing build files")
+	}
+
+	entry := &BuildEntry{
+		ID:            build.ID,
+		ParentBuildID: build.ParentBuildID,
+		Version:       build.Version,
+		UserVersion:   build.UserVersion,
+		CreatedAt:     build.CreatedAt,
+	}
+
+	for _, f := range filesRes.Files {
+		if f.State != itchio.BuildFileStateUploaded {
+			continue
+		}
+
+		relPath := buildFilePath(channel, build.ID, f)
+		destPath := filepath.Join(dir, filepath.FromSlash(relPath))
+
+		info, err := os.Stat(destPath)
+		if err == nil && info.Size() == f.Size {
+			comm.Debugf("%s already downloaded", relPath)
+		} else {
+			comm.Opf("Downloading %s (%s)", relPath, progress.FormatBytes(f.Size))
+
+			err = os.MkdirAll(filepath.Dir(destPath), 0755)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+
+			url := client.MakeBuildFileDownloadURL(itchio.MakeBuildFileDownloadURLParams{
+				BuildID: build.ID,
+				FileID:  f.ID,
+			})
+
+			// downloads resume from partial files, which are only
+			// renamed once complete and checked.
+			partPath := destPath + ".part"
+			size, err := dl.Do(ctx, url, partPath)
+			if err != nil {
+				return nil, errors.WithMessage(err, "downloading "+relPath)
+			}
+			if f.Size > 0 && size != f.Size {
+				os.Remove(partPath)
+				return nil, errors.Errorf("%s: expected %d bytes, got %d", relPath, f.Size, size)
+			}
+
+			err = os.Rename(partPath, destPath)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			st.files++
+			st.downloaded += size
+		}
+
+		entry.Files = append(entry.Files, &FileEntry{
+			ID:      f.ID,
+			Type:    f.Type,
+			SubType: f.SubType,
+			Size:    f.Size,
+			Path:    relPath,
+		})
+	}
+
+	return entry, nil
+}
+
+// isSafeName returns true if a channel name can be used as a folder name
+func isSafeName(name string) bool {
+	return name != "" && !strings.HasPrefix(name, ".") && !strings.ContainsAny(name, `/\:`)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
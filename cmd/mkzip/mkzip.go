@@ -3,6 +3,11 @@ package mkzip
 import (
 	"io"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/itchio/httpkit/progress"
@@ -11,24 +16,37 @@ import (
 
 	"github.com/itchio/arkive/zip"
 
+	"github.com/itchio/butler/cmd/auditzip"
 	"github.com/itchio/butler/comm"
 
 	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
-	"github.com/itchio/wharf/pools/fspool"
-	"github.com/itchio/wharf/pools/zipwriterpool"
+	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
 )
 
 var args = struct {
-	out string
-	dir string
+	out             string
+	dir             string
+	methods         []string
+	ignoreFiles     []string
+	mtime           string
+	keepMtimes      bool
+	keepPermissions bool
+	verify          bool
 }{}
 
 func Register(ctx *mansion.Context) {
-	cmd := ctx.App.Command("mkzip", "(Advanced) Create a .zip file").Hidden()
+	cmd := ctx.App.Command("mkzip", "Create a .zip file from a directory, the same way every time")
 	cmd.Arg("out", "Output file").Required().StringVar(&args.out)
 	cmd.Arg("dir", "Directory to compress").Required().ExistingDirVar(&args.dir)
+	cmd.Flag("method", "Compression method for files matching a glob, as GLOB=METHOD, where METHOD is store or deflate. May be given several times, the first match wins. Defaults to deflate").StringsVar(&args.methods)
+	cmd.Flag("ignore-file", "Also exclude files matching the rules of this ignore file (same syntax as .butlerignore). May be given several times").ExistingFilesVar(&args.ignoreFiles)
+	cmd.Flag("mtime", "Modification time of all entries, in RFC 3339 format. Defaults to $SOURCE_DATE_EPOCH if set, 1980-01-01T00:00:00Z otherwise").StringVar(&args.mtime)
+	cmd.Flag("keep-mtimes", "Keep the modification times of files instead").BoolVar(&args.keepMtimes)
+	cmd.Flag("keep-permissions", "Keep the permissions of files instead of normalizing them to 644 and 755 (they're still made readable by everyone)").BoolVar(&args.keepPermissions)
+	cmd.Flag("verify", "Audit the zip file once it's written").BoolVar(&args.verify)
 	ctx.Register(cmd, func(ctx *mansion.Context) {
 		ctx.Must(do(ctx))
 	})
@@ -37,45 +55,294 @@ func Register(ctx *mansion.Context) {
 func do(ctx *mansion.Context) error {
 	consumer := comm.NewStateConsumer()
 
-	consumer.Opf("Walking %s...", args.dir)
+	modTime, err := ParseModTime(args.mtime, os.Getenv("SOURCE_DATE_EPOCH"))
+	if err != nil {
+		return err
+	}
+
+	var methods []*MethodRule
+	for _, s := range args.methods {
+		rule, err := ParseMethodRule(s)
+		if err != nil {
+			return err
+		}
+		methods = append(methods, rule)
+	}
+
+	return Do(&Params{
+		Out:             args.out,
+		Dir:             args.dir,
+		Methods:         methods,
+		IgnoreFiles:     args.ignoreFiles,
+		ModTime:         modTime,
+		KeepModTimes:    args.keepMtimes,
+		KeepPermissions: args.keepPermissions,
+		Verify:          args.verify,
+		Consumer:        consumer,
+	})
+}
+
+// DefaultModTime is the modification time given to all entries unless
+// specified otherwise. It's the earliest time MS-DOS timestamps can hold.
+var DefaultModTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ParseModTime parses the value of --mtime, falling back to the value
+// of $SOURCE_DATE_EPOCH (see https://reproducible-builds.org/specs/source-date-epoch/),
+// then to DefaultModTime. Times before DefaultModTime are clamped to it.
+func ParseModTime(mtime string, sourceDateEpoch string) (time.Time, error) {
+	if mtime != "" {
+		t, err := time.Parse(time.RFC3339, mtime)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parsing --mtime")
+		}
+		return clampModTime(t.UTC()), nil
+	}
+
+	if sourceDateEpoch != "" {
+		secs, err := strconv.ParseInt(sourceDateEpoch, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parsing $SOURCE_DATE_EPOCH")
+		}
+		return clampModTime(time.Unix(secs, 0).UTC()), nil
+	}
+
+	return DefaultModTime, nil
+}
+
+// clampModTime returns DefaultModTime for times MS-DOS timestamps can't
+// hold, which would otherwise wrap around to the end of the century.
+func clampModTime(t time.Time) time.Time {
+	if t.Before(DefaultModTime) {
+		comm.Debugf("Modification time %s is before %s, using the latter", t.Format(time.RFC3339), DefaultModTime.Format(time.RFC3339))
+		return DefaultModTime
+	}
+	return t
+}
+
+// A MethodRule picks the compression method of files matching Pattern
+type MethodRule struct {
+	// Pattern is matched against the name of files if it has no slashes,
+	// and against their path relative to the folder being compressed otherwise
+	Pattern string
+	Method  uint16
+}
+
+var methodNames = map[string]uint16{
+	"store":   zip.Store,
+	"deflate": zip.Deflate,
+}
+
+// ParseMethodRule parses rules in the GLOB=METHOD form
+func ParseMethodRule(s string) (*MethodRule, error) {
+	i := strings.LastIndex(s, "=")
+	if i == -1 {
+		return nil, errors.Errorf("invalid method rule %q: expected GLOB=METHOD", s)
+	}
+
+	pattern, name := s[:i], strings.ToLower(s[i+1:])
+	method, ok := methodNames[name]
+	if !ok {
+		return nil, errors.Errorf("invalid method rule %q: unknown method %q (expected store or deflate)", s, name)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, errors.Wrapf(err, "invalid method rule %q", s)
+	}
+
+	return &MethodRule{
+		Pattern: pattern,
+		Method:  method,
+	}, nil
+}
+
+// Matches returns true if the rule applies to the file at slash path p
+func (mr *MethodRule) Matches(p string) bool {
+	subject := p
+	if !strings.Contains(mr.Pattern, "/") {
+		subject = path.Base(p)
+	}
+	match, _ := path.Match(mr.Pattern, subject)
+	return match
+}
+
+// Params configures Do
+type Params struct {
+	// Out is the path of the .zip file to write
+	Out string
+	// Dir is the folder to compress
+	Dir string
+
+	// Methods are tried in order for each file, files that match
+	// none of them are deflated
+	Methods []*MethodRule
+	// IgnoreFiles are applied on top of the .butlerignore files found in Dir
+	IgnoreFiles []string
+
+	// ModTime is given to all entries, unless KeepModTimes is set
+	ModTime      time.Time
+	KeepModTimes bool
+	// Unless KeepPermissions is set, files are stored as 644 (or 755 if they're
+	// executable by anyone), folders as 755 and symlinks as 777
+	KeepPermissions bool
+
+	// Verify runs auditzip on the result
+	Verify bool
+
+	Consumer *state.Consumer
+}
+
+type entry struct {
+	name      string
+	mode      os.FileMode
+	fileIndex int64
+	dest      string
+}
+
+// Do walks a folder and writes its contents to a .zip file. Entries are sorted
+// by name, and given the same timestamp and normalized permissions by default, so
+// that compressing the same files always gives the same bytes.
+func Do(params *Params) error {
+	consumer := params.Consumer
+	if consumer == nil {
+		consumer = &state.Consumer{}
+	}
+
+	dir := params.Dir
+	consumer.Opf("Walking %s...", dir)
 	walkOpts := &tlc.WalkOpts{
 		Filter: filtering.FilterPaths,
 	}
-	walkOpts.Wrap(&args.dir)
-	container, err := tlc.WalkDir(args.dir, walkOpts)
+	walkOpts.Wrap(&dir)
+	container, exclusions, err := filtering.WalkAnyWithExclusions(dir, walkOpts)
 	if err != nil {
-		return err
+		return errors.WithStack(err)
 	}
 
+	if len(params.IgnoreFiles) > 0 {
+		var rules []*filtering.IgnoreRule
+		for _, ignoreFile := range params.IgnoreFiles {
+			f, err := os.Open(ignoreFile)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			fileRules, err := filtering.ParseIgnoreFile(ignoreFile, "", f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			rules = append(rules, fileRules...)
+		}
+
+		var moreExclusions []*filtering.Exclusion
+		container, moreExclusions = filtering.NewIgnoreRules(rules).Apply(container, walkOpts.WrappedDir)
+		exclusions = append(exclusions, moreExclusions...)
+	}
+
+	for _, ex := range exclusions {
+		consumer.Debugf("Excluding %s (%s)", ex.Path, ex.Rule)
+	}
 	consumer.Statf("Found %s", container)
 
-	src := fspool.New(container, args.dir)
+	var entries []*entry
+	for _, d := range container.Dirs {
+		mode := os.ModeDir | 0755
+		if params.KeepPermissions {
+			mode = os.ModeDir | os.FileMode(d.Mode).Perm()
+		}
+		entries = append(entries, &entry{name: d.Path + "/", mode: mode, fileIndex: -1})
+	}
+	for i, f := range container.Files {
+		mode := os.FileMode(0644)
+		if f.Mode&0111 != 0 {
+			mode = 0755
+		}
+		if params.KeepPermissions {
+			mode = os.FileMode(f.Mode).Perm()
+		}
+		entries = append(entries, &entry{name: f.Path, mode: mode, fileIndex: int64(i)})
+	}
+	for _, s := range container.Symlinks {
+		mode := os.ModeSymlink | 0777
+		if params.KeepPermissions {
+			mode = os.ModeSymlink | os.FileMode(s.Mode).Perm()
+		}
+		entries = append(entries, &entry{name: s.Path, mode: mode, fileIndex: -1, dest: s.Dest})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].name < entries[j].name
+	})
 
-	w, err := os.Create(args.out)
+	w, err := os.Create(params.Out)
 	if err != nil {
-		return err
+		return errors.WithStack(err)
 	}
+	defer w.Close()
 
 	zw := zip.NewWriter(w)
 
-	dst := zipwriterpool.New(container, zw)
-
 	var totalBytes int64
 
-	doFile := func(fileIndex int64) error {
-		file := container.Files[fileIndex]
+	doEntry := func(e *entry) error {
+		fh := &zip.FileHeader{
+			Name:   e.name,
+			Method: zip.Store,
+		}
+		fh.SetMode(e.mode)
+
+		modTime := params.ModTime
+		if params.KeepModTimes {
+			stats, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(strings.TrimSuffix(e.name, "/"))))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			modTime = stats.ModTime().UTC().Truncate(time.Second)
+			if modTime.Before(DefaultModTime) {
+				modTime = DefaultModTime
+			}
+		}
+		fh.Modified = modTime
+
+		if e.fileIndex == -1 {
+			ew, err := zw.CreateHeader(fh)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if e.mode&os.ModeSymlink != 0 {
+				_, err = ew.Write([]byte(e.dest))
+				if err != nil {
+					return errors.WithStack(err)
+				}
+			}
+			return nil
+		}
+
+		file := container.Files[e.fileIndex]
 		consumer.ProgressLabel(file.Path)
 
-		fsrc, err := src.GetReader(fileIndex)
+		// rules are relative to the folder being compressed
+		relPath := file.Path
+		if walkOpts.WrappedDir != "" {
+			relPath = strings.TrimPrefix(relPath, walkOpts.WrappedDir+"/")
+		}
+
+		fh.Method = zip.Deflate
+		for _, rule := range params.Methods {
+			if rule.Matches(relPath) {
+				fh.Method = rule.Method
+				break
+			}
+		}
+		fh.UncompressedSize64 = uint64(file.Size)
+
+		fsrc, err := os.Open(filepath.Join(dir, filepath.FromSlash(file.Path)))
 		if err != nil {
-			return err
+			return errors.WithStack(err)
 		}
+		defer fsrc.Close()
 
-		fdst, err := dst.GetWriter(fileIndex)
+		fdst, err := zw.CreateHeader(fh)
 		if err != nil {
-			return err
+			return errors.WithStack(err)
 		}
-		defer fdst.Close()
 
 		cw := counter.NewWriterCallback(func(done int64) {
 			p := float64(totalBytes+done) / float64(container.Size)
@@ -84,7 +351,7 @@ func do(ctx *mansion.Context) error {
 
 		_, err = io.Copy(cw, fsrc)
 		if err != nil {
-			return err
+			return errors.WithStack(err)
 		}
 
 		totalBytes += file.Size
@@ -96,18 +363,23 @@ func do(ctx *mansion.Context) error {
 	comm.StartProgressWithTotalBytes(container.Size)
 	startTime := time.Now()
 
-	numFiles := len(container.Files)
-	for i := 0; i < numFiles; i++ {
-		err = doFile(int64(i))
+	for _, e := range entries {
+		err = doEntry(e)
 		if err != nil {
+			comm.EndProgress()
 			return err
 		}
 	}
 	comm.EndProgress()
 
-	err = dst.Close()
+	err = zw.Close()
 	if err != nil {
-		return err
+		return errors.WithStack(err)
+	}
+
+	err = w.Close()
+	if err != nil {
+		return errors.WithStack(err)
 	}
 
 	duration := time.Since(startTime)
@@ -115,5 +387,12 @@ func do(ctx *mansion.Context) error {
 		progress.FormatBPS(container.Size, duration),
 		progress.FormatDuration(duration),
 	)
+
+	if params.Verify {
+		return auditzip.Do(&auditzip.Params{
+			File:     params.Out,
+			Consumer: consumer,
+		})
+	}
 	return nil
 }
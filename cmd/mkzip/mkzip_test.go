@@ -0,0 +1,181 @@
+package mkzip_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/itchio/arkive/zip"
+	"github.com/itchio/butler/cmd/mkzip"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMkzip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mkzip")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	rng := rand.New(rand.NewSource(1))
+	files := map[string]os.FileMode{
+		"game.exe":              0700,
+		"readme.txt":            0600,
+		"data/b.png":            0644,
+		"data/a.pak":            0644,
+		"data/music/theme.ogg":  0644,
+		"data/music/notes.txt":  0644,
+		"logs/debug.log":        0644,
+		"a-first/inside/file.x": 0644,
+	}
+	for name, mode := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0700))
+		buf := make([]byte, 4096)
+		rng.Read(buf)
+		wtest.Must(t, ioutil.WriteFile(p, buf, mode))
+	}
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(src, ".butlerignore"), []byte("logs/\n"), 0644))
+	ignoreFile := filepath.Join(dir, "extra-ignore")
+	wtest.Must(t, ioutil.WriteFile(ignoreFile, []byte("*.pak\n"), 0644))
+	if runtime.GOOS != "windows" {
+		wtest.Must(t, os.Symlink("game.exe", filepath.Join(src, "launch")))
+	}
+
+	consumer := &state.Consumer{
+		OnMessage: func(level string, message string) {
+			t.Logf("[%s] %s", level, message)
+		},
+	}
+
+	png, err := mkzip.ParseMethodRule("*.png=store")
+	wtest.Must(t, err)
+	music, err := mkzip.ParseMethodRule("data/music/*=STORE")
+	wtest.Must(t, err)
+	_, err = mkzip.ParseMethodRule("*.png=lzma")
+	assert.Error(t, err)
+	_, err = mkzip.ParseMethodRule("*.png")
+	assert.Error(t, err)
+
+	params := func(out string) *mkzip.Params {
+		return &mkzip.Params{
+			Out:         out,
+			Dir:         src,
+			Methods:     []*mkzip.MethodRule{png, music},
+			IgnoreFiles: []string{ignoreFile},
+			ModTime:     mkzip.DefaultModTime,
+			Verify:      true,
+			Consumer:    consumer,
+		}
+	}
+
+	first := filepath.Join(dir, "first.zip")
+	wtest.Must(t, mkzip.Do(params(first)))
+
+	// touch everything, the output shouldn't change
+	later := time.Now().Add(time.Hour)
+	for name := range files {
+		wtest.Must(t, os.Chtimes(filepath.Join(src, filepath.FromSlash(name)), later, later))
+	}
+	second := filepath.Join(dir, "second.zip")
+	wtest.Must(t, mkzip.Do(params(second)))
+
+	firstBytes, err := ioutil.ReadFile(first)
+	wtest.Must(t, err)
+	secondBytes, err := ioutil.ReadFile(second)
+	wtest.Must(t, err)
+	assert.True(t, bytes.Equal(firstBytes, secondBytes), "zips should be identical")
+
+	zr, err := zip.NewReader(bytes.NewReader(firstBytes), int64(len(firstBytes)))
+	wtest.Must(t, err)
+
+	var names []string
+	methods := make(map[string]uint16)
+	modes := make(map[string]os.FileMode)
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		methods[f.Name] = f.Method
+		modes[f.Name] = f.Mode()
+		assert.True(t, f.Modified.Equal(mkzip.DefaultModTime), "%s should have the default timestamp", f.Name)
+	}
+
+	// the folder itself is part of the zip
+	expectedNames := []string{
+		"src/",
+		"src/a-first/",
+		"src/a-first/inside/",
+		"src/a-first/inside/file.x",
+		"src/data/",
+		"src/data/b.png",
+		"src/data/music/",
+		"src/data/music/notes.txt",
+		"src/data/music/theme.ogg",
+		"src/game.exe",
+	}
+	if runtime.GOOS != "windows" {
+		expectedNames = append(expectedNames, "src/launch")
+	}
+	expectedNames = append(expectedNames, "src/readme.txt")
+	assert.EqualValues(t, expectedNames, names)
+
+	assert.EqualValues(t, zip.Store, methods["src/data/b.png"])
+	assert.EqualValues(t, zip.Store, methods["src/data/music/notes.txt"])
+	assert.EqualValues(t, zip.Deflate, methods["src/readme.txt"])
+
+	if runtime.GOOS != "windows" {
+		assert.EqualValues(t, 0755, modes["src/game.exe"])
+		assert.EqualValues(t, 0644, modes["src/readme.txt"])
+		assert.EqualValues(t, os.ModeDir|0755, modes["src/data/"])
+		assert.EqualValues(t, os.ModeSymlink|0777, modes["src/launch"])
+
+		kept := params(filepath.Join(dir, "kept.zip"))
+		kept.KeepPermissions = true
+		wtest.Must(t, mkzip.Do(kept))
+		zr, err := zip.OpenReader(kept.Out)
+		wtest.Must(t, err)
+		defer zr.Close()
+		for _, f := range zr.File {
+			switch f.Name {
+			case "src/game.exe":
+				// walking always makes files readable by everyone
+				assert.EqualValues(t, 0744, f.Mode())
+			case "src/data/":
+				assert.EqualValues(t, os.ModeDir|0744, f.Mode())
+			}
+		}
+	}
+}
+
+func TestParseModTime(t *testing.T) {
+	mtime, err := mkzip.ParseModTime("", "")
+	wtest.Must(t, err)
+	assert.True(t, mtime.Equal(mkzip.DefaultModTime))
+
+	mtime, err = mkzip.ParseModTime("", "1600000000")
+	wtest.Must(t, err)
+	assert.EqualValues(t, 1600000000, mtime.Unix())
+
+	mtime, err = mkzip.ParseModTime("2020-02-03T04:05:06+01:00", "1600000000")
+	wtest.Must(t, err)
+	assert.True(t, mtime.Equal(time.Date(2020, 2, 3, 3, 5, 6, 0, time.UTC)))
+
+	// MS-DOS timestamps can't go before 1980
+	mtime, err = mkzip.ParseModTime("", "0")
+	wtest.Must(t, err)
+	assert.True(t, mtime.Equal(mkzip.DefaultModTime))
+
+	mtime, err = mkzip.ParseModTime("1970-01-01T00:00:00Z", "")
+	wtest.Must(t, err)
+	assert.True(t, mtime.Equal(mkzip.DefaultModTime))
+
+	_, err = mkzip.ParseModTime("yesterday", "")
+	assert.Error(t, err)
+	_, err = mkzip.ParseModTime("", "soon")
+	assert.Error(t, err)
+}
@@ -27,7 +27,12 @@ func commitInstall(oc *OperationContext, params *CommitInstallParams) error {
 
 	res := params.InstallResult
 
-	err := messages.TaskSucceeded.Notify(oc.rc, butlerd.TaskSucceededNotification{
+	err := checkSealedInstall(oc, params)
+	if err != nil {
+		return err
+	}
+
+	err = messages.TaskSucceeded.Notify(oc.rc, butlerd.TaskSucceededNotification{
 		Type: butlerd.TaskTypeInstall,
 		InstallResult: &butlerd.InstallResult{
 			Game:   params.Game,
@@ -34,6 +34,9 @@ type OperationContext struct {
 	loaded map[string]struct{}
 
 	pidFilePath string
+
+	// set once the build's seal was checked, see checkBuildSeal
+	sealed *sealedBuild
 }
 
 type PidFileContents struct {
@@ -139,6 +139,11 @@ func doInstallPerform(oc *OperationContext, meta *MetaSubcontext) error {
 	defer rlock.Unlock()
 
 	return InstallPrepare(oc, meta, isub, true, func(prepareRes *InstallPrepareResult) error {
+		err := checkBuildSeal(oc, meta, isub)
+		if err != nil {
+			return err
+		}
+
 		if !params.NoCave {
 			var cave *models.Cave
 			rc.WithConn(func(conn *sqlite.Conn) {
@@ -157,7 +162,7 @@ func doInstallPerform(oc *OperationContext, meta *MetaSubcontext) error {
 
 		if prepareRes.Strategy == InstallPerformStrategyUpgrade {
 			err := upgrade(oc, meta, isub, prepareRes.ReceiptIn)
-			if err == nil || errors.Cause(err) == patcher.ErrStop {
+			if err == nil || errors.Cause(err) == patcher.ErrStop || errors.Cause(err) == butlerd.CodeBuildSealMismatch {
 				return err
 			}
 
@@ -0,0 +1,169 @@
+package operate
+
+import (
+	"context"
+
+	"crawshaw.io/sqlite"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/installer"
+	"github.com/itchio/butler/seal"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+// sealedBuild is a build whose signature was sealed with a pinned key.
+// Its files are checked against that signature before the install is committed.
+type sealedBuild struct {
+	key       *models.PinnedKey
+	buildID   int64
+	signature *pwr.SignatureInfo
+}
+
+// checkBuildSeal makes sure the build we're about to install is sealed
+// with the key pinned for its game, if any.
+func checkBuildSeal(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcontext) error {
+	params := meta.Data
+	consumer := oc.Consumer()
+
+	var pk *models.PinnedKey
+	oc.rc.WithConn(func(conn *sqlite.Conn) {
+		pk = models.PinnedKeyForGame(conn, params.Game.ID)
+	})
+	if pk == nil {
+		return nil
+	}
+
+	client := oc.rc.Client(params.Access.APIKey)
+	sig, err := CheckSeal(oc.ctx, consumer, pk, params.Build, func() (eos.File, error) {
+		signatureURL := MakeSourceURL(client, consumer, isub.Data.DownloadSessionID, params, "signature")
+		return eos.Open(signatureURL, option.WithConsumer(consumer))
+	})
+	if err != nil {
+		return err
+	}
+
+	if sig != nil {
+		oc.sealed = &sealedBuild{
+			key:       pk,
+			buildID:   params.Build.ID,
+			signature: sig,
+		}
+	}
+	return nil
+}
+
+// checkSealedInstall makes sure the files about to be committed match the
+// sealed signature checked by checkBuildSeal, if any. Installs of other builds,
+// like the intermediate steps of an upgrade, aren't checked.
+func checkSealedInstall(oc *OperationContext, params *CommitInstallParams) error {
+	sb := oc.sealed
+	if sb == nil || params.Build == nil || params.Build.ID != sb.buildID {
+		return nil
+	}
+
+	consumer := oc.Consumer()
+	if params.InstallerName != string(installer.InstallerTypeArchive) {
+		return enforceSealPolicy(consumer, sb.key, errors.Errorf("installed with (%s), so the installed files can't be checked against the sealed signature", params.InstallerName))
+	}
+	return CheckSealedFolder(oc.ctx, consumer, sb.key, sb.signature, params.InstallFolder)
+}
+
+// CheckSeal checks the signature of a build against a pinned key. If it
+// doesn't match, it either logs a warning or returns butlerd.CodeBuildSealMismatch,
+// depending on the policy of the key. If it does, it returns the signature
+// read from the sealed file, which the installed build should be checked against.
+func CheckSeal(ctx context.Context, consumer *state.Consumer, pk *models.PinnedKey, build *itchio.Build, openSignature func() (eos.File, error)) (*pwr.SignatureInfo, error) {
+	pub, err := seal.ParsePublicKey(pk.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing pinned key")
+	}
+	consumer.Infof("Checking build seal against pinned key %s", seal.Fingerprint(pub))
+
+	var sig *pwr.SignatureInfo
+	checkErr := func() error {
+		if build == nil {
+			return errors.New("this upload isn't a wharf build, so it can't be sealed")
+		}
+
+		signatureFile, err := openSignature()
+		if err != nil {
+			return errors.Wrap(err, "opening build signature")
+		}
+		defer signatureFile.Close()
+
+		stats, err := signatureFile.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		s, err := seal.Check(signatureFile, stats.Size(), pub)
+		if err == seal.ErrUnknownKey {
+			return errors.Errorf("build is sealed with key %s", seal.Fingerprint(s.PublicKey))
+		}
+		if err != nil {
+			return err
+		}
+
+		// read the signature from the file we just checked, so
+		// it can't be swapped out in between
+		source := seeksource.FromFile(signatureFile)
+		_, err = source.Resume(nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		sig, err = pwr.ReadSignature(ctx, source)
+		if err != nil {
+			return errors.Wrap(err, "reading sealed signature")
+		}
+		return nil
+	}()
+
+	if checkErr != nil {
+		return nil, enforceSealPolicy(consumer, pk, errors.Wrap(checkErr, "Build seal check failed"))
+	}
+
+	consumer.Infof("✓ Build is sealed with the pinned key")
+	return sig, nil
+}
+
+// CheckSealedFolder checks the files of an installed build against its sealed
+// signature. If they don't match, it either logs a warning or returns
+// butlerd.CodeBuildSealMismatch, depending on the policy of the key.
+func CheckSealedFolder(ctx context.Context, consumer *state.Consumer, pk *models.PinnedKey, sig *pwr.SignatureInfo, folder string) error {
+	consumer.Infof("Checking installed files against the sealed signature...")
+
+	vc := &pwr.ValidatorContext{
+		Consumer: consumer,
+		FailFast: true,
+	}
+	err := vc.Validate(ctx, folder, sig)
+	if err != nil {
+		if _, ok := errors.Cause(err).(*pwr.ErrHasWound); ok {
+			return enforceSealPolicy(consumer, pk, errors.Wrap(err, "Installed files don't match the sealed signature"))
+		}
+		return errors.Wrap(err, "checking installed files against sealed signature")
+	}
+
+	consumer.Infof("✓ Installed files match the sealed signature")
+	return nil
+}
+
+// enforceSealPolicy either logs checkErr as a warning or returns
+// butlerd.CodeBuildSealMismatch, depending on the policy of the key.
+func enforceSealPolicy(consumer *state.Consumer, pk *models.PinnedKey, checkErr error) error {
+	if butlerd.SealPolicy(pk.Policy) == butlerd.SealPolicyWarn {
+		consumer.Warnf("%s", checkErr.Error())
+		consumer.Warnf("Installing anyway, the pinned key's policy is to warn")
+		return nil
+	}
+
+	consumer.Errorf("%s", checkErr.Error())
+	return errors.WithStack(butlerd.CodeBuildSealMismatch)
+}
@@ -0,0 +1,121 @@
+package operate_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/cmd/extract"
+	"github.com/itchio/butler/cmd/mkzip"
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/butler/cmd/sign"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/seal"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSeal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-seal")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := seal.GenerateKey()
+	wtest.Must(t, err)
+	otherPub, _, err := seal.GenerateKey()
+	wtest.Must(t, err)
+
+	build := filepath.Join(dir, "build")
+	writeFiles(t, build, map[string]string{
+		"game.exe":       "genuine executable",
+		"data/level.dat": "level 1",
+	})
+	sigPath := filepath.Join(dir, "build.pws")
+	wtest.Must(t, sign.Do(&sign.Params{
+		Dir:       build,
+		Signature: sigPath,
+		Compression: pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm_ZSTD,
+			Quality:   1,
+		},
+		Key: priv,
+	}))
+
+	openSignature := func() (eos.File, error) {
+		return eos.Open(sigPath)
+	}
+	consumer := &state.Consumer{}
+	pinnedKey := func(key ed25519.PublicKey, policy butlerd.SealPolicy) *models.PinnedKey {
+		return &models.PinnedKey{
+			GameID:    1,
+			PublicKey: seal.FormatPublicKey(key),
+			Policy:    string(policy),
+		}
+	}
+	check := func(pk *models.PinnedKey, build *itchio.Build) (*pwr.SignatureInfo, error) {
+		return operate.CheckSeal(context.Background(), consumer, pk, build, openSignature)
+	}
+
+	sig, err := check(pinnedKey(pub, butlerd.SealPolicyRefuse), &itchio.Build{ID: 123})
+	wtest.Must(t, err)
+	assert.EqualValues(t, 2, len(sig.Container.Files), "returns the sealed signature")
+
+	_, err = check(pinnedKey(otherPub, butlerd.SealPolicyRefuse), &itchio.Build{ID: 123})
+	assert.Equal(t, butlerd.CodeBuildSealMismatch, errors.Cause(err))
+	sig, err = check(pinnedKey(otherPub, butlerd.SealPolicyWarn), &itchio.Build{ID: 123})
+	wtest.Must(t, err)
+	assert.Nil(t, sig, "nothing to check the build against")
+
+	_, err = check(pinnedKey(pub, butlerd.SealPolicyRefuse), nil)
+	assert.Equal(t, butlerd.CodeBuildSealMismatch, errors.Cause(err), "uploads without builds can't be sealed")
+
+	// a sealed signature of the genuine build, installed from a tampered archive
+	tampered := filepath.Join(dir, "tampered")
+	writeFiles(t, tampered, map[string]string{
+		"game.exe":       "evil executable!!",
+		"data/level.dat": "level 1",
+	})
+	install := func(name string, src string) string {
+		archive := filepath.Join(dir, name+".zip")
+		wtest.Must(t, mkzip.Do(&mkzip.Params{
+			Out:      archive,
+			Dir:      src,
+			ModTime:  mkzip.DefaultModTime,
+			Consumer: consumer,
+		}))
+		folder := filepath.Join(dir, name+"-install")
+		wtest.Must(t, extract.Do(nil, &extract.ExtractParams{
+			File:     archive,
+			Dir:      folder,
+			Consumer: consumer,
+		}))
+		// the archive has the build folder at its root
+		return filepath.Join(folder, filepath.Base(src))
+	}
+	genuineFolder := install("genuine", build)
+	tamperedFolder := install("tampered", tampered)
+
+	sig, err = check(pinnedKey(pub, butlerd.SealPolicyRefuse), &itchio.Build{ID: 123})
+	wtest.Must(t, err)
+
+	checkFolder := func(policy butlerd.SealPolicy, folder string) error {
+		return operate.CheckSealedFolder(context.Background(), consumer, pinnedKey(pub, policy), sig, folder)
+	}
+	wtest.Must(t, checkFolder(butlerd.SealPolicyRefuse, genuineFolder))
+	err = checkFolder(butlerd.SealPolicyRefuse, tamperedFolder)
+	assert.Equal(t, butlerd.CodeBuildSealMismatch, errors.Cause(err))
+	wtest.Must(t, checkFolder(butlerd.SealPolicyWarn, tamperedFolder))
+
+	wtest.Must(t, ioutil.WriteFile(sigPath, []byte("not sealed"), 0644))
+	_, err = check(pinnedKey(pub, butlerd.SealPolicyRefuse), &itchio.Build{ID: 123})
+	assert.Equal(t, butlerd.CodeBuildSealMismatch, errors.Cause(err))
+}
@@ -1,6 +1,7 @@
 package operate
 
 import (
+	"context"
 	"encoding/gob"
 	"fmt"
 	"os"
@@ -18,6 +19,8 @@ import (
 	"github.com/itchio/wharf/pools/fspool"
 	"github.com/itchio/wharf/pwr/bowl"
 	"github.com/itchio/wharf/pwr/patcher"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
 	"github.com/pkg/errors"
 )
 
@@ -112,19 +115,95 @@ func applyPatch(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcont
 		UUID:        istate.DownloadSessionID,
 	})
 
-	patchSource, err := filesource.Open(patchURL, option.WithConsumer(consumer))
+	checkpointPath := filepath.Join(oc.StageFolder(), fmt.Sprintf("patch-%d-%s-checkpoint", build.ID, subType))
+
+	sourceContainer, err := PatchInPlace(&PatchInPlaceParams{
+		Ctx:            oc.Ctx(),
+		Consumer:       consumer,
+		PatchURL:       patchURL,
+		Folder:         params.InstallFolder,
+		StageFolder:    filepath.Join(params.StagingFolder, "patch-overlay"),
+		CheckpointPath: checkpointPath,
+		OnProgress:     progressTarget.Progress,
+	})
+	if err != nil {
+		return err
+	}
+
+	consumer.Infof("Patching done, getting signature info...")
+
+	res := resultForContainer(sourceContainer)
+
+	err = commitInstall(oc, &CommitInstallParams{
+		InstallFolder: params.InstallFolder,
+
+		// if we're applying patches, it's a wharf-enabled upload,
+		// and if it's a wharf-enabled upload, our installer is "archive".
+		InstallerName: "archive",
+		Game:          params.Game,
+		Upload:        params.Upload,
+		Build:         build,
+
+		InstallResult: res,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "while committing install")
+	}
+
+	istate.UpgradePathIndex = upgradePathIndex + 1
+	err = oc.Save(isub)
 	if err != nil {
-		return errors.Wrap(err, "opening remote patch")
+		return errors.WithMessage(err, "while saving install subcontext")
+	}
+
+	return nil
+}
+
+// PatchInPlaceParams control how a patch is applied to a folder
+type PatchInPlaceParams struct {
+	Ctx      context.Context
+	Consumer *state.Consumer
+
+	// PatchURL is where to read the patch from, anything eos can open
+	PatchURL string
+	// Folder contains the old build, and will contain the new one once done
+	Folder string
+	// OldFolder contains the old build instead of Folder if set, in which
+	// case the new build is written to Folder from scratch and OldFolder
+	// is left untouched.
+	OldFolder string
+	// StageFolder holds new versions of files until the patch is committed
+	StageFolder string
+	// CheckpointPath is where progress is saved, so patching can be resumed
+	// if interrupted. It's removed once patching is done.
+	CheckpointPath string
+
+	// OnProgress is called periodically with the progress of the patch, optional
+	OnProgress func(progress float64)
+}
+
+// PatchInPlace applies a patch to a folder, resuming from a checkpoint if
+// there's one, and returns the container of the new build.
+func PatchInPlace(params *PatchInPlaceParams) (*tlc.Container, error) {
+	ctx := params.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	consumer := params.Consumer
+
+	patchSource, err := filesource.Open(params.PatchURL, option.WithConsumer(consumer))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening remote patch")
 	}
 
 	consumer.Infof("Patch is %s", progress.FormatBytes(patchSource.Size()))
 
-	checkpointPath := filepath.Join(oc.StageFolder(), fmt.Sprintf("patch-%d-%s-checkpoint", build.ID, subType))
+	checkpointPath := params.CheckpointPath
 	consumer.Debugf("Using checkpoint (%s)", checkpointPath)
 
 	p, err := patcher.New(patchSource, consumer)
 	if err != nil {
-		return errors.Wrap(err, "creating patcher")
+		return nil, errors.Wrap(err, "creating patcher")
 	}
 
 	lastSaveTime := time.Now()
@@ -132,10 +211,12 @@ func applyPatch(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcont
 	consumer.Debugf("Save interval: %s", saveInterval)
 	p.SetSaveConsumer(&patcherSaveConsumer{
 		shouldSave: func() bool {
-			progressTarget.Progress(p.Progress())
+			if params.OnProgress != nil {
+				params.OnProgress(p.Progress())
+			}
 
 			select {
-			case <-oc.Ctx().Done():
+			case <-ctx.Done():
 				return true
 			default:
 				return time.Since(lastSaveTime) > saveInterval
@@ -162,7 +243,7 @@ func applyPatch(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcont
 			}
 
 			select {
-			case <-oc.Ctx().Done():
+			case <-ctx.Done():
 				return patcher.AfterSaveStop, nil
 			default:
 				return patcher.AfterSaveContinue, nil
@@ -170,20 +251,33 @@ func applyPatch(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcont
 		},
 	})
 
-	targetPool := fspool.New(p.GetTargetContainer(), params.InstallFolder)
-
-	stageFolder := filepath.Join(params.StagingFolder, "patch-overlay")
-
-	bowl, err := bowl.NewOverlayBowl(&bowl.OverlayBowlParams{
-		TargetContainer: p.GetTargetContainer(),
-		SourceContainer: p.GetSourceContainer(),
-
-		OutputFolder: params.InstallFolder,
-		StageFolder:  stageFolder,
-	})
+	var bwl bowl.Bowl
+	if params.OldFolder != "" {
+		bwl, err = bowl.NewFreshBowl(&bowl.FreshBowlParams{
+			TargetContainer: p.GetTargetContainer(),
+			SourceContainer: p.GetSourceContainer(),
+
+			TargetPool:   fspool.New(p.GetTargetContainer(), params.OldFolder),
+			OutputFolder: params.Folder,
+		})
+	} else {
+		bwl, err = bowl.NewOverlayBowl(&bowl.OverlayBowlParams{
+			TargetContainer: p.GetTargetContainer(),
+			SourceContainer: p.GetSourceContainer(),
+
+			OutputFolder: params.Folder,
+			StageFolder:  params.StageFolder,
+		})
+	}
 	if err != nil {
-		return errors.WithMessage(err, "while creating bowl for patch")
+		return nil, errors.WithMessage(err, "while creating bowl for patch")
+	}
+
+	targetFolder := params.Folder
+	if params.OldFolder != "" {
+		targetFolder = params.OldFolder
 	}
+	targetPool := fspool.New(p.GetTargetContainer(), targetFolder)
 
 	var checkpoint *patcher.Checkpoint
 	readCheckpoint := func() error {
@@ -211,48 +305,22 @@ func applyPatch(oc *OperationContext, meta *MetaSubcontext, isub *InstallSubcont
 
 	err = readCheckpoint()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = p.Resume(checkpoint, targetPool, bowl)
+	err = p.Resume(checkpoint, targetPool, bwl)
 	if err != nil {
-		return errors.WithMessage(err, "while applying patch")
+		return nil, errors.WithMessage(err, "while applying patch")
 	}
 
 	os.RemoveAll(checkpointPath)
 
-	err = bowl.Commit()
+	err = bwl.Commit()
 	if err != nil {
-		return errors.WithMessage(err, "while committing patch")
+		return nil, errors.WithMessage(err, "while committing patch")
 	}
 
-	consumer.Infof("Patching done, getting signature info...")
-
-	res := resultForContainer(p.GetSourceContainer())
-
-	err = commitInstall(oc, &CommitInstallParams{
-		InstallFolder: params.InstallFolder,
-
-		// if we're applying patches, it's a wharf-enabled upload,
-		// and if it's a wharf-enabled upload, our installer is "archive".
-		InstallerName: "archive",
-		Game:          params.Game,
-		Upload:        params.Upload,
-		Build:         build,
-
-		InstallResult: res,
-	})
-	if err != nil {
-		return errors.WithMessage(err, "while committing install")
-	}
-
-	istate.UpgradePathIndex = upgradePathIndex + 1
-	err = oc.Save(isub)
-	if err != nil {
-		return errors.WithMessage(err, "while saving install subcontext")
-	}
-
-	return nil
+	return p.GetSourceContainer(), nil
 }
 
 type patcherSaveConsumer struct {
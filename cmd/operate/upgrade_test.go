@@ -0,0 +1,92 @@
+package operate_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/diff"
+	"github.com/itchio/butler/cmd/operate"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, []byte(contents), 0644))
+	}
+}
+
+func TestPatchInPlace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "patch-in-place")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	v1 := filepath.Join(dir, "v1")
+	v2 := filepath.Join(dir, "v2")
+	writeFiles(t, v1, map[string]string{
+		"game.exe":        "old executable",
+		"data/level1.dat": "level 1",
+		"data/old.dat":    "gone in v2",
+	})
+	writeFiles(t, v2, map[string]string{
+		"game.exe":        "new executable",
+		"data/level1.dat": "level 1",
+		"data/level2.dat": "level 2",
+	})
+
+	patchPath := filepath.Join(dir, "patch.pwr")
+	wtest.Must(t, diff.Do(&diff.Params{
+		Target: v1,
+		Source: v2,
+		Patch:  patchPath,
+		Compression: pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm_ZSTD,
+			Quality:   1,
+		},
+	}))
+
+	// patch a copy of v1, in place
+	out := filepath.Join(dir, "out")
+	writeFiles(t, out, map[string]string{
+		"game.exe":        "old executable",
+		"data/level1.dat": "level 1",
+		"data/old.dat":    "gone in v2",
+		"notes.txt":       "not part of any build",
+	})
+
+	checkpointPath := filepath.Join(dir, "checkpoint")
+	container, err := operate.PatchInPlace(&operate.PatchInPlaceParams{
+		Consumer:       &state.Consumer{},
+		PatchURL:       patchPath,
+		Folder:         out,
+		StageFolder:    filepath.Join(dir, "stage"),
+		CheckpointPath: checkpointPath,
+	})
+	wtest.Must(t, err)
+
+	assert.EqualValues(t, 3, len(container.Files))
+
+	read := func(name string) string {
+		buf, err := ioutil.ReadFile(filepath.Join(out, filepath.FromSlash(name)))
+		wtest.Must(t, err)
+		return string(buf)
+	}
+	assert.EqualValues(t, "new executable", read("game.exe"))
+	assert.EqualValues(t, "level 2", read("data/level2.dat"))
+	assert.EqualValues(t, "not part of any build", read("notes.txt"), "files outside of the build are left alone")
+
+	_, err = os.Stat(filepath.Join(out, "data", "old.dat"))
+	assert.True(t, os.IsNotExist(err), "files removed from the build are removed")
+
+	_, err = os.Stat(checkpointPath)
+	assert.True(t, os.IsNotExist(err), "checkpoint is removed once done")
+}
@@ -0,0 +1,430 @@
+package probe
+
+import (
+	"fmt"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior/countingsource"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/pkg/errors"
+)
+
+// DeepAnalysis looks into the series of every file touched by a patch
+type DeepAnalysis struct {
+	// Series has one entry per touched file, in patch order
+	Series []SeriesStat
+
+	TotalTouched  int64
+	TotalPristine int64
+}
+
+// SeriesStat describes what the operations for a touched file do
+type SeriesStat struct {
+	// FileIndex is the index of the file in the source container
+	FileIndex int64
+	Algo      pwr.SyncHeader_Type
+	// Pristine is the number of bytes that stay in place when patching
+	Pristine int64
+
+	// BlocksFrom maps indices of target files to the number of blocks
+	// an rsync series takes from them
+	BlocksFrom map[int64]int64
+
+	// TargetIndex is the file a bsdiff series is diffed against
+	TargetIndex int64
+	// Similar is the number of add bytes that are zero, for bsdiff series
+	Similar int64
+	// Clobbered is the number of bytes a bsdiff series adds over
+	// data of the old file it hasn't read yet
+	Clobbered int64
+}
+
+type deepDiveContext struct {
+	target *tlc.Container
+	source *tlc.Container
+	rctx   *wire.ReadContext
+
+	totalPristine int64
+	totalTouched  int64
+}
+
+// DeepAnalyze reads a patch a second time, and analyzes further
+// the series of files that contain fresh data according to analysis.
+func DeepAnalyze(patch string, analysis *Analysis) (*DeepAnalysis, error) {
+	consumer := comm.NewStateConsumer()
+
+	patchStatPerFileIndex := make(map[int64]FileStat)
+	for _, ps := range analysis.Stats {
+		patchStatPerFileIndex[ps.FileIndex] = ps
+	}
+
+	patchReader, err := eos.Open(patch, option.WithConsumer(consumer))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer patchReader.Close()
+
+	patchSource := seeksource.FromFile(patchReader)
+
+	cs := countingsource.New(patchSource, func(count int64) {
+		comm.Progress(patchSource.Progress())
+	})
+	_, err = cs.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rctx := wire.NewReadContext(cs)
+	err = rctx.ExpectMagic(pwr.PatchMagic)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	header := &pwr.PatchHeader{}
+	err = rctx.ReadMessage(header)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rctx, err = pwr.DecompressWire(rctx, header.Compression)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	target := &tlc.Container{}
+	err = rctx.ReadMessage(target)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	source := &tlc.Container{}
+	err = rctx.ReadMessage(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	ddc := &deepDiveContext{
+		target: target,
+		source: source,
+		rctx:   rctx,
+	}
+
+	deep := &DeepAnalysis{}
+	sh := &pwr.SyncHeader{}
+
+	comm.StartProgressWithTotalBytes(cs.Size())
+	defer comm.EndProgress()
+
+	for fileIndex := range source.Files {
+		sh.Reset()
+		err = rctx.ReadMessage(sh)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if sh.FileIndex != int64(fileIndex) {
+			return nil, fmt.Errorf("malformed patch: expected file %d, got %d", fileIndex, sh.FileIndex)
+		}
+
+		pc := patchStatPerFileIndex[sh.FileIndex]
+		if pc.FreshData > 0 {
+			series, err := ddc.analyzeSeries(sh)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			deep.Series = append(deep.Series, *series)
+		} else {
+			err = ddc.skipSeries(sh)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	deep.TotalTouched = ddc.totalTouched
+	deep.TotalPristine = ddc.totalPristine
+	return deep, nil
+}
+
+func printDeepAnalysis(deep *DeepAnalysis) {
+	comm.Logf("")
+	comm.Statf("Deep-dived into %d touched files", len(deep.Series))
+	comm.Statf("All in all, that's %s / %s pristine of all the touched data",
+		progress.FormatBytes(deep.TotalPristine),
+		progress.FormatBytes(deep.TotalTouched),
+	)
+}
+
+func (ddc *deepDiveContext) analyzeSeries(sh *pwr.SyncHeader) (*SeriesStat, error) {
+	f := ddc.source.Files[sh.FileIndex]
+
+	switch sh.Type {
+	case pwr.SyncHeader_RSYNC:
+		ddc.totalTouched += f.Size
+		return ddc.analyzeRsync(sh)
+	case pwr.SyncHeader_BSDIFF:
+		ddc.totalTouched += f.Size
+		return ddc.analyzeBsdiff(sh)
+	default:
+		return nil, fmt.Errorf("don't know how to analyze series of type %d", sh.Type)
+	}
+}
+
+func (ddc *deepDiveContext) analyzeRsync(sh *pwr.SyncHeader) (*SeriesStat, error) {
+	f := ddc.source.Files[sh.FileIndex]
+	comm.Debugf("Analyzing rsync series for '%s'", f.Path)
+
+	rctx := ddc.rctx
+	readingOps := true
+
+	rop := &pwr.SyncOp{}
+
+	targetBlocks := make(map[int64]int64)
+
+	var pos int64
+	var pristine int64
+
+	for readingOps {
+		rop.Reset()
+
+		err := rctx.ReadMessage(rop)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		switch rop.Type {
+		case pwr.SyncOp_BLOCK_RANGE:
+			i := rop.FileIndex
+			targetBlocks[i] = targetBlocks[i] + rop.BlockSpan
+
+			tf := ddc.target.Files[rop.FileIndex]
+
+			fixedSize := (rop.BlockSpan - 1) * pwr.BlockSize
+			lastIndex := rop.BlockIndex + (rop.BlockSpan - 1)
+			lastSize := pwr.ComputeBlockSize(tf.Size, lastIndex)
+			totalSize := (fixedSize + lastSize)
+			pos += totalSize
+
+			if f.Path == tf.Path {
+				if pos == pwr.BlockSize*rop.BlockIndex {
+					pristine += totalSize
+				}
+			}
+		case pwr.SyncOp_DATA:
+			pos += int64(len(rop.Data))
+		case pwr.SyncOp_HEY_YOU_DID_IT:
+			readingOps = false
+		}
+	}
+
+	if len(targetBlocks) > 0 {
+		comm.Debugf("Sourcing from '%d' blocks total: ", len(targetBlocks))
+		for i, numBlocks := range targetBlocks {
+			tf := ddc.target.Files[i]
+			comm.Debugf("Taking %d blocks from '%s'", numBlocks, tf.Path)
+		}
+	} else {
+		comm.Debugf("Entirely fresh data!")
+	}
+
+	ddc.totalPristine += pristine
+
+	return &SeriesStat{
+		FileIndex:   sh.FileIndex,
+		Algo:        sh.Type,
+		Pristine:    pristine,
+		BlocksFrom:  targetBlocks,
+		TargetIndex: -1,
+	}, nil
+}
+
+func (ddc *deepDiveContext) analyzeBsdiff(sh *pwr.SyncHeader) (*SeriesStat, error) {
+	f := ddc.source.Files[sh.FileIndex]
+	comm.Debugf("Analyzing bsdiff series for '%s'", f.Path)
+
+	rctx := ddc.rctx
+	readingOps := true
+
+	bh := &pwr.BsdiffHeader{}
+	err := rctx.ReadMessage(bh)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	tf := ddc.target.Files[bh.TargetIndex]
+	comm.Debugf("Diffed against target file '%s'", tf.Path)
+	if tf.Path == f.Path {
+		comm.Debugf("Same path, can do in-place!")
+	}
+
+	bc := &bsdiff.Control{}
+
+	var oldpos int64
+	var newpos int64
+
+	var pristine int64
+	var similar int64
+	var bestUnchanged int64
+
+	clearUnchanged := func() {
+		if bestUnchanged > 1024*1024 {
+			comm.Debugf("%s contiguous unchanged block ending at from %s to %s",
+				progress.FormatBytes(bestUnchanged),
+				progress.FormatBytes(newpos-bestUnchanged),
+				progress.FormatBytes(newpos),
+			)
+		}
+		bestUnchanged = 0
+	}
+
+	var clobbered int64
+
+	for readingOps {
+		bc.Reset()
+
+		err = rctx.ReadMessage(bc)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if bc.Eof {
+			readingOps = false
+			break
+		}
+
+		if len(bc.Add) > 0 {
+			if oldpos == newpos {
+				var unchanged int64
+				for _, b := range bc.Add {
+					oldpos++
+					newpos++
+					if b == 0 {
+						unchanged++
+						bestUnchanged++
+					} else {
+						clearUnchanged()
+					}
+				}
+				pristine += unchanged
+			} else {
+				if oldpos < newpos {
+					clobbered += int64(len(bc.Add))
+				}
+				oldpos += int64(len(bc.Add))
+				newpos += int64(len(bc.Add))
+			}
+
+			for _, b := range bc.Add {
+				if b == 0 {
+					similar++
+				}
+			}
+		}
+
+		if len(bc.Copy) > 0 {
+			clearUnchanged()
+			newpos += int64(len(bc.Copy))
+		}
+
+		oldpos += bc.Seek
+	}
+
+	rop := &pwr.SyncOp{}
+
+	err = rctx.ReadMessage(rop)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if rop.Type != pwr.SyncOp_HEY_YOU_DID_IT {
+		msg := fmt.Sprintf("expected HEY_YOU_DID_IT, got %s", rop.Type)
+		return nil, errors.New(msg)
+	}
+
+	comm.Debugf("%s / %s pristine after patch application", progress.FormatBytes(pristine), progress.FormatBytes(tf.Size))
+	comm.Debugf("File went from %s to %s", progress.FormatBytes(tf.Size), progress.FormatBytes(f.Size))
+	comm.Debugf("%s / %s clobbered total", progress.FormatBytes(clobbered), progress.FormatBytes(tf.Size))
+	comm.Debugf("%s / %s similar total", progress.FormatBytes(similar), progress.FormatBytes(tf.Size))
+
+	ddc.totalPristine += pristine
+
+	return &SeriesStat{
+		FileIndex:   sh.FileIndex,
+		Algo:        sh.Type,
+		Pristine:    pristine,
+		TargetIndex: bh.TargetIndex,
+		Similar:     similar,
+		Clobbered:   clobbered,
+	}, nil
+}
+
+func (ddc *deepDiveContext) skipSeries(sh *pwr.SyncHeader) error {
+	rctx := ddc.rctx
+	rop := &pwr.SyncOp{}
+	bc := &bsdiff.Control{}
+
+	switch sh.Type {
+	case pwr.SyncHeader_RSYNC:
+		{
+			readingOps := true
+			for readingOps {
+				rop.Reset()
+
+				err := rctx.ReadMessage(rop)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				if rop.Type == pwr.SyncOp_HEY_YOU_DID_IT {
+					// yay, we did it!
+					readingOps = false
+				}
+			}
+		}
+	case pwr.SyncHeader_BSDIFF:
+		{
+			bh := &pwr.BsdiffHeader{}
+			err := rctx.ReadMessage(bh)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			readingOps := true
+			for readingOps {
+				bc.Reset()
+
+				err := rctx.ReadMessage(bc)
+				if err != nil {
+					return errors.WithStack(err)
+				}
+
+				if bc.Eof {
+					readingOps = false
+				}
+			}
+
+			rop.Reset()
+			err = rctx.ReadMessage(rop)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if rop.Type != pwr.SyncOp_HEY_YOU_DID_IT {
+				// oh noes, we didn't do it
+				return errors.New("missing HEY_YOU_DID_IT after bsdiff series")
+			}
+		}
+	default:
+		return fmt.Errorf("dunno how to skip series of type %d", sh.Type)
+	}
+
+	return nil
+}
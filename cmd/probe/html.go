@@ -0,0 +1,319 @@
+package probe
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+
+	"github.com/itchio/httpkit/progress"
+	"github.com/pkg/errors"
+)
+
+// maxTreemapFiles is how many files get their own tile in the treemap,
+// smaller files share a single tile
+const maxTreemapFiles = 500
+
+// the treemap is laid out in a box of this aspect ratio,
+// then stretched to the width of the page
+const (
+	treemapWidth  = 1000.0
+	treemapHeight = 480.0
+)
+
+type treemapTile struct {
+	Label string
+	Size  int64
+	Fresh int64
+
+	// X, Y, W and H are percentages of the treemap's size
+	X, Y, W, H float64
+}
+
+// Color goes from green for files that are entirely reused
+// to red for files that are entirely fresh
+func (t *treemapTile) Color() template.CSS {
+	var fresh float64
+	if t.Size > 0 {
+		fresh = float64(t.Fresh) / float64(t.Size)
+	}
+	if fresh < 0 {
+		fresh = 0
+	}
+	return template.CSS(fmt.Sprintf("hsl(%.0f, 55%%, 45%%)", 120*(1-fresh)))
+}
+
+// treemapTiles lays out the files of a report so that the area
+// of each tile is proportional to the size of its file
+func treemapTiles(r *Report) []*treemapTile {
+	var tiles []*treemapTile
+	for _, f := range r.Files {
+		if f.Size <= 0 {
+			continue
+		}
+		tiles = append(tiles, &treemapTile{Label: f.Path, Size: f.Size, Fresh: f.FreshBytes})
+	}
+	sort.SliceStable(tiles, func(i, j int) bool {
+		return tiles[i].Size > tiles[j].Size
+	})
+
+	if len(tiles) > maxTreemapFiles {
+		others := &treemapTile{Label: fmt.Sprintf("%d smaller files", len(tiles)-maxTreemapFiles)}
+		for _, t := range tiles[maxTreemapFiles:] {
+			others.Size += t.Size
+			others.Fresh += t.Fresh
+		}
+		tiles = append(tiles[:maxTreemapFiles], others)
+		sort.SliceStable(tiles, func(i, j int) bool {
+			return tiles[i].Size > tiles[j].Size
+		})
+	}
+
+	squarify(tiles, treemapWidth, treemapHeight)
+	return tiles
+}
+
+// squarify lays out tiles, sorted by decreasing size, in a w*h box, trying
+// to keep them close to squares (Bruls, Huizing & van Wijk)
+func squarify(tiles []*treemapTile, w float64, h float64) {
+	var total float64
+	for _, t := range tiles {
+		total += float64(t.Size)
+	}
+	if total == 0 {
+		return
+	}
+
+	scale := w * h / total
+	area := func(t *treemapTile) float64 {
+		return float64(t.Size) * scale
+	}
+
+	// worst returns the worst aspect ratio of a row laid out along side
+	worst := func(row []*treemapTile, side float64) float64 {
+		var sum, min, max float64
+		for i, t := range row {
+			a := area(t)
+			sum += a
+			if i == 0 || a < min {
+				min = a
+			}
+			if a > max {
+				max = a
+			}
+		}
+		s2 := side * side
+		sum2 := sum * sum
+		return maxFloat(s2*max/sum2, sum2/(s2*min))
+	}
+
+	var x, y float64
+	layoutRow := func(row []*treemapTile) {
+		var sum float64
+		for _, t := range row {
+			sum += area(t)
+		}
+
+		if w >= h {
+			// a column on the left
+			thickness := sum / h
+			pos := y
+			for _, t := range row {
+				length := area(t) / thickness
+				t.X, t.Y, t.W, t.H = x, pos, thickness, length
+				pos += length
+			}
+			x += thickness
+			w -= thickness
+		} else {
+			// a row at the top
+			thickness := sum / w
+			pos := x
+			for _, t := range row {
+				length := area(t) / thickness
+				t.X, t.Y, t.W, t.H = pos, y, length, thickness
+				pos += length
+			}
+			y += thickness
+			h -= thickness
+		}
+	}
+
+	var row []*treemapTile
+	for i := 0; i < len(tiles); {
+		side := minFloat(w, h)
+		candidate := append(row[:len(row):len(row)], tiles[i])
+		if len(row) == 0 || worst(candidate, side) <= worst(row, side) {
+			row = candidate
+			i++
+			continue
+		}
+		layoutRow(row)
+		row = nil
+	}
+	if len(row) > 0 {
+		layoutRow(row)
+	}
+
+	for _, t := range tiles {
+		t.X = t.X / treemapWidth * 100
+		t.W = t.W / treemapWidth * 100
+		t.Y = t.Y / treemapHeight * 100
+		t.H = t.H / treemapHeight * 100
+	}
+}
+
+func minFloat(a float64, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxFloat(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func writeHTML(w io.Writer, r *Report) error {
+	data := struct {
+		*Report
+		Tiles []*treemapTile
+	}{
+		Report: r,
+		Tiles:  treemapTiles(r),
+	}
+
+	err := reportTemplate.Execute(w, data)
+	if err != nil {
+		return errors.Wrap(err, "rendering html report")
+	}
+	return nil
+}
+
+func percent(part int64, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)/float64(total)*100)
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"bytes":   progress.FormatBytes,
+	"percent": percent,
+	"ratio": func(r float64) string {
+		return fmt.Sprintf("%.2fx", r)
+	},
+	"pos": func(f float64) template.CSS {
+		return template.CSS(fmt.Sprintf("%.3f%%", f))
+	},
+}).Parse(reportHTML))
+
+const reportHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>butler probe: {{.Patch}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.4em; word-break: break-all; }
+h2 { font-size: 1.15em; margin-top: 2em; }
+dl.summary { display: grid; grid-template-columns: max-content auto; gap: .3em 1.5em; }
+dl.summary dt { color: #666; }
+dl.summary dd { margin: 0; }
+.treemap { position: relative; width: 100%; height: 480px; background: #eee; }
+.treemap div { position: absolute; box-sizing: border-box; border: 1px solid #fff; overflow: hidden;
+  color: #fff; font-size: 11px; padding: 2px; white-space: nowrap; text-overflow: ellipsis; }
+.legend { color: #666; font-size: .9em; }
+table { border-collapse: collapse; font-size: .9em; }
+th, td { padding: .25em .75em; border-bottom: 1px solid #ddd; text-align: right; }
+th:first-child, td:first-child { text-align: left; word-break: break-all; }
+th { cursor: pointer; user-select: none; background: #f4f4f4; position: sticky; top: 0; }
+th.asc::after { content: " \25B2"; }
+th.desc::after { content: " \25BC"; }
+</style>
+</head>
+<body>
+<h1>{{.Patch}}</h1>
+
+<dl class="summary">
+<dt>Patch</dt><dd>{{bytes .PatchSize}}, {{.Kind}}, {{.NumRsync}} rsync series, {{.NumBsdiff}} bsdiff series</dd>
+<dt>Compression</dt><dd>{{.Compression}}, {{bytes .UncompressedSize}} before compression ({{ratio .CompressionRatio}})</dd>
+{{with .Before}}<dt>Before</dt><dd>{{bytes .Size}} in {{.Files}} files, {{.Dirs}} dirs, {{.Symlinks}} symlinks</dd>{{end}}
+{{with .After}}<dt>After</dt><dd>{{bytes .Size}} in {{.Files}} files, {{.Dirs}} dirs, {{.Symlinks}} symlinks</dd>{{end}}
+<dt>Fresh data</dt><dd>{{bytes .FreshBytes}} ({{percent .FreshBytes .After.Size}} of the new build), in {{.TouchedFiles}} files</dd>
+<dt>Reused data</dt><dd>{{bytes .ReusedBytes}}</dd>
+{{with .Deep}}<dt>Pristine data</dt><dd>{{bytes .PristineBytes}} of {{bytes .TouchedBytes}} touched stays in place</dd>{{end}}
+</dl>
+
+<h2>Files</h2>
+<p class="legend">Each tile is a file of the new build, its area is the size of the file.
+Green files are entirely reused from the old build, red files are entirely fresh data.</p>
+<div class="treemap">
+{{range .Tiles}}<div style="left: {{pos .X}}; top: {{pos .Y}}; width: {{pos .W}}; height: {{pos .H}}; background: {{.Color}}" title="{{.Label}}: {{bytes .Fresh}} fresh / {{bytes .Size}}">{{.Label}}</div>
+{{end}}</div>
+
+<h2>Operations per file</h2>
+<table class="sortable">
+<thead><tr><th>Path</th><th>Algorithm</th><th>Size</th><th>Fresh</th><th>Reused</th><th>Changed</th><th>Ops before compression</th></tr></thead>
+<tbody>
+{{range .Files}}<tr><td>{{.Path}}</td><td>{{.Algorithm}}</td><td data-v="{{.Size}}">{{bytes .Size}}</td><td data-v="{{.FreshBytes}}">{{bytes .FreshBytes}}</td><td data-v="{{.ReusedBytes}}">{{bytes .ReusedBytes}}</td><td data-v="{{.FreshBytes}}" data-of="{{.Size}}">{{percent .FreshBytes .Size}}</td><td data-v="{{.OpsSize}}">{{bytes .OpsSize}}</td></tr>
+{{end}}</tbody>
+</table>
+
+{{with .Deep}}
+<h2>Deep dive into touched files</h2>
+<table class="sortable">
+<thead><tr><th>Path</th><th>Algorithm</th><th>Size</th><th>Pristine</th><th>Diffed against</th><th>Similar</th><th>Clobbered</th><th>Blocks taken from</th></tr></thead>
+<tbody>
+{{range .Files}}<tr><td>{{.Path}}</td><td>{{.Algorithm}}</td><td data-v="{{.Size}}">{{bytes .Size}}</td><td data-v="{{.PristineBytes}}">{{bytes .PristineBytes}}</td><td>{{.OldPath}}</td><td data-v="{{.SimilarBytes}}">{{bytes .SimilarBytes}}</td><td data-v="{{.ClobberedBytes}}">{{bytes .ClobberedBytes}}</td><td>{{range $i, $s := .Sources}}{{if $i}}, {{end}}{{$s.Path}} ({{$s.Blocks}}){{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+
+<script>
+(function() {
+  function value(td) {
+    var v = td.getAttribute("data-v");
+    if (v === null) {
+      return td.textContent.toLowerCase();
+    }
+    var of = td.getAttribute("data-of");
+    return of === null ? Number(v) : (Number(of) === 0 ? 0 : Number(v) / Number(of));
+  }
+
+  var tables = document.querySelectorAll("table.sortable");
+  for (var i = 0; i < tables.length; i++) {
+    (function(table) {
+      var headers = table.querySelectorAll("th");
+      for (var j = 0; j < headers.length; j++) {
+        headers[j].addEventListener("click", function(e) {
+          var th = e.target;
+          var asc = !th.classList.contains("asc");
+          for (var k = 0; k < headers.length; k++) {
+            headers[k].classList.remove("asc", "desc");
+          }
+          th.classList.add(asc ? "asc" : "desc");
+
+          var col = th.cellIndex;
+          var tbody = table.tBodies[0];
+          var rows = Array.prototype.slice.call(tbody.rows);
+          rows.sort(function(a, b) {
+            var va = value(a.cells[col]), vb = value(b.cells[col]);
+            var res = va < vb ? -1 : (va > vb ? 1 : 0);
+            return asc ? res : -res;
+          });
+          for (var k = 0; k < rows.length; k++) {
+            tbody.appendChild(rows[k]);
+          }
+        });
+      }
+    })(tables[i]);
+  }
+})();
+</script>
+</body>
+</html>
+`
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/golang/protobuf/proto"
 	"github.com/itchio/httpkit/progress"
 	"github.com/itchio/savior/countingsource"
 
@@ -27,6 +28,8 @@ var args = struct {
 	fullpath bool
 	deep     bool
 	dump     string
+	format   string
+	output   string
 }{}
 
 func Register(ctx *mansion.Context) {
@@ -35,30 +38,195 @@ func Register(ctx *mansion.Context) {
 	cmd.Flag("fullpath", "Display full path names").BoolVar(&args.fullpath)
 	cmd.Flag("deep", "Analyze the top N changed files further").BoolVar(&args.deep)
 	cmd.Flag("dump", "Dump ops for any path contain a substring of this").StringVar(&args.dump)
+	cmd.Flag("format", "Output format: text, json, or html (a self-contained page)").Default(FormatText).EnumVar(&args.format, FormatText, FormatJSON, FormatHTML)
+	cmd.Flag("output", "Where to write the json or html report, instead of stdout").Short('o').StringVar(&args.output)
 	ctx.Register(cmd, do)
 }
 
+const (
+	// FormatText prints statistics for humans, as log lines
+	FormatText = "text"
+	// FormatJSON writes a Report as JSON
+	FormatJSON = "json"
+	// FormatHTML writes a Report as a self-contained HTML page
+	FormatHTML = "html"
+)
+
+// Params controls what Do analyzes and how it shows the results
+type Params struct {
+	Patch    string
+	FullPath bool
+	Deep     bool
+	Dump     string
+	Verbose  bool
+
+	// Format is one of FormatText, FormatJSON or FormatHTML
+	Format string
+	// Output is where json and html reports are written, stdout if empty
+	Output string
+}
+
 func do(ctx *mansion.Context) {
-	ctx.Must(Do(ctx, args.patch))
+	ctx.Must(Do(&Params{
+		Patch:    args.patch,
+		FullPath: args.fullpath,
+		Deep:     args.deep,
+		Dump:     args.dump,
+		Verbose:  ctx.Verbose,
+		Format:   args.format,
+		Output:   args.output,
+	}))
 }
 
-func Do(ctx *mansion.Context, patch string) error {
-	patchStats, err := doPrimaryAnalysis(ctx, patch)
+func Do(params *Params) error {
+	analysis, err := Analyze(params.Patch, &AnalyzeParams{
+		Dump:    params.Dump,
+		Verbose: params.Verbose,
+	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	if args.deep {
-		err = doDeepAnalysis(ctx, patch, patchStats)
+	var deep *DeepAnalysis
+	if params.Deep {
+		deep, err = DeepAnalyze(params.Patch, analysis)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 	}
 
-	return nil
+	switch params.Format {
+	case "", FormatText:
+		printAnalysis(analysis, params.FullPath)
+		if deep != nil {
+			printDeepAnalysis(deep)
+		}
+		return nil
+	case FormatJSON, FormatHTML:
+		report := NewReport(params.Patch, analysis, deep)
+		return writeReport(report, params.Format, params.Output)
+	default:
+		return errors.Errorf("unknown format %s", params.Format)
+	}
+}
+
+func printAnalysis(analysis *Analysis, fullPath bool) {
+	source := analysis.Source
+	target := analysis.Target
+	patchStats := analysis.Stats
+	totalFresh := analysis.TotalFresh
+
+	comm.Opf("patch:  %s", progress.FormatBytes(analysis.PatchSize))
+	comm.Logf("  before: %s in %s", progress.FormatBytes(target.Size), target.Stats())
+	comm.Logf("   after: %s in %s", progress.FormatBytes(source.Size), source.Stats())
+
+	var freshThreshold = int64(0.9 * float64(totalFresh))
+	var printedFresh int64
+
+	perSec := progress.FormatBPS(analysis.PatchSize, analysis.Duration)
+	comm.Statf("Analyzed %s @ %s/s (%s total)", progress.FormatBytes(analysis.PatchSize), perSec, analysis.Duration)
+	comm.Statf("%d bsdiff series, %d rsync series", analysis.NumBsdiff, analysis.NumRsync)
+	if analysis.UncompressedSize > 0 {
+		comm.Statf("%s before %s compression (%.2fx)",
+			progress.FormatBytes(analysis.UncompressedSize), analysis.Compression.ToString(), analysis.CompressionRatio())
+	}
+
+	var numTouched = 0
+	var numTotal = 0
+	var naivePatchSize int64
+	for _, stat := range patchStats {
+		numTotal++
+		if stat.FreshData > 0 {
+			numTouched++
+			f := source.Files[stat.FileIndex]
+			naivePatchSize += f.Size
+		}
+	}
+
+	comm.Logf("")
+	comm.Statf("Most of the fresh data is in the following files:")
+
+	for i, stat := range patchStats {
+		f := source.Files[stat.FileIndex]
+		name := f.Path
+		if !fullPath {
+			name = filepath.Base(name)
+		}
+
+		comm.Logf("  - %s / %s in %s (%.2f%% changed, %s)",
+			progress.FormatBytes(stat.FreshData),
+			progress.FormatBytes(f.Size),
+			name,
+			float64(stat.FreshData)/float64(f.Size)*100.0,
+			stat.Algo)
+
+		printedFresh += stat.FreshData
+
+		if i >= 10 || printedFresh >= freshThreshold {
+			break
+		}
+	}
+
+	comm.Logf("")
+
+	comm.Statf("All in all, that's %s of fresh data in a %s %s patch",
+		progress.FormatBytes(totalFresh),
+		progress.FormatBytes(analysis.PatchSize),
+		analysis.Kind(),
+	)
+	comm.Logf(" (%d/%d files are changed by this patch, they weigh a total of %s)", numTouched, numTotal, progress.FormatBytes(naivePatchSize))
+}
+
+// AnalyzeParams controls how a patch is analyzed
+type AnalyzeParams struct {
+	// Dump prints the operations for any file whose path contains it
+	Dump string
+	// Verbose logs the position of every piece of fresh data
+	Verbose bool
+}
+
+// Analysis sums up what a patch contains
+type Analysis struct {
+	PatchSize int64
+	Target    *tlc.Container
+	Source    *tlc.Container
+
+	NumRsync  int
+	NumBsdiff int
+
+	// Stats has one entry per file of the source container,
+	// sorted by decreasing fresh data
+	Stats      []FileStat
+	TotalFresh int64
+
+	Compression *pwr.CompressionSettings
+	// UncompressedSize is the size of everything after the patch header,
+	// before compression
+	UncompressedSize int64
+
+	Duration time.Duration
+}
+
+// Kind returns "optimized" for patches that contain bsdiff series,
+// "simple" otherwise
+func (a *Analysis) Kind() string {
+	if a.NumBsdiff > 0 {
+		return "optimized"
+	}
+	return "simple"
 }
 
-func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error) {
+// CompressionRatio returns how many times smaller compression made the patch
+func (a *Analysis) CompressionRatio() float64 {
+	if a.PatchSize == 0 {
+		return 0
+	}
+	return float64(a.UncompressedSize) / float64(a.PatchSize)
+}
+
+// Analyze reads a whole patch and computes how much fresh
+// data it contains for each file of the source container.
+func Analyze(patch string, params *AnalyzeParams) (*Analysis, error) {
 	consumer := comm.NewStateConsumer()
 
 	patchReader, err := eos.Open(patch, option.WithConsumer(consumer))
@@ -70,8 +238,6 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 
 	patchSource := seeksource.FromFile(patchReader)
 
-	comm.Opf("patch:  %s", progress.FormatBytes(patchSource.Size()))
-
 	cs := countingsource.New(patchSource, func(count int64) {
 		comm.Progress(patchSource.Progress())
 	})
@@ -93,31 +259,29 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 		return nil, errors.WithStack(err)
 	}
 
-	rctx, err = pwr.DecompressWire(rctx, header.Compression)
+	decompressed, err := pwr.DecompressWire(rctx, header.Compression)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	sr := &sizingReader{rctx: decompressed}
 
 	target := &tlc.Container{}
-	err = rctx.ReadMessage(target)
+	err = sr.ReadMessage(target)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
 	source := &tlc.Container{}
-	err = rctx.ReadMessage(source)
+	err = sr.ReadMessage(source)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	comm.Logf("  before: %s in %s", progress.FormatBytes(target.Size), target.Stats())
-	comm.Logf("   after: %s in %s", progress.FormatBytes(target.Size), source.Stats())
-
 	startTime := time.Now()
 
 	comm.StartProgressWithTotalBytes(cs.Size())
 
-	var patchStats []patchStat
+	var patchStats []FileStat
 
 	sh := &pwr.SyncHeader{}
 	rop := &pwr.SyncOp{}
@@ -126,16 +290,18 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 	var numBsdiff = 0
 	var numRsync = 0
 	for fileIndex, f := range source.Files {
+		seriesStart := sr.size
+
 		sh.Reset()
-		err = rctx.ReadMessage(sh)
+		err = sr.ReadMessage(sh)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
 
-		stat := patchStat{
-			fileIndex: int64(fileIndex),
-			freshData: f.Size,
-			algo:      sh.Type,
+		stat := FileStat{
+			FileIndex: int64(fileIndex),
+			FreshData: f.Size,
+			Algo:      sh.Type,
 		}
 
 		if sh.FileIndex != int64(fileIndex) {
@@ -143,7 +309,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 		}
 
 		sourceFile := source.Files[sh.FileIndex]
-		doDump := args.dump != "" && strings.Contains(sourceFile.Path, args.dump)
+		doDump := params.Dump != "" && strings.Contains(sourceFile.Path, params.Dump)
 
 		if doDump {
 			consumer.Infof("========== Op Stream Start ===========")
@@ -159,7 +325,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 				for readingOps {
 					rop.Reset()
 
-					err = rctx.ReadMessage(rop)
+					err = sr.ReadMessage(rop)
 					if err != nil {
 						return nil, errors.WithStack(err)
 					}
@@ -172,11 +338,11 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 						lastIndex := rop.BlockIndex + (rop.BlockSpan - 1)
 						lastSize := pwr.ComputeBlockSize(tf.Size, lastIndex)
 						totalSize := (fixedSize + lastSize)
-						stat.freshData -= totalSize
+						stat.FreshData -= totalSize
 						pos += totalSize
 					case pwr.SyncOp_DATA:
 						totalSize := int64(len(rop.Data))
-						if ctx.Verbose {
+						if params.Verbose {
 							comm.Debugf("%s fresh data at %s (%d-%d)",
 								progress.FormatBytes(totalSize),
 								progress.FormatBytes(pos),
@@ -195,7 +361,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 				readingOps := true
 
 				bh := &pwr.BsdiffHeader{}
-				err = rctx.ReadMessage(bh)
+				err = sr.ReadMessage(bh)
 				if err != nil {
 					return nil, errors.WithStack(err)
 				}
@@ -223,7 +389,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 				for readingOps {
 					bc.Reset()
 
-					err = rctx.ReadMessage(bc)
+					err = sr.ReadMessage(bc)
 					if err != nil {
 						return nil, errors.WithStack(err)
 					}
@@ -238,7 +404,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 					totalAddBytes += int64(len(bc.Add))
 					totalZeroAddBytes += zeroAddBytes
 
-					stat.freshData -= zeroAddBytes
+					stat.FreshData -= zeroAddBytes
 					if doDump {
 						percSimilar := 100.0 * float64(zeroAddBytes) / float64(len(bc.Add))
 						if len(bc.Add) == 0 && len(bc.Copy) == 0 {
@@ -260,7 +426,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 					consumer.Statf("Overall: %d/%d add bytes were zero (%.2f%%)", totalZeroAddBytes, totalAddBytes, 100.0*float64(totalZeroAddBytes)/float64(totalAddBytes))
 				}
 
-				err = rctx.ReadMessage(rop)
+				err = sr.ReadMessage(rop)
 				if err != nil {
 					return nil, errors.WithStack(err)
 				}
@@ -276,6 +442,7 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 			consumer.Infof("========== Op Stream End ===========")
 		}
 
+		stat.OpsSize = sr.size - seriesStart
 		patchStats = append(patchStats, stat)
 	}
 
@@ -285,440 +452,56 @@ func doPrimaryAnalysis(ctx *mansion.Context, patch string) ([]patchStat, error)
 
 	var totalFresh int64
 	for _, stat := range patchStats {
-		totalFresh += stat.freshData
-	}
-
-	var freshThreshold = int64(0.9 * float64(totalFresh))
-	var printedFresh int64
-
-	duration := time.Since(startTime)
-
-	perSec := progress.FormatBPS(cs.Size(), duration)
-	comm.Statf("Analyzed %s @ %s/s (%s total)", progress.FormatBytes(cs.Size()), perSec, duration)
-	comm.Statf("%d bsdiff series, %d rsync series", numBsdiff, numRsync)
-
-	var numTouched = 0
-	var numTotal = 0
-	var naivePatchSize int64
-	for _, stat := range patchStats {
-		numTotal++
-		if stat.freshData > 0 {
-			numTouched++
-			f := source.Files[stat.fileIndex]
-			naivePatchSize += f.Size
-		}
+		totalFresh += stat.FreshData
 	}
 
-	comm.Logf("")
-	comm.Statf("Most of the fresh data is in the following files:")
-
-	for i, stat := range patchStats {
-		f := source.Files[stat.fileIndex]
-		name := f.Path
-		if !args.fullpath {
-			name = filepath.Base(name)
-		}
-
-		comm.Logf("  - %s / %s in %s (%.2f%% changed, %s)",
-			progress.FormatBytes(stat.freshData),
-			progress.FormatBytes(f.Size),
-			name,
-			float64(stat.freshData)/float64(f.Size)*100.0,
-			stat.algo)
-
-		printedFresh += stat.freshData
-
-		if i >= 10 || printedFresh >= freshThreshold {
-			break
-		}
-	}
+	analysis := &Analysis{
+		PatchSize:  cs.Size(),
+		Target:     target,
+		Source:     source,
+		NumRsync:   numRsync,
+		NumBsdiff:  numBsdiff,
+		Stats:      patchStats,
+		TotalFresh: totalFresh,
 
-	comm.Logf("")
+		Compression:      header.Compression,
+		UncompressedSize: sr.size,
 
-	var kind = "simple"
-	if numBsdiff > 0 {
-		kind = "optimized"
+		Duration: time.Since(startTime),
 	}
-	comm.Statf("All in all, that's %s of fresh data in a %s %s patch",
-		progress.FormatBytes(totalFresh),
-		progress.FormatBytes(cs.Size()),
-		kind,
-	)
-	comm.Logf(" (%d/%d files are changed by this patch, they weigh a total of %s)", numTouched, numTotal, progress.FormatBytes(naivePatchSize))
-
-	return patchStats, nil
+	return analysis, nil
 }
 
-type deepDiveContext struct {
-	target *tlc.Container
-	source *tlc.Container
-	rctx   *wire.ReadContext
-
-	totalPristine int64
-	totalTouched  int64
+// FileStat describes how much fresh data a patch contains for one of the files
+type FileStat struct {
+	// FileIndex is the index of the file in the source container
+	FileIndex int64
+	// FreshData is the number of bytes that couldn't be reused from the target
+	FreshData int64
+	Algo      pwr.SyncHeader_Type
+	// OpsSize is the size of the operations for this file, before compression
+	OpsSize int64
 }
 
-func doDeepAnalysis(ctx *mansion.Context, patch string, patchStats []patchStat) error {
-	consumer := comm.NewStateConsumer()
-
-	comm.Logf("")
-	var numTouched int
-	patchStatPerFileIndex := make(map[int64]patchStat)
-	for _, ps := range patchStats {
-		patchStatPerFileIndex[ps.fileIndex] = ps
-		if ps.freshData > 0 {
-			numTouched++
-		}
-	}
-
-	comm.Statf("Now deep-diving into %d touched files", numTouched)
-
-	patchReader, err := eos.Open(patch, option.WithConsumer(consumer))
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	defer patchReader.Close()
-
-	patchSource := seeksource.FromFile(patchReader)
-
-	cs := countingsource.New(patchSource, func(count int64) {
-		comm.Progress(patchSource.Progress())
-	})
-	_, err = cs.Resume(nil)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	comm.Opf("patch:  %s", progress.FormatBytes(cs.Size()))
-
-	rctx := wire.NewReadContext(cs)
-	err = rctx.ExpectMagic(pwr.PatchMagic)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	header := &pwr.PatchHeader{}
-	err = rctx.ReadMessage(header)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	rctx, err = pwr.DecompressWire(rctx, header.Compression)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	target := &tlc.Container{}
-	err = rctx.ReadMessage(target)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	source := &tlc.Container{}
-	err = rctx.ReadMessage(source)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	ddc := &deepDiveContext{
-		target: target,
-		source: source,
-		rctx:   rctx,
-	}
-
-	sh := &pwr.SyncHeader{}
-
-	for fileIndex := range source.Files {
-		sh.Reset()
-		err = rctx.ReadMessage(sh)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-
-		if sh.FileIndex != int64(fileIndex) {
-			return fmt.Errorf("malformed patch: expected file %d, got %d", fileIndex, sh.FileIndex)
-		}
-
-		pc := patchStatPerFileIndex[sh.FileIndex]
-		if pc.freshData > 0 {
-			err = ddc.analyzeSeries(sh)
-		} else {
-			err = ddc.skipSeries(sh)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-		}
-	}
-
-	comm.Statf("All in all, that's %s / %s pristine of all the touched data",
-		progress.FormatBytes(ddc.totalPristine),
-		progress.FormatBytes(ddc.totalTouched),
-	)
-
-	return nil
-}
-
-func (ddc *deepDiveContext) analyzeSeries(sh *pwr.SyncHeader) error {
-	f := ddc.source.Files[sh.FileIndex]
-
-	switch sh.Type {
-	case pwr.SyncHeader_RSYNC:
-		ddc.totalTouched += f.Size
-		return ddc.analyzeRsync(sh)
-	case pwr.SyncHeader_BSDIFF:
-		ddc.totalTouched += f.Size
-		return ddc.analyzeBsdiff(sh)
-	default:
-		return fmt.Errorf("don't know how to analyze series of type %d", sh.Type)
-	}
-}
-
-func (ddc *deepDiveContext) analyzeRsync(sh *pwr.SyncHeader) error {
-	f := ddc.source.Files[sh.FileIndex]
-	comm.Debugf("Analyzing rsync series for '%s'", f.Path)
-
-	rctx := ddc.rctx
-	readingOps := true
-
-	rop := &pwr.SyncOp{}
-
-	targetBlocks := make(map[int64]int64)
-
-	var pos int64
-	var pristine int64
-
-	for readingOps {
-		rop.Reset()
-
-		err := rctx.ReadMessage(rop)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-
-		switch rop.Type {
-		case pwr.SyncOp_BLOCK_RANGE:
-			i := rop.FileIndex
-			targetBlocks[i] = targetBlocks[i] + rop.BlockSpan
-
-			tf := ddc.target.Files[rop.FileIndex]
-
-			fixedSize := (rop.BlockSpan - 1) * pwr.BlockSize
-			lastIndex := rop.BlockIndex + (rop.BlockSpan - 1)
-			lastSize := pwr.ComputeBlockSize(tf.Size, lastIndex)
-			totalSize := (fixedSize + lastSize)
-			pos += totalSize
-
-			if f.Path == tf.Path {
-				if pos == pwr.BlockSize*rop.BlockIndex {
-					pristine += totalSize
-				}
-			}
-		case pwr.SyncOp_DATA:
-			pos += int64(len(rop.Data))
-		case pwr.SyncOp_HEY_YOU_DID_IT:
-			readingOps = false
-		}
-	}
-
-	if len(targetBlocks) > 0 {
-		comm.Debugf("Sourcing from '%d' blocks total: ", len(targetBlocks))
-		for i, numBlocks := range targetBlocks {
-			tf := ddc.target.Files[i]
-			comm.Debugf("Taking %d blocks from '%s'", numBlocks, tf.Path)
-		}
-	} else {
-		comm.Debugf("Entirely fresh data!")
-	}
-
-	ddc.totalPristine += pristine
-
-	return nil
+// sizingReader keeps track of how much space messages take on
+// the wire, before compression
+type sizingReader struct {
+	rctx *wire.ReadContext
+	size int64
 }
 
-func (ddc *deepDiveContext) analyzeBsdiff(sh *pwr.SyncHeader) error {
-	f := ddc.source.Files[sh.FileIndex]
-	comm.Debugf("Analyzing bsdiff series for '%s'", f.Path)
-
-	rctx := ddc.rctx
-	readingOps := true
-
-	bh := &pwr.BsdiffHeader{}
-	err := rctx.ReadMessage(bh)
+func (sr *sizingReader) ReadMessage(msg proto.Message) error {
+	err := sr.rctx.ReadMessage(msg)
 	if err != nil {
-		return errors.WithStack(err)
+		return err
 	}
 
-	tf := ddc.target.Files[bh.TargetIndex]
-	comm.Debugf("Diffed against target file '%s'", tf.Path)
-	if tf.Path == f.Path {
-		comm.Debugf("Same path, can do in-place!")
-	}
-
-	bc := &bsdiff.Control{}
-
-	var oldpos int64
-	var newpos int64
-
-	var pristine int64
-	var similar int64
-	var bestUnchanged int64
-
-	clearUnchanged := func() {
-		if bestUnchanged > 1024*1024 {
-			comm.Debugf("%s contiguous unchanged block ending at from %s to %s",
-				progress.FormatBytes(bestUnchanged),
-				progress.FormatBytes(newpos-bestUnchanged),
-				progress.FormatBytes(newpos),
-			)
-		}
-		bestUnchanged = 0
-	}
-
-	var clobbered int64
-
-	for readingOps {
-		bc.Reset()
-
-		err = rctx.ReadMessage(bc)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-
-		if bc.Eof {
-			readingOps = false
-			break
-		}
-
-		if len(bc.Add) > 0 {
-			if oldpos == newpos {
-				var unchanged int64
-				for _, b := range bc.Add {
-					oldpos++
-					newpos++
-					if b == 0 {
-						unchanged++
-						bestUnchanged++
-					} else {
-						clearUnchanged()
-					}
-				}
-				pristine += unchanged
-			} else {
-				if oldpos < newpos {
-					clobbered += int64(len(bc.Add))
-				}
-				oldpos += int64(len(bc.Add))
-				newpos += int64(len(bc.Add))
-			}
-
-			for _, b := range bc.Add {
-				if b == 0 {
-					similar++
-				}
-			}
-		}
-
-		if len(bc.Copy) > 0 {
-			clearUnchanged()
-			newpos += int64(len(bc.Copy))
-		}
-
-		oldpos += bc.Seek
-	}
-
-	rop := &pwr.SyncOp{}
-
-	err = rctx.ReadMessage(rop)
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	if rop.Type != pwr.SyncOp_HEY_YOU_DID_IT {
-		msg := fmt.Sprintf("expected HEY_YOU_DID_IT, got %s", rop.Type)
-		return errors.New(msg)
-	}
-
-	comm.Debugf("%s / %s pristine after patch application", progress.FormatBytes(pristine), progress.FormatBytes(tf.Size))
-	comm.Debugf("File went from %s to %s", progress.FormatBytes(tf.Size), progress.FormatBytes(f.Size))
-	comm.Debugf("%s / %s clobbered total", progress.FormatBytes(clobbered), progress.FormatBytes(tf.Size))
-	comm.Debugf("%s / %s similar total", progress.FormatBytes(similar), progress.FormatBytes(tf.Size))
-
-	ddc.totalPristine += pristine
-
+	n := proto.Size(msg)
+	sr.size += int64(proto.SizeVarint(uint64(n)) + n)
 	return nil
 }
 
-func (ddc *deepDiveContext) skipSeries(sh *pwr.SyncHeader) error {
-	rctx := ddc.rctx
-	rop := &pwr.SyncOp{}
-	bc := &bsdiff.Control{}
-
-	switch sh.Type {
-	case pwr.SyncHeader_RSYNC:
-		{
-			readingOps := true
-			for readingOps {
-				rop.Reset()
-
-				err := rctx.ReadMessage(rop)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-
-				if rop.Type == pwr.SyncOp_HEY_YOU_DID_IT {
-					// yay, we did it!
-					readingOps = false
-				}
-			}
-		}
-	case pwr.SyncHeader_BSDIFF:
-		{
-			bh := &pwr.BsdiffHeader{}
-			err := rctx.ReadMessage(bh)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			readingOps := true
-			for readingOps {
-				bc.Reset()
-
-				err := rctx.ReadMessage(bc)
-				if err != nil {
-					return errors.WithStack(err)
-				}
-
-				if bc.Eof {
-					readingOps = false
-				}
-			}
-
-			rop.Reset()
-			err = rctx.ReadMessage(rop)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-
-			if rop.Type != pwr.SyncOp_HEY_YOU_DID_IT {
-				// oh noes, we didn't do it
-				return errors.New("missing HEY_YOU_DID_IT after bsdiff series")
-			}
-		}
-	default:
-		return fmt.Errorf("dunno how to skip series of type %d", sh.Type)
-	}
-
-	return nil
-}
-
-type patchStat struct {
-	fileIndex int64
-	freshData int64
-	algo      pwr.SyncHeader_Type
-}
-
-type byDecreasingFreshData []patchStat
+type byDecreasingFreshData []FileStat
 
 func (s byDecreasingFreshData) Len() int {
 	return len(s)
@@ -729,5 +512,5 @@ func (s byDecreasingFreshData) Swap(i, j int) {
 }
 
 func (s byDecreasingFreshData) Less(i, j int) bool {
-	return s[j].freshData < s[i].freshData
+	return s[j].FreshData < s[i].FreshData
 }
@@ -0,0 +1,216 @@
+package probe
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// Report is the machine-readable result of a probe, written by --format json
+// and rendered by --format html.
+type Report struct {
+	Patch     string `json:"patch"`
+	PatchSize int64  `json:"patchSize"`
+	// Kind is "optimized" if the patch has bsdiff series, "simple" otherwise
+	Kind string `json:"kind"`
+
+	Compression string `json:"compression"`
+	// UncompressedSize is the size of the patch before compression
+	UncompressedSize int64   `json:"uncompressedSize"`
+	CompressionRatio float64 `json:"compressionRatio"`
+
+	Before *ContainerReport `json:"before"`
+	After  *ContainerReport `json:"after"`
+
+	NumRsync  int `json:"numRsync"`
+	NumBsdiff int `json:"numBsdiff"`
+
+	FreshBytes   int64 `json:"freshBytes"`
+	ReusedBytes  int64 `json:"reusedBytes"`
+	TouchedFiles int   `json:"touchedFiles"`
+
+	// Files has one entry per file of the new build,
+	// sorted by decreasing fresh data
+	Files []*FileReport `json:"files"`
+
+	// Deep is only set when probing with --deep
+	Deep *DeepReport `json:"deep,omitempty"`
+}
+
+// ContainerReport describes a build
+type ContainerReport struct {
+	Size     int64 `json:"size"`
+	Files    int   `json:"files"`
+	Dirs     int   `json:"dirs"`
+	Symlinks int   `json:"symlinks"`
+}
+
+// FileReport describes the operations of a patch for one file
+type FileReport struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Algorithm is RSYNC or BSDIFF
+	Algorithm   string `json:"algorithm"`
+	FreshBytes  int64  `json:"freshBytes"`
+	ReusedBytes int64  `json:"reusedBytes"`
+	// OpsSize is the size of the operations for this file, before compression
+	OpsSize int64 `json:"opsSize"`
+}
+
+// DeepReport is the result of a deep analysis
+type DeepReport struct {
+	TouchedBytes  int64             `json:"touchedBytes"`
+	PristineBytes int64             `json:"pristineBytes"`
+	Files         []*DeepFileReport `json:"files"`
+}
+
+// DeepFileReport describes the operations for a touched file in detail
+type DeepFileReport struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	Algorithm string `json:"algorithm"`
+	// PristineBytes stay in place when patching
+	PristineBytes int64 `json:"pristineBytes"`
+
+	// Sources lists the old files an rsync series takes blocks from
+	Sources []*BlockSource `json:"sources,omitempty"`
+
+	// OldPath is the file a bsdiff series is diffed against
+	OldPath        string `json:"oldPath,omitempty"`
+	OldSize        int64  `json:"oldSize,omitempty"`
+	SimilarBytes   int64  `json:"similarBytes,omitempty"`
+	ClobberedBytes int64  `json:"clobberedBytes,omitempty"`
+}
+
+// BlockSource is an old file an rsync series reuses blocks of
+type BlockSource struct {
+	Path   string `json:"path"`
+	Blocks int64  `json:"blocks"`
+}
+
+// NewReport sums up an analysis, and optionally a deep analysis, of a patch
+func NewReport(patch string, a *Analysis, deep *DeepAnalysis) *Report {
+	r := &Report{
+		Patch:            patch,
+		PatchSize:        a.PatchSize,
+		Kind:             a.Kind(),
+		UncompressedSize: a.UncompressedSize,
+		CompressionRatio: a.CompressionRatio(),
+		Before:           newContainerReport(a.Target),
+		After:            newContainerReport(a.Source),
+		NumRsync:         a.NumRsync,
+		NumBsdiff:        a.NumBsdiff,
+		FreshBytes:       a.TotalFresh,
+		Files:            []*FileReport{},
+	}
+	if a.Compression != nil {
+		r.Compression = a.Compression.ToString()
+	}
+
+	for _, stat := range a.Stats {
+		f := a.Source.Files[stat.FileIndex]
+		r.Files = append(r.Files, &FileReport{
+			Path:        f.Path,
+			Size:        f.Size,
+			Algorithm:   stat.Algo.String(),
+			FreshBytes:  stat.FreshData,
+			ReusedBytes: f.Size - stat.FreshData,
+			OpsSize:     stat.OpsSize,
+		})
+		r.ReusedBytes += f.Size - stat.FreshData
+		if stat.FreshData > 0 {
+			r.TouchedFiles++
+		}
+	}
+
+	if deep != nil {
+		r.Deep = newDeepReport(a, deep)
+	}
+
+	return r
+}
+
+func newContainerReport(c *tlc.Container) *ContainerReport {
+	if c == nil {
+		return nil
+	}
+	return &ContainerReport{
+		Size:     c.Size,
+		Files:    len(c.Files),
+		Dirs:     len(c.Dirs),
+		Symlinks: len(c.Symlinks),
+	}
+}
+
+func newDeepReport(a *Analysis, deep *DeepAnalysis) *DeepReport {
+	dr := &DeepReport{
+		TouchedBytes:  deep.TotalTouched,
+		PristineBytes: deep.TotalPristine,
+		Files:         []*DeepFileReport{},
+	}
+
+	for _, series := range deep.Series {
+		f := a.Source.Files[series.FileIndex]
+		dfr := &DeepFileReport{
+			Path:          f.Path,
+			Size:          f.Size,
+			Algorithm:     series.Algo.String(),
+			PristineBytes: series.Pristine,
+		}
+
+		if series.TargetIndex >= 0 {
+			tf := a.Target.Files[series.TargetIndex]
+			dfr.OldPath = tf.Path
+			dfr.OldSize = tf.Size
+			dfr.SimilarBytes = series.Similar
+			dfr.ClobberedBytes = series.Clobbered
+		}
+
+		for targetIndex, blocks := range series.BlocksFrom {
+			dfr.Sources = append(dfr.Sources, &BlockSource{
+				Path:   a.Target.Files[targetIndex].Path,
+				Blocks: blocks,
+			})
+		}
+		sort.Slice(dfr.Sources, func(i, j int) bool {
+			si, sj := dfr.Sources[i], dfr.Sources[j]
+			if si.Blocks != sj.Blocks {
+				return si.Blocks > sj.Blocks
+			}
+			return si.Path < sj.Path
+		})
+
+		dr.Files = append(dr.Files, dfr)
+	}
+
+	return dr
+}
+
+// writeReport writes a report in the given format to a file,
+// or to stdout if output is empty
+func writeReport(report *Report, format string, output string) error {
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return errors.WithStack(enc.Encode(report))
+	case FormatHTML:
+		return writeHTML(w, report)
+	default:
+		return errors.Errorf("unknown report format %s", format)
+	}
+}
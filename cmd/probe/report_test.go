@@ -0,0 +1,127 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/butler/cmd/diff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probe")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	big := make([]byte, pwr.BlockSize*4)
+	rand.New(rand.NewSource(1)).Read(big)
+	fresh := make([]byte, 5000)
+	rand.New(rand.NewSource(2)).Read(fresh)
+
+	oldDir := filepath.Join(dir, "old")
+	newDir := filepath.Join(dir, "new")
+	wtest.Must(t, os.MkdirAll(oldDir, 0755))
+	wtest.Must(t, os.MkdirAll(newDir, 0755))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(oldDir, "big.dat"), big, 0644))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(newDir, "big.dat"), append(big, fresh...), 0644))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(newDir, "new.dat"), fresh, 0644))
+
+	patch := filepath.Join(dir, "patch.pwr")
+	wtest.Must(t, diff.Do(&diff.Params{
+		Target: oldDir,
+		Source: newDir,
+		Patch:  patch,
+		Compression: pwr.CompressionSettings{
+			Algorithm: pwr.CompressionAlgorithm_ZSTD,
+			Quality:   1,
+		},
+	}))
+
+	analysis, err := Analyze(patch, &AnalyzeParams{})
+	wtest.Must(t, err)
+	deep, err := DeepAnalyze(patch, analysis)
+	wtest.Must(t, err)
+
+	r := NewReport(patch, analysis, deep)
+	assert.EqualValues(t, "simple", r.Kind)
+	assert.EqualValues(t, "ZSTD-q1", r.Compression)
+	assert.EqualValues(t, 2, r.NumRsync)
+	assert.EqualValues(t, 2, r.After.Files)
+	assert.EqualValues(t, 1, r.Before.Files)
+	assert.EqualValues(t, 2, r.TouchedFiles)
+	assert.EqualValues(t, 10000, r.FreshBytes)
+	assert.EqualValues(t, len(big), r.ReusedBytes)
+	assert.True(t, r.UncompressedSize > r.FreshBytes)
+	assert.True(t, r.CompressionRatio > 0)
+
+	assert.Len(t, r.Files, 2)
+	var opsSize int64
+	for _, f := range r.Files {
+		assert.EqualValues(t, "RSYNC", f.Algorithm)
+		assert.EqualValues(t, f.Size, f.FreshBytes+f.ReusedBytes)
+		opsSize += f.OpsSize
+	}
+	assert.True(t, opsSize < r.UncompressedSize, "containers are part of the uncompressed size")
+
+	assert.Len(t, r.Deep.Files, 2)
+	for _, f := range r.Deep.Files {
+		if f.Path == "big.dat" {
+			assert.Len(t, f.Sources, 1)
+			assert.EqualValues(t, "big.dat", f.Sources[0].Path)
+			assert.EqualValues(t, 4, f.Sources[0].Blocks)
+		}
+	}
+
+	jsonPath := filepath.Join(dir, "report.json")
+	wtest.Must(t, writeReport(r, FormatJSON, jsonPath))
+	buf, err := ioutil.ReadFile(jsonPath)
+	wtest.Must(t, err)
+	decoded := &Report{}
+	wtest.Must(t, json.Unmarshal(buf, decoded))
+	assert.EqualValues(t, r, decoded)
+
+	var html bytes.Buffer
+	wtest.Must(t, writeHTML(&html, r))
+	page := html.String()
+	assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+	assert.Contains(t, page, `class="treemap"`)
+	assert.Contains(t, page, "new.dat")
+	assert.NotContains(t, page, "<script src", "the page should be self-contained")
+}
+
+func TestTreemap(t *testing.T) {
+	r := &Report{}
+	for i, size := range []int64{6000, 6000, 4000, 3000, 2000, 2000, 1000, 0} {
+		r.Files = append(r.Files, &FileReport{
+			Path:       string('a' + rune(i)),
+			Size:       size,
+			FreshBytes: size / 2,
+		})
+	}
+
+	tiles := treemapTiles(r)
+	assert.Len(t, tiles, 7, "empty files don't get a tile")
+
+	var totalArea float64
+	for _, tile := range tiles {
+		area := tile.W * tile.H
+		totalArea += area
+		assert.InDelta(t, float64(tile.Size)/24000*100*100, area, 0.01)
+		assert.True(t, tile.X >= 0 && tile.X+tile.W <= 100.0001, "%s fits horizontally", tile.Label)
+		assert.True(t, tile.Y >= 0 && tile.Y+tile.H <= 100.0001, "%s fits vertically", tile.Label)
+	}
+	assert.InDelta(t, 100*100, totalArea, 0.01)
+	assert.False(t, math.IsNaN(totalArea))
+}
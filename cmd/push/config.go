@@ -0,0 +1,148 @@
+package push
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/pkg/errors"
+)
+
+// Config lists all the channels of a project that should be
+// pushed together, for example:
+//
+//	project = "leafo/x-moon"
+//	userversion-file = "VERSION"
+//	ignore = ["*.pdb"]
+//	validate = true
+//
+//	[[channel]]
+//	name = "win-64"
+//	src = "build/windows"
+//
+//	[[channel]]
+//	name = "soundtrack"
+//	src = "ost"
+//	ignore = ["*.wav"]
+//
+// Relative paths are relative to the folder the config file is in.
+type Config struct {
+	// Project is the user/game (or game ID) channels are pushed to,
+	// unless they specify their own target
+	Project string `toml:"project"`
+
+	// UserVersion applies to all channels that don't specify their own
+	UserVersion string `toml:"userversion"`
+	// UserVersionFile applies to all channels that don't specify their own
+	UserVersionFile string `toml:"userversion-file"`
+
+	// Ignore is a list of glob patterns ignored for all channels, in addition
+	// to the default ones and the ones passed with `--ignore`
+	Ignore []string `toml:"ignore"`
+
+	// Validate checks every channel with `butler validate` before pushing,
+	// as if `--validate` was passed
+	Validate bool `toml:"validate"`
+
+	Channels []*ChannelConfig `toml:"channel"`
+}
+
+// ChannelConfig describes how to push a single channel
+type ChannelConfig struct {
+	// Name of the channel, for example `win-64`
+	Name string `toml:"name"`
+	// Src is the directory (or .zip archive) to push
+	Src string `toml:"src"`
+	// Target overrides the project, of the form project:channel
+	Target string `toml:"target"`
+
+	UserVersion     string `toml:"userversion"`
+	UserVersionFile string `toml:"userversion-file"`
+
+	// Ignore is a list of glob patterns ignored for this channel only
+	Ignore []string `toml:"ignore"`
+}
+
+// ReadConfig parses and validates a project config file
+func ReadConfig(configPath string) (*Config, error) {
+	config := &Config{}
+	_, err := toml.DecodeFile(configPath, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing config file %s", configPath)
+	}
+
+	if len(config.Channels) == 0 {
+		return nil, errors.Errorf("%s: no channels listed", configPath)
+	}
+
+	baseDir := filepath.Dir(configPath)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(baseDir, p)
+	}
+
+	config.UserVersionFile = resolve(config.UserVersionFile)
+
+	seenTargets := make(map[string]bool)
+	for i, ch := range config.Channels {
+		if ch.Src == "" {
+			return nil, errors.Errorf("%s: channel %d (%s) is missing src", configPath, i+1, ch.Name)
+		}
+		ch.Src = resolve(ch.Src)
+		ch.UserVersionFile = resolve(ch.UserVersionFile)
+
+		spec, err := itchio.ParseSpec(config.TargetFor(ch))
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: channel %d (%s)", configPath, i+1, ch.Name)
+		}
+
+		err = spec.EnsureChannel()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: channel %d (%s)", configPath, i+1, ch.Name)
+		}
+
+		target := fmt.Sprintf("%s:%s", spec.Target, spec.Channel)
+		if seenTargets[target] {
+			return nil, errors.Errorf("%s: %s is listed more than once", configPath, target)
+		}
+		seenTargets[target] = true
+	}
+
+	return config, nil
+}
+
+// TargetFor returns the push target of a channel, of the form project:channel
+func (c *Config) TargetFor(ch *ChannelConfig) string {
+	if ch.Target != "" {
+		return ch.Target
+	}
+	return fmt.Sprintf("%s:%s", c.Project, ch.Name)
+}
+
+// UserVersionFor returns the user version a channel should be pushed with,
+// reading it from a file if needed. Channel settings have priority over
+// project-wide settings.
+func (c *Config) UserVersionFor(ch *ChannelConfig) (string, error) {
+	switch {
+	case ch.UserVersion != "":
+		return ch.UserVersion, nil
+	case ch.UserVersionFile != "":
+		return readUserVersionFile(ch.UserVersionFile)
+	case c.UserVersion != "":
+		return c.UserVersion, nil
+	case c.UserVersionFile != "":
+		return readUserVersionFile(c.UserVersionFile)
+	}
+	return "", nil
+}
+
+// IgnoreFor returns the extra glob patterns ignored for a channel
+func (c *Config) IgnoreFor(ch *ChannelConfig) []string {
+	var patterns []string
+	patterns = append(patterns, c.Ignore...)
+	patterns = append(patterns, ch.Ignore...)
+	return patterns
+}
@@ -0,0 +1,74 @@
+package push_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/push"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, contents string) (string, func()) {
+	dir, err := ioutil.TempDir("", "push-config")
+	wtest.Must(t, err)
+
+	configPath := filepath.Join(dir, "butler.toml")
+	wtest.Must(t, ioutil.WriteFile(configPath, []byte(contents), 0644))
+	return configPath, func() { os.RemoveAll(dir) }
+}
+
+func TestReadConfig(t *testing.T) {
+	configPath, cleanup := writeConfig(t, `
+project = "leafo/x-moon"
+userversion = "1.0"
+ignore = ["*.pdb"]
+
+[[channel]]
+name = "win-64"
+src = "build/windows"
+ignore = ["*.log"]
+
+[[channel]]
+src = "/abs/ost"
+target = "leafo/x-moon-ost:soundtrack"
+userversion = "1.0-ost"
+`)
+	defer cleanup()
+
+	config, err := push.ReadConfig(configPath)
+	wtest.Must(t, err)
+	assert.Len(t, config.Channels, 2)
+
+	win := config.Channels[0]
+	assert.EqualValues(t, "leafo/x-moon:win-64", config.TargetFor(win))
+	assert.EqualValues(t, filepath.Join(filepath.Dir(configPath), "build/windows"), win.Src)
+	assert.EqualValues(t, []string{"*.pdb", "*.log"}, config.IgnoreFor(win))
+	userVersion, err := config.UserVersionFor(win)
+	wtest.Must(t, err)
+	assert.EqualValues(t, "1.0", userVersion)
+
+	ost := config.Channels[1]
+	assert.EqualValues(t, "leafo/x-moon-ost:soundtrack", config.TargetFor(ost))
+	assert.EqualValues(t, "/abs/ost", ost.Src)
+	userVersion, err = config.UserVersionFor(ost)
+	wtest.Must(t, err)
+	assert.EqualValues(t, "1.0-ost", userVersion)
+}
+
+func TestReadConfigInvalid(t *testing.T) {
+	for _, contents := range []string{
+		`project = "leafo/x-moon"`,
+		"project = \"leafo/x-moon\"\n[[channel]]\nname = \"win\"",
+		"project = \"leafo/x-moon\"\n[[channel]]\nsrc = \"a\"",
+		"project = \"leafo/x-moon\"\n[[channel]]\nname = \"win\"\nsrc = \"a\"\n[[channel]]\nname = \"win\"\nsrc = \"b\"",
+		"this is [[ not toml",
+	} {
+		configPath, cleanup := writeConfig(t, contents)
+		_, err := push.ReadConfig(configPath)
+		assert.Error(t, err, contents)
+		cleanup()
+	}
+}
@@ -0,0 +1,178 @@
+package push
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/wharf/pwr"
+	"github.com/olekukonko/tablewriter"
+	"github.com/pkg/errors"
+)
+
+// ConfigParams holds the settings that apply to every
+// channel of a config file push
+type ConfigParams struct {
+	FixPerms    bool
+	Dereference bool
+	IfChanged   bool
+	DryRun      bool
+	AutoWrap    bool
+	Resume      bool
+	Validate    bool
+	Compression *pwr.CompressionSettings
+
+	// Wait waits for all builds to be processed once they're all pushed
+	Wait        bool
+	WaitTimeout time.Duration
+
+	// Report is the path to write a JSON report of all pushes to, if any
+	Report string
+	// ReportTop is how many of the files with the most changes to list per channel
+	ReportTop int
+
+	// SignKey, if set, is used to seal the signature of every build
+	SignKey ed25519.PrivateKey
+}
+
+type channelOutcome struct {
+	target string
+	res    *Result
+	err    error
+}
+
+// DoConfig pushes every channel listed in a project config file,
+// authenticating only once, then prints a combined summary.
+// It returns an error if any of the channels failed to push.
+func DoConfig(ctx *mansion.Context, configPath string, params *ConfigParams) error {
+	config, err := ReadConfig(configPath)
+	if err != nil {
+		return err
+	}
+
+	var client *itchio.Client
+	if !params.DryRun {
+		client, err = ctx.AuthenticateViaOauth()
+		if err != nil {
+			return errors.Wrap(err, "authenticating")
+		}
+	}
+
+	var outcomes []*channelOutcome
+	for i, ch := range config.Channels {
+		target := config.TargetFor(ch)
+		comm.Opf("(%d/%d) Pushing %s to %s", i+1, len(config.Channels), ch.Src, target)
+
+		outcome := &channelOutcome{target: target}
+		outcomes = append(outcomes, outcome)
+
+		userVersion, err := config.UserVersionFor(ch)
+		if err != nil {
+			comm.Warnf("%s: %s", target, err.Error())
+			outcome.err = err
+			continue
+		}
+
+		outcome.res, outcome.err = Do(ctx, &Params{
+			Src:          ch.Src,
+			Target:       target,
+			UserVersion:  userVersion,
+			FixPerms:     params.FixPerms,
+			Dereference:  params.Dereference,
+			IfChanged:    params.IfChanged,
+			DryRun:       params.DryRun,
+			AutoWrap:     params.AutoWrap,
+			Resume:       params.Resume,
+			Validate:     params.Validate || config.Validate,
+			Compression:  params.Compression,
+			AnalyzePatch: params.Report != "",
+			Filter:       filtering.FilterPathsWith(config.IgnoreFor(ch)),
+			Client:       client,
+			SignKey:      params.SignKey,
+		})
+		if outcome.err != nil {
+			comm.Warnf("%s: %s", target, outcome.err.Error())
+		}
+	}
+
+	if params.Wait && !params.DryRun {
+		// builds are processed concurrently, so waiting on
+		// them one after the other is as fast as it gets.
+		deadline := time.Now().Add(params.WaitTimeout)
+		for _, o := range outcomes {
+			if o.err != nil || o.res == nil || o.res.Skipped {
+				continue
+			}
+
+			timeout := time.Until(deadline)
+			if timeout <= 0 {
+				timeout = time.Nanosecond
+			}
+			o.err = waitForProcessing(client, o.res, timeout)
+			if o.err != nil {
+				comm.Warnf("%s: %s", o.target, o.err.Error())
+			}
+		}
+	}
+
+	comm.Logf("")
+	comm.Opf("Summary for %s", configPath)
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Target", "Build", "Size", "Patch", "Status"})
+
+	numFailed := 0
+	for _, o := range outcomes {
+		switch {
+		case o.err != nil:
+			numFailed++
+			table.Append([]string{o.target, "", "", "", fmt.Sprintf("failed: %s", o.err.Error())})
+		case o.res.Skipped:
+			table.Append([]string{o.target, "", "", "", "unchanged"})
+		case params.DryRun:
+			table.Append([]string{o.target, "", progress.FormatBytes(o.res.Container.Size), "", "dry run"})
+		default:
+			status := "processing"
+			if o.res.State == itchio.BuildStateCompleted {
+				status = "live"
+			}
+			if len(o.res.ValidationWarnings) > 0 {
+				status = fmt.Sprintf("%s (%d validation warnings)", status, len(o.res.ValidationWarnings))
+			}
+			table.Append([]string{
+				o.target,
+				fmt.Sprintf("#%d", o.res.BuildID),
+				progress.FormatBytes(o.res.Container.Size),
+				progress.FormatBytes(o.res.PatchSize),
+				status,
+			})
+		}
+	}
+	table.Render()
+
+	if params.Report != "" {
+		report := &ConfigReport{}
+		for _, o := range outcomes {
+			report.Channels = append(report.Channels, newReport(o.target, o.res, o.err, params.ReportTop))
+		}
+		err := writeReport(params.Report, report)
+		if err != nil {
+			return err
+		}
+	}
+
+	if numFailed > 0 {
+		return fmt.Errorf("%d out of %d channels failed to push", numFailed, len(outcomes))
+	}
+
+	if !params.DryRun {
+		comm.Statf("All %d channels pushed successfully", len(outcomes))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package push
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/itchio/wharf/pwr"
+	"github.com/pkg/errors"
+)
+
+// A compressionPreset is a named set of compression settings for patches
+// and signatures, along with how it compares to the default.
+type compressionPreset struct {
+	Name     string
+	Settings pwr.CompressionSettings
+
+	// Tradeoff describes how the preset compares to the balanced one.
+	// How much faster or smaller depends a lot on what's in the build.
+	Tradeoff string
+}
+
+var compressionPresets = []*compressionPreset{
+	{
+		Name:     "fast",
+		Settings: pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_ZSTD, Quality: 1},
+		Tradeoff: "faster than balanced, larger patches",
+	},
+	{
+		Name:     "balanced",
+		Settings: pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_BROTLI, Quality: 1},
+	},
+	{
+		Name:     "smaller",
+		Settings: pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_ZSTD, Quality: 9},
+		Tradeoff: "slower than balanced, smaller patches",
+	},
+	{
+		Name:     "smallest",
+		Settings: pwr.CompressionSettings{Algorithm: pwr.CompressionAlgorithm_BROTLI, Quality: 9},
+		Tradeoff: "much slower than balanced, smallest patches",
+	},
+}
+
+// compressionPresetNames returns the names of all presets, for use in flag enums
+func compressionPresetNames() []string {
+	var names []string
+	for _, p := range compressionPresets {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func findCompressionPreset(name string) (*compressionPreset, error) {
+	for _, p := range compressionPresets {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, errors.Errorf("unknown compression preset '%s', expected one of: %s", name, strings.Join(compressionPresetNames(), ", "))
+}
+
+// describeCompression returns a human-readable description of compression
+// settings, including the expected tradeoff if they match a preset.
+func describeCompression(settings *pwr.CompressionSettings) string {
+	desc := fmt.Sprintf("%s q%d", strings.ToLower(settings.Algorithm.String()), settings.Quality)
+
+	for _, p := range compressionPresets {
+		if p.Settings.Algorithm != settings.Algorithm || p.Settings.Quality != settings.Quality {
+			continue
+		}
+
+		if p.Name == "balanced" {
+			return fmt.Sprintf("%s (%s, the default)", p.Name, desc)
+		}
+		return fmt.Sprintf("%s (%s): %s", p.Name, desc, p.Tradeoff)
+	}
+	return desc
+}
@@ -0,0 +1,29 @@
+package push
+
+import (
+	"testing"
+
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionPresets(t *testing.T) {
+	balanced, err := findCompressionPreset("balanced")
+	wtest.Must(t, err)
+	assert.EqualValues(t, pwr.CompressionAlgorithm_BROTLI, balanced.Settings.Algorithm)
+	assert.EqualValues(t, 1, balanced.Settings.Quality)
+
+	_, err = findCompressionPreset("fastest")
+	assert.Error(t, err)
+
+	assert.EqualValues(t, "balanced (brotli q1, the default)", describeCompression(&balanced.Settings))
+	assert.EqualValues(t, "smallest (brotli q9): much slower than balanced, smallest patches", describeCompression(&pwr.CompressionSettings{
+		Algorithm: pwr.CompressionAlgorithm_BROTLI,
+		Quality:   9,
+	}))
+	assert.EqualValues(t, "gzip q5", describeCompression(&pwr.CompressionSettings{
+		Algorithm: pwr.CompressionAlgorithm_GZIP,
+		Quality:   5,
+	}))
+}
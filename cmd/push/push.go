@@ -2,23 +2,27 @@ package push
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/itchio/wharf/eos/option"
-
+	"github.com/itchio/butler/buildsource"
+	"github.com/itchio/butler/cmd/probe"
+	"github.com/itchio/butler/cmd/wait"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/seal"
 	itchio "github.com/itchio/go-itchio"
 	"github.com/itchio/httpkit/progress"
 	"github.com/itchio/httpkit/uploader"
-	"github.com/itchio/savior/seeksource"
 	"github.com/itchio/wharf/counter"
-	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/pwr"
 	"github.com/itchio/wharf/state"
 	"github.com/itchio/wharf/tlc"
@@ -40,6 +44,9 @@ var singleFileWarningExtensionList = []string{
 	".pkg", // Apple installer
 }
 
+// how often to check on a build while waiting for it to be processed
+const waitInterval = 5 * time.Second
+
 var args = struct {
 	src             string
 	target          string
@@ -50,12 +57,21 @@ var args = struct {
 	ifChanged       bool
 	dryRun          bool
 	autoWrap        bool
+	resume          bool
+	config          string
+	wait            bool
+	waitTimeout     time.Duration
+	preset          string
+	validate        bool
+	report          string
+	reportTop       int
+	signKey         string
 }{}
 
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("push", "Upload a new build to itch.io. See `butler help push`.")
-	cmd.Arg("src", "Directory to upload. May also be a zip archive (slower)").Required().StringVar(&args.src)
-	cmd.Arg("target", "Where to push, for example 'leafo/x-moon:win-64'. Targets are of the form project:channel, where project is username/game or game_id.").Required().StringVar(&args.target)
+	cmd.Arg("src", "Directory to upload. May also be a .zip, .tar(.gz/.bz2/.xz/.zst) or .7z archive (slower)").StringVar(&args.src)
+	cmd.Arg("target", "Where to push, for example 'leafo/x-moon:win-64'. Targets are of the form project:channel, where project is username/game or game_id.").StringVar(&args.target)
 	cmd.Flag("userversion", "A user-supplied version number that you can later query builds by").StringVar(&args.userVersion)
 	cmd.Flag("userversion-file", "A file containing a user-supplied version number that you can later query builds by").StringVar(&args.userVersionFile)
 	cmd.Flag("fix-permissions", "Detect Mac & Linux executables and adjust their permissions automatically").Default("true").BoolVar(&args.fixPerms)
@@ -63,147 +79,417 @@ func Register(ctx *mansion.Context) {
 	cmd.Flag("if-changed", "Don't push anything if it would be an empty patch").Default("false").BoolVar(&args.ifChanged)
 	cmd.Flag("dry-run", "Don't push anything, just show what would be pushed").Default("false").BoolVar(&args.dryRun)
 	cmd.Flag("auto-wrap", "Apply workaround for https://github.com/itchio/itch/issues/2147").Default("true").BoolVar(&args.autoWrap)
+	cmd.Flag("resume", "Continue the previous push of the same src and target if it was interrupted").Default("true").BoolVar(&args.resume)
+	cmd.Flag("preset", "Compression preset to use for the patch and signature, overrides --compression and --quality").EnumVar(&args.preset, compressionPresetNames()...)
+	cmd.Flag("validate", "Check the build like `butler validate` would, for the platforms in the channel name, and don't push it if there are errors").Default("false").BoolVar(&args.validate)
+	cmd.Flag("report", "Write a JSON report of the push (sizes, timings, files with the most changes) to this path. Needs as much free space next to the credentials file as the patch is large").StringVar(&args.report)
+	cmd.Flag("report-top", "How many of the files with the most changes to list in the report").Default("10").IntVar(&args.reportTop)
+	cmd.Flag("wait", "Wait for the build to be processed, and exit with a non-zero code if processing fails").Default("false").BoolVar(&args.wait)
+	cmd.Flag("wait-timeout", "How long to wait for processing with --wait before giving up").Default("30m").DurationVar(&args.waitTimeout)
+	cmd.Flag("sign-key", "Seal the build's signature with this private key, as generated by `butler keygen`").StringVar(&args.signKey)
+	cmd.Flag("config", "Push all channels listed in a project config file (for example butler.toml) instead of a single src and target").StringVar(&args.config)
 	ctx.Register(cmd, do)
 }
 
+// Params controls how a single build is pushed
+type Params struct {
+	// Src is the directory to push. May also be a zip archive
+	Src string
+	// Target is where to push, of the form project:channel
+	Target string
+	// UserVersion is an optional user-supplied version number
+	UserVersion string
+	// FixPerms detects Mac & Linux executables and adjusts their permissions
+	FixPerms bool
+	// Dereference walks symlinks as if they were their targets
+	Dereference bool
+	// IfChanged skips the push entirely if it would be an empty patch
+	IfChanged bool
+	// DryRun only lists the files that would be pushed
+	DryRun bool
+	// AutoWrap makes macOS app bundles the top-level directory in the container
+	AutoWrap bool
+	// Resume continues an interrupted push of the same Src and Target, if any,
+	// and saves progress locally so this push can be resumed in turn
+	Resume bool
+	// Validate runs the checks of `butler validate` before creating the build,
+	// and aborts if there are any errors
+	Validate bool
+	// AnalyzePatch keeps a copy of the patch next to the push session
+	// to analyze it after pushing, see Result.Analysis. That needs as much
+	// free disk space as the patch is large.
+	AnalyzePatch bool
+	// Wait polls the build after pushing it until it's done processing,
+	// and returns an error if processing failed or took more than WaitTimeout
+	Wait        bool
+	WaitTimeout time.Duration
+	// Compression is used for the patch and signature, defaults to ctx.CompressionSettings()
+	Compression *pwr.CompressionSettings
+	// Filter decides which files to exclude from the push, defaults to filtering.FilterPaths
+	Filter tlc.FilterFunc
+	// Client is used to talk to itch.io. If nil, we authenticate first.
+	Client *itchio.Client
+	// SignKey, if set, is used to seal the build's signature, see package seal
+	SignKey ed25519.PrivateKey
+}
+
+// Result describes what a push did
+type Result struct {
+	BuildID  int64
+	ParentID int64
+
+	// Skipped is true if nothing was pushed because IfChanged was set
+	// and the source matched the latest build.
+	Skipped bool
+	// State is the state of the build once done processing, only set
+	// if Params.Wait was.
+	State itchio.BuildState
+
+	Container     *tlc.Container
+	PatchSize     int64
+	SignatureSize int64
+	FreshBytes    int64
+	ReusedBytes   int64
+
+	// ValidationWarnings lists problems found with Validate that
+	// weren't serious enough to abort the push
+	ValidationWarnings []string
+
+	// Compression describes the settings used for the patch and signature
+	Compression string
+	// Phases is how long each step of the push took
+	Phases *Phases
+	// Analysis is how much fresh data the patch has for each file,
+	// only set if Params.AnalyzePatch was.
+	Analysis *probe.Analysis
+}
+
 func do(ctx *mansion.Context) {
 	go ctx.DoVersionCheck()
 
+	compression := ctx.CompressionSettings()
+	if args.preset != "" {
+		preset, err := findCompressionPreset(args.preset)
+		ctx.Must(err)
+		compression = preset.Settings
+	}
+
+	var signKey ed25519.PrivateKey
+	if args.signKey != "" {
+		var err error
+		signKey, err = seal.LoadPrivateKey(args.signKey)
+		ctx.Must(err)
+	}
+
+	if args.config != "" {
+		ctx.Must(DoConfig(ctx, args.config, &ConfigParams{
+			FixPerms:    args.fixPerms,
+			Dereference: args.dereference,
+			IfChanged:   args.ifChanged,
+			DryRun:      args.dryRun,
+			AutoWrap:    args.autoWrap,
+			Resume:      args.resume,
+			Validate:    args.validate,
+			Wait:        args.wait,
+			WaitTimeout: args.waitTimeout,
+			Compression: &compression,
+			Report:      args.report,
+			ReportTop:   args.reportTop,
+			SignKey:     signKey,
+		}))
+		return
+	}
+
+	if args.src == "" || args.target == "" {
+		ctx.Must(errors.New("push: src and target are required, unless --config is specified"))
+	}
+
 	// if userVersionFile specified, read from the given file
-	// TODO: do utf-16 decoding here
 	userVersion := args.userVersion
 	if userVersion == "" && args.userVersionFile != "" {
-		buf, err := ioutil.ReadFile(args.userVersionFile)
+		var err error
+		userVersion, err = readUserVersionFile(args.userVersionFile)
 		ctx.Must(err)
+	}
 
-		userVersion = strings.TrimSpace(string(buf))
-		if strings.ContainsAny(userVersion, "\r\n") {
-			ctx.Must(fmt.Errorf("%s contains line breaks, refusing to use as userversion", args.userVersionFile))
-		}
+	res, err := Do(ctx, &Params{
+		Src:          args.src,
+		Target:       args.target,
+		UserVersion:  userVersion,
+		FixPerms:     args.fixPerms,
+		Dereference:  args.dereference,
+		IfChanged:    args.ifChanged,
+		DryRun:       args.dryRun,
+		AutoWrap:     args.autoWrap,
+		Resume:       args.resume,
+		Validate:     args.validate,
+		Wait:         args.wait,
+		WaitTimeout:  args.waitTimeout,
+		Compression:  &compression,
+		AnalyzePatch: args.report != "",
+		SignKey:      signKey,
+	})
+
+	if args.report != "" {
+		ctx.Must(writeReport(args.report, newReport(args.target, res, err, args.reportTop)))
+	}
+	ctx.Must(err)
+}
+
+// TODO: do utf-16 decoding here
+func readUserVersionFile(userVersionFile string) (string, error) {
+	buf, err := ioutil.ReadFile(userVersionFile)
+	if err != nil {
+		return "", errors.Wrap(err, "reading userversion file")
 	}
 
-	ctx.Must(Do(ctx, args.src, args.target, userVersion, args.fixPerms, args.dereference, args.ifChanged, args.autoWrap))
+	userVersion := strings.TrimSpace(string(buf))
+	if strings.ContainsAny(userVersion, "\r\n") {
+		return "", fmt.Errorf("%s contains line breaks, refusing to use as userversion", userVersionFile)
+	}
+	return userVersion, nil
 }
 
-func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion string, fixPerms bool, dereference bool, ifChanged bool, wrap bool) error {
+func Do(ctx *mansion.Context, params *Params) (*Result, error) {
+	startTime := time.Now()
+	phases := &Phases{}
+	phaseStart := startTime
+	endPhase := func(d *time.Duration) {
+		now := time.Now()
+		*d = now.Sub(phaseStart)
+		phaseStart = now
+	}
+
+	buildPath := params.Src
+	specStr := params.Target
+
+	filter := params.Filter
+	if filter == nil {
+		filter = filtering.FilterPaths
+	}
+
+	compression := params.Compression
+	if compression == nil {
+		defaultCompression := ctx.CompressionSettings()
+		compression = &defaultCompression
+	}
+
 	consumer := comm.NewStateConsumer()
 
 	// start walking source container while waiting on auth flow
-	sourceContainerChan := make(chan walkResult)
-	walkErrs := make(chan error)
+	sourceContainerChan := make(chan walkResult, 1)
+	walkErrs := make(chan error, 1)
 	walkOpts := &tlc.WalkOpts{
-		Filter:      filtering.FilterPaths,
-		Dereference: dereference,
+		Filter:      filter,
+		Dereference: params.Dereference,
 	}
-	if wrap {
+	if params.AutoWrap {
 		walkOpts.AutoWrap(&buildPath, consumer)
 	}
 
-	go doWalk(buildPath, sourceContainerChan, walkErrs, fixPerms, walkOpts)
+	go doWalk(buildPath, sourceContainerChan, walkErrs, params.FixPerms, walkOpts)
 
-	if args.dryRun {
-		comm.Opf("Dry run, listing files we would push...")
-		select {
-		case walkErr := <-walkErrs:
-			return errors.Wrap(walkErr, "walking directory to push")
-		case walkies := <-sourceContainerChan:
-			log := func(line string) {
-				comm.Logf(line)
-			}
-			walkies.container.Print(log)
-			comm.Statf("Would push %s", walkies.container)
+	// archives may have been extracted to a temporary folder
+	var source *buildsource.Source
+	defer func() {
+		if source != nil {
+			source.Close()
+			return
 		}
-		return nil
-	}
+
+		go func() {
+			select {
+			case walkies := <-sourceContainerChan:
+				walkies.source.Close()
+			case <-walkErrs:
+			}
+		}()
+	}()
 
 	spec, err := itchio.ParseSpec(specStr)
 	if err != nil {
-		return errors.Wrapf(err, "parsing push target '%s'", specStr)
+		return nil, errors.Wrapf(err, "parsing push target '%s'", specStr)
 	}
 
 	err = spec.EnsureChannel()
 	if err != nil {
-		return err
-	}
-
-	client, err := ctx.AuthenticateViaOauth()
-	if err != nil {
-		return errors.Wrap(err, "authenticating")
+		return nil, err
 	}
 
-	getSignature := func(ID int64) (*pwr.SignatureInfo, error) {
-		buildFiles, err := client.ListBuildFiles(ID)
+	var validationWarnings []string
+	if params.Validate {
+		comm.Opf("Validating build for channel `%s`", spec.Channel)
+		validateRes, err := validateBuild(params.Src, spec.Channel, filter)
 		if err != nil {
-			return nil, errors.Wrap(err, "listing build files")
+			return nil, err
 		}
 
-		signatureFile := itchio.FindBuildFile(itchio.BuildFileTypeSignature, buildFiles.Files)
-		if signatureFile == nil {
-			comm.Dief("Could not find signature for parent build %d, aborting", ID)
+		if len(validateRes.Errors) > 0 {
+			for _, msg := range validateRes.Errors {
+				comm.Logf("  - %s", msg)
+			}
+			return nil, errors.Errorf("build has %d validation errors, not pushing (use `butler validate` for details)", len(validateRes.Errors))
 		}
+		validationWarnings = validateRes.Warnings
+		comm.Statf("Build is valid (%d warnings)", len(validationWarnings))
+		endPhase(&phases.Validate)
+	}
 
-		signatureURL := client.MakeBuildFileDownloadURL(itchio.MakeBuildFileDownloadURLParams{
-			BuildID: ID,
-			FileID:  signatureFile.ID,
-		})
-
-		signatureReader, err := eos.Open(signatureURL, option.WithConsumer(consumer))
-		if err != nil {
-			return nil, errors.Wrap(err, "opening signature")
+	if params.DryRun {
+		comm.Opf("Dry run, listing files we would push...")
+		select {
+		case walkErr := <-walkErrs:
+			return nil, errors.Wrap(walkErr, "walking directory to push")
+		case walkies := <-sourceContainerChan:
+			source = walkies.source
+			if source.Format != "" {
+				comm.Logf("Reading from %s", source.Describe())
+			}
+			log := func(line string) {
+				comm.Logf(line)
+			}
+			walkies.container.Print(log)
+			for _, e := range walkies.exclusions {
+				comm.Logf("Excluding %s, because of %s", e.Path, e.Rule)
+			}
+			comm.Statf("Would push %s", walkies.container)
+			return &Result{Container: walkies.container, ValidationWarnings: validationWarnings}, nil
 		}
-		defer signatureReader.Close()
-
-		signatureSource := seeksource.FromFile(signatureReader)
+	}
 
-		_, err = signatureSource.Resume(nil)
+	client := params.Client
+	if client == nil {
+		client, err = ctx.AuthenticateViaOauth()
 		if err != nil {
-			return nil, errors.Wrap(err, "opening signature")
+			return nil, errors.Wrap(err, "authenticating")
 		}
+	}
+	endPhase(&phases.Authenticate)
 
-		signature, err := pwr.ReadSignature(context.Background(), signatureSource)
-		if err != nil {
-			return nil, errors.Wrap(err, "reading signature")
-		}
+	sigCache := ctx.SignatureCache()
 
-		return signature, nil
+	getSignature := func(ID int64) (*pwr.SignatureInfo, error) {
+		return sigCache.Get(client, ID, consumer)
 	}
 
-	if ifChanged {
+	if params.IfChanged && buildsource.DetectFormat(buildPath) != "" {
+		comm.Warnf("--if-changed only works with folders and .zip files, pushing unconditionally")
+	} else if params.IfChanged {
 		chanInfo, err := client.GetChannel(spec.Target, spec.Channel)
 		if err == nil && chanInfo != nil && chanInfo.Channel != nil && chanInfo.Channel.Head != nil {
 			comm.Opf("Comparing against previous build...")
 			sig, err := getSignature(chanInfo.Channel.Head.ID)
 			if err != nil {
-				return errors.Wrap(err, "getting previous build signature")
+				return nil, errors.Wrap(err, "getting previous build signature")
 			}
 
 			err = pwr.AssertValid(buildPath, sig)
 			if err == nil {
 				comm.Statf("No changes and --if-changed used, not pushing anything")
-				return nil
+				return &Result{Skipped: true}, nil
 			}
 
 			if _, ok := err.(*pwr.ErrHasWound); ok {
 				// cool, that's what we expected
 			} else {
-				return errors.Wrap(err, "checking for differences")
+				return nil, errors.Wrap(err, "checking for differences")
 			}
 		} else {
 			comm.Opf("No previous build to compare against, pushing unconditionally")
 		}
 	}
 
-	newBuildRes, err := client.CreateBuild(itchio.CreateBuildParams{
-		Target:      spec.Target,
-		Channel:     spec.Channel,
-		UserVersion: userVersion,
-	})
+	// we started walking the source container in the beginning,
+	// we need it by the time we resume a session or start diffing.
+	var sourceContainer *tlc.Container
+	var sourcePool wsync.Pool
+
+	waitForWalk := func() error {
+		if sourceContainer != nil {
+			return nil
+		}
+
+		comm.Debugf("Waiting for source container")
+		select {
+		case walkErr := <-walkErrs:
+			return errors.Wrap(walkErr, "walking directory to push")
+		case walkies := <-sourceContainerChan:
+			source = walkies.source
+			sourceContainer = walkies.container
+			sourcePool = walkies.pool
+			phases.Walk = walkies.duration
+		}
+		return nil
+	}
+
+	sessPath, err := sessionPath(filepath.Join(ctx.ConfigDir, "butler_push_sessions"), params.Src, fmt.Sprintf("%s:%s", spec.Target, spec.Channel))
 	if err != nil {
-		return errors.Wrap(err, "creating build on remote server")
+		return nil, errors.Wrap(err, "determining push session path")
+	}
+
+	var sess *session
+	if params.Resume {
+		if prevSess, err := loadSession(sessPath); err == nil && prevSess != nil {
+			err = waitForWalk()
+			if err != nil {
+				return nil, err
+			}
+			sess = resumableSession(client.HTTPClient, prevSess, params.UserVersion, compression, sourceContainer)
+		}
+	} else {
+		err = os.Remove(sessPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "removing previous push session")
+		}
 	}
 
-	buildID := newBuildRes.Build.ID
-	parentID := newBuildRes.Build.ParentBuild.ID
+	var buildID int64
+	var parentID int64
+
+	if sess != nil {
+		buildID = sess.BuildID
+		parentID = sess.ParentID
+		comm.Opf("Resuming interrupted push of build %d (started %s ago)", buildID, progress.FormatDuration(time.Since(sess.CreatedAt)))
+
+		if sess.Patch.Size > 0 && sess.Signature.Size > 0 {
+			comm.Opf("Patch and signature were already uploaded, finalizing build")
+			phaseStart = time.Now()
+			err = finalizeBothFiles(client, buildID, sess.Patch.FileID, sess.Patch.Size, sess.Signature.FileID, sess.Signature.Size)
+			if err != nil {
+				return nil, err
+			}
+			sess.remove()
+			endPhase(&phases.Finalize)
+			phases.Total = time.Since(startTime)
+
+			res := &Result{
+				BuildID:            buildID,
+				ParentID:           parentID,
+				Container:          sourceContainer,
+				PatchSize:          sess.Patch.Size,
+				SignatureSize:      sess.Signature.Size,
+				ValidationWarnings: validationWarnings,
+				Compression:        sess.Compression,
+				Phases:             phases,
+			}
+			if params.Wait {
+				err = waitForProcessing(client, res, params.WaitTimeout)
+			}
+			return res, err
+		}
+	} else {
+		newBuildRes, err := client.CreateBuild(itchio.CreateBuildParams{
+			Target:      spec.Target,
+			Channel:     spec.Channel,
+			UserVersion: params.UserVersion,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "creating build on remote server")
+		}
+
+		buildID = newBuildRes.Build.ID
+		parentID = newBuildRes.Build.ParentBuild.ID
+	}
 
 	var targetSignature *pwr.SignatureInfo
 
@@ -216,52 +502,118 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 	} else {
 		comm.Opf("For channel `%s`: last build is %d, downloading its signature", spec.Channel, parentID)
 		var err error
+		phaseStart = time.Now()
 		targetSignature, err = getSignature(parentID)
 		if err != nil {
-			return errors.Wrap(err, "searching for parent build signature")
+			return nil, errors.Wrap(err, "searching for parent build signature")
 		}
+		endPhase(&phases.ParentSignature)
 	}
 
-	bothFiles, err := createBothFiles(client, buildID)
-	if err != nil {
-		return errors.Wrap(err, "creating remote patch and signature files")
-	}
+	if sess == nil {
+		bothFiles, err := createBothFiles(client, buildID)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating remote patch and signature files")
+		}
+
+		err = waitForWalk()
+		if err != nil {
+			return nil, err
+		}
+
+		sess = &session{
+			Src:         params.Src,
+			Target:      specStr,
+			UserVersion: params.UserVersion,
+			CreatedAt:   time.Now(),
+			Compression: compression.ToString(),
+			Fingerprint: containerFingerprint(sourceContainer),
+			BuildID:     buildID,
+			ParentID:    parentID,
+			Patch: &sessionFile{
+				FileID:    bothFiles.patchRes.File.ID,
+				UploadURL: bothFiles.patchRes.File.UploadURL,
+			},
+			Signature: &sessionFile{
+				FileID:    bothFiles.signatureRes.File.ID,
+				UploadURL: bothFiles.signatureRes.File.UploadURL,
+			},
+			path: sessPath,
+		}
 
-	newPatchRes := bothFiles.patchRes
-	newSignatureRes := bothFiles.signatureRes
+		if params.Resume {
+			err = sess.save()
+			if err != nil {
+				comm.Warnf("Could not save push session, won't be able to resume: %s", err.Error())
+			}
+		}
+	}
 
-	patchWriter := uploader.NewResumableUpload(newPatchRes.File.UploadURL)
-	patchWriter.SetConsumer(consumer)
+	newUpload := func(sf *sessionFile) uploader.ResumableUpload {
+		var ru uploader.ResumableUpload
+		if sf.committed > 0 {
+			comm.Logf("Resuming upload after %s", progress.FormatBytes(sf.committed))
+			ru = newOffsetUpload(sf.UploadURL, sf.committed)
+		} else {
+			ru = uploader.NewResumableUpload(sf.UploadURL)
+		}
+		ru.SetConsumer(consumer)
+		return ru
+	}
 
-	signatureWriter := uploader.NewResumableUpload(newSignatureRes.File.UploadURL)
-	signatureWriter.SetConsumer(consumer)
+	patchWriter := newUpload(sess.Patch)
+	signatureWriter := newUpload(sess.Signature)
 
 	comm.Debugf("Launching patch & signature channels")
 
-	patchCounter := counter.NewWriter(patchWriter)
-	signatureCounter := counter.NewWriter(signatureWriter)
+	patchCheckpoints := newCheckpointWriter(patchWriter, sess.Patch.Checkpoint)
+	signatureCheckpoints := newCheckpointWriter(signatureWriter, sess.Signature.Checkpoint)
 
-	// we started walking the source container in the beginning,
-	// we actually need it now.
-	// note that we could actually start diffing before all the file
-	// creation & upload setup is done
+	var patchWriters = []io.Writer{patchCheckpoints}
+	var patchCopy *os.File
+	if params.AnalyzePatch {
+		patchCopy, err = createPatchCopy(sessPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating patch copy for analysis")
+		}
+		defer os.Remove(patchCopy.Name())
+		defer patchCopy.Close()
+		patchWriters = append(patchWriters, patchCopy)
+	}
+	patchCounter := counter.NewWriter(io.MultiWriter(patchWriters...))
+	// keep a copy of the signature we generate, so the next push
+	// from this machine doesn't have to download it.
+	signatureCache := sigCache.NewWriter(buildID)
+	defer signatureCache.Abort()
+	signatureCounter := counter.NewWriter(io.MultiWriter(signatureCheckpoints, signatureCache))
+
+	saveCheckpoints := func() {
+		if !params.Resume {
+			return
+		}
 
-	var sourceContainer *tlc.Container
-	var sourcePool wsync.Pool
+		sess.update(func() {
+			sess.Patch.Checkpoint = patchCheckpoints.checkpoint()
+			sess.Signature.Checkpoint = signatureCheckpoints.checkpoint()
+		})
+		err := sess.save()
+		if err != nil {
+			comm.Debugf("Could not save push session: %s", err.Error())
+		}
+	}
 
-	comm.Debugf("Waiting for source container")
-	select {
-	case walkErr := <-walkErrs:
-		return errors.Wrap(walkErr, "walking directory to push")
-	case walkies := <-sourceContainerChan:
-		sourceContainer = walkies.container
-		sourcePool = walkies.pool
-		break
+	err = waitForWalk()
+	if err != nil {
+		return nil, err
 	}
 
 	showSingleFileWarningIfNecessary(sourceContainer)
 
 	comm.Opf("Pushing %s", sourceContainer)
+	if source.Format != "" {
+		comm.Logf("Reading from %s", source.Describe())
+	}
+	comm.Logf("Compression: %s", describeCompression(compression))
 
 	comm.Debugf("Building diff context")
 	var readBytes int64
@@ -302,7 +654,9 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		updateProgress()
 	})
 
+	tickerDone := make(chan struct{})
 	go func() {
+		defer close(tickerDone)
 		ticker := time.NewTicker(time.Second * time.Duration(2))
 		for {
 			select {
@@ -310,6 +664,7 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 				bytesPerSec = float64(patchUploadedBytes-lastUploadedBytes) / 2.0
 				lastUploadedBytes = patchUploadedBytes
 				updateProgress()
+				saveCheckpoints()
 			case <-stopTicking:
 				return
 			}
@@ -324,10 +679,7 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 	}
 
 	dctx := &pwr.DiffContext{
-		Compression: &pwr.CompressionSettings{
-			Algorithm: pwr.CompressionAlgorithm_BROTLI,
-			Quality:   1,
-		},
+		Compression: compression,
 
 		SourceContainer: sourceContainer,
 		Pool:            sourcePool,
@@ -338,11 +690,27 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		Consumer: stateConsumer,
 	}
 
+	var sealWriter *seal.Writer
+	var signatureOut io.Writer = signatureCounter
+	if params.SignKey != nil {
+		sealWriter = seal.NewWriter(signatureCounter)
+		signatureOut = sealWriter
+	}
+
 	comm.StartProgress()
 	comm.ProgressScale(0.0)
-	err = dctx.WritePatch(context.Background(), patchCounter, signatureCounter)
+	phaseStart = time.Now()
+	err = dctx.WritePatch(context.Background(), patchCounter, signatureOut)
 	if err != nil {
-		return errors.Wrap(err, "computing and writing patch")
+		return nil, errors.Wrap(err, "computing and writing patch")
+	}
+
+	if sealWriter != nil {
+		err = sealWriter.Seal(params.SignKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "sealing signature")
+		}
+		comm.Logf("Sealed signature with key %s", seal.Fingerprint(params.SignKey.Public().(ed25519.PublicKey)))
 	}
 
 	// close both files concurrently
@@ -360,41 +728,58 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 		for i := 0; i < 2; i++ {
 			err := <-errs
 			if err != nil {
-				return errors.WithStack(err)
+				return nil, errors.WithStack(err)
 			}
 		}
 	}
 
 	close(stopTicking)
+	<-tickerDone
+	endPhase(&phases.DiffAndUpload)
 	comm.ProgressLabel("finalizing build")
 
-	// finalize both files concurrently
-	{
-		errs := make(chan error)
-
-		doFinalize := func(fileID int64, fileSize int64, done chan error) {
-			_, err = client.FinalizeBuildFile(itchio.FinalizeBuildFileParams{
-				BuildID: buildID,
-				FileID:  fileID,
-				Size:    fileSize,
-			})
-			done <- err
+	if params.Resume {
+		// if we get interrupted while finalizing, there's no need
+		// to upload anything again next time.
+		sess.update(func() {
+			sess.Patch.Size = patchCounter.Count()
+			sess.Signature.Size = signatureCounter.Count()
+		})
+		err = sess.save()
+		if err != nil {
+			comm.Debugf("Could not save push session: %s", err.Error())
 		}
+	}
 
-		go doFinalize(newPatchRes.File.ID, patchCounter.Count(), errs)
-		go doFinalize(newSignatureRes.File.ID, signatureCounter.Count(), errs)
+	err = finalizeBothFiles(client, buildID, sess.Patch.FileID, patchCounter.Count(), sess.Signature.FileID, signatureCounter.Count())
+	if err != nil {
+		return nil, err
+	}
+	endPhase(&phases.Finalize)
 
-		// 2 doFinalize
-		for i := 0; i < 2; i++ {
-			err := <-errs
-			if err != nil {
-				return errors.WithStack(err)
-			}
-		}
+	err = sess.remove()
+	if err != nil {
+		comm.Debugf("Could not remove push session: %s", err.Error())
+	}
+
+	err = signatureCache.Commit()
+	if err != nil {
+		comm.Debugf("Could not cache signature: %s", err.Error())
 	}
 
 	comm.EndProgress()
 
+	var analysis *probe.Analysis
+	if patchCopy != nil {
+		comm.Opf("Analyzing patch")
+		analysis, err = analyzePatchCopy(patchCopy)
+		if err != nil {
+			comm.Warnf("Could not analyze patch: %s", err.Error())
+		}
+		endPhase(&phases.Analyze)
+	}
+	phases.Total = time.Since(startTime)
+
 	{
 		prettyPatchSize := progress.FormatBytes(patchCounter.Count())
 		percReused := 100.0 * float64(dctx.ReusedBytes) / float64(dctx.FreshBytes+dctx.ReusedBytes)
@@ -414,11 +799,79 @@ func Do(ctx *mansion.Context, buildPath string, specStr string, userVersion stri
 			comm.Statf("%s patch (no savings)", prettyPatchSize)
 		}
 	}
+	if len(validationWarnings) > 0 {
+		comm.Warnf("Build was pushed with %d validation warnings:", len(validationWarnings))
+		for _, msg := range validationWarnings {
+			comm.Logf("  - %s", msg)
+		}
+	}
+
 	comm.Opf("Build is now processing, should be up in a bit.")
-	comm.Logf("")
-	comm.Logf("Use the `butler status %s` for more information.", specStr)
-	comm.Logf("")
+	if !params.Wait {
+		comm.Logf("")
+		comm.Logf("Use the `butler status %s` for more information.", specStr)
+		comm.Logf("")
+	}
+
+	res := &Result{
+		BuildID:       buildID,
+		ParentID:      parentID,
+		Container:     sourceContainer,
+		PatchSize:     patchCounter.Count(),
+		SignatureSize: signatureCounter.Count(),
+		FreshBytes:    dctx.FreshBytes,
+		ReusedBytes:   dctx.ReusedBytes,
+
+		ValidationWarnings: validationWarnings,
+		Compression:        compression.ToString(),
+		Phases:             phases,
+		Analysis:           analysis,
+	}
+	if params.Wait {
+		err = waitForProcessing(client, res, params.WaitTimeout)
+	}
+	return res, err
+}
+
+// waitForProcessing waits until a build we just pushed is done processing
+func waitForProcessing(client *itchio.Client, res *Result, timeout time.Duration) error {
+	startTime := time.Now()
+	build, err := wait.ForBuild(client, res.BuildID, &wait.Options{
+		Timeout:  timeout,
+		Interval: waitInterval,
+	})
+	if build != nil {
+		res.State = build.State
+	}
+
+	res.Phases.Processing = time.Since(startTime)
+	res.Phases.Total += res.Phases.Processing
+	return err
+}
+
+// finalizeBothFiles finalizes the patch and signature files concurrently
+func finalizeBothFiles(client *itchio.Client, buildID int64, patchFileID int64, patchSize int64, signatureFileID int64, signatureSize int64) error {
+	errs := make(chan error)
+
+	doFinalize := func(fileID int64, fileSize int64, done chan error) {
+		_, err := client.FinalizeBuildFile(itchio.FinalizeBuildFileParams{
+			BuildID: buildID,
+			FileID:  fileID,
+			Size:    fileSize,
+		})
+		done <- err
+	}
+
+	go doFinalize(patchFileID, patchSize, errs)
+	go doFinalize(signatureFileID, signatureSize, errs)
 
+	// 2 doFinalize
+	for i := 0; i < 2; i++ {
+		err := <-errs
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,194 @@
+package push
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/itchio/butler/cmd/probe"
+	"github.com/pkg/errors"
+)
+
+// Phases records how long each step of a push took. Walking
+// happens concurrently with authentication and validation.
+type Phases struct {
+	Walk            time.Duration
+	Authenticate    time.Duration
+	Validate        time.Duration
+	ParentSignature time.Duration
+	DiffAndUpload   time.Duration
+	Finalize        time.Duration
+	Analyze         time.Duration
+	Processing      time.Duration
+	Total           time.Duration
+}
+
+// Report is the machine-readable summary of a push written by --report
+type Report struct {
+	Target   string `json:"target"`
+	BuildID  int64  `json:"buildId,omitempty"`
+	ParentID int64  `json:"parentId,omitempty"`
+
+	// Skipped is set if nothing was pushed because of --if-changed
+	Skipped bool `json:"skipped,omitempty"`
+	// Error is set if the push failed
+	Error string `json:"error,omitempty"`
+	// State is the state of the build after waiting for it to be processed
+	State string `json:"state,omitempty"`
+
+	Container     *ContainerReport `json:"container,omitempty"`
+	Compression   string           `json:"compression,omitempty"`
+	FreshBytes    int64            `json:"freshBytes"`
+	ReusedBytes   int64            `json:"reusedBytes"`
+	PatchSize     int64            `json:"patchSize"`
+	SignatureSize int64            `json:"signatureSize"`
+
+	// Phases is the wall time of each step, in seconds
+	Phases map[string]float64 `json:"phases,omitempty"`
+
+	// TopFiles lists the files with the most fresh data, the same
+	// way `butler probe` does
+	TopFiles []*FileReport `json:"topFiles,omitempty"`
+
+	ValidationWarnings []string `json:"validationWarnings,omitempty"`
+}
+
+// ContainerReport describes the pushed files
+type ContainerReport struct {
+	Size     int64 `json:"size"`
+	Files    int   `json:"files"`
+	Dirs     int   `json:"dirs"`
+	Symlinks int   `json:"symlinks"`
+}
+
+// FileReport describes how much of a file had to be uploaded
+type FileReport struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	FreshData int64  `json:"freshData"`
+	Algorithm string `json:"algorithm"`
+}
+
+// ConfigReport is written by --report when pushing from a config file
+type ConfigReport struct {
+	Channels []*Report `json:"channels"`
+}
+
+// newReport sums up the result of a push. res may be nil if the push failed.
+func newReport(target string, res *Result, pushErr error, maxTopFiles int) *Report {
+	r := &Report{
+		Target: target,
+	}
+	if pushErr != nil {
+		r.Error = pushErr.Error()
+	}
+	if res == nil {
+		return r
+	}
+
+	r.BuildID = res.BuildID
+	r.ParentID = res.ParentID
+	r.Skipped = res.Skipped
+	r.State = string(res.State)
+	r.Compression = res.Compression
+	r.FreshBytes = res.FreshBytes
+	r.ReusedBytes = res.ReusedBytes
+	r.PatchSize = res.PatchSize
+	r.SignatureSize = res.SignatureSize
+	r.ValidationWarnings = res.ValidationWarnings
+
+	if c := res.Container; c != nil {
+		r.Container = &ContainerReport{
+			Size:     c.Size,
+			Files:    len(c.Files),
+			Dirs:     len(c.Dirs),
+			Symlinks: len(c.Symlinks),
+		}
+	}
+
+	if p := res.Phases; p != nil {
+		r.Phases = make(map[string]float64)
+		add := func(name string, d time.Duration) {
+			if d > 0 {
+				r.Phases[name] = d.Seconds()
+			}
+		}
+		add("walk", p.Walk)
+		add("authenticate", p.Authenticate)
+		add("validate", p.Validate)
+		add("parentSignature", p.ParentSignature)
+		add("diffAndUpload", p.DiffAndUpload)
+		add("finalize", p.Finalize)
+		add("analyze", p.Analyze)
+		add("processing", p.Processing)
+		add("total", p.Total)
+	}
+
+	if a := res.Analysis; a != nil {
+		r.TopFiles = topFiles(a, maxTopFiles)
+	}
+
+	return r
+}
+
+func topFiles(a *probe.Analysis, max int) []*FileReport {
+	var files []*FileReport
+	for _, stat := range a.Stats {
+		if len(files) >= max || stat.FreshData <= 0 {
+			break
+		}
+
+		f := a.Source.Files[stat.FileIndex]
+		files = append(files, &FileReport{
+			Path:      f.Path,
+			Size:      f.Size,
+			FreshData: stat.FreshData,
+			Algorithm: stat.Algo.String(),
+		})
+	}
+	return files
+}
+
+func writeReport(reportPath string, report interface{}) error {
+	buf, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = ioutil.WriteFile(reportPath, buf, 0644)
+	if err != nil {
+		return errors.Wrap(err, "writing push report")
+	}
+	return nil
+}
+
+// createPatchCopy creates the file a patch is copied to while pushing,
+// next to the push session rather than in the OS temp folder, which
+// is often much smaller than the config folder.
+func createPatchCopy(sessPath string) (*os.File, error) {
+	dir := filepath.Dir(sessPath)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	prefix := strings.TrimSuffix(filepath.Base(sessPath), filepath.Ext(sessPath)) + "-patch"
+	f, err := ioutil.TempFile(dir, prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return f, nil
+}
+
+// analyzePatchCopy analyzes the local copy of a patch we just pushed
+func analyzePatchCopy(patchCopy *os.File) (*probe.Analysis, error) {
+	err := patchCopy.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return probe.Analyze(patchCopy.Name(), &probe.AnalyzeParams{})
+}
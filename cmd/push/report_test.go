@@ -0,0 +1,63 @@
+package push
+
+import (
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/cmd/probe"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewReport(t *testing.T) {
+	container := &tlc.Container{
+		Files: []*tlc.File{
+			{Path: "game.exe", Size: 100},
+			{Path: "data.pak", Size: 1000},
+			{Path: "readme.txt", Size: 10},
+		},
+		Dirs: []*tlc.Dir{{Path: "."}},
+		Size: 1110,
+	}
+
+	res := &Result{
+		BuildID:   12,
+		ParentID:  11,
+		Container: container,
+		PatchSize: 400,
+		Phases: &Phases{
+			Walk:  1500 * time.Millisecond,
+			Total: 3 * time.Second,
+		},
+		Analysis: &probe.Analysis{
+			Source: container,
+			Stats: []probe.FileStat{
+				{FileIndex: 1, FreshData: 300, Algo: pwr.SyncHeader_RSYNC},
+				{FileIndex: 0, FreshData: 50, Algo: pwr.SyncHeader_RSYNC},
+				{FileIndex: 2, FreshData: 0, Algo: pwr.SyncHeader_RSYNC},
+			},
+		},
+	}
+
+	r := newReport("leafo/x-moon:win-64", res, nil, 5)
+	assert.EqualValues(t, 12, r.BuildID)
+	assert.EqualValues(t, 11, r.ParentID)
+	assert.EqualValues(t, 3, r.Container.Files)
+	assert.EqualValues(t, 1.5, r.Phases["walk"])
+	assert.EqualValues(t, 3.0, r.Phases["total"])
+	_, hasFinalize := r.Phases["finalize"]
+	assert.False(t, hasFinalize)
+
+	assert.Len(t, r.TopFiles, 2, "unchanged files shouldn't be listed")
+	assert.EqualValues(t, "data.pak", r.TopFiles[0].Path)
+	assert.EqualValues(t, 300, r.TopFiles[0].FreshData)
+
+	r = newReport("leafo/x-moon:win-64", res, nil, 1)
+	assert.Len(t, r.TopFiles, 1)
+
+	r = newReport("leafo/x-moon:win-64", nil, errors.New("no such game"), 5)
+	assert.EqualValues(t, "no such game", r.Error)
+	assert.Nil(t, r.Container)
+}
@@ -0,0 +1,270 @@
+package push
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/itchio/httpkit/retrycontext"
+	"github.com/itchio/httpkit/timeout"
+	"github.com/itchio/httpkit/uploader"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+const (
+	// must be a multiple of 256KiB, see https://cloud.google.com/storage/docs/json_api/v1/how-tos/resumable-upload
+	resumeChunkSize int64 = 16 * 1024 * 1024
+
+	resumeMaxRetries = 15
+)
+
+var errUploadSessionGone = errors.New("upload session expired or not found")
+
+// queryUploadStatus asks storage how much of a resumable upload
+// session was committed. complete is true if the upload was finalized.
+func queryUploadStatus(client *http.Client, uploadURL string) (committed int64, complete bool, err error) {
+	req, err := http.NewRequest("PUT", uploadURL, nil)
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	req.ContentLength = 0
+	req.Header.Set("content-range", "bytes */*")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return 0, false, errors.WithStack(err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 200, 201:
+		return 0, true, nil
+	case 308:
+		committed, err = parseCommittedRange(res.Header.Get("Range"))
+		return committed, false, err
+	case 404, 410:
+		return 0, false, errUploadSessionGone
+	}
+	return 0, false, errors.Errorf("while querying upload status, got HTTP %s", res.Status)
+}
+
+// parseCommittedRange turns a `bytes=0-N` header into the number
+// of bytes committed (N+1). A missing header means nothing was committed.
+func parseCommittedRange(rangeHeader string) (int64, error) {
+	if rangeHeader == "" {
+		return 0, nil
+	}
+
+	tokens := strings.SplitN(strings.TrimPrefix(rangeHeader, "bytes="), "-", 2)
+	if len(tokens) != 2 || tokens[0] != "0" {
+		return 0, errors.Errorf("invalid range header %q", rangeHeader)
+	}
+
+	end, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid range header %q", rangeHeader)
+	}
+	return end + 1, nil
+}
+
+// offsetUpload continues a resumable upload session that already
+// has some bytes committed. The first `committed` bytes written
+// to it are discarded, since storage already has them.
+// Unlike uploader.NewResumableUpload, chunks are sent synchronously.
+type offsetUpload struct {
+	uploadURL  string
+	httpClient *http.Client
+
+	consumer         *state.Consumer
+	progressListener uploader.ProgressListenerFunc
+
+	committed int64
+	written   int64
+	offset    int64
+	buf       bytes.Buffer
+}
+
+var _ uploader.ResumableUpload = (*offsetUpload)(nil)
+
+func newOffsetUpload(uploadURL string, committed int64) *offsetUpload {
+	return &offsetUpload{
+		uploadURL:  uploadURL,
+		httpClient: timeout.NewDefaultClient(),
+		committed:  committed,
+		offset:     committed,
+	}
+}
+
+func (ou *offsetUpload) SetConsumer(consumer *state.Consumer) {
+	ou.consumer = consumer
+}
+
+func (ou *offsetUpload) SetProgressListener(progressListener uploader.ProgressListenerFunc) {
+	ou.progressListener = progressListener
+}
+
+func (ou *offsetUpload) Write(p []byte) (int, error) {
+	n := len(p)
+
+	if ou.written < ou.committed {
+		skip := ou.committed - ou.written
+		if skip > int64(len(p)) {
+			skip = int64(len(p))
+		}
+		p = p[skip:]
+		ou.written += skip
+	}
+	ou.written += int64(len(p))
+	ou.buf.Write(p)
+
+	for int64(ou.buf.Len()) >= resumeChunkSize {
+		err := ou.put(ou.buf.Next(int(resumeChunkSize)), false)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (ou *offsetUpload) Close() error {
+	if ou.written < ou.committed {
+		return errors.Errorf("resumed upload is shorter (%d bytes) than what was already committed (%d bytes)", ou.written, ou.committed)
+	}
+	return ou.put(ou.buf.Bytes(), true)
+}
+
+func (ou *offsetUpload) put(data []byte, last bool) error {
+	retryCtx := retrycontext.New(retrycontext.Settings{
+		MaxTries: resumeMaxRetries,
+		Consumer: ou.consumer,
+	})
+
+	for retryCtx.ShouldTry() {
+		committed, err := ou.tryPut(data, last)
+		if err != nil {
+			retryCtx.Retry(err)
+			if committed > ou.offset {
+				data = data[committed-ou.offset:]
+				ou.offset = committed
+			}
+			continue
+		}
+
+		ou.offset += int64(len(data))
+		if ou.progressListener != nil {
+			ou.progressListener(ou.offset)
+		}
+		return nil
+	}
+
+	return errors.Errorf("Too many errors, stopping upload")
+}
+
+func (ou *offsetUpload) tryPut(data []byte, last bool) (int64, error) {
+	size := int64(len(data))
+
+	req, err := http.NewRequest("PUT", ou.uploadURL, bytes.NewReader(data))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	req.ContentLength = size
+
+	total := "*"
+	if last {
+		total = fmt.Sprintf("%d", ou.offset+size)
+	}
+	if size == 0 {
+		req.Header.Set("content-range", fmt.Sprintf("bytes */%s", total))
+	} else {
+		req.Header.Set("content-range", fmt.Sprintf("bytes %d-%d/%s", ou.offset, ou.offset+size-1, total))
+	}
+
+	res, err := ou.httpClient.Do(req)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case 200, 201:
+		return ou.offset + size, nil
+	case 308:
+		committed, err := parseCommittedRange(res.Header.Get("Range"))
+		if err != nil {
+			return 0, err
+		}
+		if committed == ou.offset+size && !last {
+			return committed, nil
+		}
+		return committed, errors.Errorf("only %d out of %d bytes committed", committed-ou.offset, size)
+	case 404, 410:
+		return 0, errUploadSessionGone
+	}
+	return 0, errors.Errorf("got HTTP %s", res.Status)
+}
+
+// checkpointWriter hashes everything written through it, so we can
+// record checkpoints while pushing. When resuming, it checks that
+// the regenerated data matches the previous checkpoint.
+type checkpointWriter struct {
+	w io.Writer
+
+	mu     sync.Mutex
+	h      hash.Hash
+	offset int64
+
+	expected *checkpoint
+}
+
+func newCheckpointWriter(w io.Writer, expected *checkpoint) *checkpointWriter {
+	return &checkpointWriter{
+		w:        w,
+		h:        sha256.New(),
+		expected: expected,
+	}
+}
+
+func (cw *checkpointWriter) Write(p []byte) (int, error) {
+	cw.mu.Lock()
+	if cw.expected != nil && cw.offset+int64(len(p)) >= cw.expected.Offset {
+		split := cw.expected.Offset - cw.offset
+		cw.h.Write(p[:split])
+		cw.offset += split
+		if hex.EncodeToString(cw.h.Sum(nil)) != cw.expected.SHA256 {
+			cw.mu.Unlock()
+			return 0, errors.New("the build being pushed differs from the interrupted push, use --no-resume to start over")
+		}
+		cw.expected = nil
+		cw.h.Write(p[split:])
+		cw.offset += int64(len(p)) - split
+	} else {
+		cw.h.Write(p)
+		cw.offset += int64(len(p))
+	}
+	cw.mu.Unlock()
+
+	return cw.w.Write(p)
+}
+
+func (cw *checkpointWriter) checkpoint() *checkpoint {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+
+	if cw.expected != nil {
+		// still catching up with the previous checkpoint
+		return cw.expected
+	}
+
+	return &checkpoint{
+		Offset: cw.offset,
+		SHA256: hex.EncodeToString(cw.h.Sum(nil)),
+	}
+}
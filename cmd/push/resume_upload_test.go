@@ -0,0 +1,100 @@
+package push
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStorage mimics the subset of the GCS resumable upload protocol we use
+type fakeStorage struct {
+	mu       sync.Mutex
+	data     []byte
+	complete bool
+}
+
+func (fs *fakeStorage) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	contentRange := strings.TrimPrefix(r.Header.Get("content-range"), "bytes ")
+	tokens := strings.SplitN(contentRange, "/", 2)
+
+	if tokens[0] != "*" {
+		startEnd := strings.SplitN(tokens[0], "-", 2)
+		start, _ := strconv.ParseInt(startEnd[0], 10, 64)
+		if start != int64(len(fs.data)) {
+			w.WriteHeader(400)
+			return
+		}
+		fs.data = append(fs.data, body...)
+	}
+
+	if tokens[1] != "*" {
+		fs.complete = true
+		w.WriteHeader(200)
+		return
+	}
+
+	if len(fs.data) > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(fs.data)-1))
+	}
+	w.WriteHeader(308)
+}
+
+func TestOffsetUpload(t *testing.T) {
+	payload := bytes.Repeat([]byte("butler"), 1024*1024)
+	committed := int64(256 * 1024)
+
+	fs := &fakeStorage{data: append([]byte{}, payload[:committed]...)}
+	server := httptest.NewServer(fs)
+	defer server.Close()
+
+	gotCommitted, complete, err := queryUploadStatus(http.DefaultClient, server.URL)
+	wtest.Must(t, err)
+	assert.False(t, complete)
+	assert.EqualValues(t, committed, gotCommitted)
+
+	ou := newOffsetUpload(server.URL, gotCommitted)
+	cw := newCheckpointWriter(ou, nil)
+	for i := 0; i < len(payload); i += 1000 {
+		end := i + 1000
+		if end > len(payload) {
+			end = len(payload)
+		}
+		_, err := cw.Write(payload[i:end])
+		wtest.Must(t, err)
+	}
+	wtest.Must(t, ou.Close())
+
+	assert.True(t, fs.complete)
+	assert.EqualValues(t, payload, fs.data)
+	assert.EqualValues(t, len(payload), cw.checkpoint().Offset)
+}
+
+func TestCheckpointWriterMismatch(t *testing.T) {
+	cw := newCheckpointWriter(ioutil.Discard, nil)
+	_, err := cw.Write([]byte("hello world"))
+	wtest.Must(t, err)
+	cp := cw.checkpoint()
+
+	same := newCheckpointWriter(ioutil.Discard, cp)
+	_, err = same.Write([]byte("hello "))
+	wtest.Must(t, err)
+	_, err = same.Write([]byte("world, and more"))
+	wtest.Must(t, err)
+
+	different := newCheckpointWriter(ioutil.Discard, cp)
+	_, err = different.Write([]byte("hello there, world"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+package push
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// sessionMaxAge is how long an interrupted push can be resumed for.
+// Resumable upload sessions expire after a week, but parent builds
+// tend to change much sooner than that.
+const sessionMaxAge = 24 * time.Hour
+
+// A session records everything needed to continue an interrupted
+// push: the remote build, its build files, and how far along we
+// got writing the patch and signature.
+type session struct {
+	Src         string    `json:"src"`
+	Target      string    `json:"target"`
+	UserVersion string    `json:"userVersion"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Compression string    `json:"compression"`
+
+	// Fingerprint identifies the source container (paths, sizes and modes)
+	// so we don't resume onto a different set of files
+	Fingerprint string `json:"fingerprint"`
+
+	BuildID  int64 `json:"buildId"`
+	ParentID int64 `json:"parentId"`
+
+	Patch     *sessionFile `json:"patch"`
+	Signature *sessionFile `json:"signature"`
+
+	path string
+	mu   sync.Mutex
+}
+
+type sessionFile struct {
+	FileID    int64  `json:"fileId"`
+	UploadURL string `json:"uploadUrl"`
+
+	// Checkpoint is the amount of data we had generated at some point,
+	// along with its hash. Since diffing is deterministic, when resuming,
+	// we regenerate the file and check it still hashes the same.
+	Checkpoint *checkpoint `json:"checkpoint,omitempty"`
+
+	// Size is set once the upload is complete
+	Size int64 `json:"size,omitempty"`
+
+	// committed is how much storage already has, when resuming
+	committed int64
+}
+
+type checkpoint struct {
+	Offset int64  `json:"offset"`
+	SHA256 string `json:"sha256"`
+}
+
+func sessionPath(sessionDir string, src string, target string) (string, error) {
+	absSrc, err := filepath.Abs(src)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s", absSrc, target)
+	return filepath.Join(sessionDir, fmt.Sprintf("%x.json", h.Sum(nil)[:16])), nil
+}
+
+// loadSession returns a previously-saved session, or nil if there's none
+// or it's too old to be resumed.
+func loadSession(sessionPath string) (*session, error) {
+	buf, err := ioutil.ReadFile(sessionPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	s := &session{}
+	err = json.Unmarshal(buf, s)
+	if err != nil {
+		// corrupted session file, just ignore it
+		return nil, nil
+	}
+	s.path = sessionPath
+
+	if s.Patch == nil || s.Signature == nil || time.Since(s.CreatedAt) > sessionMaxAge {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// resumableSession checks whether a previous session can be continued,
+// and returns nil if it can't.
+func resumableSession(httpClient *http.Client, s *session, userVersion string, compression *pwr.CompressionSettings, container *tlc.Container) *session {
+	if s.UserVersion != userVersion {
+		comm.Logf("Not resuming previous push: it was for userversion (%s)", s.UserVersion)
+		return nil
+	}
+
+	if s.Compression != compression.ToString() {
+		comm.Logf("Not resuming previous push: it used different compression settings (%s)", s.Compression)
+		return nil
+	}
+
+	if s.Fingerprint != containerFingerprint(container) {
+		comm.Logf("Not resuming previous push: files have changed since")
+		return nil
+	}
+
+	if s.Patch.Size > 0 && s.Signature.Size > 0 {
+		// all uploaded, just needs finalizing
+		return s
+	}
+
+	for _, sf := range []*sessionFile{s.Patch, s.Signature} {
+		committed, complete, err := queryUploadStatus(httpClient, sf.UploadURL)
+		if err != nil {
+			comm.Logf("Not resuming previous push: %s", err.Error())
+			return nil
+		}
+
+		if complete {
+			// we don't know how large it was, can't finalize it
+			comm.Logf("Not resuming previous push: upload was completed but not recorded")
+			return nil
+		}
+
+		if committed > 0 && (sf.Checkpoint == nil || sf.Checkpoint.Offset < committed) {
+			// we wouldn't be able to check the data we regenerate matches
+			comm.Logf("Not resuming previous push: no usable checkpoint")
+			return nil
+		}
+
+		sf.committed = committed
+	}
+
+	return s
+}
+
+func (s *session) update(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f()
+}
+
+func (s *session) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buf, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write + rename, so we never leave a half-written session behind
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, buf, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpPath, s.path))
+}
+
+func (s *session) remove() error {
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func containerFingerprint(container *tlc.Container) string {
+	h := sha256.New()
+	for _, d := range container.Dirs {
+		fmt.Fprintf(h, "d %s %o\n", d.Path, d.Mode)
+	}
+	for _, f := range container.Files {
+		fmt.Fprintf(h, "f %s %o %d\n", f.Path, f.Mode, f.Size)
+	}
+	for _, s := range container.Symlinks {
+		fmt.Fprintf(h, "s %s %o %s\n", s.Path, s.Mode, s.Dest)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
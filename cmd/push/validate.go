@@ -0,0 +1,95 @@
+package push
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/itchio/butler/cmd/validate"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/ox"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// channelPlatforms returns the platforms itch.io will tag a channel
+// with, based on its name (see docs/pushing.md#channel-names)
+func channelPlatforms(channel string) []ox.Platform {
+	name := strings.ToLower(channel)
+
+	var platforms []ox.Platform
+	if strings.Contains(name, "win") {
+		platforms = append(platforms, ox.PlatformWindows)
+	}
+	if strings.Contains(name, "linux") {
+		platforms = append(platforms, ox.PlatformLinux)
+	}
+	if strings.Contains(name, "osx") || strings.Contains(name, "mac") {
+		platforms = append(platforms, ox.PlatformOSX)
+	}
+	return platforms
+}
+
+// validateBuild runs the same checks as `butler validate` for every
+// platform the channel is for, on the files filter lets through. Its output
+// is only shown in verbose mode, the problems found are returned instead.
+func validateBuild(src string, channel string, filter tlc.FilterFunc) (*validate.Result, error) {
+	stats, err := os.Stat(src)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	res := &validate.Result{}
+	if !stats.IsDir() {
+		res.Warnings = append(res.Warnings, "Only folders can be validated, skipped validation")
+		return res, nil
+	}
+
+	platforms := channelPlatforms(channel)
+	if len(platforms) == 0 {
+		comm.Logf("Channel (%s) isn't tagged with any platform, validating for the current one", channel)
+		platforms = []ox.Platform{""}
+	}
+
+	consumer := &state.Consumer{
+		OnMessage: func(level string, msg string) {
+			comm.Debugf("%s", msg)
+		},
+	}
+
+	seen := make(map[string]bool)
+	add := func(list *[]string, platform ox.Platform, msg string) {
+		if seen[msg] {
+			return
+		}
+		seen[msg] = true
+
+		if len(platforms) > 1 {
+			msg = fmt.Sprintf("(%s) %s", platform, msg)
+		}
+		*list = append(*list, msg)
+	}
+
+	for _, platform := range platforms {
+		platformRes, err := validate.Validate(consumer, &validate.Params{
+			Dir:      src,
+			Platform: platform,
+			Filter:   filter,
+			// pushing (let alone a dry run) shouldn't touch the source folder
+			NoFixPermissions: true,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "validating build")
+		}
+
+		for _, msg := range platformRes.Errors {
+			add(&res.Errors, platform, msg)
+		}
+		for _, msg := range platformRes.Warnings {
+			add(&res.Warnings, platform, msg)
+		}
+	}
+
+	return res, nil
+}
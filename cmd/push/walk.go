@@ -1,38 +1,54 @@
 package push
 
 import (
-	"github.com/itchio/wharf/pools"
+	"time"
+
+	"github.com/itchio/butler/buildsource"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/filtering"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wsync"
 	"github.com/pkg/errors"
 )
 
 type walkResult struct {
-	container *tlc.Container
-	pool      wsync.Pool
+	source     *buildsource.Source
+	container  *tlc.Container
+	pool       wsync.Pool
+	exclusions []*filtering.Exclusion
+	duration   time.Duration
 }
 
 func doWalk(path string, out chan walkResult, errs chan error, fixPerms bool, walkOpts *tlc.WalkOpts) {
-	container, err := tlc.WalkAny(path, walkOpts)
+	startTime := time.Now()
+
+	source, err := buildsource.Open(path, &buildsource.Params{
+		WalkOpts: walkOpts,
+		Consumer: comm.NewStateConsumer(),
+	})
 	if err != nil {
 		errs <- errors.WithStack(err)
 		return
 	}
 
-	pool, err := pools.New(container, path)
+	pool, err := source.NewPool()
 	if err != nil {
+		source.Close()
 		errs <- errors.WithStack(err)
 		return
 	}
 
 	result := walkResult{
-		container: container,
-		pool:      pool,
+		source:     source,
+		container:  source.Container,
+		pool:       pool,
+		exclusions: source.Exclusions,
 	}
 
 	if fixPerms {
 		err := result.container.FixPermissions(result.pool)
 		if err != nil {
+			source.Close()
 			errs <- errors.WithStack(err)
 			return
 		}
@@ -46,5 +62,6 @@ func doWalk(path string, out chan walkResult, errs chan error, fixPerms bool, wa
 		}
 	}
 
+	result.duration = time.Since(startTime)
 	out <- result
 }
@@ -0,0 +1,111 @@
+package sigdiff
+
+import (
+	"bytes"
+
+	"github.com/itchio/butler/containerdiff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+)
+
+// Result lists the differences between two signatures
+type Result struct {
+	*containerdiff.Changes
+
+	// AddedBytes is the total size of added files
+	AddedBytes int64 `json:"addedBytes"`
+	// RemovedBytes is the total size of removed files
+	RemovedBytes int64 `json:"removedBytes"`
+	// MinChangedBytes is a lower bound on how many bytes differ between
+	// the builds: the size of added files, plus the minimum number of
+	// changed bytes in modified files.
+	MinChangedBytes int64 `json:"minChangedBytes"`
+}
+
+// Compare lists what changed between the builds two signatures describe
+func Compare(oldSig *pwr.SignatureInfo, newSig *pwr.SignatureInfo) *Result {
+	oldHashes := containerdiff.FileHashes(oldSig)
+	newHashes := containerdiff.FileHashes(newSig)
+
+	res := &Result{}
+	res.Changes = containerdiff.Compare(oldSig.Container, newSig.Container, func(oldFile *tlc.File, newFile *tlc.File, c *containerdiff.Change) bool {
+		min, max := changedBytes(oldFile.Size, oldHashes[oldFile.Path], newFile.Size, newHashes[newFile.Path])
+		if max == 0 {
+			return false
+		}
+		c.OldSize = oldFile.Size
+		c.MinChangedBytes = min
+		c.MaxChangedBytes = max
+		return true
+	})
+
+	for _, c := range res.Added {
+		if c.Kind == "file" {
+			res.AddedBytes += c.Size
+		}
+	}
+	for _, c := range res.Removed {
+		if c.Kind == "file" {
+			res.RemovedBytes += c.Size
+		}
+	}
+
+	res.MinChangedBytes = res.AddedBytes
+	for _, c := range res.Modified {
+		res.MinChangedBytes += c.MinChangedBytes
+	}
+
+	return res
+}
+
+// changedBytes bounds how many bytes differ between two versions of a
+// file: bytes at the same offset that differ, plus the size difference.
+//
+// Blocks at the same offset and of the same length whose hashes differ
+// contain at least one changed byte, and blocks that can't be compared
+// may have changed entirely.
+func changedBytes(oldSize int64, oldHashes []wsync.BlockHash, newSize int64, newHashes []wsync.BlockHash) (min int64, max int64) {
+	common := oldSize
+	if newSize < common {
+		common = newSize
+	}
+	sizeDiff := newSize - oldSize
+	if sizeDiff < 0 {
+		sizeDiff = -sizeDiff
+	}
+	min = sizeDiff
+	max = sizeDiff
+
+	blockLen := func(size int64, i int64) int64 {
+		l := size - i*pwr.BlockSize
+		if l > pwr.BlockSize {
+			l = pwr.BlockSize
+		}
+		return l
+	}
+
+	for i := int64(0); i*pwr.BlockSize < common; i++ {
+		oldLen := blockLen(oldSize, i)
+		newLen := blockLen(newSize, i)
+		commonLen := oldLen
+		if newLen < commonLen {
+			commonLen = newLen
+		}
+
+		if oldLen != newLen || i >= int64(len(oldHashes)) || i >= int64(len(newHashes)) {
+			// can't tell, the whole block may have changed
+			max += commonLen
+			continue
+		}
+
+		oh := oldHashes[i]
+		nh := newHashes[i]
+		if oh.WeakHash != nh.WeakHash || !bytes.Equal(oh.StrongHash, nh.StrongHash) {
+			min++
+			max += commonLen
+		}
+	}
+
+	return min, max
+}
@@ -0,0 +1,96 @@
+package sigdiff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/sign"
+	"github.com/itchio/butler/containerdiff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, contents, 0644))
+	}
+}
+
+func TestSigdiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigdiff")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	big := bytes.Repeat([]byte{0x42}, int(pwr.BlockSize*3))
+	bigModified := append([]byte{}, big...)
+	bigModified[pwr.BlockSize+10] = 0x43
+
+	v1 := filepath.Join(dir, "v1")
+	v2 := filepath.Join(dir, "v2")
+	writeFiles(t, v1, map[string][]byte{
+		"game.dat":    big,
+		"readme.txt":  []byte("hello"),
+		"old/gone.sh": []byte("#!/bin/sh"),
+		"same.txt":    []byte("unchanged"),
+	})
+	writeFiles(t, v2, map[string][]byte{
+		"game.dat":    bigModified,
+		"readme.txt":  []byte("hello world"),
+		"new/here.sh": []byte("#!/bin/sh\necho hi"),
+		"same.txt":    []byte("unchanged"),
+	})
+	wtest.Must(t, os.Chmod(filepath.Join(v2, "same.txt"), 0755))
+	wtest.Must(t, os.Symlink("readme.txt", filepath.Join(v2, "README")))
+
+	compression := pwr.CompressionSettings{
+		Algorithm: pwr.CompressionAlgorithm_ZSTD,
+		Quality:   1,
+	}
+	oldSig := filepath.Join(dir, "v1.pws")
+	newSig := filepath.Join(dir, "v2.pws")
+	wtest.Must(t, sign.Do(&sign.Params{Dir: v1, Signature: oldSig, Compression: compression}))
+	wtest.Must(t, sign.Do(&sign.Params{Dir: v2, Signature: newSig, Compression: compression}))
+
+	res, err := Do(&Params{Old: oldSig, New: newSig})
+	wtest.Must(t, err)
+
+	paths := func(changes []*containerdiff.Change) []string {
+		var res []string
+		for _, c := range changes {
+			res = append(res, c.Path)
+		}
+		return res
+	}
+	assert.EqualValues(t, []string{"new", "README", "new/here.sh"}, paths(res.Added))
+	assert.EqualValues(t, []string{"old", "old/gone.sh"}, paths(res.Removed))
+	assert.EqualValues(t, []string{"game.dat", "readme.txt"}, paths(res.Modified))
+	assert.EqualValues(t, []string{"same.txt"}, paths(res.PermissionsChanged))
+
+	game := res.Modified[0]
+	assert.EqualValues(t, 1, game.MinChangedBytes)
+	assert.EqualValues(t, pwr.BlockSize, game.MaxChangedBytes, "only one block changed")
+
+	readme := res.Modified[1]
+	assert.EqualValues(t, 6, readme.MinChangedBytes)
+	assert.EqualValues(t, 11, readme.MaxChangedBytes)
+
+	assert.EqualValues(t, 17, res.AddedBytes)
+	assert.EqualValues(t, 9, res.RemovedBytes)
+	assert.EqualValues(t, 17+1+6, res.MinChangedBytes)
+
+	res, err = Do(&Params{Old: oldSig, New: oldSig})
+	wtest.Must(t, err)
+	assert.Empty(t, res.Added)
+	assert.Empty(t, res.Removed)
+	assert.Empty(t, res.Modified)
+	assert.Empty(t, res.PermissionsChanged)
+}
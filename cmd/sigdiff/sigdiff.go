@@ -0,0 +1,89 @@
+package sigdiff
+
+import (
+	"context"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/containerdiff"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pwr"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	old *string
+	new *string
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("sigdiff", "Compare two signature files, without the directories they were made from. Use the global --json flag for machine-readable output.")
+	args.old = cmd.Arg("old", "Signature of the old build (.pws)").Required().String()
+	args.new = cmd.Arg("new", "Signature of the new build (.pws)").Required().String()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	res, err := Do(&Params{
+		Old: *args.old,
+		New: *args.new,
+	})
+	ctx.Must(err)
+
+	comm.ResultOrPrint(res, func() {
+		printResult(res)
+	})
+}
+
+// Params are the two signatures to compare
+type Params struct {
+	Old string
+	New string
+}
+
+// Do reads two signatures and compares them
+func Do(params *Params) (*Result, error) {
+	oldSig, err := readSignature(params.Old)
+	if err != nil {
+		return nil, errors.WithMessage(err, "reading old signature")
+	}
+
+	newSig, err := readSignature(params.New)
+	if err != nil {
+		return nil, errors.WithMessage(err, "reading new signature")
+	}
+
+	return Compare(oldSig, newSig), nil
+}
+
+func readSignature(path string) (*pwr.SignatureInfo, error) {
+	reader, err := eos.Open(path, option.WithConsumer(comm.NewStateConsumer()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	source := seeksource.FromFile(reader)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sig, err := pwr.ReadSignature(context.Background(), source)
+	if err != nil {
+		return nil, errors.Wrap(err, eos.Redact(path))
+	}
+	return sig, nil
+}
+
+func printResult(res *Result) {
+	if !containerdiff.Print(res.Changes) {
+		comm.Statf("Signatures describe identical builds")
+		return
+	}
+	comm.Statf("At least %s changed (%s in added files, %s in modified files)",
+		progress.FormatBytes(res.MinChangedBytes), progress.FormatBytes(res.AddedBytes), progress.FormatBytes(res.MinChangedBytes-res.AddedBytes))
+}
@@ -2,14 +2,18 @@ package sign
 
 import (
 	"context"
+	"crypto/ed25519"
+	"io"
 	"os"
 	"time"
 
+	"github.com/itchio/butler/buildsource"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/filtering"
+	"github.com/itchio/butler/hashpool"
 	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/seal"
 	"github.com/itchio/httpkit/progress"
-	"github.com/itchio/wharf/pools"
 	"github.com/itchio/wharf/pwr"
 	"github.com/itchio/wharf/tlc"
 	"github.com/itchio/wharf/wire"
@@ -18,38 +22,82 @@ import (
 )
 
 var args = struct {
-	output    *string
-	signature *string
-	fixPerms  *bool
+	output      *string
+	signature   *string
+	fixPerms    *bool
+	concurrency *int
+	key         *string
 }{}
 
 func Register(ctx *mansion.Context) {
 	cmd := ctx.App.Command("sign", "(Advanced) Generate a signature file for a given directory. Useful for integrity checks and remote diff generation.")
-	args.output = cmd.Arg("dir", "Path of directory to sign").Required().String()
+	args.output = cmd.Arg("dir", "Path of directory (or archive) to sign").Required().String()
 	args.signature = cmd.Arg("signature", "Path to write signature to").Required().String()
 	args.fixPerms = cmd.Flag("fix-permissions", "Detect Mac & Linux executables and adjust their permissions automatically").Default("true").Bool()
+	args.concurrency = cmd.Flag("concurrency", "Number of workers hashing files (negative for numbers of CPUs - j)").Default("-1").Int()
+	args.key = cmd.Flag("key", "Seal the signature with this private key, as generated by `butler keygen`").String()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
-	ctx.Must(Do(*args.output, *args.signature, ctx.CompressionSettings(), *args.fixPerms))
+	var key ed25519.PrivateKey
+	if *args.key != "" {
+		var err error
+		key, err = seal.LoadPrivateKey(*args.key)
+		ctx.Must(err)
+	}
+
+	ctx.Must(Do(&Params{
+		Dir:         *args.output,
+		Signature:   *args.signature,
+		Compression: ctx.CompressionSettings(),
+		FixPerms:    *args.fixPerms,
+		Concurrency: *args.concurrency,
+		Key:         key,
+	}))
 }
 
-func Do(output string, signature string, compression pwr.CompressionSettings, fixPerms bool) error {
+// Params controls how a signature is generated
+type Params struct {
+	// Dir is the directory (or archive) to sign
+	Dir string
+	// Signature is where to write the signature
+	Signature   string
+	Compression pwr.CompressionSettings
+	FixPerms    bool
+
+	// Concurrency is the number of workers hashing files, see hashpool.NumWorkers.
+	// The signature is the same whatever the number of workers.
+	Concurrency int
+
+	// Key, if set, is used to seal the signature, see package seal
+	Key ed25519.PrivateKey
+}
+
+func Do(params *Params) error {
+	output := params.Dir
+	signature := params.Signature
+	compression := params.Compression
+
 	comm.Opf("Creating signature for %s", output)
 	startTime := time.Now()
 
-	container, err := tlc.WalkAny(output, &tlc.WalkOpts{Filter: filtering.FilterPaths})
+	source, err := buildsource.Open(output, &buildsource.Params{
+		WalkOpts: &tlc.WalkOpts{Filter: filtering.FilterPaths},
+		Consumer: comm.NewStateConsumer(),
+	})
 	if err != nil {
 		return errors.Wrap(err, "walking directory to sign")
 	}
+	defer source.Close()
+	container := source.Container
 
-	pool, err := pools.New(container, output)
+	pool, err := source.NewPool()
 	if err != nil {
 		return errors.Wrap(err, "creating pool for directory to sign")
 	}
 
-	if fixPerms {
+	if params.FixPerms {
 		container.FixPermissions(pool)
 	}
 
@@ -59,7 +107,14 @@ func Do(output string, signature string, compression pwr.CompressionSettings, fi
 	}
 	defer signatureWriter.Close()
 
-	rawSigWire := wire.NewWriteContext(signatureWriter)
+	var sealWriter *seal.Writer
+	var out io.Writer = signatureWriter
+	if params.Key != nil {
+		sealWriter = seal.NewWriter(signatureWriter)
+		out = sealWriter
+	}
+
+	rawSigWire := wire.NewWriteContext(out)
 	rawSigWire.WriteMagic(pwr.SignatureMagic)
 
 	rawSigWire.WriteMessage(&pwr.SignatureHeader{
@@ -72,13 +127,41 @@ func Do(output string, signature string, compression pwr.CompressionSettings, fi
 	}
 	sigWire.WriteMessage(container)
 
-	comm.StartProgress()
-	err = pwr.ComputeSignatureToWriter(context.Background(), container, pool, comm.NewStateConsumer(), func(hash wsync.BlockHash) error {
+	writeHash := func(hash wsync.BlockHash) error {
 		return sigWire.WriteMessage(&pwr.BlockHash{
 			WeakHash:   hash.WeakHash,
 			StrongHash: hash.StrongHash,
 		})
-	})
+	}
+
+	comm.StartProgress()
+	if source.Streamed {
+		// files of a streamed archive can only be read in order
+		err = pwr.ComputeSignatureToWriter(context.Background(), container, pool, comm.NewStateConsumer(), writeHash)
+	} else {
+		err = pool.Close()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = hashpool.Hash(context.Background(), &hashpool.Params{
+			Container:   container,
+			NewPool:     source.NewPool,
+			Concurrency: params.Concurrency,
+			Chunked:     source.Seekable(),
+			Consumer:    comm.NewStateConsumer(),
+		}, func(c *hashpool.Chunk) error {
+			if c.Short() || c.TooLarge {
+				return errors.Errorf("%s changed while it was being signed", container.Files[c.FileIndex].Path)
+			}
+			for _, hash := range c.Hashes {
+				err := writeHash(hash)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
 	comm.EndProgress()
 	if err != nil {
 		return errors.Wrap(err, "computing signature")
@@ -89,6 +172,14 @@ func Do(output string, signature string, compression pwr.CompressionSettings, fi
 		return errors.Wrap(err, "finalizing signature file")
 	}
 
+	if sealWriter != nil {
+		err = sealWriter.Seal(params.Key)
+		if err != nil {
+			return errors.Wrap(err, "sealing signature file")
+		}
+		comm.Logf("Sealed with key %s", seal.Fingerprint(params.Key.Public().(ed25519.PublicKey)))
+	}
+
 	prettySize := progress.FormatBytes(container.Size)
 	perSecond := progress.FormatBPS(container.Size, time.Since(startTime))
 	comm.Statf("%s (%s) @ %s/s\n", prettySize, container.Stats(), perSecond)
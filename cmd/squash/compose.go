@@ -0,0 +1,299 @@
+package squash
+
+import (
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/pkg/errors"
+)
+
+// A squasher keeps track of the contents of every file of a build,
+// in terms of the old build and of fresh data, as patches are applied.
+type squasher struct {
+	staging *staging
+
+	// oldContainer is the build the first patch applies to
+	oldContainer *tlc.Container
+	// container is the build we get after applying all patches so far
+	container *tlc.Container
+	layouts   []*layout
+}
+
+func newSquasher(oldContainer *tlc.Container, st *staging) *squasher {
+	sq := &squasher{
+		staging:      st,
+		oldContainer: oldContainer,
+		container:    oldContainer,
+	}
+	for i, f := range oldContainer.Files {
+		l := &layout{}
+		l.append(segment{file: int64(i), offset: 0, length: f.Size, diff: -1})
+		sq.layouts = append(sq.layouts, l)
+	}
+	return sq
+}
+
+// apply composes a patch on top of the current layouts, reading it
+// from start to finish.
+func (sq *squasher) apply(patchPath string) error {
+	reader, err := eos.Open(patchPath, option.WithConsumer(comm.NewStateConsumer()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	source := seeksource.FromFile(reader)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rawWire := wire.NewReadContext(source)
+	err = rawWire.ExpectMagic(pwr.PatchMagic)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	header := &pwr.PatchHeader{}
+	err = rawWire.ReadMessage(header)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rctx, err := pwr.DecompressWire(rawWire, header.Compression)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	targetContainer := &tlc.Container{}
+	err = rctx.ReadMessage(targetContainer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sourceContainer := &tlc.Container{}
+	err = rctx.ReadMessage(sourceContainer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = sameFiles(sq.container, targetContainer)
+	if err != nil {
+		return errors.WithMessage(err, "patch doesn't apply to the previous build")
+	}
+
+	var layouts []*layout
+	sh := &pwr.SyncHeader{}
+	for i, f := range sourceContainer.Files {
+		sh.Reset()
+		err = rctx.ReadMessage(sh)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if sh.FileIndex != int64(i) {
+			return errors.Errorf("corrupted patch: expected file %d, got file %d", i, sh.FileIndex)
+		}
+
+		var l *layout
+		switch sh.Type {
+		case pwr.SyncHeader_RSYNC:
+			l, err = sq.composeRsync(rctx)
+		case pwr.SyncHeader_BSDIFF:
+			l, err = sq.composeBsdiff(rctx)
+		default:
+			err = errors.Errorf("unknown patch series kind %d", sh.Type)
+		}
+		if err != nil {
+			return errors.WithMessage(err, f.Path)
+		}
+
+		if l.size != f.Size {
+			return errors.Errorf("corrupted patch: expected %s to be %d bytes, got %d", f.Path, f.Size, l.size)
+		}
+		layouts = append(layouts, l)
+	}
+
+	sq.container = sourceContainer
+	sq.layouts = layouts
+	return nil
+}
+
+// blockRange returns which bytes of a file a block range op covers,
+// just like wsync does when applying it.
+func blockRange(fileSize int64, blockIndex int64, blockSpan int64) (int64, int64) {
+	offset := blockIndex * pwr.BlockSize
+	length := blockSpan * pwr.BlockSize
+	if offset+length > fileSize {
+		length = fileSize - offset
+	}
+	return offset, length
+}
+
+func (sq *squasher) composeRsync(rctx *wire.ReadContext) (*layout, error) {
+	l := &layout{}
+	op := &pwr.SyncOp{}
+
+	for {
+		op.Reset()
+		err := rctx.ReadMessage(op)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		switch op.Type {
+		case pwr.SyncOp_HEY_YOU_DID_IT:
+			return l, nil
+		case pwr.SyncOp_BLOCK_RANGE:
+			if op.FileIndex < 0 || op.FileIndex >= int64(len(sq.layouts)) {
+				return nil, errors.Errorf("corrupted patch: block range refers to file %d", op.FileIndex)
+			}
+			old := sq.layouts[op.FileIndex]
+			offset, length := blockRange(old.size, op.BlockIndex, op.BlockSpan)
+			segments, err := old.slice(offset, length)
+			if err != nil {
+				return nil, errors.WithMessage(err, "corrupted patch")
+			}
+			for _, s := range segments {
+				l.append(s)
+			}
+		case pwr.SyncOp_DATA:
+			offset, err := sq.staging.write(op.Data)
+			if err != nil {
+				return nil, err
+			}
+			l.append(segment{file: -1, offset: offset, length: int64(len(op.Data)), diff: -1})
+		default:
+			return nil, errors.Errorf("unknown sync op type %s", op.Type)
+		}
+	}
+}
+
+func (sq *squasher) composeBsdiff(rctx *wire.ReadContext) (*layout, error) {
+	bh := &pwr.BsdiffHeader{}
+	err := rctx.ReadMessage(bh)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if bh.TargetIndex < 0 || bh.TargetIndex >= int64(len(sq.layouts)) {
+		return nil, errors.Errorf("corrupted patch: bsdiff refers to file %d", bh.TargetIndex)
+	}
+	old := sq.layouts[bh.TargetIndex]
+
+	l := &layout{}
+	oldOffset := int64(0)
+	ctrl := &bsdiff.Control{}
+	for {
+		ctrl.Reset()
+		err = rctx.ReadMessage(ctrl)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if ctrl.Eof {
+			break
+		}
+
+		if len(ctrl.Add) > 0 {
+			segments, err := old.slice(oldOffset, int64(len(ctrl.Add)))
+			if err != nil {
+				return nil, errors.WithMessage(err, "corrupted patch")
+			}
+
+			pos := int64(0)
+			for _, s := range segments {
+				s, err = sq.addTo(s, ctrl.Add[pos:pos+s.length])
+				if err != nil {
+					return nil, err
+				}
+				l.append(s)
+				pos += s.length
+			}
+			oldOffset += int64(len(ctrl.Add))
+		}
+
+		if len(ctrl.Copy) > 0 {
+			offset, err := sq.staging.write(ctrl.Copy)
+			if err != nil {
+				return nil, err
+			}
+			l.append(segment{file: -1, offset: offset, length: int64(len(ctrl.Copy)), diff: -1})
+		}
+
+		oldOffset += ctrl.Seek
+	}
+
+	// bsdiff series end with a sentinel sync op
+	op := &pwr.SyncOp{}
+	err = rctx.ReadMessage(op)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if op.Type != pwr.SyncOp_HEY_YOU_DID_IT {
+		return nil, errors.Errorf("corrupted patch: expected sentinel after bsdiff series, got %s", op.Type)
+	}
+	return l, nil
+}
+
+// addTo returns a segment whose bytes are those of s, plus add (bytewise)
+func (sq *squasher) addTo(s segment, add []byte) (segment, error) {
+	if isZero(add) {
+		return s, nil
+	}
+
+	var base []byte
+	var err error
+	switch {
+	case s.isFresh():
+		base, err = sq.staging.read(s.offset, s.length)
+	case s.diff >= 0:
+		base, err = sq.staging.read(s.diff, s.length)
+	}
+	if err != nil {
+		return s, err
+	}
+
+	sum := make([]byte, len(add))
+	copy(sum, add)
+	for i := range base {
+		sum[i] += base[i]
+	}
+
+	offset, err := sq.staging.write(sum)
+	if err != nil {
+		return s, err
+	}
+
+	if s.isFresh() {
+		s.offset = offset
+	} else {
+		s.diff = offset
+	}
+	return s, nil
+}
+
+func isZero(buf []byte) bool {
+	for _, b := range buf {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sameFiles checks that a patch's old container is the build we have
+func sameFiles(have *tlc.Container, want *tlc.Container) error {
+	if len(have.Files) != len(want.Files) {
+		return errors.Errorf("expected %d files, patch expects %d", len(have.Files), len(want.Files))
+	}
+	for i, f := range have.Files {
+		wf := want.Files[i]
+		if f.Path != wf.Path || f.Size != wf.Size {
+			return errors.Errorf("expected file %d to be %s (%d bytes), patch expects %s (%d bytes)", i, f.Path, f.Size, wf.Path, wf.Size)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package squash
+
+import (
+	"io"
+
+	"github.com/itchio/wharf/bsdiff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// maxChunk is how many bytes of fresh data go into a single op
+const maxChunk = wsync.MaxDataOp
+
+// encodeStats counts how files of the squashed patch were written
+type encodeStats struct {
+	rsync  int
+	bsdiff int
+	// staged is the number of files that had to be rebuilt from the old build
+	staged int
+}
+
+// write writes the squashed patch. oldPool is only needed for files that
+// can't be expressed in terms of the old build, it may be nil.
+func (sq *squasher) write(out io.Writer, compression *pwr.CompressionSettings, oldPool wsync.Pool) (*encodeStats, error) {
+	stats := &encodeStats{}
+
+	rawWire := wire.NewWriteContext(out)
+	err := rawWire.WriteMagic(pwr.PatchMagic)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = rawWire.WriteMessage(&pwr.PatchHeader{
+		Compression: compression,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	wc, err := pwr.CompressWire(rawWire, compression)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = wc.WriteMessage(sq.oldContainer)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	err = wc.WriteMessage(sq.container)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for i, l := range sq.layouts {
+		f := sq.container.Files[i]
+
+		sh := &pwr.SyncHeader{FileIndex: int64(i)}
+		if sq.canRsync(l) {
+			sh.Type = pwr.SyncHeader_RSYNC
+			stats.rsync++
+		} else if target, ok := sq.bsdiffTarget(l); ok {
+			sh.Type = pwr.SyncHeader_BSDIFF
+			stats.bsdiff++
+			err = wc.WriteMessage(sh)
+			if err == nil {
+				err = sq.writeBsdiff(wc, l, target)
+			}
+			if err != nil {
+				return nil, errors.WithMessage(err, f.Path)
+			}
+			continue
+		} else if oldPool != nil {
+			sh.Type = pwr.SyncHeader_RSYNC
+			stats.staged++
+		} else {
+			return nil, errors.Errorf("%s mixes parts of several old files that don't line up with blocks, so it can't be squashed without the old build (see --old)", f.Path)
+		}
+
+		err = wc.WriteMessage(sh)
+		if err == nil {
+			err = sq.writeRsync(wc, l, oldPool)
+		}
+		if err != nil {
+			return nil, errors.WithMessage(err, f.Path)
+		}
+	}
+
+	err = wc.Close()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return stats, nil
+}
+
+// isBlockRange returns true if a segment can be written as a block range op
+func (sq *squasher) isBlockRange(s segment) bool {
+	if s.isFresh() || s.diff >= 0 || s.offset%pwr.BlockSize != 0 {
+		return false
+	}
+	return s.length%pwr.BlockSize == 0 || s.offset+s.length == sq.oldContainer.Files[s.file].Size
+}
+
+func (sq *squasher) canRsync(l *layout) bool {
+	for _, s := range l.segments {
+		if !s.isFresh() && !sq.isBlockRange(s) {
+			return false
+		}
+	}
+	return true
+}
+
+// bsdiffTarget returns the old file a layout can be written as a bsdiff
+// series against, if it only uses one.
+func (sq *squasher) bsdiffTarget(l *layout) (int64, bool) {
+	target := int64(-1)
+	for _, s := range l.segments {
+		if s.isFresh() {
+			continue
+		}
+		if target >= 0 && s.file != target {
+			return -1, false
+		}
+		target = s.file
+	}
+	return target, target >= 0
+}
+
+func (sq *squasher) writeRsync(wc *wire.WriteContext, l *layout, oldPool wsync.Pool) error {
+	if len(l.segments) == 0 {
+		// the patcher expects at least one op before the end marker
+		err := wc.WriteMessage(&pwr.SyncOp{Type: pwr.SyncOp_DATA})
+		if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	for _, s := range l.segments {
+		if sq.isBlockRange(s) {
+			err := wc.WriteMessage(&pwr.SyncOp{
+				Type:       pwr.SyncOp_BLOCK_RANGE,
+				FileIndex:  s.file,
+				BlockIndex: s.offset / pwr.BlockSize,
+				BlockSpan:  (s.length + pwr.BlockSize - 1) / pwr.BlockSize,
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			continue
+		}
+
+		for done := int64(0); done < s.length; {
+			n := s.length - done
+			if n > maxChunk {
+				n = maxChunk
+			}
+			data, err := sq.read(s.cut(done, n), oldPool)
+			if err != nil {
+				return err
+			}
+
+			err = wc.WriteMessage(&pwr.SyncOp{
+				Type: pwr.SyncOp_DATA,
+				Data: data,
+			})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			done += n
+		}
+	}
+
+	return errors.WithStack(wc.WriteMessage(&pwr.SyncOp{Type: pwr.SyncOp_HEY_YOU_DID_IT}))
+}
+
+func (sq *squasher) writeBsdiff(wc *wire.WriteContext, l *layout, target int64) error {
+	err := wc.WriteMessage(&pwr.BsdiffHeader{TargetIndex: target})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	zeroes := make([]byte, maxChunk)
+	oldOffset := int64(0)
+	for _, s := range l.segments {
+		if !s.isFresh() && s.offset != oldOffset {
+			err = wc.WriteMessage(&bsdiff.Control{Seek: s.offset - oldOffset})
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			oldOffset = s.offset
+		}
+
+		for done := int64(0); done < s.length; {
+			n := s.length - done
+			if n > maxChunk {
+				n = maxChunk
+			}
+			chunk := s.cut(done, n)
+
+			ctrl := &bsdiff.Control{}
+			switch {
+			case chunk.isFresh():
+				ctrl.Copy, err = sq.staging.read(chunk.offset, n)
+			case chunk.diff >= 0:
+				ctrl.Add, err = sq.staging.read(chunk.diff, n)
+			default:
+				ctrl.Add = zeroes[:n]
+			}
+			if err != nil {
+				return err
+			}
+
+			err = wc.WriteMessage(ctrl)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if !chunk.isFresh() {
+				oldOffset += n
+			}
+			done += n
+		}
+	}
+
+	err = wc.WriteMessage(&bsdiff.Control{Eof: true})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(wc.WriteMessage(&pwr.SyncOp{Type: pwr.SyncOp_HEY_YOU_DID_IT}))
+}
+
+// read returns the bytes of a segment. Segments of the old build can
+// only be read if oldPool isn't nil.
+func (sq *squasher) read(s segment, oldPool wsync.Pool) ([]byte, error) {
+	if s.isFresh() {
+		return sq.staging.read(s.offset, s.length)
+	}
+	if oldPool == nil {
+		return nil, errors.New("internal error: reading from the old build without it")
+	}
+
+	r, err := oldPool.GetReadSeeker(s.file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	_, err = r.Seek(s.offset, io.SeekStart)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	buf := make([]byte, s.length)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s from old build", sq.oldContainer.Files[s.file].Path)
+	}
+
+	if s.diff >= 0 {
+		diff, err := sq.staging.read(s.diff, s.length)
+		if err != nil {
+			return nil, err
+		}
+		for i := range buf {
+			buf[i] += diff[i]
+		}
+	}
+	return buf, nil
+}
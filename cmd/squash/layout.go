@@ -0,0 +1,141 @@
+package squash
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// A segment is a run of bytes of a file, either taken from a file of the
+// old build (the one the first patch applies to), or fresh data that
+// came from one of the patches.
+type segment struct {
+	// file is the index of a file in the old container, or -1 for
+	// fresh data kept in the staging file
+	file int64
+	// offset is where the bytes are in the old file, or in the staging file
+	offset int64
+	length int64
+	// diff is where the bytes to add to the old bytes (bsdiff-style) are
+	// in the staging file, or -1 if the old bytes are used as-is.
+	// Fresh data never has a diff.
+	diff int64
+}
+
+func (s segment) isFresh() bool {
+	return s.file < 0
+}
+
+// cut returns the part of a segment that starts delta bytes in, and is
+// length bytes long
+func (s segment) cut(delta int64, length int64) segment {
+	s.offset += delta
+	if s.diff >= 0 {
+		s.diff += delta
+	}
+	s.length = length
+	return s
+}
+
+// follows returns true if s starts exactly where prev ends
+func (s segment) follows(prev segment) bool {
+	if s.file != prev.file || prev.offset+prev.length != s.offset {
+		return false
+	}
+	if s.diff < 0 || prev.diff < 0 {
+		return s.diff < 0 && prev.diff < 0
+	}
+	return prev.diff+prev.length == s.diff
+}
+
+// A layout describes the contents of a file of an intermediate or of the
+// final build, as a list of segments
+type layout struct {
+	segments []segment
+	// starts[i] is the offset of segments[i] in the file
+	starts []int64
+	size   int64
+}
+
+func (l *layout) append(s segment) {
+	if s.length == 0 {
+		return
+	}
+
+	n := len(l.segments)
+	if n > 0 && s.follows(l.segments[n-1]) {
+		l.segments[n-1].length += s.length
+	} else {
+		l.segments = append(l.segments, s)
+		l.starts = append(l.starts, l.size)
+	}
+	l.size += s.length
+}
+
+// slice returns the segments covering [offset, offset+length) of the file
+func (l *layout) slice(offset int64, length int64) ([]segment, error) {
+	if offset < 0 || length < 0 || offset+length > l.size {
+		return nil, errors.Errorf("range %d+%d is out of bounds (file is %d bytes)", offset, length, l.size)
+	}
+
+	var res []segment
+	i := sort.Search(len(l.starts), func(i int) bool {
+		return l.starts[i]+l.segments[i].length > offset
+	})
+	for ; length > 0; i++ {
+		s := l.segments[i]
+		delta := offset - l.starts[i]
+		n := s.length - delta
+		if n > length {
+			n = length
+		}
+		res = append(res, s.cut(delta, n))
+		offset += n
+		length -= n
+	}
+	return res, nil
+}
+
+// staging is a temporary file where fresh data from patches is kept, so
+// squashing long chains doesn't need much memory.
+type staging struct {
+	file *os.File
+	size int64
+}
+
+func newStaging(dir string) (*staging, error) {
+	f, err := ioutil.TempFile(dir, "butler-squash-")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &staging{file: f}, nil
+}
+
+// write appends data to the staging file and returns its offset
+func (st *staging) write(data []byte) (int64, error) {
+	offset := st.size
+	_, err := st.file.WriteAt(data, offset)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	st.size += int64(len(data))
+	return offset, nil
+}
+
+func (st *staging) read(offset int64, length int64) ([]byte, error) {
+	buf := make([]byte, length)
+	_, err := st.file.ReadAt(buf, offset)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return buf, nil
+}
+
+func (st *staging) Close() error {
+	name := st.file.Name()
+	err := st.file.Close()
+	os.Remove(name)
+	return err
+}
@@ -0,0 +1,208 @@
+package squash
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	"github.com/itchio/httpkit/progress"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+var args = struct {
+	oldSignature *string
+	patches      *[]string
+	output       *string
+	signature    *string
+	old          *string
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("squash", "(Advanced) Combine a chain of patches into a single patch that goes from the first build to the last one.")
+	args.oldSignature = cmd.Arg("old-signature", "Signature of the build the first patch applies to").Required().String()
+	args.patches = cmd.Arg("patches", "Patches to combine, in order").Required().Strings()
+	args.output = cmd.Flag("output", "Path to write the combined patch to").Short('o').Required().String()
+	args.signature = cmd.Flag("signature", "Signature of the build the last patch produces, to verify the combined patch").String()
+	args.old = cmd.Flag("old", "Directory with the build the first patch applies to, needed for files that can't be combined otherwise, and to verify blocks that moved since").String()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	ctx.Must(Do(&Params{
+		OldSignature: *args.oldSignature,
+		Patches:      *args.patches,
+		Output:       *args.output,
+		Signature:    *args.signature,
+		Old:          *args.old,
+		Compression:  ctx.CompressionSettings(),
+	}))
+}
+
+// Params control which patches to squash, and how
+type Params struct {
+	// OldSignature is the signature of the build the first patch applies to
+	OldSignature string
+	// Patches are applied in order
+	Patches []string
+	// Output is where to write the combined patch
+	Output string
+	// Signature is the signature of the final build, optional
+	Signature string
+	// Old is a directory with the build the first patch applies to, optional
+	Old string
+
+	Compression pwr.CompressionSettings
+}
+
+// Do composes a chain of patches into a single one, without the
+// intermediate builds. Fresh data from patches is kept in a staging file
+// next to the output, and parts of old files are referred to symbolically.
+func Do(params *Params) error {
+	if len(params.Patches) == 0 {
+		return errors.New("squash: need at least one patch")
+	}
+	if params.Output == "" {
+		return errors.New("squash: must specify Output")
+	}
+
+	startTime := time.Now()
+
+	oldSig, err := readSignature(params.OldSignature)
+	if err != nil {
+		return errors.WithMessage(err, "reading old signature")
+	}
+
+	var finalSig *pwr.SignatureInfo
+	if params.Signature != "" {
+		finalSig, err = readSignature(params.Signature)
+		if err != nil {
+			return errors.WithMessage(err, "reading final signature")
+		}
+	} else {
+		comm.Warnf("No final signature given (--signature), the combined patch won't be verified")
+	}
+
+	var oldPool wsync.Pool
+	if params.Old != "" {
+		oldPool = fspool.New(oldSig.Container, params.Old)
+		defer oldPool.Close()
+	}
+
+	err = os.MkdirAll(filepath.Dir(params.Output), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	st, err := newStaging(filepath.Dir(params.Output))
+	if err != nil {
+		return errors.Wrap(err, "creating staging file")
+	}
+	defer st.Close()
+
+	sq := newSquasher(oldSig.Container, st)
+
+	var inputSize int64
+	for i, patch := range params.Patches {
+		comm.Opf("Composing patch %d/%d: %s", i+1, len(params.Patches), eos.Redact(patch))
+		err = sq.apply(patch)
+		if err != nil {
+			return errors.WithMessage(err, eos.Redact(patch))
+		}
+
+		if stats, err := os.Stat(patch); err == nil {
+			inputSize += stats.Size()
+		}
+	}
+	comm.Debugf("Staged %s of fresh data", progress.FormatBytes(st.size))
+
+	comm.Opf("Writing %s", params.Output)
+
+	// the combined patch only ends up at the output path once it's verified
+	out, err := ioutil.TempFile(filepath.Dir(params.Output), filepath.Base(params.Output)+".tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	committed := false
+	defer func() {
+		out.Close()
+		if !committed {
+			os.Remove(out.Name())
+		}
+	}()
+
+	encodeStats, err := sq.write(out, &params.Compression, oldPool)
+	if err != nil {
+		return errors.WithMessage(err, "writing combined patch")
+	}
+	comm.Debugf("%d files as rsync, %d as bsdiff, %d rebuilt from the old build",
+		encodeStats.rsync, encodeStats.bsdiff, encodeStats.staged)
+
+	if finalSig != nil {
+		comm.Opf("Verifying against %s", params.Signature)
+		verifyStats, err := sq.verify(finalSig, oldSig, oldPool)
+		if err != nil {
+			return err
+		}
+
+		if verifyStats.uncertain > 0 {
+			return errors.Errorf("could only verify %d of %d blocks against the final signature, pass the first build with --old to check the other %d",
+				verifyStats.verified, verifyStats.blocks, verifyStats.uncertain)
+		}
+		comm.Statf("Verified all %d blocks", verifyStats.blocks)
+	}
+
+	outStats, err := out.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = out.Chmod(0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	err = os.Rename(out.Name(), params.Output)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	committed = true
+
+	comm.Statf("Combined %d patches (%s) into %s in %s",
+		len(params.Patches), progress.FormatBytes(inputSize), progress.FormatBytes(outStats.Size()),
+		progress.FormatDuration(time.Since(startTime)))
+
+	return nil
+}
+
+func readSignature(path string) (*pwr.SignatureInfo, error) {
+	reader, err := eos.Open(path, option.WithConsumer(comm.NewStateConsumer()))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	source := seeksource.FromFile(reader)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sig, err := pwr.ReadSignature(context.Background(), source)
+	if err != nil {
+		return nil, errors.Wrap(err, eos.Redact(path))
+	}
+	return sig, nil
+}
@@ -0,0 +1,275 @@
+package squash_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/cmd/apply"
+	"github.com/itchio/butler/cmd/diff"
+	"github.com/itchio/butler/cmd/sign"
+	"github.com/itchio/butler/cmd/squash"
+	"github.com/itchio/savior/filesource"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+var compression = pwr.CompressionSettings{
+	Algorithm: pwr.CompressionAlgorithm_ZSTD,
+	Quality:   1,
+}
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	for name, contents := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, contents, 0644))
+	}
+}
+
+func randomBytes(seed int64, size int64) []byte {
+	buf := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(buf)
+	return buf
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+type chain struct {
+	dir     string
+	v1      string
+	v3      string
+	v1Sig   string
+	v3Sig   string
+	patches []string
+}
+
+// makeChain creates three builds and the patches between them. The second
+// build inserts data at the start of a file, so the third build refers to
+// it at offsets that don't line up with blocks of the first build.
+func makeChain(t *testing.T, extraV3 map[string][]byte) *chain {
+	dir, err := ioutil.TempDir("", "squash")
+	wtest.Must(t, err)
+
+	c := &chain{
+		dir:   dir,
+		v1:    filepath.Join(dir, "v1"),
+		v3:    filepath.Join(dir, "v3"),
+		v1Sig: filepath.Join(dir, "v1.pws"),
+		v3Sig: filepath.Join(dir, "p2.pwr.sig"),
+	}
+	v2 := filepath.Join(dir, "v2")
+
+	big := randomBytes(1, pwr.BlockSize*5+1000)
+	other := randomBytes(2, pwr.BlockSize*3)
+
+	writeFiles(t, c.v1, map[string][]byte{
+		"big.dat":   big,
+		"other.dat": other,
+		"same.txt":  []byte("unchanged"),
+		"gone.txt":  []byte("removed in v2"),
+		"empty":     nil,
+	})
+
+	bigV2 := concat(randomBytes(3, 300), big)
+	writeFiles(t, v2, map[string][]byte{
+		"big.dat":   bigV2,
+		"other.dat": other,
+		"same.txt":  []byte("unchanged"),
+		"added.txt": []byte("added in v2"),
+		"empty":     nil,
+	})
+
+	bigV3 := append([]byte{}, bigV2...)
+	bigV3[2*pwr.BlockSize+10]++
+	v3Files := map[string][]byte{
+		"big.dat":     bigV3,
+		"renamed.dat": other,
+		"same.txt":    []byte("unchanged"),
+		"added.txt":   []byte("added in v2, changed in v3"),
+		"empty":       nil,
+	}
+	for k, v := range extraV3 {
+		v3Files[k] = v
+	}
+	writeFiles(t, c.v3, v3Files)
+
+	wtest.Must(t, sign.Do(&sign.Params{Dir: c.v1, Signature: c.v1Sig, Compression: compression}))
+	for i, pair := range [][2]string{{c.v1, v2}, {v2, c.v3}} {
+		patch := filepath.Join(dir, []string{"p1.pwr", "p2.pwr"}[i])
+		wtest.Must(t, diff.Do(&diff.Params{
+			Target:      pair[0],
+			Source:      pair[1],
+			Patch:       patch,
+			Compression: compression,
+		}))
+		c.patches = append(c.patches, patch)
+	}
+
+	return c
+}
+
+// checkApplies applies a squashed patch to the first build, and checks
+// that we get the last build
+func checkApplies(t *testing.T, c *chain, patch string) {
+	out := filepath.Join(c.dir, "out")
+	wtest.Must(t, os.RemoveAll(out))
+	wtest.Must(t, apply.Do(&apply.Params{
+		Patch:         patch,
+		Target:        c.v1,
+		Output:        out,
+		SignaturePath: c.v3Sig,
+	}))
+
+	filepath.Walk(c.v3, func(path string, info os.FileInfo, err error) error {
+		wtest.Must(t, err)
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(c.v3, path)
+		wtest.Must(t, err)
+
+		expected, err := ioutil.ReadFile(path)
+		wtest.Must(t, err)
+		actual, err := ioutil.ReadFile(filepath.Join(out, rel))
+		wtest.Must(t, err)
+		assert.True(t, bytes.Equal(expected, actual), "%s should match", rel)
+		return nil
+	})
+}
+
+func TestSquash(t *testing.T) {
+	c := makeChain(t, nil)
+	defer os.RemoveAll(c.dir)
+
+	squashed := filepath.Join(c.dir, "squashed.pwr")
+	wtest.Must(t, squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      c.patches,
+		Output:       squashed,
+		Signature:    c.v3Sig,
+		Old:          c.v1,
+		Compression:  compression,
+	}))
+	checkApplies(t, c, squashed)
+
+	assertNoOutput := func(output string) {
+		matches, err := filepath.Glob(output + "*")
+		wtest.Must(t, err)
+		assert.Empty(t, matches, "failed squashes shouldn't leave anything behind")
+	}
+
+	// the final signature must match
+	failed := filepath.Join(c.dir, "failed.pwr")
+	err := squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      c.patches,
+		Output:       failed,
+		Signature:    c.patches[0] + ".sig",
+		Old:          c.v1,
+		Compression:  compression,
+	})
+	assert.Error(t, err)
+	assertNoOutput(failed)
+
+	// blocks that moved since the first build can only be verified with --old
+	err = squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      c.patches,
+		Output:       failed,
+		Signature:    c.v3Sig,
+		Compression:  compression,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "--old")
+	assertNoOutput(failed)
+
+	// and patches must be in order
+	err = squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      []string{c.patches[1], c.patches[0]},
+		Output:       squashed,
+		Compression:  compression,
+	})
+	assert.Error(t, err)
+}
+
+func TestSquashOptimizedPatch(t *testing.T) {
+	c := makeChain(t, nil)
+	defer os.RemoveAll(c.dir)
+
+	// turn the first patch into a bsdiff one
+	optimized := filepath.Join(c.dir, "p1-optimized.pwr")
+	rc := &pwr.RediffContext{
+		Consumer:    &state.Consumer{},
+		Partitions:  1,
+		Compression: &compression,
+	}
+	patchSource, err := filesource.Open(c.patches[0])
+	wtest.Must(t, err)
+	wtest.Must(t, rc.AnalyzePatch(patchSource))
+	rc.TargetPool = fspool.New(rc.TargetContainer, c.v1)
+	rc.SourcePool = fspool.New(rc.SourceContainer, filepath.Join(c.dir, "v2"))
+	_, err = patchSource.Resume(nil)
+	wtest.Must(t, err)
+	writer, err := os.Create(optimized)
+	wtest.Must(t, err)
+	wtest.Must(t, rc.OptimizePatch(patchSource, writer))
+	writer.Close()
+	patchSource.Close()
+
+	squashed := filepath.Join(c.dir, "squashed.pwr")
+	wtest.Must(t, squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      []string{optimized, c.patches[1]},
+		Output:       squashed,
+		Signature:    c.v3Sig,
+		Old:          c.v1,
+		Compression:  compression,
+	}))
+	checkApplies(t, c, squashed)
+}
+
+func TestSquashNeedsOldBuild(t *testing.T) {
+	// this file is made of parts of two files of v1, and one of them
+	// doesn't line up with blocks of v1 anymore.
+	mixed := concat(
+		randomBytes(3, 300),
+		randomBytes(1, pwr.BlockSize*2-300),
+		randomBytes(2, pwr.BlockSize),
+	)
+	c := makeChain(t, map[string][]byte{"mixed.dat": mixed})
+	defer os.RemoveAll(c.dir)
+
+	squashed := filepath.Join(c.dir, "squashed.pwr")
+	err := squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      c.patches,
+		Output:       squashed,
+		Signature:    c.v3Sig,
+		Compression:  compression,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "mixed.dat")
+
+	wtest.Must(t, squash.Do(&squash.Params{
+		OldSignature: c.v1Sig,
+		Patches:      c.patches,
+		Output:       squashed,
+		Signature:    c.v3Sig,
+		Old:          c.v1,
+		Compression:  compression,
+	}))
+	checkApplies(t, c, squashed)
+}
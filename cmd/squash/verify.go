@@ -0,0 +1,140 @@
+package squash
+
+import (
+	"bytes"
+
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// verifyStats counts how many blocks of the final build were checked
+type verifyStats struct {
+	blocks    int64
+	verified  int64
+	uncertain int64
+}
+
+// verify checks the squashed build against the final signature. Blocks
+// made of fresh data are hashed, blocks that are whole blocks of the old
+// build are checked against the old signature. Other blocks can only be
+// checked if oldPool isn't nil.
+func (sq *squasher) verify(sig *pwr.SignatureInfo, oldSig *pwr.SignatureInfo, oldPool wsync.Pool) (*verifyStats, error) {
+	err := sq.container.EnsureEqual(sig.Container)
+	if err != nil {
+		return nil, errors.WithMessage(err, "squashed build doesn't match final signature")
+	}
+
+	err = checkHashCount(sig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "final signature")
+	}
+	err = checkHashCount(oldSig)
+	if err != nil {
+		return nil, errors.WithMessage(err, "old signature")
+	}
+
+	oldHashes := make([]int64, len(oldSig.Container.Files))
+	hashIndex := int64(0)
+	for i, f := range oldSig.Container.Files {
+		oldHashes[i] = hashIndex
+		hashIndex += numBlocks(f.Size)
+	}
+
+	stats := &verifyStats{}
+	wctx := wsync.NewContext(int(pwr.BlockSize))
+
+	hashIndex = 0
+	for i, f := range sq.container.Files {
+		l := sq.layouts[i]
+		n := numBlocks(f.Size)
+		for b := int64(0); b < n; b++ {
+			stats.blocks++
+			expected := sig.Hashes[hashIndex+b]
+
+			offset, length := blockRange(f.Size, b, 1)
+			segments, err := l.slice(offset, length)
+			if err != nil {
+				return nil, err
+			}
+
+			var weak uint32
+			var strong []byte
+			if oldHash, ok := sq.oldBlockHash(segments, oldSig, oldHashes); ok {
+				weak, strong = oldHash.WeakHash, oldHash.StrongHash
+			} else if readable(segments, oldPool) {
+				var buf []byte
+				for _, s := range segments {
+					data, err := sq.read(s, oldPool)
+					if err != nil {
+						return nil, err
+					}
+					buf = append(buf, data...)
+				}
+				weak, strong = wctx.HashBlock(buf)
+			} else {
+				stats.uncertain++
+				continue
+			}
+
+			if weak != expected.WeakHash || !bytes.Equal(strong, expected.StrongHash) {
+				return nil, errors.Errorf("squashed build doesn't match final signature: %s, block %d", f.Path, b)
+			}
+			stats.verified++
+		}
+		hashIndex += n
+	}
+
+	return stats, nil
+}
+
+// oldBlockHash returns the hash of the old block a block is made of,
+// if it's exactly a block of the old build
+func (sq *squasher) oldBlockHash(segments []segment, oldSig *pwr.SignatureInfo, oldHashes []int64) (wsync.BlockHash, bool) {
+	if len(segments) != 1 {
+		return wsync.BlockHash{}, false
+	}
+	s := segments[0]
+	if s.isFresh() || s.diff >= 0 || s.offset%pwr.BlockSize != 0 {
+		return wsync.BlockHash{}, false
+	}
+
+	oldSize := sq.oldContainer.Files[s.file].Size
+	b := s.offset / pwr.BlockSize
+	if _, length := blockRange(oldSize, b, 1); length != s.length {
+		return wsync.BlockHash{}, false
+	}
+	return oldSig.Hashes[oldHashes[s.file]+b], true
+}
+
+func readable(segments []segment, oldPool wsync.Pool) bool {
+	if oldPool != nil {
+		return true
+	}
+	for _, s := range segments {
+		if !s.isFresh() {
+			return false
+		}
+	}
+	return true
+}
+
+// numBlocks returns how many hashes a file has in a signature,
+// empty files have a 0-length shortblock
+func numBlocks(size int64) int64 {
+	if size == 0 {
+		return 1
+	}
+	return pwr.ComputeNumBlocks(size)
+}
+
+func checkHashCount(sig *pwr.SignatureInfo) error {
+	expected := int64(0)
+	for _, f := range sig.Container.Files {
+		expected += numBlocks(f.Size)
+	}
+	if int64(len(sig.Hashes)) != expected {
+		return errors.Errorf("expected %d block hashes, found %d", expected, len(sig.Hashes))
+	}
+	return nil
+}
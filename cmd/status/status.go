@@ -66,7 +66,7 @@ func Do(ctx *mansion.Context, specStr string, showAllFiles bool) error {
 		found = true
 
 		if ch.Head != nil {
-			line := []string{ch.Name, fmt.Sprintf("#%d", ch.Upload.ID), buildState(ch.Head), versionState(ch.Head)}
+			line := []string{ch.Name, fmt.Sprintf("#%d", ch.Upload.ID), BuildState(ch.Head), versionState(ch.Head)}
 			table.Append(line)
 		} else {
 			line := []string{ch.Name, fmt.Sprintf("#%d", ch.Upload.ID), "No builds yet"}
@@ -74,7 +74,7 @@ func Do(ctx *mansion.Context, specStr string, showAllFiles bool) error {
 		}
 
 		if ch.Pending != nil {
-			line := []string{"", "", buildState(ch.Pending), versionState(ch.Pending)}
+			line := []string{"", "", BuildState(ch.Pending), versionState(ch.Pending)}
 			table.Append(line)
 		}
 	}
@@ -88,7 +88,9 @@ func Do(ctx *mansion.Context, specStr string, showAllFiles bool) error {
 	return nil
 }
 
-func buildState(build *itchio.Build) string {
+// BuildState returns a short, human-readable description of a build's
+// state, along with its ID and the ID of its parent, if any.
+func BuildState(build *itchio.Build) string {
 	theme := state.GetTheme()
 	var s string
 
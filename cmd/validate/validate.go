@@ -4,14 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/itchio/httpkit/progress"
 	"github.com/itchio/ox"
 	"github.com/itchio/wharf/eos/option"
 
-	"github.com/itchio/butler/manager"
-
 	"github.com/itchio/dash"
 
 	"github.com/mitchellh/mapstructure"
@@ -19,13 +18,16 @@ import (
 	"github.com/BurntSushi/toml"
 
 	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/cmd/configure"
 	"github.com/itchio/butler/comm"
 	"github.com/itchio/butler/endpoints/launch"
 	"github.com/itchio/butler/endpoints/launch/manifest"
+	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
 	"github.com/itchio/butler/redist"
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
 	"github.com/pkg/errors"
 )
 
@@ -44,10 +46,45 @@ func Register(ctx *mansion.Context) {
 }
 
 func doValidate(ctx *mansion.Context) {
-	ctx.Must(Validate(comm.NewStateConsumer()))
+	res, err := Validate(comm.NewStateConsumer(), &Params{
+		Dir:      *args.dir,
+		Platform: ox.Platform(*args.platform),
+		Arch:     *args.arch,
+	})
+	ctx.Must(err)
+
+	if len(res.Errors) > 0 {
+		ctx.Must(fmt.Errorf("Found %d errors.", len(res.Errors)))
+	}
 }
 
-func Validate(consumer *state.Consumer) error {
+// Params describes what to validate
+type Params struct {
+	// Dir is a build folder, or the path of a manifest
+	Dir string
+	// Platform to validate for, defaults to the current one
+	Platform ox.Platform
+	// Arch to validate for (386 or amd64), defaults to the current one
+	Arch string
+	// Filter decides which files are part of the build, defaults to filtering.FilterPaths
+	Filter tlc.FilterFunc
+	// NoFixPermissions leaves the build as it is, instead of making
+	// executables found by the launch heuristics executable
+	NoFixPermissions bool
+}
+
+// Result lists the problems found in a build
+type Result struct {
+	Errors   []string
+	Warnings []string
+}
+
+// Validate checks a build folder and its manifest, if any. Problems
+// with the build are listed in the result, errors are only returned
+// if validation couldn't be performed, or if the manifest is invalid.
+func Validate(consumer *state.Consumer, params *Params) (*Result, error) {
+	res := &Result{}
+
 	banner := func(banner string, msg string, args ...interface{}) {
 		consumer.Infof("")
 		consumer.Infof("================== %s ==================", banner)
@@ -57,40 +94,53 @@ func Validate(consumer *state.Consumer) error {
 	}
 
 	showWarning := func(msg string, args ...interface{}) {
+		res.Warnings = append(res.Warnings, fmt.Sprintf(msg, args...))
 		banner("Warning", msg, args...)
 	}
 
-	errorCount := 0
 	showError := func(msg string, args ...interface{}) {
-		errorCount++
+		res.Errors = append(res.Errors, fmt.Sprintf(msg, args...))
 		banner("Error", msg, args...)
 	}
 
 	hasDir := false
-	dir := *args.dir
+	dir := params.Dir
 
 	var manifestPath string
 	dirStats, err := os.Stat(dir)
 	if err != nil {
-		return errors.Wrapf(err, "stat'ing %s", dir)
+		return nil, errors.Wrapf(err, "stat'ing %s", dir)
 	}
 
 	consumer.Infof("")
 	if dirStats.IsDir() {
-		comm.Opf("Validating build directory %s", dir)
+		consumer.Opf("Validating build directory %s", dir)
 		manifestPath = manifest.Path(dir)
 		hasDir = true
 	} else {
-		comm.Opf("Validating manifest only")
+		consumer.Opf("Validating manifest only")
 		manifestPath = dir
 	}
 
+	filter := params.Filter
+	if filter == nil {
+		filter = filtering.FilterPaths
+	}
+
+	ignoreRules := filtering.NewIgnoreRules(nil)
+	if hasDir {
+		ignoreRules, err = filtering.LoadIgnoreFiles(dir, filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading ignore files")
+		}
+	}
+
 	runtime := ox.CurrentRuntime()
-	if *args.platform != "" {
-		runtime.Platform = ox.Platform(*args.platform)
+	if params.Platform != "" {
+		runtime.Platform = params.Platform
 	}
-	if *args.arch != "" {
-		runtime.Is64 = (*args.arch == string(dash.ArchAmd64))
+	if params.Arch != "" {
+		runtime.Is64 = (params.Arch == string(dash.ArchAmd64))
 	}
 	consumer.Infof("For runtime %s (use --platform and --arch to simulate others)", runtime)
 	consumer.Infof("")
@@ -109,11 +159,30 @@ func Validate(consumer *state.Consumer) error {
 		consumer.Infof("")
 		consumer.Infof("Heuristics will be used to launch your project.")
 		if hasDir {
-			verdict, err := manager.Configure(consumer, dir, runtime)
+			verdict, err := configure.Do(&configure.Params{
+				Consumer:   consumer,
+				Path:       dir,
+				OsFilter:   runtime.OS(),
+				ArchFilter: runtime.Arch(),
+				Filter:     filter,
+
+				NoFixPermissions: params.NoFixPermissions,
+			})
 			if err != nil {
 				return errors.Wrapf(err, "automatically determing launch targets for %s", dir)
 			}
 
+			// files excluded by ignore files won't be pushed, don't suggest them
+			var candidates []*dash.Candidate
+			for _, candidate := range verdict.Candidates {
+				if rule := ignoreRules.Excluded(filepath.ToSlash(candidate.Path), false); rule != nil {
+					consumer.Debugf("Skipping %s, excluded by %s", candidate.Path, rule)
+					continue
+				}
+				candidates = append(candidates, candidate)
+			}
+			verdict.Candidates = candidates
+
 			consumer.Infof("")
 			consumer.Statf("Heuristic results (best first):")
 
@@ -139,11 +208,11 @@ func Validate(consumer *state.Consumer) error {
 			consumer.Infof("No manifest found (expected it to be at %s)", manifestPath)
 			err := showHeuristics()
 			if err != nil {
-				return errors.Wrap(err, "showing heuristics")
+				return nil, errors.Wrap(err, "showing heuristics")
 			}
-			return nil
+			return res, nil
 		}
-		return errors.Wrap(err, "stat'ing manifest file")
+		return nil, errors.Wrap(err, "stat'ing manifest file")
 	}
 
 	consumer.Opf("Validating %s manifest at (%s)", progress.FormatBytes(stats.Size()), manifestPath)
@@ -152,12 +221,12 @@ func Validate(consumer *state.Consumer) error {
 	_, err = toml.DecodeFile(manifestPath, &intermediate)
 	if err != nil {
 		consumer.Errorf("Parse error:")
-		return errors.Wrap(err, "parsing manifest")
+		return nil, errors.Wrap(err, "parsing manifest")
 	}
 
 	jsonIntermediate, err := json.MarshalIndent(intermediate, "", "  ")
 	if err != nil {
-		return errors.Wrap(err, "marshalling manifest as json")
+		return nil, errors.Wrap(err, "marshalling manifest as json")
 	}
 	consumer.Debugf("Intermediate:\n%s", string(jsonIntermediate))
 
@@ -168,7 +237,7 @@ func Validate(consumer *state.Consumer) error {
 	})
 	if err != nil {
 		consumer.Errorf("Internal error:")
-		return errors.Wrap(err, "decoding manifest from json form")
+		return nil, errors.Wrap(err, "decoding manifest from json form")
 	}
 
 	err = decoder.Decode(intermediate)
@@ -190,18 +259,18 @@ func Validate(consumer *state.Consumer) error {
 			showWarning("%s", err.Error())
 		} else {
 			consumer.Errorf("Decoding error:")
-			return errors.Wrap(err, "decoding manifest")
+			return nil, errors.Wrap(err, "decoding manifest")
 		}
 	}
 
 	_, err = toml.DecodeFile(manifestPath, appManifest)
 	if err != nil {
-		return errors.Wrap(err, "parsing toml manifest")
+		return nil, errors.Wrap(err, "parsing toml manifest")
 	}
 
 	jsonManifest, err := json.MarshalIndent(appManifest, "", "  ")
 	if err != nil {
-		return errors.Wrap(err, "marshalling manifest as json")
+		return nil, errors.Wrap(err, "marshalling manifest as json")
 	}
 
 	consumer.Debugf("Manifest:\n%s", string(jsonManifest))
@@ -236,6 +305,13 @@ func Validate(consumer *state.Consumer) error {
 			if len(action.Args) > 0 {
 				consumer.Infof("    Passes arguments: %s", strings.Join(action.Args, " ::: "))
 			}
+			if hasDir && !strings.Contains(action.Path, "://") {
+				if rule := ignoreRules.Excluded(filepath.ToSlash(filepath.Clean(action.Path)), false); rule != nil {
+					showError("Action path (%s) is excluded by %s, it won't be pushed", action.Path, rule)
+				} else if filteredOut(dir, action.Path, filter) {
+					showError("Action path (%s) is filtered out, it won't be pushed", action.Path)
+				}
+			}
 			if hasDir {
 				sr, err := launch.DetermineStrategy(consumer, runtime, dir, action)
 				if err != nil {
@@ -249,7 +325,7 @@ func Validate(consumer *state.Consumer) error {
 		consumer.Statf("No actions found.")
 		err := showHeuristics()
 		if err != nil {
-			return errors.Wrap(err, "showing heuristics")
+			return nil, errors.Wrap(err, "showing heuristics")
 		}
 	}
 
@@ -260,13 +336,13 @@ func Validate(consumer *state.Consumer) error {
 
 		regFile, err := eos.Open("https://broth.itch.ovh/itch-redists/info/LATEST/unpacked", option.WithConsumer(consumer))
 		if err != nil {
-			return errors.Wrap(err, "opening prereqs registry")
+			return nil, errors.Wrap(err, "opening prereqs registry")
 		}
 
 		reg := &redist.RedistRegistry{}
 		err = json.NewDecoder(regFile).Decode(reg)
 		if err != nil {
-			return errors.Wrap(err, "decoding prereqs registry")
+			return nil, errors.Wrap(err, "decoding prereqs registry")
 		}
 
 		for _, p := range appManifest.Prereqs {
@@ -298,9 +374,21 @@ func Validate(consumer *state.Consumer) error {
 		consumer.Infof("Visit https://itch.io/docs/itch/integrating/manifest.html for more information.")
 	}
 
-	if errorCount > 0 {
-		return fmt.Errorf("Found %d errors.", errorCount)
-	}
+	return res, nil
+}
 
-	return nil
+// filteredOut returns true if filter leaves out p, a path relative
+// to dir, or one of the folders it's in
+func filteredOut(dir string, p string, filter tlc.FilterFunc) bool {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(p)), "/")
+	for i := range parts {
+		stats, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(strings.Join(parts[:i+1], "/"))))
+		if err != nil {
+			return false
+		}
+		if !filter(stats) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,356 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/itchio/butler/hashpool"
+	"github.com/itchio/wharf/pools"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// validator checks a folder against a signature like pwr.ValidatorContext
+// does, but hashes files with a hashpool, and sends wounds in container
+// order so that wound files don't depend on the number of workers.
+type validator struct {
+	WoundsPath  string
+	HealPath    string
+	Concurrency int
+
+	Consumer *state.Consumer
+
+	// set by validate
+	WoundsConsumer pwr.WoundsConsumer
+}
+
+func (v *validator) validate(ctx context.Context, target string, signature *pwr.SignatureInfo) error {
+	container := signature.Container
+	consumer := v.Consumer
+	if consumer == nil {
+		consumer = &state.Consumer{}
+	}
+
+	// when healing, progress is the healer's
+	var healing bool
+	scanConsumer := &state.Consumer{
+		OnProgress: func(progress float64) {
+			if !healing {
+				consumer.Progress(progress)
+			}
+		},
+		OnProgressLabel: consumer.ProgressLabel,
+		OnMessage:       consumer.OnMessage,
+	}
+
+	switch {
+	case v.WoundsPath != "":
+		v.WoundsConsumer = &pwr.WoundsWriter{
+			WoundsPath: v.WoundsPath,
+		}
+	case v.HealPath != "":
+		// healers can deal with "everything missing"
+		err := os.MkdirAll(target, 0755)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		healer, err := pwr.NewHealer(v.HealPath, target)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		healing = true
+		healer.SetConsumer(&state.Consumer{
+			OnProgress:      consumer.Progress,
+			OnProgressLabel: consumer.ProgressLabel,
+		})
+		v.WoundsConsumer = healer
+	default:
+		v.WoundsConsumer = &pwr.WoundsPrinter{
+			Consumer: consumer,
+		}
+	}
+
+	hashGroups, err := makeHashGroups(signature)
+	if err != nil {
+		return err
+	}
+
+	wounds := make(chan *pwr.Wound, 1024)
+	consumerErr := make(chan error, 1)
+	consumerCtx, cancelConsumer := context.WithCancel(ctx)
+	defer cancelConsumer()
+
+	go func() {
+		consumerErr <- v.WoundsConsumer.Do(consumerCtx, container, wounds)
+
+		// throw away wounds until closed
+		for range wounds {
+			// muffin
+		}
+	}()
+
+	send := func(wound *pwr.Wound) error {
+		select {
+		case wounds <- wound:
+			return nil
+		case err := <-consumerErr:
+			consumerErr <- err
+			if err == nil {
+				err = errors.New("wounds consumer stopped early")
+			}
+			return err
+		}
+	}
+
+	validateErr := func() error {
+		// validate dirs and symlinks first
+		for dirIndex, dir := range container.Dirs {
+			path := filepath.Join(target, filepath.FromSlash(dir.Path))
+			stats, err := os.Lstat(path)
+			if err != nil && !pwr.IsNotExist(err) {
+				return errors.WithStack(err)
+			}
+
+			if err != nil || !stats.IsDir() {
+				err := send(&pwr.Wound{
+					Kind:  pwr.WoundKind_DIR,
+					Index: int64(dirIndex),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for symlinkIndex, symlink := range container.Symlinks {
+			path := filepath.Join(target, filepath.FromSlash(symlink.Path))
+			dest, err := os.Readlink(path)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.WithStack(err)
+			}
+
+			if err != nil || dest != filepath.FromSlash(symlink.Dest) {
+				err := send(&pwr.Wound{
+					Kind:  pwr.WoundKind_SYMLINK,
+					Index: int64(symlinkIndex),
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		fc := &fileChecker{
+			hashGroups: hashGroups,
+			signature:  signature,
+			send:       send,
+		}
+
+		chunked := false
+		if stats, err := os.Stat(target); err == nil && stats.IsDir() {
+			chunked = true
+		}
+
+		return hashpool.Hash(ctx, &hashpool.Params{
+			Container: container,
+			NewPool: func() (wsync.Pool, error) {
+				return pools.New(container, target)
+			},
+			Concurrency: v.Concurrency,
+			Chunked:     chunked,
+			Consumer:    scanConsumer,
+		}, fc.check)
+	}()
+
+	close(wounds)
+	cErr := <-consumerErr
+	if validateErr != nil {
+		return validateErr
+	}
+	return cErr
+}
+
+// makeHashGroups splits the hashes of a signature per file, see
+// pwr.ValidatingPool. Empty files have no hashes to check.
+func makeHashGroups(signature *pwr.SignatureInfo) ([][]wsync.BlockHash, error) {
+	hashGroups := make([][]wsync.BlockHash, len(signature.Container.Files))
+	hashIndex := int64(0)
+
+	for fileIndex, f := range signature.Container.Files {
+		if f.Size == 0 {
+			// empty files have a 0-length shortblock for historical reasons.
+			hashIndex++
+			continue
+		}
+
+		numBlocks := pwr.ComputeNumBlocks(f.Size)
+		if hashIndex+numBlocks > int64(len(signature.Hashes)) {
+			break
+		}
+		hashGroups[fileIndex] = signature.Hashes[hashIndex : hashIndex+numBlocks]
+		hashIndex += numBlocks
+	}
+
+	if hashIndex != int64(len(signature.Hashes)) {
+		return nil, errors.Errorf("expected to have %d hashes in signature, had %d", hashIndex, len(signature.Hashes))
+	}
+	return hashGroups, nil
+}
+
+// fileChecker turns hashed chunks into wounds, aggregated the same way
+// pwr.AggregateWounds does it: consecutive corrupted blocks of a file are
+// sent as a single wound, up to pwr.MaxWoundSize.
+type fileChecker struct {
+	hashGroups [][]wsync.BlockHash
+	signature  *pwr.SignatureInfo
+	send       func(wound *pwr.Wound) error
+
+	// file wound waiting to be extended by the next block
+	lastWound *pwr.Wound
+	// set when the current file was found to be missing or too short
+	skipFile bool
+}
+
+func (fc *fileChecker) check(c *hashpool.Chunk) error {
+	file := fc.signature.Container.Files[c.FileIndex]
+	if c.Offset == 0 {
+		fc.skipFile = false
+	}
+
+	err := fc.checkChunk(c, file.Size)
+	if err != nil {
+		return err
+	}
+
+	if c.Last {
+		return fc.flush()
+	}
+	return nil
+}
+
+func (fc *fileChecker) checkChunk(c *hashpool.Chunk, fileSize int64) error {
+	if fc.skipFile {
+		return nil
+	}
+
+	if c.Missing {
+		fc.skipFile = true
+		if c.Offset == 0 {
+			// whole file is missing
+			return fc.send(&pwr.Wound{
+				Kind:  pwr.WoundKind_FILE,
+				Index: c.FileIndex,
+				Start: 0,
+				End:   fileSize,
+			})
+		}
+		// it was there for the previous chunks
+		return fc.send(&pwr.Wound{
+			Kind:  pwr.WoundKind_FILE,
+			Index: c.FileIndex,
+			Start: c.Offset,
+			End:   fileSize,
+		})
+	}
+
+	if fileSize == 0 {
+		if c.TooLarge {
+			return fc.add(&pwr.Wound{
+				Kind:  pwr.WoundKind_FILE,
+				Index: c.FileIndex,
+			})
+		}
+		return nil
+	}
+
+	hashGroup := fc.hashGroups[c.FileIndex]
+	for i, bh := range c.Hashes {
+		blockIndex := bh.BlockIndex
+		start := blockIndex * pwr.BlockSize
+		size := pwr.ComputeBlockSize(fileSize, blockIndex)
+
+		kind := pwr.WoundKind_CLOSED_FILE
+		if blockIndex >= int64(len(hashGroup)) {
+			kind = pwr.WoundKind_FILE
+		} else {
+			expected := hashGroup[blockIndex]
+			if expected.WeakHash != bh.WeakHash || !bytes.Equal(expected.StrongHash, bh.StrongHash) {
+				kind = pwr.WoundKind_FILE
+			}
+		}
+		if c.TooLarge && i == len(c.Hashes)-1 {
+			// there's more data after the last block
+			kind = pwr.WoundKind_FILE
+		}
+
+		err := fc.add(&pwr.Wound{
+			Kind:  kind,
+			Index: c.FileIndex,
+			Start: start,
+			End:   start + size,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Short() {
+		// file is shorter than expected. like pwr.ValidatorContext, this
+		// goes out before the aggregated wound of the last blocks read.
+		fc.skipFile = true
+		return fc.send(&pwr.Wound{
+			Kind:  pwr.WoundKind_FILE,
+			Index: c.FileIndex,
+			Start: c.Offset + c.Read,
+			End:   fileSize,
+		})
+	}
+	return nil
+}
+
+// add aggregates file wounds, see pwr.AggregateWounds
+func (fc *fileChecker) add(wound *pwr.Wound) error {
+	if wound.Kind != pwr.WoundKind_FILE {
+		err := fc.flush()
+		if err != nil {
+			return err
+		}
+		return fc.send(wound)
+	}
+
+	lastWound := fc.lastWound
+	if lastWound == nil {
+		fc.lastWound = wound
+		return nil
+	}
+
+	if lastWound.End <= wound.Start && wound.Start >= lastWound.Start {
+		lastWound.End = wound.End
+		if lastWound.End-lastWound.Start >= pwr.MaxWoundSize {
+			return fc.flush()
+		}
+		return nil
+	}
+
+	err := fc.flush()
+	if err != nil {
+		return err
+	}
+	fc.lastWound = wound
+	return nil
+}
+
+func (fc *fileChecker) flush() error {
+	if fc.lastWound == nil {
+		return nil
+	}
+	wound := fc.lastWound
+	fc.lastWound = nil
+	return fc.send(wound)
+}
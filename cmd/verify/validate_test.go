@@ -0,0 +1,119 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/butler/hashpool"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "target")
+	rng := rand.New(rand.NewSource(1))
+	sizes := map[string]int64{
+		"empty":          0,
+		"small":          1000,
+		"corrupt":        pwr.BlockSize*10 + 10,
+		"truncated":      hashpool.ChunkSize*2 + 10,
+		"missing":        pwr.BlockSize * 2,
+		"extra":          pwr.BlockSize,
+		"sub/large.dat":  hashpool.ChunkSize*3 + 1,
+		"sub/intact.dat": pwr.BlockSize * 3,
+	}
+	for name, size := range sizes {
+		buf := make([]byte, size)
+		rng.Read(buf)
+		p := filepath.Join(target, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, buf, 0644))
+	}
+
+	container, err := tlc.WalkAny(target, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	signature := &pwr.SignatureInfo{Container: container}
+	wtest.Must(t, pwr.ComputeSignatureToWriter(context.Background(), container, fspool.New(container, target), &state.Consumer{}, func(bh wsync.BlockHash) error {
+		signature.Hashes = append(signature.Hashes, bh)
+		return nil
+	}))
+
+	corrupt := func(name string, offset int64) {
+		f, err := os.OpenFile(filepath.Join(target, name), os.O_WRONLY, 0644)
+		wtest.Must(t, err)
+		_, err = f.WriteAt([]byte("nope"), offset)
+		wtest.Must(t, err)
+		wtest.Must(t, f.Close())
+	}
+	corrupt("corrupt", 0)
+	corrupt("corrupt", pwr.BlockSize*3+5)
+	corrupt("corrupt", pwr.BlockSize*4)
+	corrupt("corrupt", pwr.BlockSize*10+1)
+	corrupt("sub/large.dat", hashpool.ChunkSize)
+	corrupt("sub/large.dat", hashpool.ChunkSize*3-3)
+	wtest.Must(t, os.Truncate(filepath.Join(target, "truncated"), hashpool.ChunkSize+pwr.BlockSize/2))
+	wtest.Must(t, os.Remove(filepath.Join(target, "missing")))
+
+	// a single worker is deterministic, and is the reference
+	referencePath := filepath.Join(dir, "reference.pww")
+	vctx := &pwr.ValidatorContext{
+		WoundsPath: referencePath,
+		NumWorkers: 1,
+		Consumer:   &state.Consumer{},
+	}
+	wtest.Must(t, vctx.Validate(context.Background(), target, signature))
+	reference, err := ioutil.ReadFile(referencePath)
+	wtest.Must(t, err)
+	referenceCorrupted := vctx.WoundsConsumer.TotalCorrupted()
+	assert.True(t, referenceCorrupted > 0)
+
+	woundsPath := filepath.Join(dir, "wounds.pww")
+	validate := func(concurrency int) (*validator, []byte) {
+		v := &validator{
+			WoundsPath:  woundsPath,
+			Concurrency: concurrency,
+		}
+		wtest.Must(t, v.validate(context.Background(), target, signature))
+		assert.True(t, v.WoundsConsumer.HasWounds())
+
+		wounds, err := ioutil.ReadFile(woundsPath)
+		wtest.Must(t, err)
+		return v, wounds
+	}
+
+	for _, concurrency := range []int{1, 2, 8} {
+		v, wounds := validate(concurrency)
+		assert.EqualValues(t, referenceCorrupted, v.WoundsConsumer.TotalCorrupted())
+		assert.True(t, bytes.Equal(reference, wounds), "wounds with %d workers should match", concurrency)
+	}
+
+	// trailing data wounds the last block, instead of the
+	// negative-sized wounds pwr.ValidatorContext comes up with
+	f, err := os.OpenFile(filepath.Join(target, "extra"), os.O_APPEND|os.O_WRONLY, 0644)
+	wtest.Must(t, err)
+	_, err = f.Write([]byte("trailing"))
+	wtest.Must(t, err)
+	wtest.Must(t, f.Close())
+
+	v, reference := validate(1)
+	assert.EqualValues(t, referenceCorrupted+pwr.BlockSize, v.WoundsConsumer.TotalCorrupted())
+	for _, concurrency := range []int{2, 8} {
+		_, wounds := validate(concurrency)
+		assert.True(t, bytes.Equal(reference, wounds), "wounds with %d workers should match", concurrency)
+	}
+}
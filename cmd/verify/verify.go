@@ -2,10 +2,13 @@ package verify
 
 import (
 	"context"
+	"crypto/ed25519"
 	"time"
 
 	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/filtering"
 	"github.com/itchio/butler/mansion"
+	"github.com/itchio/butler/seal"
 	"github.com/itchio/httpkit/progress"
 	"github.com/itchio/savior/seeksource"
 	"github.com/itchio/wharf/eos"
@@ -14,10 +17,12 @@ import (
 )
 
 var args = struct {
-	signature *string
-	dir       *string
-	wounds    *string
-	heal      *string
+	signature   *string
+	dir         *string
+	wounds      *string
+	heal        *string
+	concurrency *int
+	pubkeys     *[]string
 }{}
 
 func Register(ctx *mansion.Context) {
@@ -26,14 +31,53 @@ func Register(ctx *mansion.Context) {
 	args.dir = cmd.Arg("dir", "Path of directory to verify").Required().String()
 	args.wounds = cmd.Flag("wounds", "When given, writes wounds to this path").String()
 	args.heal = cmd.Flag("heal", "When given, heal wounds using this path").String()
+	args.concurrency = cmd.Flag("concurrency", "Number of workers hashing files (negative for numbers of CPUs - j)").Default("-1").Int()
+	args.pubkeys = cmd.Flag("pubkey", "Require the signature to be sealed with this public key (or .pub file). May be given several times").Strings()
 	ctx.Register(cmd, do)
 }
 
 func do(ctx *mansion.Context) {
-	ctx.Must(Do(*args.signature, *args.dir, *args.wounds, *args.heal))
+	var publicKeys []ed25519.PublicKey
+	for _, s := range *args.pubkeys {
+		pub, err := seal.LoadPublicKey(s)
+		ctx.Must(err)
+		publicKeys = append(publicKeys, pub)
+	}
+
+	ctx.Must(Do(&Params{
+		SignaturePath: *args.signature,
+		Dir:           *args.dir,
+		WoundsPath:    *args.wounds,
+		HealPath:      *args.heal,
+		Concurrency:   *args.concurrency,
+		PublicKeys:    publicKeys,
+	}))
+}
+
+// Params controls how a directory is verified
+type Params struct {
+	SignaturePath string
+	Dir           string
+	// WoundsPath is where to write wounds, if any
+	WoundsPath string
+	// HealPath is where to heal wounds from, if any
+	HealPath string
+
+	// Concurrency is the number of workers hashing files, see hashpool.NumWorkers.
+	// Wounds are the same whatever the number of workers.
+	Concurrency int
+
+	// PublicKeys, if any, are the keys the signature file may be sealed
+	// with. Nothing is verified if it isn't sealed with one of them.
+	PublicKeys []ed25519.PublicKey
 }
 
-func Do(signaturePath string, dir string, woundsPath string, healPath string) error {
+func Do(params *Params) error {
+	signaturePath := params.SignaturePath
+	dir := params.Dir
+	woundsPath := params.WoundsPath
+	healPath := params.HealPath
+
 	if woundsPath == "" {
 		if healPath == "" {
 			comm.Opf("Verifying %s", dir)
@@ -55,6 +99,13 @@ func Do(signaturePath string, dir string, woundsPath string, healPath string) er
 	}
 	defer signatureReader.Close()
 
+	if len(params.PublicKeys) > 0 {
+		err = checkSeal(signatureReader, params.PublicKeys)
+		if err != nil {
+			return errors.Wrapf(err, "checking seal of %s", signaturePath)
+		}
+	}
+
 	signatureSource := seeksource.FromFile(signatureReader)
 
 	_, err = signatureSource.Resume(nil)
@@ -67,15 +118,29 @@ func Do(signaturePath string, dir string, woundsPath string, healPath string) er
 		return errors.Wrap(err, "reading signature file")
 	}
 
-	vc := &pwr.ValidatorContext{
-		Consumer:   comm.NewStateConsumer(),
-		WoundsPath: woundsPath,
-		HealPath:   healPath,
+	ignoreRules, err := filtering.LoadIgnoreFiles(dir, filtering.FilterPaths)
+	if err != nil {
+		return errors.Wrap(err, "reading ignore files")
+	}
+	_, exclusions := ignoreRules.Apply(signature.Container, "")
+	if len(exclusions) > 0 {
+		// those were pushed before the rules were added, or from somewhere else
+		comm.Warnf("%d entries in the signature are excluded by ignore files in %s", len(exclusions), dir)
+		for _, e := range exclusions {
+			comm.Debugf("%s is excluded by %s", e.Path, e.Rule)
+		}
+	}
+
+	vc := &validator{
+		Consumer:    comm.NewStateConsumer(),
+		WoundsPath:  woundsPath,
+		HealPath:    healPath,
+		Concurrency: params.Concurrency,
 	}
 
 	comm.StartProgressWithTotalBytes(signature.Container.Size)
 
-	err = vc.Validate(context.Background(), dir, signature)
+	err = vc.validate(context.Background(), dir, signature)
 	if err != nil {
 		return errors.Wrap(err, "while validating")
 	}
@@ -95,3 +160,21 @@ func Do(signaturePath string, dir string, woundsPath string, healPath string) er
 
 	return nil
 }
+
+func checkSeal(signatureReader eos.File, publicKeys []ed25519.PublicKey) error {
+	stats, err := signatureReader.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	s, err := seal.Check(signatureReader, stats.Size(), publicKeys...)
+	if err != nil {
+		if err == seal.ErrUnknownKey {
+			return errors.Errorf("sealed with key %s, which isn't one of the keys given", seal.Fingerprint(s.PublicKey))
+		}
+		return err
+	}
+
+	comm.Logf("Signature sealed with key %s", seal.Fingerprint(s.PublicKey))
+	return nil
+}
@@ -0,0 +1,195 @@
+package wait
+
+import (
+	"time"
+
+	"github.com/itchio/butler/cmd/status"
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/httpkit/progress"
+	"github.com/pkg/errors"
+)
+
+// how many API calls in a row may fail before we give up
+const maxConsecutiveErrors = 5
+
+// how often to remind the user we're still waiting
+const heartbeatInterval = time.Minute
+
+var args = struct {
+	target   *string
+	buildID  *int64
+	timeout  *time.Duration
+	interval *time.Duration
+}{}
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("wait", "Wait until a build is done processing. Exits with a non-zero code if processing failed or took too long.")
+	args.target = cmd.Arg("target", "Which user/project:channel to wait for the latest build of, for example 'leafo/x-moon:win-64'").Required().String()
+	args.buildID = cmd.Flag("build", "Wait for this build instead of the latest one of the channel").Int64()
+	args.timeout = cmd.Flag("timeout", "How long to wait for before giving up").Default("30m").Duration()
+	args.interval = cmd.Flag("interval", "How long to wait between checks").Default("10s").Duration()
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	go ctx.DoVersionCheck()
+	_, err := Do(ctx, &Params{
+		Target:  *args.target,
+		BuildID: *args.buildID,
+		Options: Options{
+			Timeout:  *args.timeout,
+			Interval: *args.interval,
+		},
+	})
+	ctx.Must(err)
+}
+
+// Options control how long to wait for a build, and how often to check on it
+type Options struct {
+	// Timeout is how long to wait for before giving up, 0 means forever
+	Timeout time.Duration
+	// Interval is how long to wait between checks
+	Interval time.Duration
+}
+
+func (opts *Options) validate() error {
+	if opts.Interval <= 0 {
+		return errors.Errorf("interval between checks must be positive (got %s)", opts.Interval)
+	}
+	return nil
+}
+
+// Params controls which build to wait for
+type Params struct {
+	// Target is of the form project:channel. The channel may be omitted if BuildID is set.
+	Target string
+	// BuildID is the build to wait for. If 0, the channel's pending build
+	// (or its latest build, if nothing is pending) is used.
+	BuildID int64
+
+	Options Options
+}
+
+// Do waits for a build to be done processing and returns it. It returns
+// an error if processing failed or didn't finish in time.
+func Do(ctx *mansion.Context, params *Params) (*itchio.Build, error) {
+	err := params.Options.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	spec, err := itchio.ParseSpec(params.Target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing target '%s'", params.Target)
+	}
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return nil, errors.Wrap(err, "authenticating")
+	}
+
+	buildID := params.BuildID
+	if buildID == 0 {
+		err = spec.EnsureChannel()
+		if err != nil {
+			return nil, err
+		}
+
+		chanInfo, err := client.GetChannel(spec.Target, spec.Channel)
+		if err != nil {
+			return nil, errors.Wrapf(err, "looking up channel %s", spec.Channel)
+		}
+
+		ch := chanInfo.Channel
+		switch {
+		case ch == nil:
+			return nil, errors.Errorf("channel %s not found for %s", spec.Channel, spec.Target)
+		case ch.Pending != nil:
+			buildID = ch.Pending.ID
+		case ch.Head != nil:
+			buildID = ch.Head.ID
+		default:
+			return nil, errors.Errorf("channel %s of %s has no builds yet", spec.Channel, spec.Target)
+		}
+	}
+
+	return ForBuild(client, buildID, &params.Options)
+}
+
+// ForBuild polls a build until it's done processing, logging its progress
+func ForBuild(client *itchio.Client, buildID int64, opts *Options) (*itchio.Build, error) {
+	comm.Opf("Waiting for build #%d to be processed...", buildID)
+
+	getBuild := func() (*itchio.Build, error) {
+		res, err := client.GetBuild(itchio.GetBuildParams{BuildID: buildID})
+		if err != nil {
+			return nil, err
+		}
+		return res.Build, nil
+	}
+
+	return poll(buildID, getBuild, opts)
+}
+
+func poll(buildID int64, getBuild func() (*itchio.Build, error), opts *Options) (*itchio.Build, error) {
+	err := opts.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	startTime := time.Now()
+	lastHeartbeat := startTime
+	var lastState itchio.BuildState
+	var lastErr error
+	numErrors := 0
+
+	for {
+		build, err := getBuild()
+		if err != nil {
+			numErrors++
+			lastErr = err
+			if numErrors >= maxConsecutiveErrors {
+				return nil, errors.Wrapf(err, "checking on build #%d", buildID)
+			}
+			comm.Debugf("Could not check on build #%d, will retry: %s", buildID, err.Error())
+		} else {
+			numErrors = 0
+			elapsed := progress.FormatDuration(time.Since(startTime))
+
+			switch build.State {
+			case itchio.BuildStateCompleted:
+				comm.Statf("Build #%d is live, after %s", buildID, elapsed)
+				return build, nil
+			case itchio.BuildStateFailed:
+				comm.Logf("%s", status.BuildState(build))
+				return build, errors.Errorf("build #%d failed processing", buildID)
+			}
+
+			if build.State != lastState {
+				comm.Logf("%s", status.BuildState(build))
+				lastState = build.State
+				lastHeartbeat = time.Now()
+			} else if time.Since(lastHeartbeat) >= heartbeatInterval {
+				comm.Logf("Still %s after %s...", build.State, elapsed)
+				lastHeartbeat = time.Now()
+			}
+		}
+
+		sleep := opts.Interval
+		if opts.Timeout > 0 {
+			if time.Since(startTime) >= opts.Timeout {
+				if lastState == "" && lastErr != nil {
+					return nil, errors.Wrapf(lastErr, "timed out after %s checking on build #%d", opts.Timeout, buildID)
+				}
+				return nil, errors.Errorf("timed out after %s, build #%d is still %s", opts.Timeout, buildID, lastState)
+			}
+			// check one last time right when the timeout is up
+			if remaining := opts.Timeout - time.Since(startTime); remaining < sleep {
+				sleep = remaining
+			}
+		}
+		time.Sleep(sleep)
+	}
+}
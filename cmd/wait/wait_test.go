@@ -0,0 +1,53 @@
+package wait
+
+import (
+	"testing"
+	"time"
+
+	itchio "github.com/itchio/go-itchio"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func states(list ...interface{}) func() (*itchio.Build, error) {
+	i := 0
+	return func() (*itchio.Build, error) {
+		item := list[i]
+		if i < len(list)-1 {
+			i++
+		}
+		if err, ok := item.(error); ok {
+			return nil, err
+		}
+		return &itchio.Build{ID: 12, ParentBuildID: -1, State: item.(itchio.BuildState)}, nil
+	}
+}
+
+func TestPoll(t *testing.T) {
+	opts := &Options{Interval: time.Millisecond}
+
+	build, err := poll(12, states(itchio.BuildStateStarted, itchio.BuildStateProcessing, errors.New("network hiccup"), itchio.BuildStateCompleted), opts)
+	assert.NoError(t, err)
+	assert.EqualValues(t, itchio.BuildStateCompleted, build.State)
+
+	_, err = poll(12, states(itchio.BuildStateProcessing, itchio.BuildStateFailed), opts)
+	assert.Error(t, err, "failed builds are errors")
+
+	_, err = poll(12, states(errors.New("server down")), opts)
+	assert.Error(t, err, "gives up after too many errors")
+
+	opts.Timeout = 20 * time.Millisecond
+	_, err = poll(12, states(itchio.BuildStateProcessing), opts)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "still processing")
+
+	// an interval longer than the timeout still gets a last check in
+	opts.Interval = time.Hour
+	build, err = poll(12, states(itchio.BuildStateProcessing, itchio.BuildStateCompleted), opts)
+	assert.NoError(t, err)
+	assert.EqualValues(t, itchio.BuildStateCompleted, build.State)
+
+	opts.Interval = 0
+	_, err = poll(12, states(itchio.BuildStateCompleted), opts)
+	assert.Error(t, err, "interval must be positive")
+}
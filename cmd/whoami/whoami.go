@@ -0,0 +1,89 @@
+package whoami
+
+import (
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/mansion"
+	itchio "github.com/itchio/go-itchio"
+	"github.com/pkg/errors"
+)
+
+func Register(ctx *mansion.Context) {
+	cmd := ctx.App.Command("whoami", "Show which itch.io account butler is using, and list saved profiles.")
+	ctx.Register(cmd, do)
+}
+
+func do(ctx *mansion.Context) {
+	ctx.Must(Do(ctx))
+}
+
+// Result describes the account butler would use
+type Result struct {
+	// Profile is the name of the profile in use, if any
+	Profile string `json:"profile,omitempty"`
+	// Source is where the API key came from: the environment or a key file
+	Source string `json:"source"`
+	// User is the account the API key belongs to
+	User *itchio.User `json:"user"`
+	// Profiles lists all saved profiles
+	Profiles []string `json:"profiles"`
+}
+
+func Do(ctx *mansion.Context) error {
+	profiles, err := ctx.ListProfiles()
+	if err != nil {
+		return errors.WithMessage(err, "listing profiles")
+	}
+
+	if !ctx.HasSavedCredentials() {
+		if ctx.Profile != "" {
+			return errors.Errorf("not logged in with profile %s, use `butler login --profile %s` first", ctx.Profile, ctx.Profile)
+		}
+		return errors.New("not logged in, use `butler login` first")
+	}
+
+	client, err := ctx.AuthenticateViaOauth()
+	if err != nil {
+		return errors.Wrap(err, "authenticating")
+	}
+
+	res, err := client.GetProfile()
+	if err != nil {
+		return errors.Wrap(err, "fetching profile")
+	}
+
+	result := &Result{
+		Profile:  ctx.Profile,
+		Source:   ctx.KeyPath(),
+		User:     res.User,
+		Profiles: profiles,
+	}
+	if ctx.UsingEnvironmentKey() {
+		result.Source = "environment"
+	}
+
+	comm.ResultOrPrint(result, func() {
+		user := result.User
+		if user.DisplayName != "" && user.DisplayName != user.Username {
+			comm.Statf("Logged in as %s (%s), user #%d", user.Username, user.DisplayName, user.ID)
+		} else {
+			comm.Statf("Logged in as %s, user #%d", user.Username, user.ID)
+		}
+
+		if result.Source == "environment" {
+			comm.Logf("Using the API key from $BUTLER_API_KEY")
+		} else if result.Profile != "" {
+			comm.Logf("Using profile %s (%s)", result.Profile, result.Source)
+		} else {
+			comm.Logf("Using default credentials (%s)", result.Source)
+		}
+
+		if len(result.Profiles) > 0 {
+			comm.Logf("")
+			comm.Logf("Saved profiles:")
+			for _, name := range result.Profiles {
+				comm.Logf("  %s", name)
+			}
+		}
+	})
+	return nil
+}
@@ -4,11 +4,14 @@ import (
 	"github.com/itchio/butler/cmd/apply"
 	"github.com/itchio/butler/cmd/apply2"
 	"github.com/itchio/butler/cmd/auditzip"
+	"github.com/itchio/butler/cmd/builds"
+	"github.com/itchio/butler/cmd/cache"
 	"github.com/itchio/butler/cmd/clean"
 	"github.com/itchio/butler/cmd/configure"
 	"github.com/itchio/butler/cmd/cp"
 	"github.com/itchio/butler/cmd/daemon"
 	"github.com/itchio/butler/cmd/diff"
+	"github.com/itchio/butler/cmd/diffremote"
 	"github.com/itchio/butler/cmd/ditto"
 	"github.com/itchio/butler/cmd/dl"
 	"github.com/itchio/butler/cmd/elevate"
@@ -18,9 +21,11 @@ import (
 	"github.com/itchio/butler/cmd/fetch"
 	"github.com/itchio/butler/cmd/file"
 	"github.com/itchio/butler/cmd/heal"
+	"github.com/itchio/butler/cmd/keygen"
 	"github.com/itchio/butler/cmd/login"
 	"github.com/itchio/butler/cmd/logout"
 	"github.com/itchio/butler/cmd/ls"
+	"github.com/itchio/butler/cmd/mirror"
 	"github.com/itchio/butler/cmd/mkdir"
 	"github.com/itchio/butler/cmd/mkzip"
 	"github.com/itchio/butler/cmd/msi"
@@ -31,9 +36,11 @@ import (
 	"github.com/itchio/butler/cmd/rediff"
 	"github.com/itchio/butler/cmd/repack"
 	"github.com/itchio/butler/cmd/run"
+	"github.com/itchio/butler/cmd/sigdiff"
 	"github.com/itchio/butler/cmd/sign"
 	"github.com/itchio/butler/cmd/singlediff"
 	"github.com/itchio/butler/cmd/sizeof"
+	"github.com/itchio/butler/cmd/squash"
 	"github.com/itchio/butler/cmd/status"
 	"github.com/itchio/butler/cmd/unsz"
 	"github.com/itchio/butler/cmd/untar"
@@ -42,8 +49,10 @@ import (
 	"github.com/itchio/butler/cmd/validate"
 	"github.com/itchio/butler/cmd/verify"
 	"github.com/itchio/butler/cmd/version"
+	"github.com/itchio/butler/cmd/wait"
 	"github.com/itchio/butler/cmd/walk"
 	"github.com/itchio/butler/cmd/which"
+	"github.com/itchio/butler/cmd/whoami"
 	"github.com/itchio/butler/cmd/fujicmd"
 	"github.com/itchio/butler/cmd/wipe"
 	"github.com/itchio/butler/mansion"
@@ -56,10 +65,16 @@ func registerCommands(ctx *mansion.Context) {
 
 	login.Register(ctx)
 	logout.Register(ctx)
+	whoami.Register(ctx)
 
 	push.Register(ctx)
 	fetch.Register(ctx)
 	status.Register(ctx)
+	builds.Register(ctx)
+	mirror.Register(ctx)
+	cache.Register(ctx)
+	wait.Register(ctx)
+	diffremote.Register(ctx)
 
 	file.Register(ctx)
 	ls.Register(ctx)
@@ -68,11 +83,14 @@ func registerCommands(ctx *mansion.Context) {
 	version.Register(ctx)
 	upgrade.Register(ctx)
 
+	keygen.Register(ctx)
 	sign.Register(ctx)
 	verify.Register(ctx)
+	sigdiff.Register(ctx)
 	diff.Register(ctx)
 	apply.Register(ctx)
 	heal.Register(ctx)
+	squash.Register(ctx)
 
 	// hidden commands
 
@@ -0,0 +1,131 @@
+package containerdiff
+
+import (
+	"os"
+
+	"github.com/itchio/wharf/tlc"
+)
+
+// A Change is a difference between two containers
+type Change struct {
+	Path string `json:"path"`
+	// Kind is "file", "dir" or "symlink"
+	Kind string `json:"kind"`
+	// Size is the size of the file in the new container, or in the old
+	// one if it was removed
+	Size int64 `json:"size,omitempty"`
+	// OldSize is the size of a modified file in the old container
+	OldSize int64 `json:"oldSize,omitempty"`
+	// ChangedBytes is an estimate of how much of a modified file changed,
+	// when the new contents are available to check against
+	ChangedBytes int64 `json:"changedBytes,omitempty"`
+	// MinChangedBytes and MaxChangedBytes bound how many bytes of a
+	// modified file differ (counting the size difference), when only
+	// block hashes are available.
+	MinChangedBytes int64 `json:"minChangedBytes,omitempty"`
+	MaxChangedBytes int64 `json:"maxChangedBytes,omitempty"`
+	// Mode is the permissions of the entry in the new container,
+	// OldMode those in the old container, if they changed.
+	Mode    os.FileMode `json:"mode,omitempty"`
+	OldMode os.FileMode `json:"oldMode,omitempty"`
+	// Dest and OldDest are where a symlink points to
+	Dest    string `json:"dest,omitempty"`
+	OldDest string `json:"oldDest,omitempty"`
+}
+
+// Changes lists the differences between two containers
+type Changes struct {
+	Added              []*Change `json:"added"`
+	Removed            []*Change `json:"removed"`
+	Modified           []*Change `json:"modified"`
+	PermissionsChanged []*Change `json:"permissionsChanged"`
+}
+
+// A CompareFileFunc is called for files present in both containers. It fills
+// in how the file changed in c and returns true if it was modified.
+type CompareFileFunc func(oldFile *tlc.File, newFile *tlc.File, c *Change) bool
+
+// Compare lists what was added, removed, modified, and had its permissions
+// changed between two containers. How files present in both compare is up
+// to compareFile.
+func Compare(oldContainer *tlc.Container, newContainer *tlc.Container, compareFile CompareFileFunc) *Changes {
+	changes := &Changes{}
+
+	perm := func(mode uint32) os.FileMode {
+		return os.FileMode(mode).Perm()
+	}
+
+	oldDirs := make(map[string]*tlc.Dir)
+	for _, d := range oldContainer.Dirs {
+		oldDirs[d.Path] = d
+	}
+	newDirs := make(map[string]bool)
+	for _, d := range newContainer.Dirs {
+		newDirs[d.Path] = true
+		old, ok := oldDirs[d.Path]
+		if !ok {
+			changes.Added = append(changes.Added, &Change{Path: d.Path, Kind: "dir", Mode: perm(d.Mode)})
+		} else if perm(old.Mode) != perm(d.Mode) {
+			changes.PermissionsChanged = append(changes.PermissionsChanged, &Change{Path: d.Path, Kind: "dir", Mode: perm(d.Mode), OldMode: perm(old.Mode)})
+		}
+	}
+	for _, d := range oldContainer.Dirs {
+		if !newDirs[d.Path] {
+			changes.Removed = append(changes.Removed, &Change{Path: d.Path, Kind: "dir", Mode: perm(d.Mode)})
+		}
+	}
+
+	oldSymlinks := make(map[string]*tlc.Symlink)
+	for _, s := range oldContainer.Symlinks {
+		oldSymlinks[s.Path] = s
+	}
+	newSymlinks := make(map[string]bool)
+	for _, s := range newContainer.Symlinks {
+		newSymlinks[s.Path] = true
+		c := &Change{Path: s.Path, Kind: "symlink", Dest: s.Dest}
+		if old, ok := oldSymlinks[s.Path]; !ok {
+			changes.Added = append(changes.Added, c)
+		} else if old.Dest != s.Dest {
+			c.OldDest = old.Dest
+			changes.Modified = append(changes.Modified, c)
+		}
+	}
+	for _, s := range oldContainer.Symlinks {
+		if !newSymlinks[s.Path] {
+			changes.Removed = append(changes.Removed, &Change{Path: s.Path, Kind: "symlink", Dest: s.Dest})
+		}
+	}
+
+	oldFiles := make(map[string]*tlc.File)
+	for _, f := range oldContainer.Files {
+		oldFiles[f.Path] = f
+	}
+	newFiles := make(map[string]bool)
+	for _, f := range newContainer.Files {
+		newFiles[f.Path] = true
+		c := &Change{Path: f.Path, Kind: "file", Size: f.Size, Mode: perm(f.Mode)}
+
+		old, ok := oldFiles[f.Path]
+		if !ok {
+			changes.Added = append(changes.Added, c)
+			continue
+		}
+
+		if perm(old.Mode) != perm(f.Mode) {
+			pc := *c
+			pc.OldMode = perm(old.Mode)
+			changes.PermissionsChanged = append(changes.PermissionsChanged, &pc)
+		}
+
+		if compareFile(old, f, c) {
+			changes.Modified = append(changes.Modified, c)
+		}
+	}
+	for _, f := range oldContainer.Files {
+		if !newFiles[f.Path] {
+			changes.Removed = append(changes.Removed, &Change{Path: f.Path, Kind: "file", Size: f.Size, Mode: perm(f.Mode)})
+		}
+	}
+
+	return changes
+}
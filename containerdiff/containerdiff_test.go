@@ -0,0 +1,130 @@
+package containerdiff_test
+
+import (
+	"testing"
+
+	"github.com/itchio/butler/containerdiff"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompare(t *testing.T) {
+	oldContainer := &tlc.Container{
+		Dirs: []*tlc.Dir{
+			{Path: "data", Mode: 0755},
+			{Path: "old", Mode: 0755},
+		},
+		Files: []*tlc.File{
+			{Path: "data/a.dat", Mode: 0644, Size: 10},
+			{Path: "data/b.dat", Mode: 0644, Size: 20},
+			{Path: "game", Mode: 0644, Size: 30},
+			{Path: "old/c.dat", Mode: 0644, Size: 40},
+		},
+		Symlinks: []*tlc.Symlink{
+			{Path: "launch", Mode: 0777, Dest: "game"},
+		},
+	}
+	newContainer := &tlc.Container{
+		Dirs: []*tlc.Dir{
+			{Path: "data", Mode: 0755},
+			{Path: "new", Mode: 0755},
+		},
+		Files: []*tlc.File{
+			{Path: "data/a.dat", Mode: 0644, Size: 10},
+			{Path: "data/b.dat", Mode: 0644, Size: 25},
+			{Path: "game", Mode: 0755, Size: 30},
+			{Path: "new/d.dat", Mode: 0644, Size: 50},
+		},
+		Symlinks: []*tlc.Symlink{
+			{Path: "launch", Mode: 0777, Dest: "game.sh"},
+		},
+	}
+
+	var candidates []*tlc.File
+	changes := containerdiff.Compare(oldContainer, newContainer, func(oldFile *tlc.File, newFile *tlc.File, c *containerdiff.Change) bool {
+		if oldFile.Size != newFile.Size {
+			c.OldSize = oldFile.Size
+			return true
+		}
+		candidates = append(candidates, oldFile)
+		return false
+	})
+
+	paths := func(changes []*containerdiff.Change) []string {
+		var res []string
+		for _, c := range changes {
+			res = append(res, c.Path)
+		}
+		return res
+	}
+	assert.EqualValues(t, []string{"new", "new/d.dat"}, paths(changes.Added))
+	assert.EqualValues(t, []string{"old", "old/c.dat"}, paths(changes.Removed))
+	assert.EqualValues(t, []string{"launch", "data/b.dat"}, paths(changes.Modified))
+	assert.EqualValues(t, []string{"game"}, paths(changes.PermissionsChanged))
+	assert.EqualValues(t, 0644, changes.PermissionsChanged[0].OldMode)
+	assert.EqualValues(t, 0755, changes.PermissionsChanged[0].Mode)
+	assert.EqualValues(t, 20, changes.Modified[1].OldSize)
+	assert.EqualValues(t, 25, changes.Modified[1].Size)
+
+	// same size, left to the callback
+	assert.EqualValues(t, []string{"data/a.dat", "game"}, func() []string {
+		var res []string
+		for _, f := range candidates {
+			res = append(res, f.Path)
+		}
+		return res
+	}())
+}
+
+func TestSubSignature(t *testing.T) {
+	bs := int64(pwr.BlockSize)
+	sig := &pwr.SignatureInfo{
+		Container: &tlc.Container{
+			Files: []*tlc.File{
+				{Path: "a", Size: bs + 1},
+				{Path: "empty", Size: 0},
+				{Path: "b", Size: 2 * bs},
+				{Path: "c", Size: 5},
+			},
+		},
+	}
+	for i := 0; i < 6; i++ {
+		sig.Hashes = append(sig.Hashes, wsync.BlockHash{WeakHash: uint32(i)})
+	}
+
+	sub := containerdiff.SubSignature(sig, []*tlc.File{sig.Container.Files[1], sig.Container.Files[2]})
+	assert.EqualValues(t, 2, len(sub.Container.Files))
+	assert.EqualValues(t, 2*bs, sub.Container.Size)
+	assert.EqualValues(t, 0, sub.Container.Files[1].Offset)
+
+	var weakHashes []uint32
+	for _, h := range sub.Hashes {
+		weakHashes = append(weakHashes, h.WeakHash)
+	}
+	assert.EqualValues(t, []uint32{2, 3, 4}, weakHashes)
+}
+
+func TestFileHashes(t *testing.T) {
+	bs := int64(pwr.BlockSize)
+	sig := &pwr.SignatureInfo{
+		Container: &tlc.Container{
+			Files: []*tlc.File{
+				{Path: "a", Size: bs + 1},
+				{Path: "empty", Size: 0},
+				{Path: "b", Size: 2 * bs},
+			},
+		},
+	}
+	// truncated: "b" is missing its last block
+	for i := 0; i < 4; i++ {
+		sig.Hashes = append(sig.Hashes, wsync.BlockHash{WeakHash: uint32(i)})
+	}
+
+	hashes := containerdiff.FileHashes(sig)
+	assert.EqualValues(t, 2, len(hashes["a"]))
+	assert.EqualValues(t, 1, len(hashes["empty"]))
+	assert.EqualValues(t, 1, len(hashes["b"]))
+	assert.EqualValues(t, 3, hashes["b"][0].WeakHash)
+}
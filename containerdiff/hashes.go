@@ -0,0 +1,67 @@
+package containerdiff
+
+import (
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+)
+
+// eachFile calls cb with each file of a signature and its block hashes.
+// Files past the end of a truncated signature get fewer hashes, or none.
+func eachFile(sig *pwr.SignatureInfo, cb func(f *tlc.File, hashes []wsync.BlockHash)) {
+	hashIndex := int64(0)
+	for _, f := range sig.Container.Files {
+		// empty files have a 0-length shortblock, see pwr.ValidatingPool
+		numBlocks := int64(1)
+		if f.Size > 0 {
+			numBlocks = pwr.ComputeNumBlocks(f.Size)
+		}
+
+		start := hashIndex
+		end := hashIndex + numBlocks
+		if end > int64(len(sig.Hashes)) {
+			end = int64(len(sig.Hashes))
+		}
+		if start > end {
+			start = end
+		}
+		cb(f, sig.Hashes[start:end])
+		hashIndex += numBlocks
+	}
+}
+
+// FileHashes returns the block hashes of each file of a signature, by path
+func FileHashes(sig *pwr.SignatureInfo) map[string][]wsync.BlockHash {
+	res := make(map[string][]wsync.BlockHash)
+	eachFile(sig, func(f *tlc.File, hashes []wsync.BlockHash) {
+		if len(hashes) > 0 {
+			res[f.Path] = hashes
+		}
+	})
+	return res
+}
+
+// SubSignature returns the part of a signature that covers the given files
+func SubSignature(sig *pwr.SignatureInfo, files []*tlc.File) *pwr.SignatureInfo {
+	wanted := make(map[string]bool)
+	for _, f := range files {
+		wanted[f.Path] = true
+	}
+
+	res := &pwr.SignatureInfo{
+		Container: &tlc.Container{},
+	}
+
+	eachFile(sig, func(f *tlc.File, hashes []wsync.BlockHash) {
+		if !wanted[f.Path] {
+			return
+		}
+		nf := *f
+		nf.Offset = res.Container.Size
+		res.Container.Size += nf.Size
+		res.Container.Files = append(res.Container.Files, &nf)
+		res.Hashes = append(res.Hashes, hashes...)
+	})
+
+	return res
+}
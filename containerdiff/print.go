@@ -0,0 +1,80 @@
+package containerdiff
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/itchio/butler/comm"
+	"github.com/itchio/httpkit/progress"
+	"github.com/olekukonko/tablewriter"
+)
+
+// Print shows changes as a table, followed by how many of each kind
+// there are. It returns false without printing anything if there are
+// no changes at all.
+func Print(changes *Changes) bool {
+	var rows [][]string
+	appendRows := func(change string, list []*Change, details func(c *Change) string) {
+		for _, c := range list {
+			path := c.Path
+			size := ""
+			switch c.Kind {
+			case "dir":
+				path += "/"
+			case "file":
+				size = progress.FormatBytes(c.Size)
+			}
+			rows = append(rows, []string{change, path, size, details(c)})
+		}
+	}
+
+	appendRows("added", changes.Added, func(c *Change) string {
+		if c.Kind == "symlink" {
+			return "-> " + c.Dest
+		}
+		return ""
+	})
+	appendRows("removed", changes.Removed, func(c *Change) string {
+		return ""
+	})
+	appendRows("modified", changes.Modified, modifiedDetails)
+	appendRows("permissions", changes.PermissionsChanged, func(c *Change) string {
+		return fmt.Sprintf("%s -> %s", c.OldMode, c.Mode)
+	})
+
+	if len(rows) == 0 {
+		return false
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"Change", "Path", "Size", "Details"})
+	table.AppendBulk(rows)
+	table.Render()
+
+	comm.Statf("%d added, %d removed, %d modified, %d with new permissions",
+		len(changes.Added), len(changes.Removed), len(changes.Modified), len(changes.PermissionsChanged))
+	return true
+}
+
+func modifiedDetails(c *Change) string {
+	if c.Kind == "symlink" {
+		return fmt.Sprintf("-> %s, was -> %s", c.Dest, c.OldDest)
+	}
+
+	var details string
+	switch {
+	case c.MaxChangedBytes > 0:
+		details = fmt.Sprintf("%s to %s changed", progress.FormatBytes(c.MinChangedBytes), progress.FormatBytes(c.MaxChangedBytes))
+	case c.OldSize == c.Size:
+		details = fmt.Sprintf("~%s changed", progress.FormatBytes(c.ChangedBytes))
+	}
+
+	if c.OldSize != c.Size {
+		was := fmt.Sprintf("was %s", progress.FormatBytes(c.OldSize))
+		if details == "" {
+			return was
+		}
+		return was + ", " + details
+	}
+	return details
+}
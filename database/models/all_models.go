@@ -26,4 +26,5 @@ var AllModels = []interface{}{
 	&FetchInfo{},
 	&GameUpload{},
 	&CaveHistoricalPlayTime{},
+	&PinnedKey{},
 }
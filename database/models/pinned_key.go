@@ -0,0 +1,26 @@
+package models
+
+import (
+	"crawshaw.io/sqlite"
+	"github.com/go-xorm/builder"
+)
+
+// A PinnedKey is the public key builds of a game must be sealed with,
+// see butlerd.InstallKeysPinParams
+type PinnedKey struct {
+	GameID int64 `json:"gameId" hades:"primary_key"`
+
+	// PublicKey is of the form "ed25519:<base64>"
+	PublicKey string `json:"publicKey"`
+	// Policy is a butlerd.SealPolicy
+	Policy string `json:"policy"`
+}
+
+// PinnedKeyForGame returns the key pinned for a game, or nil
+func PinnedKeyForGame(conn *sqlite.Conn, gameID int64) *PinnedKey {
+	var pk PinnedKey
+	if MustSelectOne(conn, &pk, builder.Eq{"game_id": gameID}) {
+		return &pk
+	}
+	return nil
+}
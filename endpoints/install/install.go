@@ -18,6 +18,9 @@ func Register(router *butlerd.Router) {
 	messages.InstallLocationsAdd.Register(router, InstallLocationsAdd)
 	messages.InstallLocationsRemove.Register(router, InstallLocationsRemove)
 	messages.InstallLocationsScan.Register(router, InstallLocationsScan)
+	messages.InstallKeysPin.Register(router, InstallKeysPin)
+	messages.InstallKeysUnpin.Register(router, InstallKeysUnpin)
+	messages.InstallKeysGet.Register(router, InstallKeysGet)
 
 	messages.CavesSetPinned.Register(router, CavesSetPinned)
 }
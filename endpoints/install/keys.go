@@ -0,0 +1,70 @@
+package install
+
+import (
+	"github.com/go-xorm/builder"
+	"github.com/itchio/butler/butlerd"
+	"github.com/itchio/butler/database/models"
+	"github.com/itchio/butler/seal"
+	"github.com/pkg/errors"
+)
+
+func InstallKeysPin(rc *butlerd.RequestContext, params butlerd.InstallKeysPinParams) (*butlerd.InstallKeysPinResult, error) {
+	pub, err := seal.ParsePublicKey(params.PublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing public key")
+	}
+
+	policy := params.Policy
+	if policy == "" {
+		policy = butlerd.SealPolicyRefuse
+	}
+
+	pk := &models.PinnedKey{
+		GameID:    params.GameID,
+		PublicKey: seal.FormatPublicKey(pub),
+		Policy:    string(policy),
+	}
+
+	conn := rc.GetConn()
+	defer rc.PutConn(conn)
+	models.MustSave(conn, pk)
+
+	rc.Consumer.Infof("Pinned key %s for game %d (%s)", seal.Fingerprint(pub), params.GameID, policy)
+
+	res := &butlerd.InstallKeysPinResult{
+		PinnedKey: FormatPinnedKey(pk),
+	}
+	return res, nil
+}
+
+func InstallKeysUnpin(rc *butlerd.RequestContext, params butlerd.InstallKeysUnpinParams) (*butlerd.InstallKeysUnpinResult, error) {
+	conn := rc.GetConn()
+	defer rc.PutConn(conn)
+	models.MustDelete(conn, &models.PinnedKey{}, builder.Eq{"game_id": params.GameID})
+
+	res := &butlerd.InstallKeysUnpinResult{}
+	return res, nil
+}
+
+func InstallKeysGet(rc *butlerd.RequestContext, params butlerd.InstallKeysGetParams) (*butlerd.InstallKeysGetResult, error) {
+	conn := rc.GetConn()
+	defer rc.PutConn(conn)
+
+	res := &butlerd.InstallKeysGetResult{}
+	if pk := models.PinnedKeyForGame(conn, params.GameID); pk != nil {
+		res.PinnedKey = FormatPinnedKey(pk)
+	}
+	return res, nil
+}
+
+func FormatPinnedKey(pk *models.PinnedKey) *butlerd.PinnedKey {
+	fpk := &butlerd.PinnedKey{
+		GameID:    pk.GameID,
+		PublicKey: pk.PublicKey,
+		Policy:    butlerd.SealPolicy(pk.Policy),
+	}
+	if pub, err := seal.ParsePublicKey(pk.PublicKey); err == nil {
+		fpk.Fingerprint = seal.Fingerprint(pub)
+	}
+	return fpk
+}
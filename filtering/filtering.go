@@ -29,3 +29,23 @@ func FilterPaths(fileInfo os.FileInfo) bool {
 
 	return true
 }
+
+// FilterPathsWith returns a filter that ignores everything FilterPaths
+// ignores, along with any folder/file matching one of extraPatterns
+func FilterPathsWith(extraPatterns []string) func(fileInfo os.FileInfo) bool {
+	return func(fileInfo os.FileInfo) bool {
+		if !FilterPaths(fileInfo) {
+			return false
+		}
+
+		name := fileInfo.Name()
+		for _, pattern := range extraPatterns {
+			match, _ := filepath.Match(pattern, name)
+			if match {
+				return false
+			}
+		}
+
+		return true
+	}
+}
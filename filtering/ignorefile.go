@@ -0,0 +1,424 @@
+package filtering
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/itchio/wharf/tlc"
+	"github.com/pkg/errors"
+)
+
+// IgnoreFileName is the name of the files listing paths to exclude,
+// with the same syntax as .gitignore files. They can be placed
+// at any depth of a build folder, and apply to the folder they're in.
+const IgnoreFileName = ".butlerignore"
+
+// An IgnoreRule is a single pattern from an ignore file
+type IgnoreRule struct {
+	// Source is the path of the ignore file this rule comes from,
+	// relative to the folder being walked
+	Source string
+	// Line is the line number of the rule in its ignore file
+	Line int
+	// Pattern is the rule as it was written
+	Pattern string
+
+	// base is the folder the ignore file is in, in slash form
+	base    string
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+func (r *IgnoreRule) String() string {
+	if r.Line == 0 {
+		return fmt.Sprintf("%s (%s)", r.Pattern, r.Source)
+	}
+	return fmt.Sprintf("%s (%s:%d)", r.Pattern, r.Source, r.Line)
+}
+
+// ignoreFileRule excludes the ignore files themselves, they're only meant for butler
+var ignoreFileRule = &IgnoreRule{
+	Source:  "built-in",
+	Pattern: IgnoreFileName,
+}
+
+// IgnoreRules is the set of rules from all the ignore files of a folder
+type IgnoreRules struct {
+	rules []*IgnoreRule
+
+	dirCache map[string]*IgnoreRule
+}
+
+// An Exclusion is a container entry that was excluded by an ignore rule
+type Exclusion struct {
+	Path string
+	Rule *IgnoreRule
+}
+
+// ParseIgnoreFile reads gitignore-style rules from r. source is the path of the
+// ignore file, and base the folder it's in, both relative to the walked folder.
+func ParseIgnoreFile(source string, base string, r io.Reader) ([]*IgnoreRule, error) {
+	var rules []*IgnoreRule
+
+	base = filepath.ToSlash(base)
+	if base == "." {
+		base = ""
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !strings.HasSuffix(line, "\\ ") {
+			line = strings.TrimRight(line, " \t")
+		}
+		if line == "" {
+			continue
+		}
+
+		rule := &IgnoreRule{
+			Source:  source,
+			Line:    lineNumber,
+			Pattern: line,
+			base:    base,
+		}
+
+		pattern := line
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+			pattern = pattern[1:]
+		}
+
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+		if pattern == "" {
+			continue
+		}
+
+		re, err := compileIgnorePattern(pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s:%d", source, lineNumber)
+		}
+		rule.re = re
+
+		rules = append(rules, rule)
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return rules, nil
+}
+
+// compileIgnorePattern turns a gitignore pattern into a regular expression
+// matching slash-separated paths relative to the ignore file's folder.
+func compileIgnorePattern(pattern string) (*regexp.Regexp, error) {
+	var sb bytes.Buffer
+	sb.WriteString("^")
+
+	// patterns without a slash (other than a trailing one) match at any depth,
+	// all others are relative to the ignore file's folder
+	if strings.HasPrefix(pattern, "/") {
+		pattern = pattern[1:]
+	} else if !strings.Contains(pattern, "/") {
+		sb.WriteString("(?:.*/)?")
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			// zero or more folders
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case pattern[i:] == "**" && i > 0 && pattern[i-1] == '/':
+			// everything inside
+			sb.WriteString(".+")
+			i++
+		case strings.HasPrefix(pattern[i:], "**"):
+			// not a path component of its own, behaves like a single star
+			sb.WriteString("[^/]*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			sb.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				sb.WriteString(regexp.QuoteMeta(string(c)))
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			i += end + 1
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.Replace(class, "\\", "\\\\", -1) + "]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// NewIgnoreRules returns a set of rules, in order of increasing priority
+func NewIgnoreRules(rules []*IgnoreRule) *IgnoreRules {
+	return &IgnoreRules{
+		rules:    rules,
+		dirCache: make(map[string]*IgnoreRule),
+	}
+}
+
+// LoadIgnoreFiles finds all ignore files in dir and its subfolders,
+// skipping folders that are excluded by filter or by an ignore file.
+// If dir isn't a folder (an archive for example), no rules are returned.
+func LoadIgnoreFiles(dir string, filter tlc.FilterFunc) (*IgnoreRules, error) {
+	ir := NewIgnoreRules(nil)
+
+	stats, err := os.Stat(dir)
+	if err != nil || !stats.IsDir() {
+		return ir, nil
+	}
+
+	var walk func(rel string) error
+	walk = func(rel string) error {
+		infos, err := ioutil.ReadDir(filepath.Join(dir, filepath.FromSlash(rel)))
+		if err != nil {
+			if os.IsPermission(err) {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		// rules apply to the whole folder, so read them before going deeper
+		for _, info := range infos {
+			if info.Name() != IgnoreFileName || !info.Mode().IsRegular() {
+				continue
+			}
+
+			source := path.Join(rel, IgnoreFileName)
+			f, err := os.Open(filepath.Join(dir, filepath.FromSlash(source)))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			rules, err := ParseIgnoreFile(source, rel, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			ir.rules = append(ir.rules, rules...)
+		}
+
+		for _, info := range infos {
+			if !info.IsDir() {
+				continue
+			}
+			if filter != nil && !filter(info) {
+				continue
+			}
+
+			childRel := path.Join(rel, info.Name())
+			if ir.Excluded(childRel, true) != nil {
+				continue
+			}
+
+			err := walk(childRel)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err = walk("")
+	if err != nil {
+		return nil, err
+	}
+
+	// folders were checked while rules were still being loaded
+	ir.dirCache = make(map[string]*IgnoreRule)
+	return ir, nil
+}
+
+// Empty returns true if there are no rules at all
+func (ir *IgnoreRules) Empty() bool {
+	return len(ir.rules) == 0
+}
+
+// match returns the last rule matching p, which has priority over the others
+func (ir *IgnoreRules) match(p string, isDir bool) *IgnoreRule {
+	for i := len(ir.rules) - 1; i >= 0; i-- {
+		rule := ir.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+
+		rel := p
+		if rule.base != "" {
+			if !strings.HasPrefix(p, rule.base+"/") {
+				continue
+			}
+			rel = p[len(rule.base)+1:]
+		}
+
+		if rule.re.MatchString(rel) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// Excluded returns the rule excluding p (a slash-separated path relative
+// to the walked folder), or nil if it's included. As with git, files
+// can't be re-included if one of their parent folders is excluded.
+func (ir *IgnoreRules) Excluded(p string, isDir bool) *IgnoreRule {
+	if !isDir && path.Base(p) == IgnoreFileName {
+		return ignoreFileRule
+	}
+	return ir.Matching(p, isDir)
+}
+
+// Matching is like Excluded, but only looks at the rules themselves, so it
+// can be used to pick paths as well as exclude them.
+func (ir *IgnoreRules) Matching(p string, isDir bool) *IgnoreRule {
+	if dir := path.Dir(p); dir != "." {
+		if rule := ir.excludedDir(dir); rule != nil {
+			return rule
+		}
+	}
+
+	if isDir {
+		return ir.excludedDir(p)
+	}
+
+	if rule := ir.match(p, false); rule != nil && !rule.negate {
+		return rule
+	}
+	return nil
+}
+
+func (ir *IgnoreRules) excludedDir(p string) *IgnoreRule {
+	if rule, ok := ir.dirCache[p]; ok {
+		return rule
+	}
+
+	var res *IgnoreRule
+	if parent := path.Dir(p); parent != "." {
+		res = ir.excludedDir(parent)
+	}
+	if res == nil {
+		if rule := ir.match(p, true); rule != nil && !rule.negate {
+			res = rule
+		}
+	}
+
+	ir.dirCache[p] = res
+	return res
+}
+
+// Apply returns a copy of container without the entries excluded by the rules,
+// along with what was excluded and why. prefix is the path, in the container,
+// of the folder the rules were loaded from (see tlc.WalkOpts.WrappedDir).
+func (ir *IgnoreRules) Apply(container *tlc.Container, prefix string) (*tlc.Container, []*Exclusion) {
+	var exclusions []*Exclusion
+
+	excluded := func(p string, isDir bool) bool {
+		rel := p
+		if prefix != "" {
+			if !strings.HasPrefix(p, prefix+"/") {
+				return false
+			}
+			rel = p[len(prefix)+1:]
+		}
+
+		if rule := ir.Excluded(rel, isDir); rule != nil {
+			exclusions = append(exclusions, &Exclusion{Path: p, Rule: rule})
+			return true
+		}
+		return false
+	}
+
+	res := &tlc.Container{}
+	for _, d := range container.Dirs {
+		if !excluded(d.Path, true) {
+			res.Dirs = append(res.Dirs, d)
+		}
+	}
+
+	for _, f := range container.Files {
+		if excluded(f.Path, false) {
+			continue
+		}
+		nf := *f
+		nf.Offset = res.Size
+		res.Size += nf.Size
+		res.Files = append(res.Files, &nf)
+	}
+
+	for _, s := range container.Symlinks {
+		if !excluded(s.Path, false) {
+			res.Symlinks = append(res.Symlinks, s)
+		}
+	}
+
+	return res, exclusions
+}
+
+// WalkAny is like tlc.WalkAny, but also honors the ignore files found
+// when walking a folder.
+func WalkAny(containerPath string, opts *tlc.WalkOpts) (*tlc.Container, error) {
+	container, _, err := WalkAnyWithExclusions(containerPath, opts)
+	return container, err
+}
+
+// WalkAnyWithExclusions is like WalkAny, but also returns what was excluded
+// by ignore files, and why.
+func WalkAnyWithExclusions(containerPath string, opts *tlc.WalkOpts) (*tlc.Container, []*Exclusion, error) {
+	container, err := tlc.WalkAny(containerPath, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rootPath := containerPath
+	if opts.WrappedDir != "" {
+		rootPath = filepath.Join(containerPath, opts.WrappedDir)
+	}
+
+	ir, err := LoadIgnoreFiles(rootPath, opts.Filter)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reading ignore files")
+	}
+
+	container, exclusions := ir.Apply(container, opts.WrappedDir)
+	return container, exclusions, nil
+}
@@ -0,0 +1,104 @@
+package filtering_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/butler/filtering"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func rulesFor(t *testing.T, base string, lines ...string) *filtering.IgnoreRules {
+	rules, err := filtering.ParseIgnoreFile(base+"/.butlerignore", base, strings.NewReader(strings.Join(lines, "\n")))
+	wtest.Must(t, err)
+	return filtering.NewIgnoreRules(rules)
+}
+
+func TestIgnorePatterns(t *testing.T) {
+	excluded := func(ir *filtering.IgnoreRules, p string, isDir bool) bool {
+		return ir.Excluded(p, isDir) != nil
+	}
+
+	ir := rulesFor(t, ".",
+		"# comment",
+		"*.pdb",
+		"/build.log",
+		"Builds/Debug/**",
+		"cache/",
+		"docs/**/draft.md",
+		"*.txt",
+		"!README.txt",
+	)
+
+	assert.True(t, excluded(ir, "game.pdb", false))
+	assert.True(t, excluded(ir, "bin/x64/game.pdb", false))
+
+	assert.True(t, excluded(ir, "build.log", false))
+	assert.False(t, excluded(ir, "sub/build.log", false), "anchored patterns only match at the root")
+
+	assert.True(t, excluded(ir, "Builds/Debug/game.exe", false))
+	assert.True(t, excluded(ir, "Builds/Debug/x/y/z.dll", false))
+	assert.False(t, excluded(ir, "Builds/Release/game.exe", false))
+
+	assert.True(t, excluded(ir, "cache", true))
+	assert.True(t, excluded(ir, "data/cache/level1.bin", false), "files in excluded folders are excluded")
+	assert.False(t, excluded(ir, "cache", false), "directory-only patterns don't match files")
+
+	assert.True(t, excluded(ir, "docs/draft.md", false))
+	assert.True(t, excluded(ir, "docs/a/b/draft.md", false))
+	assert.False(t, excluded(ir, "other/draft.md", false))
+
+	assert.True(t, excluded(ir, "notes.txt", false))
+	assert.False(t, excluded(ir, "README.txt", false), "negated patterns re-include files")
+	assert.True(t, excluded(ir, "cache/README.txt", false), "can't re-include files in excluded folders")
+
+	assert.True(t, excluded(ir, "sub/.butlerignore", false), "ignore files are never pushed")
+}
+
+func TestNestedIgnoreFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "butlerignore")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	write := func(p string, contents string) {
+		fullPath := filepath.Join(dir, filepath.FromSlash(p))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(fullPath), 0755))
+		wtest.Must(t, ioutil.WriteFile(fullPath, []byte(contents), 0644))
+	}
+
+	write(".butlerignore", "*.log\n")
+	write("game.exe", "game")
+	write("game.log", "log")
+	write("mods/.butlerignore", "!keep.log\n/local/\n")
+	write("mods/keep.log", "keep")
+	write("mods/other.log", "other")
+	write("mods/local/mod.dat", "local")
+	write("local/data.dat", "data")
+
+	container, exclusions, err := filtering.WalkAnyWithExclusions(dir, &tlc.WalkOpts{
+		Filter: filtering.FilterPaths,
+	})
+	wtest.Must(t, err)
+
+	var paths []string
+	for _, f := range container.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.EqualValues(t, []string{"game.exe", "local/data.dat", "mods/keep.log"}, paths)
+	assert.EqualValues(t, int64(len("game")+len("data")+len("keep")), container.Size)
+	assert.EqualValues(t, int64(len("game")+len("data")), container.Files[2].Offset)
+
+	reasons := make(map[string]string)
+	for _, e := range exclusions {
+		reasons[e.Path] = e.Rule.Source
+	}
+	assert.EqualValues(t, ".butlerignore", reasons["game.log"])
+	assert.EqualValues(t, ".butlerignore", reasons["mods/other.log"])
+	assert.EqualValues(t, "mods/.butlerignore", reasons["mods/local"])
+	assert.EqualValues(t, "mods/.butlerignore", reasons["mods/local/mod.dat"])
+}
@@ -0,0 +1,363 @@
+// Package hashpool hashes the blocks of every file of a container with
+// a pool of workers, the way signatures are computed, and hands the results
+// back in container order so that anything built from them (signatures,
+// wound files) doesn't depend on the number of workers.
+//
+// Large files are split into chunks, so that a few big files keep every
+// worker busy. Only a bounded number of chunks are in flight at any time,
+// so memory use doesn't depend on the number or size of files.
+package hashpool
+
+import (
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/werrors"
+	"github.com/itchio/wharf/wsync"
+	"github.com/pkg/errors"
+)
+
+// ChunkSize is how much of a file a worker hashes at once, it's a
+// multiple of pwr.BlockSize
+const ChunkSize = 64 * pwr.BlockSize
+
+// chunksPerWorker is how many chunks can be waiting to be handed back, per worker
+const chunksPerWorker = 4
+
+// Params controls how a container is hashed
+type Params struct {
+	Container *tlc.Container
+	// NewPool is called once per worker, pools are not shared
+	NewPool func() (wsync.Pool, error)
+
+	// Concurrency is the number of workers, negative values are
+	// subtracted from the number of CPUs, 0 means 1.
+	Concurrency int
+	// Chunked splits files in chunks of ChunkSize, hashed by different
+	// workers. Pools must support GetReadSeeker and seek cheaply.
+	Chunked bool
+
+	// Consumer receives progress, and the path of files as they're done
+	Consumer *state.Consumer
+}
+
+// A Chunk is a part of a file, and the hashes of its blocks
+type Chunk struct {
+	FileIndex int64
+	// Offset is where the chunk starts, a multiple of pwr.BlockSize
+	Offset int64
+	// Size is how large the chunk should be according to the container
+	Size int64
+	// Last is true for the last chunk of a file
+	Last bool
+
+	// Hashes has one entry per block that could be read, the last one
+	// may be short. Empty files have a single, empty block.
+	Hashes []wsync.BlockHash
+	// Read is how many bytes could be read, it's less than Size if
+	// the file is shorter than expected
+	Read int64
+	// Missing is set if the file doesn't exist
+	Missing bool
+	// TooLarge is set on the last chunk of a file if it has
+	// more data than expected
+	TooLarge bool
+
+	seq int64
+}
+
+// Short returns true if the chunk couldn't be read entirely
+func (c *Chunk) Short() bool {
+	return c.Missing || c.Read < c.Size
+}
+
+// ChunkFunc receives chunks in container order: by file index, then offset
+type ChunkFunc func(c *Chunk) error
+
+// NumWorkers returns how many workers to use for a given concurrency
+func NumWorkers(concurrency int) int {
+	numWorkers := concurrency
+	if numWorkers < 0 {
+		numWorkers = runtime.NumCPU() + concurrency
+	}
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	return numWorkers
+}
+
+// Hash reads every file of the container, and calls onChunk in order
+// as chunks are hashed.
+func Hash(ctx context.Context, params *Params, onChunk ChunkFunc) error {
+	container := params.Container
+	consumer := params.Consumer
+	if consumer == nil {
+		consumer = &state.Consumer{}
+	}
+
+	numWorkers := NumWorkers(params.Concurrency)
+	consumer.Debugf("Hashing with %d workers", numWorkers)
+
+	jobs := make(chan *Chunk)
+	results := make(chan *Chunk, numWorkers)
+	errs := make(chan error, numWorkers)
+	// a token is taken for every chunk handed out, and given back when
+	// it has been passed to onChunk, so workers can't get too far ahead
+	tokens := make(chan struct{}, numWorkers*chunksPerWorker)
+	done := make(chan struct{})
+
+	var bytesDone int64
+	onProgress := func(delta int64) {
+		if container.Size > 0 {
+			consumer.Progress(float64(atomic.AddInt64(&bytesDone, delta)) / float64(container.Size))
+		}
+	}
+
+	go func() {
+		defer close(jobs)
+
+		var seq int64
+		for fileIndex, f := range container.Files {
+			for offset := int64(0); ; offset += ChunkSize {
+				size := f.Size - offset
+				if params.Chunked && size > ChunkSize {
+					size = ChunkSize
+				}
+
+				c := &Chunk{
+					FileIndex: int64(fileIndex),
+					Offset:    offset,
+					Size:      size,
+					Last:      offset+size >= f.Size,
+					seq:       seq,
+				}
+				seq++
+
+				select {
+				case tokens <- struct{}{}:
+				case <-done:
+					return
+				}
+
+				select {
+				case jobs <- c:
+				case <-done:
+					return
+				}
+
+				if c.Last {
+					break
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := work(params, jobs, results, done, onProgress)
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	err := collect(ctx, container, consumer, results, errs, tokens, onChunk)
+	close(done)
+	wg.Wait()
+	return err
+}
+
+// collect hands chunks to onChunk in order, keeping those
+// that are done early around until it's their turn
+func collect(ctx context.Context, container *tlc.Container, consumer *state.Consumer,
+	results chan *Chunk, errs chan error, tokens chan struct{}, onChunk ChunkFunc) error {
+	pending := make(map[int64]*Chunk)
+	var next int64
+
+	for {
+		select {
+		case <-ctx.Done():
+			return werrors.ErrCancelled
+		case err := <-errs:
+			return err
+		case c, ok := <-results:
+			if !ok {
+				select {
+				case err := <-errs:
+					return err
+				default:
+				}
+
+				if len(pending) > 0 {
+					return errors.New("hashpool: workers stopped early")
+				}
+				return nil
+			}
+
+			pending[c.seq] = c
+			for {
+				c, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				<-tokens
+
+				if c.Offset == 0 {
+					consumer.ProgressLabel(container.Files[c.FileIndex].Path)
+				}
+
+				err := onChunk(c)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func work(params *Params, jobs chan *Chunk, results chan *Chunk, done chan struct{}, onProgress func(delta int64)) (retErr error) {
+	pool, err := params.NewPool()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		err := pool.Close()
+		if err != nil && retErr == nil {
+			retErr = errors.WithStack(err)
+		}
+	}()
+
+	h := &hasher{
+		pool:    pool,
+		chunked: params.Chunked,
+		sctx:    wsync.NewContext(int(pwr.BlockSize)),
+		buf:     make([]byte, pwr.BlockSize),
+	}
+
+	for {
+		select {
+		case c, ok := <-jobs:
+			if !ok {
+				return nil
+			}
+
+			err := h.hash(c)
+			if err != nil {
+				return err
+			}
+			onProgress(c.Size)
+
+			select {
+			case results <- c:
+			case <-done:
+				return nil
+			}
+		case <-done:
+			return nil
+		}
+	}
+}
+
+type hasher struct {
+	pool    wsync.Pool
+	chunked bool
+	sctx    *wsync.Context
+	buf     []byte
+}
+
+func (h *hasher) hash(c *Chunk) error {
+	var reader io.Reader
+	if h.chunked {
+		rs, err := h.pool.GetReadSeeker(c.FileIndex)
+		if err != nil {
+			if pwr.IsNotExist(err) {
+				c.Missing = true
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+
+		_, err = rs.Seek(c.Offset, io.SeekStart)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		reader = rs
+	} else {
+		r, err := h.pool.GetReader(c.FileIndex)
+		if err != nil {
+			if pwr.IsNotExist(err) {
+				c.Missing = true
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+		reader = r
+	}
+
+	blockIndex := c.Offset / pwr.BlockSize
+	addHash := func(block []byte) {
+		weakHash, strongHash := h.sctx.HashBlock(block)
+		bh := wsync.BlockHash{
+			FileIndex:  c.FileIndex,
+			BlockIndex: blockIndex,
+			WeakHash:   weakHash,
+			StrongHash: strongHash,
+		}
+		if int64(len(block)) < pwr.BlockSize {
+			bh.ShortSize = int32(len(block))
+		}
+		c.Hashes = append(c.Hashes, bh)
+		blockIndex++
+	}
+
+	for c.Read < c.Size {
+		want := c.Size - c.Read
+		if want > pwr.BlockSize {
+			want = pwr.BlockSize
+		}
+
+		n, err := io.ReadFull(reader, h.buf[:want])
+		if n > 0 {
+			addHash(h.buf[:n])
+			c.Read += int64(n)
+		}
+		if err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return errors.WithStack(err)
+		}
+	}
+
+	if c.Size == 0 {
+		// empty files have a 0-length shortblock
+		addHash(h.buf[:0])
+	}
+
+	if c.Last {
+		n, err := io.ReadFull(reader, h.buf[:1])
+		if n > 0 {
+			c.TooLarge = true
+		} else if err != nil && err != io.EOF {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package hashpool
+
+import (
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeFolder(t *testing.T) (string, *tlc.Container) {
+	dir, err := ioutil.TempDir("", "hashpool")
+	wtest.Must(t, err)
+
+	rng := rand.New(rand.NewSource(1))
+	sizes := map[string]int64{
+		"empty":         0,
+		"tiny":          10,
+		"one-block":     pwr.BlockSize,
+		"short-block":   pwr.BlockSize*3 + 100,
+		"chunk":         ChunkSize,
+		"large":         ChunkSize*2 + pwr.BlockSize + 7,
+		"sub/other.dat": ChunkSize + 1,
+	}
+	for name, size := range sizes {
+		buf := make([]byte, size)
+		rng.Read(buf)
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		wtest.Must(t, os.MkdirAll(filepath.Dir(p), 0755))
+		wtest.Must(t, ioutil.WriteFile(p, buf, 0644))
+	}
+
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+	return dir, container
+}
+
+func TestHash(t *testing.T) {
+	dir, container := makeFolder(t)
+	defer os.RemoveAll(dir)
+
+	var expected []wsync.BlockHash
+	wtest.Must(t, pwr.ComputeSignatureToWriter(context.Background(), container, fspool.New(container, dir), &state.Consumer{}, func(bh wsync.BlockHash) error {
+		expected = append(expected, bh)
+		return nil
+	}))
+
+	for _, chunked := range []bool{false, true} {
+		for _, concurrency := range []int{1, 2, 8} {
+			var hashes []wsync.BlockHash
+			var lastFile, lastOffset int64 = -1, -1
+			wtest.Must(t, Hash(context.Background(), &Params{
+				Container: container,
+				NewPool: func() (wsync.Pool, error) {
+					return fspool.New(container, dir), nil
+				},
+				Concurrency: concurrency,
+				Chunked:     chunked,
+			}, func(c *Chunk) error {
+				if c.FileIndex == lastFile {
+					assert.True(t, c.Offset > lastOffset, "chunks should be in order")
+				} else {
+					assert.True(t, c.FileIndex > lastFile, "files should be in order")
+					assert.EqualValues(t, 0, c.Offset)
+				}
+				lastFile, lastOffset = c.FileIndex, c.Offset
+				assert.False(t, c.Short())
+				assert.False(t, c.TooLarge)
+
+				hashes = append(hashes, c.Hashes...)
+				return nil
+			}))
+
+			assert.Len(t, hashes, len(expected))
+			for i := range expected {
+				if i >= len(hashes) {
+					break
+				}
+				assert.EqualValues(t, expected[i].WeakHash, hashes[i].WeakHash)
+				assert.EqualValues(t, expected[i].StrongHash, hashes[i].StrongHash)
+				assert.EqualValues(t, expected[i].ShortSize, hashes[i].ShortSize)
+			}
+		}
+	}
+}
+
+func TestHashChangedFiles(t *testing.T) {
+	dir, container := makeFolder(t)
+	defer os.RemoveAll(dir)
+
+	wtest.Must(t, os.Remove(filepath.Join(dir, "tiny")))
+	wtest.Must(t, os.Truncate(filepath.Join(dir, "large"), ChunkSize+10))
+	f, err := os.OpenFile(filepath.Join(dir, "one-block"), os.O_APPEND|os.O_WRONLY, 0644)
+	wtest.Must(t, err)
+	_, err = f.Write([]byte("more"))
+	wtest.Must(t, err)
+	wtest.Must(t, f.Close())
+
+	chunks := make(map[string][]*Chunk)
+	wtest.Must(t, Hash(context.Background(), &Params{
+		Container: container,
+		NewPool: func() (wsync.Pool, error) {
+			return fspool.New(container, dir), nil
+		},
+		Concurrency: 4,
+		Chunked:     true,
+	}, func(c *Chunk) error {
+		path := container.Files[c.FileIndex].Path
+		chunks[path] = append(chunks[path], c)
+		return nil
+	}))
+
+	assert.True(t, chunks["tiny"][0].Missing)
+
+	large := chunks["large"]
+	assert.Len(t, large, 3)
+	assert.False(t, large[0].Short())
+	assert.True(t, large[1].Short())
+	assert.EqualValues(t, 10, large[1].Read)
+	assert.True(t, large[2].Short())
+
+	assert.True(t, chunks["one-block"][0].TooLarge)
+	assert.False(t, chunks["chunk"][0].TooLarge)
+}
+
+func TestNumWorkers(t *testing.T) {
+	assert.EqualValues(t, 1, NumWorkers(0))
+	assert.EqualValues(t, 3, NumWorkers(3))
+	assert.EqualValues(t, 1, NumWorkers(-10000))
+}
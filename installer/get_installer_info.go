@@ -1,6 +1,7 @@
 package installer
 
 import (
+	"fmt"
 	"io"
 	"path/filepath"
 	"time"
@@ -32,7 +33,8 @@ func GetInstallerInfo(consumer *state.Consumer, file eos.File) (*InstallerInfo,
 		} else {
 			consumer.Infof("✓ Using file extension registry (%s)", typ)
 			return &InstallerInfo{
-				Type: typ,
+				Type:   typ,
+				Reason: fmt.Sprintf("File extension (%s) is registered as (%s)", ext, typ),
 			}, nil
 		}
 	}
@@ -52,15 +54,20 @@ func GetInstallerInfo(consumer *state.Consumer, file eos.File) (*InstallerInfo,
 	consumer.Debugf("  (took %s)", time.Since(beforeConfiguratorProbe))
 
 	var typePerConfigurator = InstallerTypeUnknown
+	var reasonPerConfigurator string
 
 	if candidate != nil {
 		consumer.Infof("  Candidate: %s", candidate.String())
-		typePerConfigurator, err = getInstallerTypeForCandidate(consumer, candidate, file)
+		typePerConfigurator, reasonPerConfigurator, err = getInstallerTypeForCandidate(consumer, candidate, file)
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
+		if reasonPerConfigurator == "" {
+			reasonPerConfigurator = fmt.Sprintf("Not an archive, and %s files aren't installers", candidate.Flavor)
+		}
 	} else {
 		consumer.Infof("  No results from configurator")
+		reasonPerConfigurator = "Not an archive, and no results from configurator"
 	}
 
 	if typePerConfigurator == InstallerTypeUnknown || typePerConfigurator == InstallerTypeNaked || typePerConfigurator == InstallerTypeArchive {
@@ -98,6 +105,8 @@ func GetInstallerInfo(consumer *state.Consumer, file eos.File) (*InstallerInfo,
 				Type:        InstallerTypeArchive,
 				ArchiveInfo: archiveInfo,
 				Entries:     entries,
+				Reason:      fmt.Sprintf("Source is a supported archive format (%s)", archiveInfo.Format),
+				Candidate:   candidate,
 			}, nil
 		}
 
@@ -109,79 +118,80 @@ func GetInstallerInfo(consumer *state.Consumer, file eos.File) (*InstallerInfo,
 
 	consumer.Infof("✓ Using configurator results")
 	return &InstallerInfo{
-		Type: typePerConfigurator,
+		Type:      typePerConfigurator,
+		Reason:    reasonPerConfigurator,
+		Candidate: candidate,
 	}, nil
 }
 
-func getInstallerTypeForCandidate(consumer *state.Consumer, candidate *dash.Candidate, file eos.File) (InstallerType, error) {
+// getInstallerTypeForCandidate returns the installer type for a file dash
+// sniffed, and the reason for it. The reason is empty if the type is unknown.
+func getInstallerTypeForCandidate(consumer *state.Consumer, candidate *dash.Candidate, file eos.File) (InstallerType, string, error) {
+	decide := func(typ InstallerType, reason string, args ...interface{}) (InstallerType, string, error) {
+		formatted := fmt.Sprintf(reason, args...)
+		consumer.Infof("  → %s", formatted)
+		return typ, formatted, nil
+	}
+
 	switch candidate.Flavor {
 
 	case dash.FlavorNativeWindows:
 		if candidate.WindowsInfo != nil && candidate.WindowsInfo.InstallerType != "" {
 			typ := (InstallerType)(candidate.WindowsInfo.InstallerType)
-			consumer.Infof("  → Windows installer of type %s", typ)
-			return typ, nil
+			return decide(typ, "Windows installer of type %s", typ)
 		}
 
 		_, err := file.Seek(0, io.SeekStart)
 		if err != nil {
-			return InstallerTypeUnknown, errors.WithStack(err)
+			return InstallerTypeUnknown, "", errors.WithStack(err)
 		}
 
 		peInfo, err := pelican.Probe(file, &pelican.ProbeParams{
 			Consumer: consumer,
 		})
 		if err != nil {
-			return InstallerTypeUnknown, errors.WithStack(err)
+			return InstallerTypeUnknown, "", errors.WithStack(err)
 		}
 
 		if peInfo.AssemblyInfo != nil {
 			switch peInfo.AssemblyInfo.RequestedExecutionLevel {
 			case "highestAvailable", "requireAdministrator":
-				consumer.Infof("  → Unsupported Windows installer (requested execution level %s)", peInfo.AssemblyInfo.RequestedExecutionLevel)
-				return InstallerTypeUnsupported, nil
+				return decide(InstallerTypeUnsupported, "Unsupported Windows installer (requested execution level %s)", peInfo.AssemblyInfo.RequestedExecutionLevel)
 			}
 
 			if peInfo.AssemblyInfo.Description == "IExpress extraction tool" {
-				consumer.Infof("  → Self-extracting CAB created with IExpress")
-				return InstallerTypeIExpress, nil
+				return decide(InstallerTypeIExpress, "Self-extracting CAB created with IExpress")
 			}
 		} else {
 			stats, err := file.Stat()
 			if err != nil {
-				return InstallerTypeUnknown, errors.WithStack(err)
+				return InstallerTypeUnknown, "", errors.WithStack(err)
 			}
 
 			if HasSuspiciouslySetupLikeName(stats.Name()) {
-				consumer.Infof("  → Unsupported Windows installer (no manifest, has name '%s')", stats.Name())
-				return InstallerTypeUnsupported, nil
+				return decide(InstallerTypeUnsupported, "Unsupported Windows installer (no manifest, has name '%s')", stats.Name())
 			}
 		}
 
-		consumer.Infof("  → Native windows executable, but not an installer")
-		return InstallerTypeNaked, nil
+		return decide(InstallerTypeNaked, "Native windows executable, but not an installer")
 
 	case dash.FlavorNativeMacos:
-		consumer.Infof("  → Native macOS executable")
-		return InstallerTypeNaked, nil
+		return decide(InstallerTypeNaked, "Native macOS executable")
 
 	case dash.FlavorNativeLinux:
-		consumer.Infof("  → Native linux executable")
-		return InstallerTypeNaked, nil
+		return decide(InstallerTypeNaked, "Native linux executable")
 
 	case dash.FlavorScript:
-		consumer.Infof("  → Script")
 		if candidate.ScriptInfo != nil && candidate.ScriptInfo.Interpreter != "" {
-			consumer.Infof("    with interpreter %s", candidate.ScriptInfo.Interpreter)
+			return decide(InstallerTypeNaked, "Script with interpreter %s", candidate.ScriptInfo.Interpreter)
 		}
-		return InstallerTypeNaked, nil
+		return decide(InstallerTypeNaked, "Script")
 
 	case dash.FlavorScriptWindows:
-		consumer.Infof("  → Windows script")
-		return InstallerTypeNaked, nil
+		return decide(InstallerTypeNaked, "Windows script")
 	}
 
-	return InstallerTypeUnknown, nil
+	return InstallerTypeUnknown, "", nil
 }
 
 func IsWindowsInstaller(typ InstallerType) bool {
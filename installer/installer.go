@@ -7,6 +7,7 @@ import (
 
 	"github.com/itchio/boar"
 	"github.com/itchio/butler/installer/bfs"
+	"github.com/itchio/dash"
 	"github.com/itchio/savior"
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/state"
@@ -61,10 +62,17 @@ type InstallResult struct {
 	MSIProductCode string
 }
 
+// InstallerInfo describes how a file would be installed. It's saved
+// along with install state, Reason and Candidate are left out of it.
 type InstallerInfo struct {
 	Type        InstallerType
 	ArchiveInfo *boar.Info
 	Entries     []*savior.Entry
+
+	// Reason explains why Type was picked
+	Reason string `json:"-"`
+	// Candidate is what dash made of the file, if it got that far
+	Candidate *dash.Candidate `json:"-"`
 }
 
 type InstallerType string
@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/efarrer/iothrottler"
 	"github.com/itchio/butler/cmd/elevate"
 	"github.com/itchio/butler/comm"
@@ -24,6 +25,7 @@ import (
 	"github.com/itchio/wharf/eos"
 	"github.com/itchio/wharf/eos/option"
 	shellquote "github.com/kballard/go-shellquote"
+	"github.com/pkg/errors"
 
 	"net/http"
 	_ "net/http/pprof"
@@ -54,12 +56,15 @@ var appArgs = struct {
 	beeps4Life *bool
 
 	identity             *string
+	profile              *string
 	address              *string
 	userAgentAddition    *string
 	dbPath               *string
 	compressionAlgorithm *string
 	compressionQuality   *int
 
+	signatureCacheSize *units.Base2Bytes
+
 	cpuprofile *string
 	memstats   *bool
 	elevate    *bool
@@ -77,6 +82,7 @@ var appArgs = struct {
 	app.Flag("beeps4life", "Restore historical robot bug.").Hidden().Bool(),
 
 	app.Flag("identity", "Path to your itch.io API token").Default(defaultKeyPath()).Short('i').String(),
+	app.Flag("profile", "Name of the saved credentials to use, see `butler login --profile`").Envar("BUTLER_PROFILE").String(),
 	app.Flag("address", "itch.io server to talk to").Default("https://api.itch.io").Short('a').Hidden().String(),
 	app.Flag("user-agent", "string to include in user-agent for all http requests").Default("").Hidden().String(),
 	app.Flag("dbpath", "Path of the sqlite database path to use (for butlerd)").Default("").Hidden().String(),
@@ -84,6 +90,8 @@ var appArgs = struct {
 	app.Flag("compression", "Compression algorithm to use when writing patch or signature files").Default("brotli").Hidden().Enum("none", "brotli", "gzip", "zstd"),
 	app.Flag("quality", "Quality level to use when writing patch or signature files").Default("1").Short('q').Hidden().Int(),
 
+	app.Flag("signature-cache-size", "Disk space to use for caching build signatures locally (0 to disable)").Default("2GiB").Hidden().Bytes(),
+
 	app.Flag("cpuprofile", "Write CPU profile to given file").Hidden().String(),
 	app.Flag("memstats", "Print memory stats for some operations").Hidden().Bool(),
 
@@ -227,6 +235,14 @@ func doMain(args []string) {
 	fullCmd := kingpin.MustParse(cmd, err)
 
 	ctx.Identity = *appArgs.identity
+	ctx.ConfigDir = filepath.Dir(defaultKeyPath())
+	if *appArgs.profile != "" {
+		must(mansion.ValidateProfileName(*appArgs.profile))
+		if ctx.Identity != defaultKeyPath() {
+			must(errors.New("--identity and --profile can't be used together"))
+		}
+		ctx.Profile = *appArgs.profile
+	}
 	ctx.SetAddress(*appArgs.address)
 	ctx.UserAgentAddition = *appArgs.userAgentAddition
 	ctx.DBPath = *appArgs.dbPath
@@ -238,6 +254,7 @@ func doMain(args []string) {
 	ctx.JSON = *appArgs.json
 	ctx.CompressionAlgorithm = *appArgs.compressionAlgorithm
 	ctx.CompressionQuality = *appArgs.compressionQuality
+	ctx.SignatureCacheSize = int64(*appArgs.signatureCacheSize)
 
 	// set up eos
 	{
@@ -101,8 +101,8 @@ func (ctx *Context) HasSavedCredentials() bool {
 		return true
 	}
 
-	// then file at usual or specified path
-	var identity = ctx.Identity
+	// then file at usual or specified path, or profile
+	var identity = ctx.KeyPath()
 	_, err := os.Lstat(identity)
 
 	exists := !os.IsNotExist(err)
@@ -146,11 +146,14 @@ func writeKeyFile(path string, key string) error {
 
 func (ctx *Context) AuthenticateViaOauth() (*itchio.Client, error) {
 	var err error
-	var identity = ctx.Identity
+	var identity = ctx.KeyPath()
 	var key string
 
 	envKey := os.Getenv(environmentApiKeyVariable)
 	if envKey != "" {
+		if ctx.Profile != "" {
+			comm.Warnf("%s is set, using it instead of profile %s", environmentApiKeyVariable, ctx.Profile)
+		}
 		return ctx.NewClient(envKey), nil
 	}
 
@@ -172,109 +175,190 @@ func (ctx *Context) AuthenticateViaOauth() (*itchio.Client, error) {
 			comm.Dief("No credentials and stdin is not a terminal - terminating.")
 		}
 
-		done := make(chan string)
-		errs := make(chan error)
+		key, err = ctx.login()
+		if err != nil {
+			return nil, err
+		}
 
-		handler := func(w http.ResponseWriter, r *http.Request) {
-			matches := callbackRe.FindStringSubmatch(r.RequestURI)
-			if matches != nil {
-				client := ctx.NewClient(matches[1])
-				client.WharfStatus()
+		err = ctx.checkAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
 
-				w.Header().Set("Content-Type", "text/plain")
-				fmt.Fprintf(w, art.ItchLogo)
-				done <- matches[1]
-				return
+		err = ctx.writeAPIKey(key)
+		if err != nil {
+			// we can still use the key for this session
+			comm.Logf("\nCould not save API key: %s\n\n", err)
+		}
+	}
+
+	return ctx.NewClient(key), nil
+}
+
+// login gets a new API key, from the device authorization flow or
+// from the browser flow.
+func (ctx *Context) login() (string, error) {
+	useDevice := ctx.DeviceLogin
+	if !useDevice && os.Getenv("BUTLER_MANUAL_OAUTH") != "1" && isRemoteSession() {
+		comm.Logf("Looks like you're connected over SSH, logging in from another device.")
+		comm.Logf("(Set BUTLER_MANUAL_OAUTH=1 to copy-paste the browser address instead)")
+		useDevice = true
+	}
+
+	if useDevice {
+		da := ctx.NewDeviceAuth()
+		dc, err := da.Start()
+		if err != nil {
+			if ctx.DeviceLogin {
+				return "", errors.WithMessage(err, "logging in from another device")
 			}
+			// we only picked the device flow because of SSH, the
+			// browser flow still works there with copy-paste
+			comm.Logf("Logging in from another device isn't available (%s), falling back to browser login.", err.Error())
+			return ctx.loginViaBrowser()
+		}
 
-			w.Header().Set("Content-Type", "text/html")
-			fmt.Fprintf(w, "%s", authHTML)
+		// the user has seen the code by now, so falling back
+		// to another flow would only be confusing
+		key, err := ctx.loginViaDeviceCode(da, dc)
+		if err != nil {
+			return "", errors.WithMessage(err, "logging in from another device")
 		}
+		return key, nil
+	}
 
-		http.HandleFunc("/", handler)
+	return ctx.loginViaBrowser()
+}
 
-		// if we're running `butler login` remotely, we're asking the user to copy-paste
-		var addr = "127.0.0.1:226"
-		var doManualOauth = os.Getenv("BUTLER_MANUAL_OAUTH") == "1"
+// isRemoteSession returns true if we're running over SSH, where
+// opening a browser on the local machine isn't going to work.
+func isRemoteSession() bool {
+	return os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != ""
+}
 
-		if !doManualOauth {
-			var listener net.Listener
-			listener, err = net.Listen("tcp", "127.0.0.1:0")
-			if err != nil {
-				return nil, errors.Wrap(err, "listening on local address for oauth process")
-			}
+// SaveAPIKey checks that an API key works, then saves it to KeyPath
+func (ctx *Context) SaveAPIKey(key string) error {
+	err := ctx.checkAPIKey(key)
+	if err != nil {
+		return err
+	}
+	return ctx.writeAPIKey(key)
+}
+
+func (ctx *Context) checkAPIKey(key string) error {
+	client := ctx.NewClient(key)
+	_, err := client.WharfStatus()
+	if err != nil {
+		if ae, ok := itchio.AsAPIError(err); ok && ae.StatusCode == http.StatusUnauthorized {
+			return errors.New("invalid API key")
+		}
+		return errors.Wrap(err, "retrieving wharf status")
+	}
+	return nil
+}
 
-			addr = listener.Addr().String()
+func (ctx *Context) writeAPIKey(key string) error {
+	var identity = ctx.KeyPath()
 
-			go func() {
-				err = http.Serve(listener, nil)
-				if err != nil {
-					errs <- errors.Wrap(err, "serving local http server for oauth process")
-				}
-			}()
+	if ctx.Profile != "" {
+		comm.Logf("\nAuthenticated successfully! Saving key for profile %s in %s...\n", ctx.Profile, identity)
+	} else {
+		comm.Logf("\nAuthenticated successfully! Saving key in %s...\n", identity)
+	}
+
+	err := os.MkdirAll(filepath.Dir(identity), os.FileMode(0755))
+	if err != nil {
+		return errors.Wrap(err, "creating directory for storing API key")
+	}
+
+	err = writeKeyFile(identity, key)
+	if err != nil {
+		return errors.Wrap(err, "writing key file")
+	}
+	return nil
+}
+
+// loginViaBrowser opens the itch.io OAuth page and waits for it to
+// redirect to a local server, or for the user to paste the redirect address.
+func (ctx *Context) loginViaBrowser() (string, error) {
+	var err error
+	done := make(chan string)
+	errs := make(chan error)
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		matches := callbackRe.FindStringSubmatch(r.RequestURI)
+		if matches != nil {
+			client := ctx.NewClient(matches[1])
+			client.WharfStatus()
+
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, art.ItchLogo)
+			done <- matches[1]
+			return
 		}
 
-		form := url.Values{}
-		form.Add("client_id", "butler")
-		form.Add("scope", "wharf")
-		form.Add("response_type", "token")
-		form.Add("redirect_uri", fmt.Sprintf("http://%s/oauth/callback", addr))
-		query := form.Encode()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "%s", authHTML)
+	}
+
+	http.HandleFunc("/", handler)
 
-		uri := fmt.Sprintf("%s/user/oauth?%s", ctx.WebAddress(), query)
+	// if we're running `butler login` remotely, we're asking the user to copy-paste
+	var addr = "127.0.0.1:226"
+	var doManualOauth = os.Getenv("BUTLER_MANUAL_OAUTH") == "1"
 
-		comm.Login(uri)
+	if !doManualOauth {
+		var listener net.Listener
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return "", errors.Wrap(err, "listening on local address for oauth process")
+		}
+
+		addr = listener.Addr().String()
 
 		go func() {
-			s := bufio.NewScanner(os.Stdin)
-			for s.Scan() {
-				line := strings.TrimSpace(s.Text())
-				u, err := url.Parse(line)
-				if err != nil {
-					// not a valid url
-					continue
-				}
-
-				if u.Fragment != "" {
-					// user pasted the url!
-					done <- u.Fragment
-					return
-				}
+			err := http.Serve(listener, nil)
+			if err != nil {
+				errs <- errors.Wrap(err, "serving local http server for oauth process")
 			}
 		}()
+	}
 
-		select {
-		case err = <-errs:
-			return nil, errors.WithStack(err)
-		case key = <-done:
-			err = nil
+	form := url.Values{}
+	form.Add("client_id", "butler")
+	form.Add("scope", "wharf")
+	form.Add("response_type", "token")
+	form.Add("redirect_uri", fmt.Sprintf("http://%s/oauth/callback", addr))
+	query := form.Encode()
 
-			client := ctx.NewClient(key)
-			_, err = client.WharfStatus()
-			if err != nil {
-				return nil, errors.Wrap(err, "retrieving wharf status")
-			}
+	uri := fmt.Sprintf("%s/user/oauth?%s", ctx.WebAddress(), query)
 
-			comm.Logf("\nAuthenticated successfully! Saving key in %s...\n", identity)
+	comm.Login(uri)
 
-			err = os.MkdirAll(filepath.Dir(identity), os.FileMode(0755))
+	go func() {
+		s := bufio.NewScanner(os.Stdin)
+		for s.Scan() {
+			line := strings.TrimSpace(s.Text())
+			u, err := url.Parse(line)
 			if err != nil {
-				comm.Logf("\nCould not create directory for storing API key: %s\n\n", err)
-				err = nil
-			} else {
-				err = writeKeyFile(identity, key)
-				if err != nil {
-					comm.Logf("\nCould not save API key: %s\n\n", err)
-					err = nil
-				}
+				// not a valid url
+				continue
+			}
+
+			if u.Fragment != "" {
+				// user pasted the url!
+				done <- u.Fragment
+				return
 			}
 		}
-	}
+	}()
 
-	if err != nil {
-		err = errors.WithStack(err)
+	select {
+	case err = <-errs:
+		return "", errors.WithStack(err)
+	case key := <-done:
+		return key, nil
 	}
-	return ctx.NewClient(key), nil
 }
 
 func stripApiSubdomain(address string) (string, error) {
@@ -3,8 +3,10 @@ package mansion
 import (
 	"fmt"
 	"net/http"
+	"path/filepath"
 
 	"github.com/itchio/butler/comm"
+	"github.com/itchio/butler/sigcache"
 	itchio "github.com/itchio/go-itchio"
 	"github.com/itchio/httpkit/timeout"
 	"github.com/itchio/wharf/pwr"
@@ -20,6 +22,17 @@ type Context struct {
 	// Identity is the path to the credentials file
 	Identity string
 
+	// Profile is the name of the saved credentials to use instead of
+	// Identity, see KeyPath
+	Profile string
+
+	// DeviceLogin logs in by entering a code from another device instead
+	// of being redirected to a local server
+	DeviceLogin bool
+
+	// ConfigDir is where butler keeps local state, like interrupted push sessions
+	ConfigDir string
+
 	// String to include in our user-agent
 	UserAgentAddition string
 
@@ -47,6 +60,10 @@ type Context struct {
 	CompressionAlgorithm string
 	CompressionQuality   int
 
+	// SignatureCacheSize is how much disk space the local signature cache
+	// may use, 0 disables it
+	SignatureCacheSize int64
+
 	HTTPClient    *http.Client
 	HTTPTransport *http.Transport
 
@@ -102,6 +119,11 @@ func (ctx *Context) UserAgent() string {
 	return res
 }
 
+// SignatureCache returns the local cache of build signatures
+func (ctx *Context) SignatureCache() *sigcache.Cache {
+	return sigcache.New(filepath.Join(ctx.ConfigDir, "butler_signature_cache"), ctx.SignatureCacheSize)
+}
+
 func (ctx *Context) CompressionSettings() pwr.CompressionSettings {
 	var algo pwr.CompressionAlgorithm
 
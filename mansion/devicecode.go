@@ -0,0 +1,187 @@
+package mansion
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/itchio/butler/comm"
+	"github.com/pkg/errors"
+)
+
+// deviceGrantType is the grant type used when polling for a token,
+// see RFC 8628
+const deviceGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// errDeviceFlowUnsupported is returned when the server doesn't know
+// about device authorization.
+var errDeviceFlowUnsupported = errors.New("device authorization is not supported by the server")
+
+// A DeviceCode is what the server gives us when starting a device
+// authorization: the user enters UserCode at VerificationURI from any
+// device, while we poll with DeviceCode.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type deviceTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// DeviceAuth talks to the device authorization endpoints of an itch.io
+// web instance.
+type DeviceAuth struct {
+	// WebAddress is the itch.io instance, like https://itch.io
+	WebAddress string
+	HTTPClient *http.Client
+
+	// Sleep waits between polls, defaults to time.Sleep
+	Sleep func(d time.Duration)
+}
+
+// NewDeviceAuth returns a DeviceAuth for the instance ctx talks to
+func (ctx *Context) NewDeviceAuth() *DeviceAuth {
+	return &DeviceAuth{
+		WebAddress: ctx.WebAddress(),
+		HTTPClient: ctx.HTTPClient,
+	}
+}
+
+func (da *DeviceAuth) post(path string, form url.Values, out interface{}) (int, error) {
+	client := da.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	uri := strings.TrimSuffix(da.WebAddress, "/") + path
+	res, err := client.PostForm(uri, form)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return res.StatusCode, errDeviceFlowUnsupported
+	}
+
+	err = json.NewDecoder(res.Body).Decode(out)
+	if err != nil {
+		return res.StatusCode, errors.Wrapf(err, "decoding response from %s (HTTP %d)", uri, res.StatusCode)
+	}
+	return res.StatusCode, nil
+}
+
+// Start asks the server for a new device code
+func (da *DeviceAuth) Start() (*DeviceCode, error) {
+	form := url.Values{}
+	form.Add("client_id", "butler")
+	form.Add("scope", "wharf")
+
+	dc := &DeviceCode{}
+	status, err := da.post("/user/oauth/device", form, dc)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, errors.Errorf("requesting device code: HTTP %d", status)
+	}
+	if dc.DeviceCode == "" || dc.UserCode == "" || dc.VerificationURI == "" {
+		return nil, errors.New("requesting device code: incomplete response from server")
+	}
+	return dc, nil
+}
+
+// Poll waits until the user approves or denies the device code, or until
+// it expires, and returns the API key.
+func (da *DeviceAuth) Poll(dc *DeviceCode) (string, error) {
+	sleep := da.Sleep
+	if sleep == nil {
+		sleep = time.Sleep
+	}
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiresIn := time.Duration(dc.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = 15 * time.Minute
+	}
+	// polls can be instant in tests, so count them rather than using
+	// the clock.
+	maxPolls := int(expiresIn / interval)
+	if maxPolls < 1 {
+		maxPolls = 1
+	}
+
+	form := url.Values{}
+	form.Add("client_id", "butler")
+	form.Add("grant_type", deviceGrantType)
+	form.Add("device_code", dc.DeviceCode)
+
+	for i := 0; i < maxPolls; i++ {
+		sleep(interval)
+
+		res := &deviceTokenResponse{}
+		_, err := da.post("/user/oauth/token", form, res)
+		if err != nil {
+			return "", err
+		}
+
+		switch res.Error {
+		case "":
+			if res.AccessToken == "" {
+				return "", errors.New("polling for device authorization: no access token in response")
+			}
+			return res.AccessToken, nil
+		case "authorization_pending":
+			// keep waiting
+		case "slow_down":
+			interval += 5 * time.Second
+			comm.Debugf("Server asked us to slow down, polling every %s", interval)
+		case "access_denied":
+			return "", errors.New("login was denied")
+		case "expired_token":
+			return "", errors.New("login code expired, please try again")
+		default:
+			msg := res.Error
+			if res.ErrorDescription != "" {
+				msg = fmt.Sprintf("%s: %s", msg, res.ErrorDescription)
+			}
+			return "", errors.Errorf("polling for device authorization: %s", msg)
+		}
+	}
+	return "", errors.New("login code expired, please try again")
+}
+
+// loginViaDeviceCode shows a device code started with da, and returns
+// the API key once the user approved it.
+func (ctx *Context) loginViaDeviceCode(da *DeviceAuth, dc *DeviceCode) (string, error) {
+	uri := dc.VerificationURIComplete
+	if uri == "" {
+		uri = dc.VerificationURI
+	}
+	comm.Login(uri)
+	comm.Notice("Log in from another device", []string{
+		"To authorize butler, open the following address in any browser:",
+		"",
+		"  " + dc.VerificationURI,
+		"",
+		"And enter the code:",
+		"",
+		"  " + dc.UserCode,
+	})
+	comm.Logf("Waiting for authorization...")
+
+	return da.Poll(dc)
+}
@@ -0,0 +1,160 @@
+package mansion
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+// standInServer pretends to be an itch.io instance that supports device
+// authorization. The device code is approved after a few polls.
+type standInServer struct {
+	polls     int
+	approveAt int
+	deny      bool
+}
+
+func (s *standInServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	reply := func(status int, v interface{}) {
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(v)
+	}
+
+	switch r.URL.Path {
+	case "/user/oauth/device":
+		reply(200, map[string]interface{}{
+			"device_code":      "dev-123",
+			"user_code":        "ABCD-EFGH",
+			"verification_uri": "http://example.org/device",
+			"expires_in":       600,
+			"interval":         5,
+		})
+	case "/user/oauth/token":
+		if r.FormValue("device_code") != "dev-123" || r.FormValue("grant_type") != deviceGrantType {
+			reply(400, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		s.polls++
+		switch {
+		case s.polls == 1:
+			reply(400, map[string]string{"error": "slow_down"})
+		case s.polls < s.approveAt:
+			reply(400, map[string]string{"error": "authorization_pending"})
+		case s.deny:
+			reply(400, map[string]string{"error": "access_denied"})
+		default:
+			reply(200, map[string]string{"access_token": "good-key"})
+		}
+	case "/wharf/status":
+		if r.URL.Query().Get("api_key") != "good-key" && r.Header.Get("Authorization") != "good-key" {
+			reply(401, map[string]interface{}{"errors": []string{"invalid key"}})
+			return
+		}
+		reply(200, map[string]interface{}{"success": true})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestDeviceAuth(t *testing.T) {
+	s := &standInServer{approveAt: 4}
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	var waited []time.Duration
+	da := &DeviceAuth{
+		WebAddress: server.URL,
+		Sleep: func(d time.Duration) {
+			waited = append(waited, d)
+		},
+	}
+
+	dc, err := da.Start()
+	wtest.Must(t, err)
+	assert.EqualValues(t, "ABCD-EFGH", dc.UserCode)
+
+	key, err := da.Poll(dc)
+	wtest.Must(t, err)
+	assert.EqualValues(t, "good-key", key)
+	assert.EqualValues(t, 4, s.polls)
+	assert.EqualValues(t, []time.Duration{5 * time.Second, 10 * time.Second, 10 * time.Second, 10 * time.Second}, waited, "slow_down increases the interval")
+
+	s.polls = 0
+	s.deny = true
+	_, err = da.Poll(dc)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "denied")
+
+	s.polls = 0
+	s.deny = false
+	s.approveAt = 1000
+	_, err = da.Poll(dc)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "expired")
+}
+
+func TestDeviceAuthUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	da := &DeviceAuth{WebAddress: server.URL}
+	_, err := da.Start()
+	assert.Equal(t, errDeviceFlowUnsupported, err)
+
+	// servers that don't know about the endpoint don't all say 404
+	notAllowed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}))
+	defer notAllowed.Close()
+
+	da = &DeviceAuth{WebAddress: notAllowed.URL}
+	_, err = da.Start()
+	assert.Error(t, err)
+
+	html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<!doctype html><html><body>Not here</body></html>"))
+	}))
+	defer html.Close()
+
+	da = &DeviceAuth{WebAddress: html.URL}
+	_, err = da.Start()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "decoding response")
+}
+
+func TestSaveAPIKey(t *testing.T) {
+	server := httptest.NewServer(&standInServer{})
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "save-api-key")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := &Context{
+		Identity:   filepath.Join(dir, "butler_creds"),
+		ConfigDir:  dir,
+		Profile:    "studio",
+		HTTPClient: http.DefaultClient,
+	}
+	ctx.SetAddress(server.URL)
+
+	err = ctx.SaveAPIKey("bad-key")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid API key")
+	assert.False(t, ctx.HasSavedCredentials())
+
+	wtest.Must(t, ctx.SaveAPIKey("good-key"))
+	buf, err := ioutil.ReadFile(filepath.Join(dir, "butler_creds.studio"))
+	wtest.Must(t, err)
+	assert.EqualValues(t, "good-key", string(buf))
+}
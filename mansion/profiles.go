@@ -0,0 +1,64 @@
+package mansion
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// profileKeyPrefix is how the key files of named profiles start, they're
+// saved next to the default key file.
+const profileKeyPrefix = "butler_creds."
+
+var profileNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateProfileName returns an error if a profile name can't be
+// used as part of a file name.
+func ValidateProfileName(name string) error {
+	if !profileNameRe.MatchString(name) {
+		return errors.Errorf("invalid profile name '%s': only letters, digits, dashes and underscores are allowed", name)
+	}
+	return nil
+}
+
+// KeyPath returns the path of the file the API key is read from and saved
+// to: the one of the chosen profile if any, Identity otherwise.
+func (ctx *Context) KeyPath() string {
+	if ctx.Profile == "" {
+		return ctx.Identity
+	}
+	return filepath.Join(ctx.ConfigDir, profileKeyPrefix+ctx.Profile)
+}
+
+// ListProfiles returns the names of all saved profiles, sorted
+func (ctx *Context) ListProfiles() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(ctx.ConfigDir, profileKeyPrefix+"*"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var names []string
+	for _, m := range matches {
+		stats, err := os.Stat(m)
+		if err != nil || !stats.Mode().IsRegular() {
+			continue
+		}
+
+		name := strings.TrimPrefix(filepath.Base(m), profileKeyPrefix)
+		if ValidateProfileName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// UsingEnvironmentKey returns true if the API key is taken from the
+// environment rather than from a file.
+func (ctx *Context) UsingEnvironmentKey() bool {
+	return os.Getenv(environmentApiKeyVariable) != ""
+}
@@ -0,0 +1,47 @@
+package mansion
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProfileName(t *testing.T) {
+	assert.NoError(t, ValidateProfileName("studio"))
+	assert.NoError(t, ValidateProfileName("my_studio-2"))
+	assert.Error(t, ValidateProfileName(""))
+	assert.Error(t, ValidateProfileName("../studio"))
+	assert.Error(t, ValidateProfileName("my studio"))
+}
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profiles")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := &Context{
+		Identity:  filepath.Join(dir, "butler_creds"),
+		ConfigDir: dir,
+	}
+	assert.EqualValues(t, ctx.Identity, ctx.KeyPath())
+
+	ctx.Profile = "studio"
+	assert.EqualValues(t, filepath.Join(dir, "butler_creds.studio"), ctx.KeyPath())
+
+	profiles, err := ctx.ListProfiles()
+	wtest.Must(t, err)
+	assert.Empty(t, profiles)
+
+	for _, name := range []string{"butler_creds", "butler_creds.studio", "butler_creds.personal"} {
+		wtest.Must(t, ioutil.WriteFile(filepath.Join(dir, name), []byte("key"), 0600))
+	}
+	wtest.Must(t, os.Mkdir(filepath.Join(dir, "butler_creds.folder"), 0755))
+
+	profiles, err = ctx.ListProfiles()
+	wtest.Must(t, err)
+	assert.EqualValues(t, []string{"personal", "studio"}, profiles)
+}
@@ -1,5 +1,7 @@
 package mansion
 
+import "github.com/itchio/dash"
+
 // WalkResult is sent for each item that's walked
 //
 // For command `walk`
@@ -21,6 +23,43 @@ type ContainerResult struct {
 	UncompressedSize int64    `json:"uncompressedSize"`
 }
 
+// A DeepFileResult is sent in json mode by the file command when
+// it's called with --deep, it describes how the app would install a file
+//
+// For command `file --deep`
+type DeepFileResult struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+
+	// InstallerType is what installer.GetInstallerInfo picked, see installer.InstallerType
+	InstallerType string `json:"installerType"`
+	// Reason explains why InstallerType was picked
+	Reason string `json:"reason"`
+
+	// Rejected is true if the app would refuse to install the file
+	Rejected     bool   `json:"rejected"`
+	RejectReason string `json:"rejectReason,omitempty"`
+
+	// Archive is set if the file is an archive the app can extract
+	Archive *DeepArchiveResult `json:"archive,omitempty"`
+	// NestedInstallerType is set if the archive only contains a
+	// Windows installer, which the app would run after extracting it
+	NestedInstallerType string `json:"nestedInstallerType,omitempty"`
+
+	// Candidates are the launch targets the app would find after installing
+	Candidates []*dash.Candidate `json:"candidates"`
+}
+
+// A DeepArchiveResult describes an archive found by `file --deep`
+type DeepArchiveResult struct {
+	Format     string `json:"format"`
+	NumEntries int    `json:"numEntries"`
+	// StageTwo is set for archives that need more work after being extracted,
+	// like MojoSetup installers
+	StageTwo    string   `json:"stageTwo,omitempty"`
+	PostExtract []string `json:"postExtract,omitempty"`
+}
+
 // FileExtractedResult is sent as json so the consumer can know what we extracted
 // It is sent even if we're resuming an extract.
 //
@@ -30,6 +69,19 @@ type FileExtractedResult struct {
 	Path string `json:"path"`
 }
 
+// An ArchiveEntryResult is sent for each entry listed
+//
+// For command `extract --list`
+type ArchiveEntryResult struct {
+	// Path is where the entry would be extracted, after --strip-components
+	Path string `json:"path"`
+	// Kind is one of "file", "dir" or "symlink"
+	Kind string `json:"kind"`
+	Mode uint32 `json:"mode"`
+	// Size may be 0 if the format doesn't store it
+	Size int64 `json:"size"`
+}
+
 // FileMirroredResult is sent as json so the consumer can know what we mirrored
 //
 // For command `ditto`
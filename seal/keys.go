@@ -0,0 +1,134 @@
+package seal
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	publicKeyPrefix  = "ed25519:"
+	privateKeyPrefix = "ed25519-private:"
+)
+
+// GenerateKey returns a new key pair
+func GenerateKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return pub, priv, nil
+}
+
+// FormatPublicKey returns a public key in the form found in .pub files,
+// which is also what butlerd expects when pinning keys: "ed25519:<base64>"
+func FormatPublicKey(pub ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(pub)
+}
+
+// ParsePublicKey parses a key formatted with FormatPublicKey
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, publicKeyPrefix) {
+		if strings.HasPrefix(s, privateKeyPrefix) {
+			return nil, errors.New("expected a public key, got a private key")
+		}
+		return nil, errors.Errorf("public keys should start with %q", publicKeyPrefix)
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, publicKeyPrefix))
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding public key")
+	}
+	if len(buf) != ed25519.PublicKeySize {
+		return nil, errors.Errorf("public keys should be %d bytes, got %d", ed25519.PublicKeySize, len(buf))
+	}
+	return ed25519.PublicKey(buf), nil
+}
+
+// LoadPublicKey accepts either a key formatted with FormatPublicKey,
+// or the path of a .pub file
+func LoadPublicKey(keyOrPath string) (ed25519.PublicKey, error) {
+	if strings.HasPrefix(keyOrPath, publicKeyPrefix) {
+		return ParsePublicKey(keyOrPath)
+	}
+
+	buf, err := ioutil.ReadFile(keyOrPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading public key")
+	}
+	pub, err := ParsePublicKey(string(buf))
+	if err != nil {
+		return nil, errors.Wrapf(err, "in %s", keyOrPath)
+	}
+	return pub, nil
+}
+
+// LoadPrivateKey reads a private key written by WriteKeyPair
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading private key")
+	}
+
+	s := strings.TrimSpace(string(buf))
+	if !strings.HasPrefix(s, privateKeyPrefix) {
+		return nil, errors.Errorf("%s is not a private key written by butler keygen", path)
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, privateKeyPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding private key %s", path)
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.Errorf("%s: private keys should be %d bytes, got %d", path, ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// WriteKeyPair writes the private key to path, readable by the current user only,
+// and the public key to path + ".pub". It won't overwrite existing keys.
+func WriteKeyPair(path string, priv ed25519.PrivateKey) error {
+	pubPath := path + ".pub"
+	for _, p := range []string{path, pubPath} {
+		_, err := os.Stat(p)
+		if err == nil {
+			return errors.Errorf("%s already exists, refusing to overwrite it", p)
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = fmt.Fprintf(f, "%s%s\n", privateKeyPrefix, base64.StdEncoding.EncodeToString(priv.Seed()))
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	pub := priv.Public().(ed25519.PublicKey)
+	err = ioutil.WriteFile(pubPath, []byte(FormatPublicKey(pub)+"\n"), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// Fingerprint returns a short, human-readable identifier for a public key
+func Fingerprint(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,156 @@
+// Package seal proves that a wharf signature file (and so the container
+// and block hashes in it) was made by whoever holds a given ed25519 key.
+//
+// A seal is a fixed-size trailer appended to the signature file:
+//
+//	signature   64 bytes, ed25519 signature of the message below
+//	public key  32 bytes, key the file was sealed with
+//	magic        8 bytes, "BTLRSEAL"
+//
+// The signed message is a short prefix followed by the sha256 of everything
+// that comes before the trailer. pwr.ReadSignature stops reading after the
+// last block hash, so sealed signature files can be used anywhere
+// a regular one can.
+package seal
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Magic is found at the very end of sealed files
+const Magic = "BTLRSEAL"
+
+// TrailerSize is how many bytes sealing adds to a file
+const TrailerSize = ed25519.SignatureSize + ed25519.PublicKeySize + len(Magic)
+
+const messagePrefix = "butler seal v1\n"
+
+// ErrNotSealed is returned when a file doesn't end with a seal
+var ErrNotSealed = errors.New("not sealed")
+
+// ErrUnknownKey is returned when a file was sealed with a key we don't trust
+var ErrUnknownKey = errors.New("sealed with an unknown key")
+
+// ErrBadSeal is returned when the contents of a file don't match its seal
+var ErrBadSeal = errors.New("contents don't match seal")
+
+// A Writer keeps track of everything written through it, so it can be sealed
+type Writer struct {
+	w io.Writer
+	h hash.Hash
+}
+
+var _ io.Writer = (*Writer)(nil)
+
+// NewWriter returns a Writer that passes everything through to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w: w,
+		h: sha256.New(),
+	}
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+	n, err := sw.w.Write(p)
+	sw.h.Write(p[:n])
+	return n, err
+}
+
+// Seal writes a trailer for everything written so far. Nothing should be
+// written after that.
+func (sw *Writer) Seal(key ed25519.PrivateKey) error {
+	trailer := make([]byte, 0, TrailerSize)
+	trailer = append(trailer, ed25519.Sign(key, message(sw.h.Sum(nil)))...)
+	trailer = append(trailer, key.Public().(ed25519.PublicKey)...)
+	trailer = append(trailer, Magic...)
+
+	_, err := sw.w.Write(trailer)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// A Seal is the trailer of a sealed file
+type Seal struct {
+	PublicKey ed25519.PublicKey
+	Signature []byte
+	// ContentSize is the size of the file without the trailer
+	ContentSize int64
+}
+
+// Read returns the seal at the end of a file of the given size,
+// or ErrNotSealed
+func Read(r io.ReaderAt, size int64) (*Seal, error) {
+	if size < int64(TrailerSize) {
+		return nil, ErrNotSealed
+	}
+
+	trailer := make([]byte, TrailerSize)
+	_, err := r.ReadAt(trailer, size-int64(TrailerSize))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if !bytes.Equal(trailer[ed25519.SignatureSize+ed25519.PublicKeySize:], []byte(Magic)) {
+		return nil, ErrNotSealed
+	}
+
+	return &Seal{
+		Signature:   trailer[:ed25519.SignatureSize],
+		PublicKey:   ed25519.PublicKey(trailer[ed25519.SignatureSize : ed25519.SignatureSize+ed25519.PublicKeySize]),
+		ContentSize: size - int64(TrailerSize),
+	}, nil
+}
+
+// Verify reads the contents of the sealed file and returns ErrBadSeal
+// if they weren't sealed with s.PublicKey.
+func (s *Seal) Verify(r io.ReaderAt) error {
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(r, 0, s.ContentSize))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !ed25519.Verify(s.PublicKey, message(h.Sum(nil)), s.Signature) {
+		return ErrBadSeal
+	}
+	return nil
+}
+
+// Check makes sure a file was sealed with one of the given keys, and
+// that it wasn't modified since. It returns ErrNotSealed, ErrUnknownKey or
+// ErrBadSeal otherwise.
+func Check(r io.ReaderAt, size int64, keys ...ed25519.PublicKey) (*Seal, error) {
+	s, err := Read(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := false
+	for _, key := range keys {
+		if bytes.Equal(key, s.PublicKey) {
+			trusted = true
+			break
+		}
+	}
+	if !trusted {
+		return s, ErrUnknownKey
+	}
+
+	err = s.Verify(r)
+	if err != nil {
+		return s, err
+	}
+	return s, nil
+}
+
+func message(digest []byte) []byte {
+	return append([]byte(messagePrefix), digest...)
+}
@@ -0,0 +1,142 @@
+package seal
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pools/fspool"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/itchio/wharf/tlc"
+	"github.com/itchio/wharf/wire"
+	"github.com/itchio/wharf/wsync"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+
+	_ "github.com/itchio/wharf/compressors/gzip"
+	_ "github.com/itchio/wharf/compressors/zstd"
+	_ "github.com/itchio/wharf/decompressors/gzip"
+	_ "github.com/itchio/wharf/decompressors/zstd"
+)
+
+func makeSignature(t *testing.T, dir string, compression pwr.CompressionSettings, key ed25519.PrivateKey) []byte {
+	container, err := tlc.WalkAny(dir, &tlc.WalkOpts{})
+	wtest.Must(t, err)
+
+	var buf bytes.Buffer
+	sw := NewWriter(&buf)
+
+	rawSigWire := wire.NewWriteContext(sw)
+	wtest.Must(t, rawSigWire.WriteMagic(pwr.SignatureMagic))
+	wtest.Must(t, rawSigWire.WriteMessage(&pwr.SignatureHeader{Compression: &compression}))
+	sigWire, err := pwr.CompressWire(rawSigWire, &compression)
+	wtest.Must(t, err)
+	wtest.Must(t, sigWire.WriteMessage(container))
+	wtest.Must(t, pwr.ComputeSignatureToWriter(context.Background(), container, fspool.New(container, dir), &state.Consumer{}, func(bh wsync.BlockHash) error {
+		return sigWire.WriteMessage(&pwr.BlockHash{
+			WeakHash:   bh.WeakHash,
+			StrongHash: bh.StrongHash,
+		})
+	}))
+	wtest.Must(t, sigWire.Close())
+
+	if key != nil {
+		wtest.Must(t, sw.Seal(key))
+	}
+	return buf.Bytes()
+}
+
+func TestSeal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seal")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, pwr.BlockSize*3+10)
+	rand.New(rand.NewSource(1)).Read(data)
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(dir, "data.bin"), data, 0644))
+	wtest.Must(t, ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0644))
+
+	pub, priv, err := GenerateKey()
+	wtest.Must(t, err)
+	otherPub, _, err := GenerateKey()
+	wtest.Must(t, err)
+
+	for _, algo := range []pwr.CompressionAlgorithm{
+		pwr.CompressionAlgorithm_NONE,
+		pwr.CompressionAlgorithm_GZIP,
+		pwr.CompressionAlgorithm_ZSTD,
+	} {
+		compression := pwr.CompressionSettings{Algorithm: algo, Quality: 1}
+		plain := makeSignature(t, dir, compression, nil)
+		sealed := makeSignature(t, dir, compression, priv)
+		assert.Len(t, sealed, len(plain)+TrailerSize)
+
+		// sealed signatures are still signatures
+		for _, buf := range [][]byte{plain, sealed} {
+			source := seeksource.FromBytes(buf)
+			_, err = source.Resume(nil)
+			wtest.Must(t, err)
+			sig, err := pwr.ReadSignature(context.Background(), source)
+			wtest.Must(t, err)
+			assert.Len(t, sig.Container.Files, 2)
+			assert.Len(t, sig.Hashes, 5)
+		}
+
+		s, err := Check(bytes.NewReader(sealed), int64(len(sealed)), otherPub, pub)
+		wtest.Must(t, err)
+		assert.EqualValues(t, pub, s.PublicKey)
+		assert.EqualValues(t, len(plain), s.ContentSize)
+
+		_, err = Check(bytes.NewReader(plain), int64(len(plain)), pub)
+		assert.Equal(t, ErrNotSealed, err)
+
+		_, err = Check(bytes.NewReader(sealed), int64(len(sealed)), otherPub)
+		assert.Equal(t, ErrUnknownKey, err)
+
+		tampered := append([]byte{}, sealed...)
+		tampered[len(plain)/2] ^= 0xff
+		_, err = Check(bytes.NewReader(tampered), int64(len(tampered)), pub)
+		assert.Equal(t, ErrBadSeal, err)
+	}
+}
+
+func TestKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "seal-keys")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	pub, priv, err := GenerateKey()
+	wtest.Must(t, err)
+
+	keyPath := filepath.Join(dir, "studio")
+	wtest.Must(t, WriteKeyPair(keyPath, priv))
+	assert.Error(t, WriteKeyPair(keyPath, priv), "should refuse to overwrite keys")
+
+	loadedPriv, err := LoadPrivateKey(keyPath)
+	wtest.Must(t, err)
+	assert.EqualValues(t, priv, loadedPriv)
+
+	loadedPub, err := LoadPublicKey(keyPath + ".pub")
+	wtest.Must(t, err)
+	assert.EqualValues(t, pub, loadedPub)
+
+	loadedPub, err = LoadPublicKey(FormatPublicKey(pub))
+	wtest.Must(t, err)
+	assert.EqualValues(t, pub, loadedPub)
+
+	_, err = LoadPublicKey(keyPath)
+	assert.Error(t, err, "private keys aren't public keys")
+	_, err = LoadPrivateKey(keyPath + ".pub")
+	assert.Error(t, err, "public keys aren't private keys")
+	_, err = ParsePublicKey("ed25519:AAAA")
+	assert.Error(t, err)
+
+	assert.Len(t, Fingerprint(pub), 16)
+}
@@ -0,0 +1,80 @@
+package sigcache
+
+import (
+	"context"
+	"io"
+
+	itchio "github.com/itchio/go-itchio"
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/eos"
+	"github.com/itchio/wharf/eos/option"
+	"github.com/itchio/wharf/pwr"
+	"github.com/itchio/wharf/state"
+	"github.com/pkg/errors"
+)
+
+// Get returns the signature of a build, from the cache if possible,
+// downloading it from itch.io (and caching it) otherwise.
+func (c *Cache) Get(client *itchio.Client, buildID int64, consumer *state.Consumer) (*pwr.SignatureInfo, error) {
+	signature, err := c.Read(buildID)
+	if err != nil {
+		return nil, err
+	}
+	if signature != nil {
+		consumer.Debugf("Using cached signature for build %d", buildID)
+		return signature, nil
+	}
+
+	buildFiles, err := client.ListBuildFiles(buildID)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing build files")
+	}
+
+	signatureFile := itchio.FindBuildFile(itchio.BuildFileTypeSignature, buildFiles.Files)
+	if signatureFile == nil {
+		return nil, errors.Errorf("could not find signature for build %d", buildID)
+	}
+
+	signatureURL := client.MakeBuildFileDownloadURL(itchio.MakeBuildFileDownloadURLParams{
+		BuildID: buildID,
+		FileID:  signatureFile.ID,
+	})
+
+	signatureReader, err := eos.Open(signatureURL, option.WithConsumer(consumer))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening signature")
+	}
+	defer signatureReader.Close()
+
+	if c.Enabled() {
+		err = c.Put(buildID, signatureReader)
+		if err == nil {
+			signature, err = c.Read(buildID)
+			if err == nil && signature != nil {
+				return signature, nil
+			}
+		}
+		if err != nil {
+			consumer.Debugf("Could not cache signature: %s", err.Error())
+		}
+
+		_, err = signatureReader.Seek(0, io.SeekStart)
+		if err != nil {
+			return nil, errors.Wrap(err, "opening signature")
+		}
+	}
+
+	signatureSource := seeksource.FromFile(signatureReader)
+
+	_, err = signatureSource.Resume(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening signature")
+	}
+
+	signature, err = pwr.ReadSignature(context.Background(), signatureSource)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading signature")
+	}
+
+	return signature, nil
+}
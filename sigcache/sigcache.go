@@ -0,0 +1,354 @@
+// Package sigcache implements an on-disk cache of build signatures,
+// so that pushing or comparing against a build we've seen before
+// doesn't require downloading its signature again.
+//
+// Signatures are stored by content hash, and indexed by build ID:
+//
+//	blobs/<sha256>    signature files
+//	builds/<buildID>  the sha256 of that build's signature
+//
+// The modification time of index files is used to find
+// the least recently used signatures when pruning.
+package sigcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/itchio/savior/seeksource"
+	"github.com/itchio/wharf/pwr"
+	"github.com/pkg/errors"
+)
+
+// Cache is an on-disk, content-addressed store of signatures
+type Cache struct {
+	Dir string
+
+	// MaxSize is the total size of signatures above which the least
+	// recently used ones are evicted. A MaxSize of 0 disables the cache.
+	MaxSize int64
+}
+
+// An Entry is a cached signature for a build
+type Entry struct {
+	BuildID  int64
+	Hash     string
+	Size     int64
+	LastUsed time.Time
+}
+
+// New returns a cache stored in dir, capped to maxSize bytes
+func New(dir string, maxSize int64) *Cache {
+	return &Cache{
+		Dir:     dir,
+		MaxSize: maxSize,
+	}
+}
+
+// Enabled returns false if the cache was disabled by setting its size to 0
+func (c *Cache) Enabled() bool {
+	return c.MaxSize > 0
+}
+
+func (c *Cache) blobsDir() string {
+	return filepath.Join(c.Dir, "blobs")
+}
+
+func (c *Cache) buildsDir() string {
+	return filepath.Join(c.Dir, "builds")
+}
+
+func (c *Cache) blobPath(hash string) string {
+	return filepath.Join(c.blobsDir(), hash)
+}
+
+func (c *Cache) indexPath(buildID int64) string {
+	return filepath.Join(c.buildsDir(), fmt.Sprintf("%d", buildID))
+}
+
+// Path returns the path of the cached signature of a build,
+// and false if it isn't in the cache.
+func (c *Cache) Path(buildID int64) (string, bool) {
+	if !c.Enabled() {
+		return "", false
+	}
+
+	indexPath := c.indexPath(buildID)
+	buf, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return "", false
+	}
+
+	blobPath := c.blobPath(strings.TrimSpace(string(buf)))
+	_, err = os.Stat(blobPath)
+	if err != nil {
+		// dangling index entry
+		os.Remove(indexPath)
+		return "", false
+	}
+
+	now := time.Now()
+	os.Chtimes(indexPath, now, now)
+	return blobPath, true
+}
+
+// Read returns the cached signature of a build, or nil if it isn't in the cache.
+func (c *Cache) Read(buildID int64) (*pwr.SignatureInfo, error) {
+	blobPath, ok := c.Path(buildID)
+	if !ok {
+		return nil, nil
+	}
+
+	f, err := os.Open(blobPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	source := seeksource.FromFile(f)
+	_, err = source.Resume(nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sig, err := pwr.ReadSignature(context.Background(), source)
+	if err != nil {
+		// corrupted blob, forget about it
+		f.Close()
+		os.Remove(blobPath)
+		os.Remove(c.indexPath(buildID))
+		return nil, nil
+	}
+	return sig, nil
+}
+
+// Put stores the signature of a build, read entirely from r
+func (c *Cache) Put(buildID int64, r io.Reader) error {
+	w := c.NewWriter(buildID)
+	defer w.Abort()
+
+	_, err := io.Copy(w, r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return w.Commit()
+}
+
+// A Writer stores a signature as it's being generated or downloaded.
+// Writing to it never fails, so it can be used alongside other writers:
+// errors are returned by Commit instead.
+type Writer struct {
+	c       *Cache
+	buildID int64
+
+	f    *os.File
+	h    hash.Hash
+	err  error
+	done bool
+}
+
+// NewWriter returns a writer for the signature of a build. It's a no-op if
+// the cache is disabled. Nothing is stored until Commit is called.
+func (c *Cache) NewWriter(buildID int64) *Writer {
+	w := &Writer{
+		c:       c,
+		buildID: buildID,
+		h:       sha256.New(),
+	}
+
+	if !c.Enabled() {
+		w.done = true
+		return w
+	}
+
+	err := os.MkdirAll(c.blobsDir(), 0755)
+	if err != nil {
+		w.err = errors.WithStack(err)
+		return w
+	}
+
+	w.f, err = ioutil.TempFile(c.blobsDir(), "incoming-")
+	if err != nil {
+		w.err = errors.WithStack(err)
+	}
+	return w
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.done || w.err != nil {
+		return len(p), nil
+	}
+
+	w.h.Write(p)
+	_, err := w.f.Write(p)
+	if err != nil {
+		w.err = errors.WithStack(err)
+	}
+	return len(p), nil
+}
+
+// Commit adds the written signature to the cache, then prunes
+// the cache if it's grown too large.
+func (w *Writer) Commit() error {
+	if w.done {
+		return nil
+	}
+	if w.err != nil {
+		w.Abort()
+		return w.err
+	}
+	w.done = true
+
+	c := w.c
+	err := w.f.Close()
+	if err != nil {
+		os.Remove(w.f.Name())
+		return errors.WithStack(err)
+	}
+
+	hash := hex.EncodeToString(w.h.Sum(nil))
+	err = os.Rename(w.f.Name(), c.blobPath(hash))
+	if err != nil {
+		os.Remove(w.f.Name())
+		return errors.WithStack(err)
+	}
+
+	err = os.MkdirAll(c.buildsDir(), 0755)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = ioutil.WriteFile(c.indexPath(w.buildID), []byte(hash), 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = c.prune(c.MaxSize, w.buildID)
+	return err
+}
+
+// Abort discards whatever was written, unless Commit was called already.
+func (w *Writer) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+
+	if w.f != nil {
+		w.f.Close()
+		os.Remove(w.f.Name())
+	}
+}
+
+// List returns all cached signatures, most recently used first
+func (c *Cache) List() ([]*Entry, error) {
+	infos, err := ioutil.ReadDir(c.buildsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+
+	var entries []*Entry
+	for _, info := range infos {
+		buildID, err := strconv.ParseInt(info.Name(), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		buf, err := ioutil.ReadFile(filepath.Join(c.buildsDir(), info.Name()))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		hash := strings.TrimSpace(string(buf))
+
+		blobInfo, err := os.Stat(c.blobPath(hash))
+		if err != nil {
+			// dangling index entry
+			continue
+		}
+
+		entries = append(entries, &Entry{
+			BuildID:  buildID,
+			Hash:     hash,
+			Size:     blobInfo.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.After(entries[j].LastUsed)
+	})
+	return entries, nil
+}
+
+// TotalSize returns the disk space taken by a set of entries,
+// counting signatures shared by several builds only once.
+func TotalSize(entries []*Entry) int64 {
+	var total int64
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if seen[e.Hash] {
+			continue
+		}
+		seen[e.Hash] = true
+		total += e.Size
+	}
+	return total
+}
+
+// Prune evicts the least recently used signatures until the cache
+// takes up at most maxSize bytes, and returns the evicted entries.
+func (c *Cache) Prune(maxSize int64) ([]*Entry, error) {
+	return c.prune(maxSize, -1)
+}
+
+func (c *Cache) prune(maxSize int64, keepBuildID int64) ([]*Entry, error) {
+	entries, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []*Entry
+	var evicted []*Entry
+	for _, e := range entries {
+		if e.BuildID == keepBuildID || TotalSize(append(kept, e)) <= maxSize {
+			kept = append(kept, e)
+		} else {
+			evicted = append(evicted, e)
+		}
+	}
+
+	keptHashes := make(map[string]bool)
+	for _, e := range kept {
+		keptHashes[e.Hash] = true
+	}
+
+	for _, e := range evicted {
+		err := os.Remove(c.indexPath(e.BuildID))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.WithStack(err)
+		}
+
+		if !keptHashes[e.Hash] {
+			err := os.Remove(c.blobPath(e.Hash))
+			if err != nil && !os.IsNotExist(err) {
+				return nil, errors.WithStack(err)
+			}
+		}
+	}
+
+	return evicted, nil
+}
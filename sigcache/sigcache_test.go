@@ -0,0 +1,78 @@
+package sigcache_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/butler/sigcache"
+	"github.com/itchio/wharf/wtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPutAndPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigcache")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	c := sigcache.New(dir, 250)
+
+	put := func(buildID int64, data []byte) {
+		wtest.Must(t, c.Put(buildID, bytes.NewReader(data)))
+	}
+
+	a := bytes.Repeat([]byte{'a'}, 100)
+	b := bytes.Repeat([]byte{'b'}, 100)
+
+	put(1, a)
+	put(2, a)
+	put(3, b)
+
+	entries, err := c.List()
+	wtest.Must(t, err)
+	assert.Len(t, entries, 3)
+	assert.EqualValues(t, 200, sigcache.TotalSize(entries))
+
+	p1, ok := c.Path(1)
+	assert.True(t, ok)
+	p2, ok := c.Path(2)
+	assert.True(t, ok)
+	assert.EqualValues(t, p1, p2, "identical signatures should share a blob")
+
+	// make build 3 the least recently used
+	old := time.Now().Add(-time.Hour)
+	wtest.Must(t, os.Chtimes(filepath.Join(dir, "builds", "3"), old, old))
+
+	put(4, bytes.Repeat([]byte{'c'}, 100))
+
+	_, ok = c.Path(3)
+	assert.False(t, ok, "least recently used signature should have been evicted")
+	_, ok = c.Path(4)
+	assert.True(t, ok)
+
+	evicted, err := c.Prune(0)
+	wtest.Must(t, err)
+	assert.Len(t, evicted, 3)
+
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	wtest.Must(t, err)
+	assert.Empty(t, blobs)
+}
+
+func TestDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sigcache")
+	wtest.Must(t, err)
+	defer os.RemoveAll(dir)
+
+	c := sigcache.New(dir, 0)
+	wtest.Must(t, c.Put(1, bytes.NewReader([]byte("hello"))))
+
+	_, ok := c.Path(1)
+	assert.False(t, ok)
+
+	_, err = os.Stat(filepath.Join(dir, "blobs"))
+	assert.True(t, os.IsNotExist(err))
+}